@@ -1,3 +1,13 @@
 package consts
 
 const PodAnnotationSMCR = "network.alibabacloud.com/erdma-smcr"
+
+// NodeAnnotationDryRun set to "true" or "false" overrides the dryRun config
+// for the ERIs and the ERdmaDevice of a node.
+const NodeAnnotationDryRun = "network.alibabacloud.com/erdma-dry-run"
+
+// NodeAnnotationOptOut set to "true" opts a node out of erdma like leaving the
+// node selector: its ERIs are released and its primary ENI is converted back.
+// The controller also sets it on the ERdmaDevice of an opted out node before
+// deleting it.
+const NodeAnnotationOptOut = "network.alibabacloud.com/erdma-opt-out"
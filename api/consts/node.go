@@ -0,0 +1,10 @@
+package consts
+
+// NodeTaintNotReady is the NoSchedule taint the controller puts on new nodes
+// with notReadyTaint, the agent removes it once the erdma devices of the node
+// are ready.
+const NodeTaintNotReady = "network.alibabacloud.com/erdma-not-ready"
+
+// NodeConditionERdmaReady is the node condition the agent reports whether the
+// erdma devices of the node are ready in.
+const NodeConditionERdmaReady = "ERdmaReady"
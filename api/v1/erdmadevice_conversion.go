@@ -0,0 +1,233 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+)
+
+// conversionDataAnnotation keeps the v1beta2 fields which have no v1
+// representation, so that an object read and written back through v1 does not
+// lose them.
+const conversionDataAnnotation = "network.alibabacloud.com/conversion-data"
+
+// ConvertTo converts this ERdmaDevice to the hub version (v1beta2).
+func (src *ERdmaDevice) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta2.ERdmaDevice)
+	if !ok {
+		return fmt.Errorf("unexpected hub type %T", dstRaw)
+	}
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	macs := map[string]string{}
+	dst.Spec.ERIs = make([]v1beta2.ERISpec, 0, len(src.Spec.Devices))
+	for _, dev := range src.Spec.Devices {
+		if dst.Spec.InstanceID == "" {
+			dst.Spec.InstanceID = dev.InstanceID
+		}
+		dst.Spec.ERIs = append(dst.Spec.ERIs, v1beta2.ERISpec{
+			ID:               dev.ID,
+			NetworkCardIndex: dev.NetworkCardIndex,
+			QueuePair:        dev.QueuePair,
+			PrimaryENI:       dev.IsPrimaryENI,
+		})
+		macs[dev.ID] = dev.MAC
+	}
+	dst.Spec.JumboFrame = src.Spec.JumboFrame
+
+	// the MAC lived in the v1 spec, it is cloud observed state in v1beta2
+	dst.Status.ERIs = nil
+	seen := map[string]bool{}
+	for _, devStatus := range src.Status.Devices {
+		dst.Status.ERIs = append(dst.Status.ERIs, v1beta2.ERIStatus{
+			ID:      devStatus.ID,
+			MAC:     macs[devStatus.ID],
+			Phase:   v1beta2.ERIPhase(devStatus.Status),
+			Message: devStatus.Message,
+		})
+		seen[devStatus.ID] = true
+	}
+	for _, dev := range src.Spec.Devices {
+		if seen[dev.ID] || dev.MAC == "" {
+			continue
+		}
+		dst.Status.ERIs = append(dst.Status.ERIs, v1beta2.ERIStatus{
+			ID:  dev.ID,
+			MAC: dev.MAC,
+		})
+	}
+
+	dst.Status.ObservedGeneration = src.Status.ObservedGeneration
+	dst.Status.Node = v1beta2.NodeStatus{
+		Driver:           src.Status.Driver,
+		InstallerVersion: src.Status.InstallerVersion,
+	}
+	for _, nodeDev := range src.Status.NodeDevices {
+		dst.Status.Node.Devices = append(dst.Status.Node.Devices, v1beta2.NodeDeviceStatus{
+			ID:           nodeDev.ID,
+			MAC:          nodeDev.MAC,
+			RdmaDevice:   nodeDev.RdmaDevice,
+			NetDevice:    nodeDev.NetDevice,
+			DevPaths:     nodeDev.DevPaths,
+			NUMA:         nodeDev.NUMA,
+			Capabilities: capabilitiesFromString(nodeDev.Capabilities),
+			MTU:          nodeDev.MTU,
+			IP:           nodeDev.IP,
+		})
+	}
+	dst.Status.Conditions = src.Status.Conditions
+
+	return restoreConversionData(dst)
+}
+
+// ConvertFrom converts from the hub version (v1beta2) to this version.
+func (dst *ERdmaDevice) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta2.ERdmaDevice)
+	if !ok {
+		return fmt.Errorf("unexpected hub type %T", srcRaw)
+	}
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	macs := map[string]string{}
+	for _, eriStatus := range src.Status.ERIs {
+		macs[eriStatus.ID] = eriStatus.MAC
+	}
+	dst.Spec.Devices = make([]DeviceInfo, 0, len(src.Spec.ERIs))
+	for _, eri := range src.Spec.ERIs {
+		dst.Spec.Devices = append(dst.Spec.Devices, DeviceInfo{
+			InstanceID:       src.Spec.InstanceID,
+			MAC:              macs[eri.ID],
+			IsPrimaryENI:     eri.PrimaryENI,
+			ID:               eri.ID,
+			NetworkCardIndex: eri.NetworkCardIndex,
+			QueuePair:        eri.QueuePair,
+		})
+	}
+	dst.Spec.JumboFrame = src.Spec.JumboFrame
+
+	dst.Status.Devices = nil
+	for _, eriStatus := range src.Status.ERIs {
+		if eriStatus.Phase == "" && eriStatus.Message == "" {
+			// only carries the MAC, which v1 keeps in spec
+			continue
+		}
+		dst.Status.Devices = append(dst.Status.Devices, DeviceStatus{
+			ID:      eriStatus.ID,
+			Status:  string(eriStatus.Phase),
+			Message: eriStatus.Message,
+		})
+	}
+
+	dst.Status.ObservedGeneration = src.Status.ObservedGeneration
+	dst.Status.Driver = src.Status.Node.Driver
+	dst.Status.InstallerVersion = src.Status.Node.InstallerVersion
+	dst.Status.NodeDevices = nil
+	for _, nodeDev := range src.Status.Node.Devices {
+		dst.Status.NodeDevices = append(dst.Status.NodeDevices, NodeDeviceStatus{
+			ID:           nodeDev.ID,
+			MAC:          nodeDev.MAC,
+			RdmaDevice:   nodeDev.RdmaDevice,
+			NetDevice:    nodeDev.NetDevice,
+			DevPaths:     nodeDev.DevPaths,
+			NUMA:         nodeDev.NUMA,
+			Capabilities: capabilitiesToString(nodeDev.Capabilities),
+			MTU:          nodeDev.MTU,
+			IP:           nodeDev.IP,
+		})
+	}
+	dst.Status.Conditions = src.Status.Conditions
+
+	return saveConversionData(src, dst)
+}
+
+// saveConversionData stores the hub spec and status in an annotation of dst.
+func saveConversionData(src *v1beta2.ERdmaDevice, dst *ERdmaDevice) error {
+	data, err := json.Marshal(struct {
+		Spec   v1beta2.ERdmaDeviceSpec   `json:"spec"`
+		Status v1beta2.ERdmaDeviceStatus `json:"status"`
+	}{src.Spec, src.Status})
+	if err != nil {
+		return fmt.Errorf("marshal conversion data failed: %v", err)
+	}
+	if dst.Annotations == nil {
+		dst.Annotations = map[string]string{}
+	}
+	dst.Annotations[conversionDataAnnotation] = string(data)
+	return nil
+}
+
+// restoreConversionData restores the hub fields v1 cannot represent from the
+// annotation saved by ConvertFrom, and drops the annotation from dst.
+func restoreConversionData(dst *v1beta2.ERdmaDevice) error {
+	data, ok := dst.Annotations[conversionDataAnnotation]
+	if !ok {
+		return nil
+	}
+	delete(dst.Annotations, conversionDataAnnotation)
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+
+	restored := &v1beta2.ERdmaDevice{}
+	if err := json.Unmarshal([]byte(data), restored); err != nil {
+		return fmt.Errorf("unmarshal conversion data failed: %v", err)
+	}
+	eris := map[string]v1beta2.ERIStatus{}
+	for _, eriStatus := range restored.Status.ERIs {
+		eris[eriStatus.ID] = eriStatus
+	}
+	for i := range dst.Status.ERIs {
+		eri := eris[dst.Status.ERIs[i].ID]
+		dst.Status.ERIs[i].QueuePair = eri.QueuePair
+		dst.Status.ERIs[i].VSwitchID = eri.VSwitchID
+		dst.Status.ERIs[i].IP = eri.IP
+		dst.Status.ERIs[i].IPv6 = eri.IPv6
+	}
+	dst.Spec.InstanceType = restored.Spec.InstanceType
+	dst.Spec.Profile = restored.Spec.Profile
+	dst.Spec.Driver = restored.Spec.Driver
+	dst.Spec.JumboFrameMTU = restored.Spec.JumboFrameMTU
+	dst.Status.Node.PolicyGeneration = restored.Status.Node.PolicyGeneration
+	dst.Status.Node.Settings = restored.Status.Node.Settings
+	dst.Status.QueuePairChanges = restored.Status.QueuePairChanges
+	return nil
+}
+
+func capabilitiesFromString(caps string) []v1beta2.ERdmaCapability {
+	if caps == "" {
+		return nil
+	}
+	var ret []v1beta2.ERdmaCapability
+	for _, c := range strings.Split(caps, ",") {
+		ret = append(ret, v1beta2.ERdmaCapability(c))
+	}
+	return ret
+}
+
+func capabilitiesToString(caps []v1beta2.ERdmaCapability) string {
+	capSlice := make([]string, 0, len(caps))
+	for _, c := range caps {
+		capSlice = append(capSlice, string(c))
+	}
+	return strings.Join(capSlice, ",")
+}
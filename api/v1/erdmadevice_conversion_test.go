@@ -0,0 +1,153 @@
+package v1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+)
+
+func TestERdmaDeviceConversion(t *testing.T) {
+	tests := []struct {
+		name string
+		v1   *ERdmaDevice
+		hub  *v1beta2.ERdmaDevice
+	}{
+		{
+			name: "empty",
+			v1: &ERdmaDevice{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Spec:       ERdmaDeviceSpec{Devices: []DeviceInfo{}},
+			},
+			hub: &v1beta2.ERdmaDevice{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Spec:       v1beta2.ERdmaDeviceSpec{ERIs: []v1beta2.ERISpec{}},
+			},
+		},
+		{
+			name: "attached and pending",
+			v1: &ERdmaDevice{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"a": "b"}},
+				Spec: ERdmaDeviceSpec{
+					Devices: []DeviceInfo{
+						{InstanceID: "i-1", MAC: "00:00:00:00:00:01", IsPrimaryENI: true, ID: "eni-1", QueuePair: 8},
+						{InstanceID: "i-1", MAC: "00:00:00:00:00:02", ID: "eni-2", NetworkCardIndex: 1, QueuePair: 8},
+					},
+					JumboFrame: true,
+				},
+				Status: ERdmaDeviceStatus{
+					Devices: []DeviceStatus{
+						{ID: "eni-1", Status: DeviceStatusReady},
+						{ID: "eni-2", Status: DeviceStatusFailed, Message: "attach failed"},
+					},
+					ObservedGeneration: 2,
+					Driver:             "default",
+					InstallerVersion:   "1.5.9",
+					NodeDevices: []NodeDeviceStatus{
+						{ID: "eni-1", MAC: "00:00:00:00:00:01", RdmaDevice: "erdma_0", Capabilities: "RDMA_CM,VERBS", MTU: 8500},
+					},
+					Conditions: []metav1.Condition{
+						{Type: ConditionDriverInstalled, Status: metav1.ConditionTrue, Reason: "Installed"},
+					},
+				},
+			},
+			hub: &v1beta2.ERdmaDevice{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"a": "b"}},
+				Spec: v1beta2.ERdmaDeviceSpec{
+					InstanceID: "i-1",
+					ERIs: []v1beta2.ERISpec{
+						{ID: "eni-1", PrimaryENI: true, QueuePair: 8},
+						{ID: "eni-2", NetworkCardIndex: 1, QueuePair: 8},
+					},
+					JumboFrame: true,
+				},
+				Status: v1beta2.ERdmaDeviceStatus{
+					ObservedGeneration: 2,
+					ERIs: []v1beta2.ERIStatus{
+						{ID: "eni-1", MAC: "00:00:00:00:00:01", Phase: v1beta2.ERIPhaseReady},
+						{ID: "eni-2", MAC: "00:00:00:00:00:02", Phase: v1beta2.ERIPhaseFailed, Message: "attach failed"},
+					},
+					Node: v1beta2.NodeStatus{
+						Driver:           "default",
+						InstallerVersion: "1.5.9",
+						Devices: []v1beta2.NodeDeviceStatus{
+							{ID: "eni-1", MAC: "00:00:00:00:00:01", RdmaDevice: "erdma_0", MTU: 8500,
+								Capabilities: []v1beta2.ERdmaCapability{v1beta2.ERdmaCapabilityRDMACM, v1beta2.ERdmaCapabilityVerbs}},
+						},
+					},
+					Conditions: []metav1.Condition{
+						{Type: ConditionDriverInstalled, Status: metav1.ConditionTrue, Reason: "Installed"},
+					},
+				},
+			},
+		},
+		{
+			name: "mac observed before phase",
+			v1: &ERdmaDevice{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Spec: ERdmaDeviceSpec{
+					Devices: []DeviceInfo{
+						{InstanceID: "i-1", MAC: "00:00:00:00:00:01", ID: "eni-1"},
+					},
+				},
+			},
+			hub: &v1beta2.ERdmaDevice{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Spec: v1beta2.ERdmaDeviceSpec{
+					InstanceID: "i-1",
+					ERIs:       []v1beta2.ERISpec{{ID: "eni-1"}},
+				},
+				Status: v1beta2.ERdmaDeviceStatus{
+					ERIs: []v1beta2.ERIStatus{{ID: "eni-1", MAC: "00:00:00:00:00:01"}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := &v1beta2.ERdmaDevice{}
+			assert.NoError(t, tt.v1.DeepCopy().ConvertTo(hub))
+			assert.Equal(t, tt.hub, hub)
+
+			spoke := &ERdmaDevice{}
+			assert.NoError(t, spoke.ConvertFrom(tt.hub.DeepCopy()))
+			assert.Contains(t, spoke.Annotations, conversionDataAnnotation)
+			delete(spoke.Annotations, conversionDataAnnotation)
+			if len(spoke.Annotations) == 0 {
+				spoke.Annotations = nil
+			}
+			assert.Equal(t, tt.v1, spoke)
+		})
+	}
+}
+
+func TestERdmaDeviceConversionRestoresHubFields(t *testing.T) {
+	hub := &v1beta2.ERdmaDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec: v1beta2.ERdmaDeviceSpec{
+			InstanceID:    "i-1",
+			InstanceType:  "ecs.ebmgn8v.48xlarge",
+			ERIs:          []v1beta2.ERISpec{{ID: "eni-1", QueuePair: 8}},
+			Profile:       "gpu",
+			Driver:        "compat",
+			JumboFrameMTU: 9000,
+		},
+		Status: v1beta2.ERdmaDeviceStatus{
+			ERIs: []v1beta2.ERIStatus{{ID: "eni-1", MAC: "00:00:00:00:00:01", Phase: v1beta2.ERIPhaseReady, QueuePair: 8,
+				VSwitchID: "vsw-1", IP: "192.168.1.10", IPv6: "2408:4005:3ab:1a00::1"}},
+			QueuePairChanges: []v1beta2.QueuePairChange{{ID: "eni-1", From: 4, To: 8}},
+			Node: v1beta2.NodeStatus{
+				PolicyGeneration: 2,
+				Settings:         &v1beta2.AgentSettings{JumboFrameMTU: 8500},
+			},
+		},
+	}
+	spoke := &ERdmaDevice{}
+	assert.NoError(t, spoke.ConvertFrom(hub.DeepCopy()))
+
+	restored := &v1beta2.ERdmaDevice{}
+	assert.NoError(t, spoke.ConvertTo(restored))
+	assert.Equal(t, hub, restored)
+}
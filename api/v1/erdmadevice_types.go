@@ -54,11 +54,45 @@ type DeviceStatus struct {
 	Message string `json:"message,omitempty"`
 }
 
+// Condition types reported by the agent on ERdmaDevice.
+const (
+	ConditionDriverInstalled        = "DriverInstalled"
+	ConditionDevicesProbed          = "DevicesProbed"
+	ConditionNetdevConfigured       = "NetdevConfigured"
+	ConditionDevicePluginRegistered = "DevicePluginRegistered"
+	ConditionSMCRConfigured         = "SMCRConfigured"
+)
+
+// NodeDeviceStatus is the node-side state of an ERI as observed by the agent.
+type NodeDeviceStatus struct {
+	ID           string   `json:"id,omitempty"`
+	MAC          string   `json:"mac,omitempty"`
+	RdmaDevice   string   `json:"rdmaDevice,omitempty"`
+	NetDevice    string   `json:"netDevice,omitempty"`
+	DevPaths     []string `json:"devPaths,omitempty"`
+	NUMA         int64    `json:"numa,omitempty"`
+	Capabilities string   `json:"capabilities,omitempty"`
+	MTU          int      `json:"mtu,omitempty"`
+	IP           string   `json:"ip,omitempty"`
+}
+
 // ERdmaDeviceStatus defines the observed state of ERdmaDevice
 type ERdmaDeviceStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Devices is the cloud-side attach state of each ERI, written by the controller.
 	Devices []DeviceStatus `json:"devices,omitempty"`
+
+	// ObservedGeneration is the spec generation the agent last applied on the node.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Driver is the erdma driver flavour the agent loaded.
+	Driver string `json:"driver,omitempty"`
+	// InstallerVersion is the erdma installer version the agent installed the driver with.
+	InstallerVersion string `json:"installerVersion,omitempty"`
+	// NodeDevices is the node-side state of each ERI, written by the agent.
+	NodeDevices []NodeDeviceStatus `json:"nodeDevices,omitempty"`
+	// Conditions describe the progress of the agent on the node.
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -21,16 +21,47 @@ limitations under the License.
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeviceInfo) DeepCopyInto(out *DeviceInfo) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceInfo.
+func (in *DeviceInfo) DeepCopy() *DeviceInfo {
+	if in == nil {
+		return nil
+	}
+	out := new(DeviceInfo)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeviceStatus) DeepCopyInto(out *DeviceStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceStatus.
+func (in *DeviceStatus) DeepCopy() *DeviceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DeviceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ERdmaDevice) DeepCopyInto(out *ERdmaDevice) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaDevice.
@@ -86,6 +117,11 @@ func (in *ERdmaDeviceList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ERdmaDeviceSpec) DeepCopyInto(out *ERdmaDeviceSpec) {
 	*out = *in
+	if in.Devices != nil {
+		in, out := &in.Devices, &out.Devices
+		*out = make([]DeviceInfo, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaDeviceSpec.
@@ -101,6 +137,25 @@ func (in *ERdmaDeviceSpec) DeepCopy() *ERdmaDeviceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ERdmaDeviceStatus) DeepCopyInto(out *ERdmaDeviceStatus) {
 	*out = *in
+	if in.Devices != nil {
+		in, out := &in.Devices, &out.Devices
+		*out = make([]DeviceStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.NodeDevices != nil {
+		in, out := &in.NodeDevices, &out.NodeDevices
+		*out = make([]NodeDeviceStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaDeviceStatus.
@@ -112,3 +167,23 @@ func (in *ERdmaDeviceStatus) DeepCopy() *ERdmaDeviceStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeDeviceStatus) DeepCopyInto(out *NodeDeviceStatus) {
+	*out = *in
+	if in.DevPaths != nil {
+		in, out := &in.DevPaths, &out.DevPaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeDeviceStatus.
+func (in *NodeDeviceStatus) DeepCopy() *NodeDeviceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeDeviceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultClusterPolicyName is the name of the ERdmaClusterPolicy the
+// controller and the agents apply, other policies are ignored.
+const DefaultClusterPolicyName = "default"
+
+// ControllerPolicy are the controller settings, unset fields fall back to the
+// controller config file. Region, ControllerNamespace, ControllerName,
+// ClusterDomain and CertDir only take effect when the controller starts, they
+// are reported in the RestartRequired condition until then.
+type ControllerPolicy struct {
+	Region                    string `json:"region,omitempty"`
+	ManageNonOwnedERIs        *bool  `json:"manageNonOwnedENIs,omitempty"`
+	ControllerNamespace       string `json:"controllerNamespace,omitempty"`
+	ControllerName            string `json:"controllerName,omitempty"`
+	ClusterDomain             string `json:"clusterDomain,omitempty"`
+	CertDir                   string `json:"certDir,omitempty"`
+	EnableDevicePlugin        *bool  `json:"enableDevicePlugin,omitempty"`
+	EnableWebhook             *bool  `json:"enableWebhook,omitempty"`
+	SMCInitImage              string `json:"smcInitImage,omitempty"`
+	EnableInitContainerInject *bool  `json:"enableInitContainerInject,omitempty"`
+	// NodeSelector selects the nodes the controller manages ERIs for.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	WaitNodeReadyTimeoutSeconds *int `json:"waitNodeReadyTimeoutSeconds,omitempty"`
+	// OrphanERIGC configures the collector of orphaned ERIs.
+	OrphanERIGC *OrphanERIGCPolicy `json:"orphanERIGC,omitempty"`
+	// RebalanceQueuePairs splits the queue pairs of the instance evenly between
+	// the ERIs of a node when they change, detaching and reattaching the ERIs
+	// whose queue pair number changes.
+	RebalanceQueuePairs *bool `json:"rebalanceQueuePairs,omitempty"`
+	// VSwitches are the candidate vSwitches of the ERIs created by the
+	// controller by zone ID, the vSwitch of the primary ENI is used in zones
+	// without candidates.
+	VSwitches map[string][]string `json:"vSwitches,omitempty"`
+	// VSwitchSelectionPolicy selects among the candidate vSwitches of a zone
+	// by their available IP addresses: mostAvailableIP picks the vSwitch with
+	// the most, ordered the first one with any.
+	// +kubebuilder:validation:Enum=mostAvailableIP;ordered
+	VSwitchSelectionPolicy *string `json:"vSwitchSelectionPolicy,omitempty"`
+	// EnableIPv6 assigns an IPv6 address to the ERIs created or adopted by the
+	// controller, for dual-stack VPCs.
+	EnableIPv6 *bool `json:"enableIPv6,omitempty"`
+	// ManagedSecurityGroup configures the security group the controller
+	// maintains for the ERIs.
+	ManagedSecurityGroup *ManagedSecurityGroupPolicy `json:"managedSecurityGroup,omitempty"`
+	// DryRun changes nothing in ECS: the ERIs of the nodes without an
+	// ERdmaDevice are only planned into an ERdmaPlan of the node, the existing
+	// ERdmaDevices are not reconciled, orphaned ERIs are only reported and the
+	// security groups are not repaired.
+	DryRun *bool `json:"dryRun,omitempty"`
+}
+
+// ManagedSecurityGroupPolicy configures the security group the controller
+// creates in each VPC of the nodes and joins the ERIs it creates or adopts to.
+// The group allows the traffic between its ERIs plus Rules, other rules are
+// revoked when the group is repaired.
+type ManagedSecurityGroupPolicy struct {
+	// Enabled creates and maintains the security group.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Name is the name of the security group.
+	Name string `json:"name,omitempty"`
+	// Rules are the rules of the group besides the one allowing the traffic
+	// between its ERIs.
+	Rules []SecurityGroupRule `json:"rules,omitempty"`
+	// RepairIntervalSeconds is the interval between two repairs of the rules.
+	// +kubebuilder:validation:Minimum=60
+	RepairIntervalSeconds *int `json:"repairIntervalSeconds,omitempty"`
+}
+
+// SecurityGroupRule is a rule of the managed security group.
+type SecurityGroupRule struct {
+	// Direction is the direction of the traffic, ingress by default.
+	// +kubebuilder:validation:Enum=ingress;egress
+	Direction string `json:"direction,omitempty"`
+	// Protocol is the IP protocol of the traffic.
+	// +kubebuilder:validation:Enum=TCP;UDP;ICMP;GRE;ALL
+	Protocol string `json:"protocol"`
+	// PortRange is the port range, e.g. 22/22, -1/-1 by default for all ports.
+	PortRange string `json:"portRange,omitempty"`
+	// CIDR is the source of an ingress rule or the destination of an egress rule.
+	CIDR string `json:"cidr"`
+	// Policy accepts or drops the traffic, accept by default.
+	// +kubebuilder:validation:Enum=accept;drop
+	Policy string `json:"policy,omitempty"`
+	// Priority is the priority from 1, the default and the highest, to 100.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	Priority    int    `json:"priority,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// OrphanERIGCPolicy configures the collector of the detached ERIs created by
+// the controller which are neither used by an ERdmaDevice nor planned for a
+// node.
+type OrphanERIGCPolicy struct {
+	// Enabled runs the collector.
+	Enabled *bool `json:"enabled,omitempty"`
+	// IntervalSeconds is the interval between two sweeps.
+	// +kubebuilder:validation:Minimum=60
+	IntervalSeconds *int `json:"intervalSeconds,omitempty"`
+	// GracePeriodSeconds is how long an ERI stays orphaned before it is deleted.
+	// +kubebuilder:validation:Minimum=0
+	GracePeriodSeconds *int `json:"gracePeriodSeconds,omitempty"`
+	// ReportOnly only reports the orphaned ERIs instead of deleting them.
+	ReportOnly *bool `json:"reportOnly,omitempty"`
+}
+
+// AgentPolicy are the agent settings, unset fields fall back to the agent
+// command-line flags.
+type AgentPolicy struct {
+	// PreferDriver is the erdma driver flavour to load, empty to detect it.
+	// +kubebuilder:validation:Enum="";default;compat;ofed
+	PreferDriver *string `json:"preferDriver,omitempty"`
+	// JumboFrameMTU is the MTU set on the ERI netdevs when jumbo frame is enabled.
+	// +kubebuilder:validation:Minimum=1500
+	// +kubebuilder:validation:Maximum=9000
+	JumboFrameMTU *int `json:"jumboFrameMTU,omitempty"`
+	// ExposedLocalERIs are the ERIs exposed in local ERI discovery mode, in the
+	// format "<instance_id> <eri-0>/<eri-1>/...".
+	ExposedLocalERIs []string `json:"exposedLocalERIs,omitempty"`
+	// AllocateAllDevices allocates all erdma devices to a pod instead of the
+	// devices on the NUMA node of the pod.
+	AllocateAllDevices *bool `json:"allocateAllDevices,omitempty"`
+	// InstallerVersion is the erdma installer version used to install the driver.
+	InstallerVersion string `json:"installerVersion,omitempty"`
+	// ExclusiveERIs is the number of secondary ERIs of a node advertised as
+	// whole devices of the aliyun/erdma-exclusive resource, their netdev and
+	// rdma device are moved into the network namespace of the pod.
+	// +kubebuilder:validation:Minimum=0
+	ExclusiveERIs *int `json:"exclusiveERIs,omitempty"`
+}
+
+// ERdmaClusterPolicySpec defines the desired settings of the controller and the agents.
+type ERdmaClusterPolicySpec struct {
+	Controller ControllerPolicy `json:"controller,omitempty"`
+	Agent      AgentPolicy      `json:"agent,omitempty"`
+}
+
+// AgentSettings are the effective settings an agent runs with.
+type AgentSettings struct {
+	PreferDriver       string   `json:"preferDriver,omitempty"`
+	JumboFrameMTU      int      `json:"jumboFrameMTU,omitempty"`
+	ExposedLocalERIs   []string `json:"exposedLocalERIs,omitempty"`
+	AllocateAllDevices bool     `json:"allocateAllDevices,omitempty"`
+	InstallerVersion   string   `json:"installerVersion,omitempty"`
+	ExclusiveERIs      int      `json:"exclusiveERIs,omitempty"`
+}
+
+// NodePolicyStatus is the effective agent settings on a node.
+type NodePolicyStatus struct {
+	// NodeName is the name of the node.
+	NodeName string `json:"nodeName"`
+	// PolicyGeneration is the policy generation the agent applied, 0 when the
+	// agent runs with its flags only.
+	PolicyGeneration int64 `json:"policyGeneration,omitempty"`
+	// Settings are the effective settings of the agent.
+	Settings AgentSettings `json:"settings,omitempty"`
+}
+
+// ConditionRestartRequired is True while settings of the policy which only
+// take effect when the controller starts differ from the running ones.
+const ConditionRestartRequired = "RestartRequired"
+
+// ERdmaClusterPolicyStatus defines the observed state of ERdmaClusterPolicy
+type ERdmaClusterPolicyStatus struct {
+	// ObservedGeneration is the policy generation the controller applied.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Nodes are the effective agent settings of each node.
+	Nodes []NodePolicyStatus `json:"nodes,omitempty"`
+	// Conditions describe how the controller applied the policy.
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=erdmaclusterpolicies,scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Observed",type=integer,JSONPath=`.status.observedGeneration`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ERdmaClusterPolicy is the Schema for the erdmaclusterpolicies API
+type ERdmaClusterPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ERdmaClusterPolicySpec   `json:"spec,omitempty"`
+	Status ERdmaClusterPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ERdmaClusterPolicyList contains a list of ERdmaClusterPolicy
+type ERdmaClusterPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ERdmaClusterPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ERdmaClusterPolicy{}, &ERdmaClusterPolicyList{})
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ERISpec is the desired configuration of one ERI on the node.
+type ERISpec struct {
+	// ID is the ID of the ENI backing the ERI.
+	ID string `json:"id"`
+	// NetworkCardIndex is the network card the ERI is attached to.
+	// +kubebuilder:validation:Minimum=0
+	NetworkCardIndex int `json:"networkCardIndex,omitempty"`
+	// QueuePair is the queue pair number of the ERI.
+	// +kubebuilder:validation:Minimum=0
+	QueuePair int `json:"queuePair,omitempty"`
+	// PrimaryENI is set when the ERI is the primary ENI of the instance converted to RDMA traffic mode.
+	PrimaryENI bool `json:"primaryENI,omitempty"`
+}
+
+// ERdmaDeviceSpec defines the desired ERI layout of a node.
+type ERdmaDeviceSpec struct {
+	// InstanceID is the ECS instance of the node.
+	InstanceID string `json:"instanceID,omitempty"`
+	// InstanceType is the ECS instance type the ERI layout is planned for, the
+	// layout is planned again when the instance type of the node changes.
+	InstanceType string `json:"instanceType,omitempty"`
+	// ERIs is the desired ERI layout of the node.
+	ERIs []ERISpec `json:"eris"`
+	// JumboFrame enables jumbo frame MTU on the ERI netdevs.
+	JumboFrame bool `json:"jumboFrame,omitempty"`
+	// Profile is the ERdmaNodeProfile the ERI layout is planned with.
+	Profile string `json:"profile,omitempty"`
+	// Driver is the erdma driver flavour the agent loads, empty for the agent settings.
+	// The agent switches the driver at runtime, reloading the erdma module when it is
+	// not in use.
+	// +kubebuilder:validation:Enum="";default;compat;ofed
+	Driver string `json:"driver,omitempty"`
+	// JumboFrameMTU is the jumbo frame MTU the agent sets, 0 for the agent settings.
+	JumboFrameMTU int `json:"jumboFrameMTU,omitempty"`
+}
+
+// ERIPhase is the cloud-side state of an ERI.
+// +kubebuilder:validation:Enum=Pending;Ready;Failed;Releasing;Released;Retained;Rebalancing
+type ERIPhase string
+
+const (
+	ERIPhasePending ERIPhase = "Pending"
+	ERIPhaseReady   ERIPhase = "Ready"
+	ERIPhaseFailed  ERIPhase = "Failed"
+	// ERIPhaseReleasing is an ERI created by the controller being detached and
+	// deleted after the ERdmaDevice is deleted.
+	ERIPhaseReleasing ERIPhase = "Releasing"
+	// ERIPhaseReleased is an ERI created by the controller which is deleted.
+	ERIPhaseReleased ERIPhase = "Released"
+	// ERIPhaseRetained is an adopted or primary ENI kept after the ERdmaDevice
+	// is deleted.
+	ERIPhaseRetained ERIPhase = "Retained"
+	// ERIPhaseRebalancing is an ERI detached to change its queue pair number,
+	// it is attached again once the change is applied.
+	ERIPhaseRebalancing ERIPhase = "Rebalancing"
+)
+
+// ERIStatus is the cloud-side state of an ERI as observed by the controller.
+type ERIStatus struct {
+	// ID is the ID of the ENI backing the ERI.
+	ID string `json:"id"`
+	// MAC is the MAC address of the ENI.
+	MAC string `json:"mac,omitempty"`
+	// Phase is the attach state of the ERI.
+	Phase ERIPhase `json:"phase,omitempty"`
+	// QueuePair is the queue pair number the ENI currently has.
+	QueuePair int `json:"queuePair,omitempty"`
+	// Message is a human readable detail of the phase.
+	Message string `json:"message,omitempty"`
+	// VSwitchID is the vSwitch of the ENI.
+	VSwitchID string `json:"vSwitchID,omitempty"`
+	// IP is the primary private IPv4 address of the ENI.
+	IP string `json:"ip,omitempty"`
+	// IPv6 is the IPv6 address of the ENI, if any.
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+// QueuePairChange is a change of the queue pair number of an ERI.
+type QueuePairChange struct {
+	// ID is the ID of the ENI backing the ERI.
+	ID string `json:"id"`
+	// From is the queue pair number before the change.
+	From int `json:"from"`
+	// To is the queue pair number after the change.
+	To int `json:"to"`
+}
+
+// ERdmaCapability is a feature supported by an erdma device.
+// +kubebuilder:validation:Enum=RDMA_CM;SMC_R;VERBS;GDR;OOB
+type ERdmaCapability string
+
+const (
+	ERdmaCapabilityRDMACM ERdmaCapability = "RDMA_CM"
+	ERdmaCapabilitySMCR   ERdmaCapability = "SMC_R"
+	ERdmaCapabilityVerbs  ERdmaCapability = "VERBS"
+	ERdmaCapabilityGDR    ERdmaCapability = "GDR"
+	ERdmaCapabilityOOB    ERdmaCapability = "OOB"
+)
+
+// NodeDeviceStatus is the node-side state of an ERI as observed by the agent.
+type NodeDeviceStatus struct {
+	// ID is the ID of the ENI backing the ERI.
+	ID string `json:"id"`
+	// MAC is the MAC address of the netdev.
+	MAC string `json:"mac,omitempty"`
+	// RdmaDevice is the name of the rdma device, e.g. erdma_0.
+	RdmaDevice string `json:"rdmaDevice,omitempty"`
+	// NetDevice is the name of the netdev, e.g. eth1.
+	NetDevice string `json:"netDevice,omitempty"`
+	// DevPaths are the character devices exposed to pods.
+	DevPaths []string `json:"devPaths,omitempty"`
+	// NUMA is the NUMA node of the device.
+	NUMA int64 `json:"numa,omitempty"`
+	// Capabilities are the features the device supports with the loaded driver.
+	Capabilities []ERdmaCapability `json:"capabilities,omitempty"`
+	// MTU is the MTU of the netdev.
+	MTU int `json:"mtu,omitempty"`
+	// IP is the IPv4 address of the netdev.
+	IP string `json:"ip,omitempty"`
+}
+
+// NodeStatus is the node-side state of the ERIs, written by the agent.
+type NodeStatus struct {
+	// Driver is the erdma driver flavour the agent loaded.
+	Driver string `json:"driver,omitempty"`
+	// InstallerVersion is the erdma installer version the agent installed the driver with.
+	InstallerVersion string `json:"installerVersion,omitempty"`
+	// Devices is the node-side state of each ERI.
+	Devices []NodeDeviceStatus `json:"devices,omitempty"`
+	// PolicyGeneration is the ERdmaClusterPolicy generation the agent applied.
+	PolicyGeneration int64 `json:"policyGeneration,omitempty"`
+	// Settings are the effective settings of the agent.
+	Settings *AgentSettings `json:"settings,omitempty"`
+}
+
+// Condition types reported on ERdmaDevice.
+const (
+	ConditionDriverInstalled        = "DriverInstalled"
+	ConditionDevicesProbed          = "DevicesProbed"
+	ConditionNetdevConfigured       = "NetdevConfigured"
+	ConditionDevicePluginRegistered = "DevicePluginRegistered"
+	ConditionSMCRConfigured         = "SMCRConfigured"
+	// ConditionDriverModeApplied is False while the switch to the driver of
+	// the spec is deferred because the erdma module is in use.
+	ConditionDriverModeApplied = "DriverModeApplied"
+	// ConditionERIsReleased is reported by the controller while it releases
+	// the ERIs of a deleted ERdmaDevice.
+	ConditionERIsReleased = "ERIsReleased"
+	// ConditionDevicesReleased is reported by the agent once it unconfigured
+	// the netdevs and stopped the device plugins of a deleted ERdmaDevice.
+	ConditionDevicesReleased = "DevicesReleased"
+	// ConditionQueuePairsBalanced is reported by the controller when it
+	// rebalances the queue pairs of the ERIs, it is False while the queue pair
+	// numbers of the ERIs differ from the spec.
+	ConditionQueuePairsBalanced = "QueuePairsBalanced"
+)
+
+// ERdmaDeviceStatus defines the observed state of ERdmaDevice
+type ERdmaDeviceStatus struct {
+	// ObservedGeneration is the spec generation the agent last applied on the node.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ERIs is the cloud-side state of each ERI, written by the controller.
+	ERIs []ERIStatus `json:"eris,omitempty"`
+	// QueuePairChanges are the queue pair changes of the ERIs planned by the
+	// last rebalance of the controller.
+	QueuePairChanges []QueuePairChange `json:"queuePairChanges,omitempty"`
+	// Node is the node-side state of the ERIs, written by the agent.
+	Node NodeStatus `json:"node,omitempty"`
+	// Conditions describe the progress of the ERIs on the node.
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=erdmadevices,scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="Instance",type=string,JSONPath=`.spec.instanceID`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ERdmaDevice is the Schema for the erdmadevices API
+type ERdmaDevice struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ERdmaDeviceSpec   `json:"spec,omitempty"`
+	Status ERdmaDeviceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ERdmaDeviceList contains a list of ERdmaDevice
+type ERdmaDeviceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ERdmaDevice `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ERdmaDevice{}, &ERdmaDeviceList{})
+}
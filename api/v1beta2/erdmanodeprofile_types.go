@@ -0,0 +1,86 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// QueuePairWeight is the share of the instance queue pairs given to the ERI
+// on a network card.
+type QueuePairWeight struct {
+	// +kubebuilder:validation:Minimum=0
+	NetworkCardIndex int `json:"networkCardIndex"`
+	// +kubebuilder:validation:Minimum=1
+	Weight int `json:"weight"`
+}
+
+// ERdmaNodeProfileSpec defines the ERI layout and agent settings of a group of nodes.
+type ERdmaNodeProfileSpec struct {
+	// NodeSelector selects the nodes of the profile, an empty selector selects all nodes.
+	NodeSelector metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// Priority orders the profiles selecting the same node, the highest wins and
+	// ties are broken by name.
+	Priority int32 `json:"priority,omitempty"`
+	// ERICount is the maximum number of ERIs of a node, unset for one ERI per
+	// network card up to the ERI quantity of the instance type.
+	// +kubebuilder:validation:Minimum=1
+	ERICount *int `json:"eriCount,omitempty"`
+	// NetworkCards are the network card indexes to place ERIs on, unset for all
+	// network cards of the instance type.
+	NetworkCards []int `json:"networkCards,omitempty"`
+	// QueuePairWeights split the queue pairs of the instance between the network
+	// cards by weight, cards not listed have weight 1.
+	QueuePairWeights []QueuePairWeight `json:"queuePairWeights,omitempty"`
+	// AllowPrimaryENIConversion allows converting the primary ENI to RDMA traffic
+	// mode as the ERI of network card 0, defaults to true.
+	AllowPrimaryENIConversion *bool `json:"allowPrimaryENIConversion,omitempty"`
+	// Driver is the erdma driver flavour the agents load, unset for the agent settings.
+	// +kubebuilder:validation:Enum="";default;compat;ofed
+	Driver string `json:"driver,omitempty"`
+	// JumboFrameMTU is the MTU set on the ERI netdevs when jumbo frame is enabled,
+	// unset for the agent settings.
+	// +kubebuilder:validation:Minimum=1500
+	// +kubebuilder:validation:Maximum=9000
+	JumboFrameMTU *int `json:"jumboFrameMTU,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=erdmanodeprofiles,scope=Cluster
+// +kubebuilder:printcolumn:name="Priority",type=integer,JSONPath=`.spec.priority`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ERdmaNodeProfile is the Schema for the erdmanodeprofiles API
+type ERdmaNodeProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ERdmaNodeProfileSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ERdmaNodeProfileList contains a list of ERdmaNodeProfile
+type ERdmaNodeProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ERdmaNodeProfile `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ERdmaNodeProfile{}, &ERdmaNodeProfileList{})
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ERIAction is what the controller would do for an ERI of a node.
+type ERIAction string
+
+const (
+	// ERIActionAdopt adopts an existing ERI of the instance.
+	ERIActionAdopt ERIAction = "Adopt"
+	// ERIActionConvertPrimaryENI converts the primary ENI to RDMA traffic mode.
+	ERIActionConvertPrimaryENI ERIAction = "ConvertPrimaryENI"
+	// ERIActionCreate creates an ERI and attaches it to the instance.
+	ERIActionCreate ERIAction = "Create"
+)
+
+// PlannedERI is an ERI the controller would set up for a node.
+type PlannedERI struct {
+	Action ERIAction `json:"action"`
+	// ID is the ENI ID, empty for an ERI to create.
+	ID               string `json:"id,omitempty"`
+	NetworkCardIndex int    `json:"networkCardIndex"`
+	QueuePair        int    `json:"queuePair,omitempty"`
+	PrimaryENI       bool   `json:"primaryENI,omitempty"`
+	// VSwitchID is the vSwitch of an ERI to create.
+	VSwitchID string `json:"vSwitchID,omitempty"`
+}
+
+// ERdmaPlanStatus is the plan of the ERIs of a node.
+type ERdmaPlanStatus struct {
+	InstanceID   string `json:"instanceID,omitempty"`
+	InstanceType string `json:"instanceType,omitempty"`
+	// QueuePairCount is the queue pair number of the instance split between
+	// its ERIs.
+	QueuePairCount int          `json:"queuePairCount,omitempty"`
+	ERIs           []PlannedERI `json:"eris,omitempty"`
+	// Message tells why no ERI is planned, e.g. the instance type has no ERI
+	// support or the plan failed.
+	Message string `json:"message,omitempty"`
+	// PlanTime is when the plan was computed.
+	PlanTime metav1.Time `json:"planTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=erdmaplans,scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Instance",type=string,JSONPath=`.status.instanceID`
+// +kubebuilder:printcolumn:name="PlanTime",type=date,JSONPath=`.status.planTime`
+
+// ERdmaPlan is the plan of the ERIs the controller would set up for the node
+// of the same name in dry-run mode, nothing is changed in ECS.
+type ERdmaPlan struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status ERdmaPlanStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ERdmaPlanList contains a list of ERdmaPlan
+type ERdmaPlanList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ERdmaPlan `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ERdmaPlan{}, &ERdmaPlanList{})
+}
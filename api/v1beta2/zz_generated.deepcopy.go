@@ -0,0 +1,797 @@
+//go:build !ignore_autogenerated
+
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta2
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AgentPolicy) DeepCopyInto(out *AgentPolicy) {
+	*out = *in
+	if in.PreferDriver != nil {
+		in, out := &in.PreferDriver, &out.PreferDriver
+		*out = new(string)
+		**out = **in
+	}
+	if in.JumboFrameMTU != nil {
+		in, out := &in.JumboFrameMTU, &out.JumboFrameMTU
+		*out = new(int)
+		**out = **in
+	}
+	if in.ExposedLocalERIs != nil {
+		in, out := &in.ExposedLocalERIs, &out.ExposedLocalERIs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllocateAllDevices != nil {
+		in, out := &in.AllocateAllDevices, &out.AllocateAllDevices
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ExclusiveERIs != nil {
+		in, out := &in.ExclusiveERIs, &out.ExclusiveERIs
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentPolicy.
+func (in *AgentPolicy) DeepCopy() *AgentPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(AgentPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AgentSettings) DeepCopyInto(out *AgentSettings) {
+	*out = *in
+	if in.ExposedLocalERIs != nil {
+		in, out := &in.ExposedLocalERIs, &out.ExposedLocalERIs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentSettings.
+func (in *AgentSettings) DeepCopy() *AgentSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(AgentSettings)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControllerPolicy) DeepCopyInto(out *ControllerPolicy) {
+	*out = *in
+	if in.ManageNonOwnedERIs != nil {
+		in, out := &in.ManageNonOwnedERIs, &out.ManageNonOwnedERIs
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EnableDevicePlugin != nil {
+		in, out := &in.EnableDevicePlugin, &out.EnableDevicePlugin
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EnableWebhook != nil {
+		in, out := &in.EnableWebhook, &out.EnableWebhook
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EnableInitContainerInject != nil {
+		in, out := &in.EnableInitContainerInject, &out.EnableInitContainerInject
+		*out = new(bool)
+		**out = **in
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.WaitNodeReadyTimeoutSeconds != nil {
+		in, out := &in.WaitNodeReadyTimeoutSeconds, &out.WaitNodeReadyTimeoutSeconds
+		*out = new(int)
+		**out = **in
+	}
+	if in.OrphanERIGC != nil {
+		in, out := &in.OrphanERIGC, &out.OrphanERIGC
+		*out = new(OrphanERIGCPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RebalanceQueuePairs != nil {
+		in, out := &in.RebalanceQueuePairs, &out.RebalanceQueuePairs
+		*out = new(bool)
+		**out = **in
+	}
+	if in.VSwitches != nil {
+		in, out := &in.VSwitches, &out.VSwitches
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.VSwitchSelectionPolicy != nil {
+		in, out := &in.VSwitchSelectionPolicy, &out.VSwitchSelectionPolicy
+		*out = new(string)
+		**out = **in
+	}
+	if in.EnableIPv6 != nil {
+		in, out := &in.EnableIPv6, &out.EnableIPv6
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ManagedSecurityGroup != nil {
+		in, out := &in.ManagedSecurityGroup, &out.ManagedSecurityGroup
+		*out = new(ManagedSecurityGroupPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DryRun != nil {
+		in, out := &in.DryRun, &out.DryRun
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControllerPolicy.
+func (in *ControllerPolicy) DeepCopy() *ControllerPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ControllerPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERISpec) DeepCopyInto(out *ERISpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERISpec.
+func (in *ERISpec) DeepCopy() *ERISpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ERISpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERIStatus) DeepCopyInto(out *ERIStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERIStatus.
+func (in *ERIStatus) DeepCopy() *ERIStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ERIStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaClusterPolicy) DeepCopyInto(out *ERdmaClusterPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaClusterPolicy.
+func (in *ERdmaClusterPolicy) DeepCopy() *ERdmaClusterPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaClusterPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ERdmaClusterPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaClusterPolicyList) DeepCopyInto(out *ERdmaClusterPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ERdmaClusterPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaClusterPolicyList.
+func (in *ERdmaClusterPolicyList) DeepCopy() *ERdmaClusterPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaClusterPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ERdmaClusterPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaClusterPolicySpec) DeepCopyInto(out *ERdmaClusterPolicySpec) {
+	*out = *in
+	in.Controller.DeepCopyInto(&out.Controller)
+	in.Agent.DeepCopyInto(&out.Agent)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaClusterPolicySpec.
+func (in *ERdmaClusterPolicySpec) DeepCopy() *ERdmaClusterPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaClusterPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaClusterPolicyStatus) DeepCopyInto(out *ERdmaClusterPolicyStatus) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodePolicyStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaClusterPolicyStatus.
+func (in *ERdmaClusterPolicyStatus) DeepCopy() *ERdmaClusterPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaClusterPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaDevice) DeepCopyInto(out *ERdmaDevice) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaDevice.
+func (in *ERdmaDevice) DeepCopy() *ERdmaDevice {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaDevice)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ERdmaDevice) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaDeviceList) DeepCopyInto(out *ERdmaDeviceList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ERdmaDevice, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaDeviceList.
+func (in *ERdmaDeviceList) DeepCopy() *ERdmaDeviceList {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaDeviceList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ERdmaDeviceList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaDeviceSpec) DeepCopyInto(out *ERdmaDeviceSpec) {
+	*out = *in
+	if in.ERIs != nil {
+		in, out := &in.ERIs, &out.ERIs
+		*out = make([]ERISpec, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaDeviceSpec.
+func (in *ERdmaDeviceSpec) DeepCopy() *ERdmaDeviceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaDeviceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaDeviceStatus) DeepCopyInto(out *ERdmaDeviceStatus) {
+	*out = *in
+	if in.ERIs != nil {
+		in, out := &in.ERIs, &out.ERIs
+		*out = make([]ERIStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.QueuePairChanges != nil {
+		in, out := &in.QueuePairChanges, &out.QueuePairChanges
+		*out = make([]QueuePairChange, len(*in))
+		copy(*out, *in)
+	}
+	in.Node.DeepCopyInto(&out.Node)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaDeviceStatus.
+func (in *ERdmaDeviceStatus) DeepCopy() *ERdmaDeviceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaDeviceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaNodeProfile) DeepCopyInto(out *ERdmaNodeProfile) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaNodeProfile.
+func (in *ERdmaNodeProfile) DeepCopy() *ERdmaNodeProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaNodeProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ERdmaNodeProfile) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaNodeProfileList) DeepCopyInto(out *ERdmaNodeProfileList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ERdmaNodeProfile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaNodeProfileList.
+func (in *ERdmaNodeProfileList) DeepCopy() *ERdmaNodeProfileList {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaNodeProfileList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ERdmaNodeProfileList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaNodeProfileSpec) DeepCopyInto(out *ERdmaNodeProfileSpec) {
+	*out = *in
+	in.NodeSelector.DeepCopyInto(&out.NodeSelector)
+	if in.ERICount != nil {
+		in, out := &in.ERICount, &out.ERICount
+		*out = new(int)
+		**out = **in
+	}
+	if in.NetworkCards != nil {
+		in, out := &in.NetworkCards, &out.NetworkCards
+		*out = make([]int, len(*in))
+		copy(*out, *in)
+	}
+	if in.QueuePairWeights != nil {
+		in, out := &in.QueuePairWeights, &out.QueuePairWeights
+		*out = make([]QueuePairWeight, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowPrimaryENIConversion != nil {
+		in, out := &in.AllowPrimaryENIConversion, &out.AllowPrimaryENIConversion
+		*out = new(bool)
+		**out = **in
+	}
+	if in.JumboFrameMTU != nil {
+		in, out := &in.JumboFrameMTU, &out.JumboFrameMTU
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaNodeProfileSpec.
+func (in *ERdmaNodeProfileSpec) DeepCopy() *ERdmaNodeProfileSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaNodeProfileSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaPlan) DeepCopyInto(out *ERdmaPlan) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaPlan.
+func (in *ERdmaPlan) DeepCopy() *ERdmaPlan {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaPlan)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ERdmaPlan) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaPlanList) DeepCopyInto(out *ERdmaPlanList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ERdmaPlan, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaPlanList.
+func (in *ERdmaPlanList) DeepCopy() *ERdmaPlanList {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaPlanList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ERdmaPlanList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ERdmaPlanStatus) DeepCopyInto(out *ERdmaPlanStatus) {
+	*out = *in
+	if in.ERIs != nil {
+		in, out := &in.ERIs, &out.ERIs
+		*out = make([]PlannedERI, len(*in))
+		copy(*out, *in)
+	}
+	in.PlanTime.DeepCopyInto(&out.PlanTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ERdmaPlanStatus.
+func (in *ERdmaPlanStatus) DeepCopy() *ERdmaPlanStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ERdmaPlanStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagedSecurityGroupPolicy) DeepCopyInto(out *ManagedSecurityGroupPolicy) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]SecurityGroupRule, len(*in))
+		copy(*out, *in)
+	}
+	if in.RepairIntervalSeconds != nil {
+		in, out := &in.RepairIntervalSeconds, &out.RepairIntervalSeconds
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagedSecurityGroupPolicy.
+func (in *ManagedSecurityGroupPolicy) DeepCopy() *ManagedSecurityGroupPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagedSecurityGroupPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeDeviceStatus) DeepCopyInto(out *NodeDeviceStatus) {
+	*out = *in
+	if in.DevPaths != nil {
+		in, out := &in.DevPaths, &out.DevPaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Capabilities != nil {
+		in, out := &in.Capabilities, &out.Capabilities
+		*out = make([]ERdmaCapability, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeDeviceStatus.
+func (in *NodeDeviceStatus) DeepCopy() *NodeDeviceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeDeviceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePolicyStatus) DeepCopyInto(out *NodePolicyStatus) {
+	*out = *in
+	in.Settings.DeepCopyInto(&out.Settings)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePolicyStatus.
+func (in *NodePolicyStatus) DeepCopy() *NodePolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeStatus) DeepCopyInto(out *NodeStatus) {
+	*out = *in
+	if in.Devices != nil {
+		in, out := &in.Devices, &out.Devices
+		*out = make([]NodeDeviceStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Settings != nil {
+		in, out := &in.Settings, &out.Settings
+		*out = new(AgentSettings)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeStatus.
+func (in *NodeStatus) DeepCopy() *NodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrphanERIGCPolicy) DeepCopyInto(out *OrphanERIGCPolicy) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.IntervalSeconds != nil {
+		in, out := &in.IntervalSeconds, &out.IntervalSeconds
+		*out = new(int)
+		**out = **in
+	}
+	if in.GracePeriodSeconds != nil {
+		in, out := &in.GracePeriodSeconds, &out.GracePeriodSeconds
+		*out = new(int)
+		**out = **in
+	}
+	if in.ReportOnly != nil {
+		in, out := &in.ReportOnly, &out.ReportOnly
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrphanERIGCPolicy.
+func (in *OrphanERIGCPolicy) DeepCopy() *OrphanERIGCPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(OrphanERIGCPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlannedERI) DeepCopyInto(out *PlannedERI) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlannedERI.
+func (in *PlannedERI) DeepCopy() *PlannedERI {
+	if in == nil {
+		return nil
+	}
+	out := new(PlannedERI)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QueuePairChange) DeepCopyInto(out *QueuePairChange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QueuePairChange.
+func (in *QueuePairChange) DeepCopy() *QueuePairChange {
+	if in == nil {
+		return nil
+	}
+	out := new(QueuePairChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QueuePairWeight) DeepCopyInto(out *QueuePairWeight) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QueuePairWeight.
+func (in *QueuePairWeight) DeepCopy() *QueuePairWeight {
+	if in == nil {
+		return nil
+	}
+	out := new(QueuePairWeight)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecurityGroupRule) DeepCopyInto(out *SecurityGroupRule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecurityGroupRule.
+func (in *SecurityGroupRule) DeepCopy() *SecurityGroupRule {
+	if in == nil {
+		return nil
+	}
+	out := new(SecurityGroupRule)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"flag"
+	"os"
 
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/agent"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
+var setupLog = ctrl.Log.WithName("setup")
+
 func main() {
 	opts := zap.Options{
 		Development: true,
@@ -23,6 +26,9 @@ func main() {
 		exposedLocalERIs      string
 		erdmaInstallerVersion string
 		jumboFrameMTU         int
+		exclusiveERIs         int
+		metricsAddr           string
+		notReadyTaint         bool
 	)
 	flag.StringVar(&preferDriver, "prefer-driver", "", "prefer driver")
 	flag.BoolVar(&allocAllDevices, "allocate-all-devices", false,
@@ -37,6 +43,12 @@ func main() {
 		"erdma installer version")
 	flag.IntVar(&jumboFrameMTU, "jumbo-frame-mtu", 8500,
 		"MTU value to set on ERDMA network interfaces when jumbo frame is enabled")
+	flag.IntVar(&exclusiveERIs, "exclusive-eris", 0,
+		"Number of secondary ERIs allocated as a whole to pods as the aliyun/erdma-exclusive resource")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", "0",
+		"The address the erdma device counters are served on, use :9302 for example. Use 0 to disable the metrics service.")
+	flag.BoolVar(&notReadyTaint, "not-ready-taint", false,
+		"Taint the node with network.alibabacloud.com/erdma-not-ready again when its erdma devices fail")
 	flag.Parse()
 
 	eriAgent, err := agent.NewAgent(
@@ -47,12 +59,16 @@ func main() {
 		exposedLocalERIs,
 		erdmaInstallerVersion,
 		jumboFrameMTU,
+		exclusiveERIs,
+		metricsAddr,
+		notReadyTaint,
 	)
 	if err != nil {
-		panic(err)
+		setupLog.Error(err, "unable to create erdma agent")
+		os.Exit(1)
 	}
-	err = eriAgent.Run()
-	if err != nil {
-		panic(err)
+	if err = eriAgent.Run(); err != nil {
+		setupLog.Error(err, "problem running erdma agent")
+		os.Exit(1)
 	}
 }
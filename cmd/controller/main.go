@@ -25,13 +25,18 @@ import (
 
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/config"
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/consts"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/events"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/fakeecs"
 	erdmaWebhook "github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -43,6 +48,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	networkv1 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1"
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/controller"
 	// +kubebuilder:scaffold:imports
 )
@@ -55,7 +61,10 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
+
 	utilruntime.Must(networkv1.AddToScheme(scheme))
+	utilruntime.Must(networkv1beta2.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -66,9 +75,13 @@ func main() {
 	var secureMetrics bool
 	var enableHTTP2 bool
 	var configPath, credentialPath string
+	var fakeECSState string
+	var plansAddr string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&plansAddr, "plans-bind-address", ":8082", "The address the erdma plans of the nodes in "+
+		"dry-run mode are served on, at /debug/erdma-plans. Use 0 to disable it.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", true,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -78,6 +91,9 @@ func main() {
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
 	flag.StringVar(&configPath, "config-path", "", "The path to the config file")
 	flag.StringVar(&credentialPath, "credential-path", "", "The path to the credential file")
+	flag.StringVar(&fakeECSState, "fake-ecs-state", "",
+		"If set, ECS is simulated in memory from the instances and instance types in this file instead of calling "+
+			"Alibaba Cloud, for testing only")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -100,18 +116,32 @@ func main() {
 		os.Exit(1)
 	}
 
-	var webhookServer webhook.Server
-	if config.GetConfig().EnableWebhook != nil && *config.GetConfig().EnableWebhook {
-		err = cert.SyncCert(context.Background(), directClient, config.GetConfig().ControllerNamespace,
-			config.GetConfig().ControllerName, config.GetConfig().ClusterDomain, config.GetConfig().CertDir)
-		if err != nil {
-			panic(err)
-		}
-		webhookServer = webhook.NewServer(webhook.Options{
-			CertDir: config.GetConfig().CertDir,
-		})
+	// settings in the cluster policy which are only read at startup must be
+	// applied before they are used, the rest is applied live by the
+	// cluster policy controller
+	policy := &networkv1beta2.ERdmaClusterPolicy{}
+	err = directClient.Get(context.Background(), client.ObjectKey{Name: networkv1beta2.DefaultClusterPolicyName}, policy)
+	switch {
+	case err == nil:
+		config.InitClusterPolicy(&policy.Spec.Controller)
+	case errors.IsNotFound(err):
+		config.InitClusterPolicy(nil)
+	default:
+		setupLog.Error(err, "unable to get cluster policy")
+		os.Exit(1)
 	}
 
+	// the webhook server always runs, it serves the ERdmaDevice conversion
+	// webhook, the admission webhooks only act when enabled
+	err = cert.SyncCert(context.Background(), directClient, config.GetConfig().ControllerNamespace,
+		config.GetConfig().ControllerName, config.GetConfig().ClusterDomain, config.GetConfig().CertDir)
+	if err != nil {
+		panic(err)
+	}
+	webhookServer := webhook.NewServer(webhook.Options{
+		CertDir: config.GetConfig().CertDir,
+	})
+
 	// Metrics endpoint is enabled in 'config/default/kustomization.yaml'. The Metrics options configure the server.
 	// More info:
 	// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.19.0/pkg/metrics/server
@@ -153,31 +183,89 @@ func main() {
 		os.Exit(1)
 	}
 
-	eriClient, err := controller.NewEriClient(directClient)
-	if err != nil {
-		setupLog.Error(err, "cannot start eri client")
-		os.Exit(1)
+	var eriClient *controller.EriClient
+	if fakeECSState != "" {
+		state, err := fakeecs.LoadState(fakeECSState)
+		if err != nil {
+			setupLog.Error(err, "cannot load fake ecs state")
+			os.Exit(1)
+		}
+		setupLog.Info("using the in-memory fake ecs backend", "state", fakeECSState)
+		eriClient = controller.NewEriClientWithECS(fakeecs.New(state), config.GetConfig().Region)
+	} else {
+		eriClient, err = controller.NewEriClient(directClient)
+		if err != nil {
+			setupLog.Error(err, "cannot start eri client")
+			os.Exit(1)
+		}
 	}
 
+	// the reconcilers share the recorder, so that an Event is recorded once
+	recorder := events.NewRecorder(mgr.GetEventRecorderFor("erdma-controller"))
 	if err = (&controller.ERdmaDeviceReconciler{
 		Client:    mgr.GetClient(),
 		Scheme:    mgr.GetScheme(),
 		EriClient: eriClient,
+		Recorder:  recorder,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ERdmaDevice")
 		os.Exit(1)
 	}
 
+	configEvents := make(chan event.GenericEvent, 1)
+	if err = (&controller.ClusterPolicyReconciler{
+		Client:       mgr.GetClient(),
+		ConfigEvents: configEvents,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ERdmaClusterPolicy")
+		os.Exit(1)
+	}
+	if err = (&controller.ClusterPolicyStatusReconciler{
+		Client: mgr.GetClient(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ERdmaClusterPolicyStatus")
+		os.Exit(1)
+	}
+
 	if err = (&controller.NodeReconciler{
-		Client:     mgr.GetClient(),
-		Scheme:     mgr.GetScheme(),
-		EriClient:  eriClient,
-		CtrlConfig: config.GetConfig(),
+		Client:       mgr.GetClient(),
+		Scheme:       mgr.GetScheme(),
+		EriClient:    eriClient,
+		ConfigEvents: configEvents,
+		Recorder:     recorder,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Node")
 		os.Exit(1)
 	}
 
+	if err = mgr.Add(&controller.StorageVersionMigrator{
+		Client: directClient,
+	}); err != nil {
+		setupLog.Error(err, "unable to add storage version migrator")
+		os.Exit(1)
+	}
+
+	if err = mgr.Add(controller.NewOrphanERICollector(mgr.GetClient(), eriClient)); err != nil {
+		setupLog.Error(err, "unable to add orphaned eri collector")
+		os.Exit(1)
+	}
+
+	if err = mgr.Add(controller.NewSecurityGroupRepairer(eriClient)); err != nil {
+		setupLog.Error(err, "unable to add security group repairer")
+		os.Exit(1)
+	}
+
+	if plansAddr != "0" {
+		if err = mgr.Add(controller.NewPlanServer(plansAddr, mgr.GetClient())); err != nil {
+			setupLog.Error(err, "unable to add erdma plan server")
+			os.Exit(1)
+		}
+	}
+
+	if err = erdmaWebhook.SetupConversionWebhook(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "ERdmaDevice")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -189,9 +277,10 @@ func main() {
 		os.Exit(1)
 	}
 
-	if webhookServer != nil {
-		mgr.GetWebhookServer().Register("/mutating", erdmaWebhook.MutatingHook(mgr.GetClient()))
-	}
+	// the hooks check enableWebhook on each request, so that they can be
+	// switched by the cluster policy
+	mgr.GetWebhookServer().Register("/mutating", erdmaWebhook.MutatingHook(mgr.GetClient()))
+	mgr.GetWebhookServer().Register("/validating", erdmaWebhook.ValidatingHook(eriClient))
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -1,35 +1,73 @@
 package agent
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"reflect"
 	"runtime"
+	"slices"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/deviceplugin"
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/drivers"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/events"
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/k8s"
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
 	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/api/meta"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 
-	networkv1 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1"
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
 )
 
 var (
 	agentLog = ctrl.Log.WithName("Agent")
 )
 
+// applyRetryInterval is the interval to retry applying the settings when the
+// last apply failed or the driver switch was deferred.
+const applyRetryInterval = time.Minute
+
 type Agent struct {
 	kubernetes           k8s.Kubernetes
+	events               *events.Recorder
 	driver               drivers.ERdmaDriver
-	allocAllDevices      bool
 	devicepluginPreStart bool
 	localERIDiscovery    bool
-	exposedLocalERIs     []string
-	jumboFrameMTU        int
+	// flagSettings are the settings from the command-line flags, the cluster
+	// policy overrides them.
+	flagSettings networkv1beta2.AgentSettings
+	// settings are the settings the agent currently runs with.
+	settings         networkv1beta2.AgentSettings
+	policyGeneration int64
+
+	eriInfos *networkv1beta2.ERdmaDevice
+	// deleted is set while the ERdmaDevice of the node is deleted, e.g. when
+	// the node opts out, released once its devices are released.
+	deleted  bool
+	released bool
+	// eris are the ERIs the devices are set up for, see attachedERIs.
+	eris         []string
+	devicePlugin *deviceplugin.ERDMADevicePlugin
+	// exclusivePlugin advertises the exclusive ERIs, nil when there are none.
+	exclusivePlugin *deviceplugin.ERDMADevicePlugin
+	exclusiveNetns  *deviceplugin.ExclusiveNetns
+	pluginStop      chan struct{}
+
+	// metricsBindAddress is the address the counters of the erdma devices
+	// are served on, "0" disables it.
+	metricsBindAddress string
+	counters           *counterCollector
+	podResources       *podResourceCollector
+	// notReadyTaint taints the node again when its erdma devices fail, the
+	// taint is removed once they are ready anyway.
+	notReadyTaint bool
 }
 
 func stackTriger() {
@@ -55,7 +93,7 @@ func stackTriger() {
 	signal.Notify(sigchain, syscall.SIGUSR1)
 }
 
-func NewAgent(preferDriver string, allocAllDevice bool, devicepluginPreStart bool, localERIDiscovery bool, exposedLocalERIs string, erdmaInstallerVersion string, jumboFrameMTU int) (*Agent, error) {
+func NewAgent(preferDriver string, allocAllDevice bool, devicepluginPreStart bool, localERIDiscovery bool, exposedLocalERIs string, erdmaInstallerVersion string, jumboFrameMTU int, exclusiveERIs int, metricsBindAddress string, notReadyTaint bool) (*Agent, error) {
 	kubernetes, err := k8s.NewKubernetes()
 	if err != nil {
 		return nil, err
@@ -63,92 +101,477 @@ func NewAgent(preferDriver string, allocAllDevice bool, devicepluginPreStart boo
 	agentLog.Info("NewAgent: ", "localERIDiscovery", localERIDiscovery, "erdmaInstallerVersion", erdmaInstallerVersion, "jumboFrameMTU", jumboFrameMTU)
 	return &Agent{
 		kubernetes:           kubernetes,
-		driver:               drivers.GetDriver(preferDriver, erdmaInstallerVersion),
-		allocAllDevices:      allocAllDevice,
+		events:               events.NewRecorder(kubernetes.EventRecorder()),
 		devicepluginPreStart: devicepluginPreStart,
 		localERIDiscovery:    localERIDiscovery,
-		exposedLocalERIs:     strings.Split(exposedLocalERIs, ","),
-		jumboFrameMTU:        jumboFrameMTU,
+		metricsBindAddress:   metricsBindAddress,
+		notReadyTaint:        notReadyTaint,
+		counters:             newCounterCollector(),
+		podResources:         newPodResourceCollector(),
+		exclusiveNetns:       deviceplugin.NewExclusiveNetns(),
+		flagSettings: networkv1beta2.AgentSettings{
+			PreferDriver:       preferDriver,
+			JumboFrameMTU:      jumboFrameMTU,
+			ExposedLocalERIs:   strings.Split(exposedLocalERIs, ","),
+			AllocateAllDevices: allocAllDevice,
+			InstallerVersion:   erdmaInstallerVersion,
+			ExclusiveERIs:      exclusiveERIs,
+		},
 	}, nil
 }
 
+// Run sets up the erdma devices with the settings of the cluster policy, and
+// sets them up again whenever the effective settings change. Failures to set
+// them up are retried, it only returns when the ERdmaDevice of the node
+// cannot be read.
 func (a *Agent) Run() error {
 	go stackTriger()
-	var err error
-	var eriInfos *networkv1.ERdmaDevice
-	var eri []*types.ERI
+	if a.metricsBindAddress != "" && a.metricsBindAddress != "0" {
+		go serveMetrics(a.metricsBindAddress, a.counters, a.podResources)
+	}
 	if !a.localERIDiscovery {
 		// 1. wait related eri device
-		eriInfos, err = a.kubernetes.WaitEriInfo()
+		eriInfos, err := a.kubernetes.WaitEriInfo()
 		if err != nil {
 			return err
 		}
-	} else {
-		if !(len(a.exposedLocalERIs) == 1 && a.exposedLocalERIs[0] == "") {
-			a.allocAllDevices = true
+		a.eriInfos = eriInfos
+	}
+
+	policies := make(chan *networkv1beta2.ERdmaClusterPolicy, 1)
+	go a.kubernetes.WatchClusterPolicy(context.Background(), func(policy *networkv1beta2.ERdmaClusterPolicy) {
+		sendLatest(policies, policy)
+	})
+	// the node profile settings are set on the ERdmaDevice by the controller
+	devices := make(chan *networkv1beta2.ERdmaDevice, 1)
+	if !a.localERIDiscovery {
+		go a.kubernetes.WatchERdmaDevice(context.Background(), func(device *networkv1beta2.ERdmaDevice) {
+			sendLatest(devices, device)
+		})
+	}
+
+	policy := <-policies
+	settings, generation := a.effectiveSettings(policy, a.eriInfos)
+	if a.eriInfos != nil && !a.eriInfos.DeletionTimestamp.IsZero() {
+		a.release(a.eriInfos)
+	} else if err := a.apply(settings, generation); err != nil {
+		agentLog.Error(err, "failed to set up erdma devices, will retry")
+	}
+	// settings which failed to apply or a deferred driver switch are retried
+	retry := time.NewTicker(applyRetryInterval)
+	defer retry.Stop()
+	for {
+		select {
+		case policy = <-policies:
+		case device := <-devices:
+			if device == nil || !device.DeletionTimestamp.IsZero() {
+				a.release(device)
+				continue
+			}
+			a.eriInfos = device
+			a.deleted, a.released = false, false
+		case <-retry.C:
+			if a.deleted {
+				a.release(a.eriInfos)
+				continue
+			}
+		}
+		if a.deleted {
+			continue
+		}
+		settings, generation = a.effectiveSettings(policy, a.eriInfos)
+		eris := attachedERIs(a.eriInfos)
+		if reflect.DeepEqual(settings, a.settings) && slices.Equal(eris, a.eris) {
+			if generation != a.policyGeneration {
+				a.policyGeneration = generation
+				a.reportSettings()
+			}
+			continue
+		}
+		agentLog.Info("agent settings or eris changed, set up erdma devices again", "settings", settings, "eris", eris, "policyGeneration", generation)
+		if err := a.apply(settings, generation); err != nil {
+			agentLog.Error(err, "failed to apply agent settings, will retry")
+		}
+	}
+}
+
+// resolveDriver returns the driver for settings. Switching to a driver which
+// loads the erdma module with another compat_mode is deferred while the
+// module is in use: the running driver is kept, settings are returned with its
+// driver setting, and the error tells why.
+func (a *Agent) resolveDriver(settings networkv1beta2.AgentSettings) (drivers.ERdmaDriver, networkv1beta2.AgentSettings, error) {
+	if a.driver != nil && settings.PreferDriver == a.settings.PreferDriver && settings.InstallerVersion == a.settings.InstallerVersion {
+		return a.driver, settings, nil
+	}
+	driver := drivers.GetDriver(settings.PreferDriver, settings.InstallerVersion)
+	if a.driver == nil || driver.Name() == a.driver.Name() {
+		return driver, settings, nil
+	}
+	if err := drivers.CheckDriverSwitch(driver.Name()); err != nil {
+		if !errors.Is(err, drivers.ErrModuleInUse) {
+			// let the driver install report it
+			agentLog.Error(err, "failed to check erdma module before driver switch")
+			return driver, settings, nil
+		}
+		a.driver.SetERdmaInstallerVersion(settings.InstallerVersion)
+		settings.PreferDriver = a.settings.PreferDriver
+		return a.driver, settings, fmt.Errorf("switch driver from %s to %s: %w", a.driver.Name(), driver.Name(), err)
+	}
+	agentLog.Info("switch driver", "from", a.driver.Name(), "to", driver.Name())
+	return driver, settings, nil
+}
+
+// sendLatest sends v on ch dropping the pending value, only the latest one
+// matters.
+func sendLatest[T any](ch chan T, v T) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- v
+}
+
+// apply sets up the driver, the erdma devices and the device plugin with
+// settings, the device plugin of the previous settings is stopped first.
+func (a *Agent) apply(settings networkv1beta2.AgentSettings, policyGeneration int64) error {
+	driver, settings, switchErr := a.resolveDriver(settings)
+	if switchErr != nil {
+		agentLog.Info("driver switch deferred, keep the running driver", "driver", driver.Name(), "reason", switchErr.Error())
+		if a.devicePlugin != nil && reflect.DeepEqual(settings, a.settings) && slices.Equal(attachedERIs(a.eriInfos), a.eris) {
+			// nothing else changed, keep the devices and the device plugin
+			a.reportConditions(a.driverModeCondition(switchErr))
+			a.policyGeneration = policyGeneration
+			a.reportSettings()
+			return nil
+		}
+	}
+	a.stopDevicePlugins()
+	a.driver = driver
+	allocAllDevices := settings.AllocateAllDevices
+	if a.localERIDiscovery {
+		if !(len(settings.ExposedLocalERIs) == 1 && settings.ExposedLocalERIs[0] == "") {
+			allocAllDevices = true
 			agentLog.Info("LocalERIDiscovery: enable expose ERIs, set allocAllDevices to true")
 		}
-		eri, err = drivers.SelectERIs(a.exposedLocalERIs)
+		eri, err := drivers.SelectERIs(settings.ExposedLocalERIs)
 		if err != nil {
-			return fmt.Errorf("LocalERIDiscovery: select eri failed: %v", err)
-		}
-		eriInfos = &networkv1.ERdmaDevice{
-			Spec: networkv1.ERdmaDeviceSpec{
-				Devices: lo.Map(eri, func(item *types.ERI, index int) networkv1.DeviceInfo {
-					return networkv1.DeviceInfo{
-						InstanceID:       item.InstanceID,
-						MAC:              item.MAC,
-						IsPrimaryENI:     item.IsPrimaryENI,
+			err = fmt.Errorf("LocalERIDiscovery: select eri failed: %v", err)
+			a.reportReady(reasonProbeFailed, err)
+			return err
+		}
+		a.eriInfos = &networkv1beta2.ERdmaDevice{
+			Spec: networkv1beta2.ERdmaDeviceSpec{
+				ERIs: lo.Map(eri, func(item *types.ERI, index int) networkv1beta2.ERISpec {
+					return networkv1beta2.ERISpec{
 						ID:               item.ID,
 						NetworkCardIndex: item.CardIndex,
 						QueuePair:        item.QueuePair,
+						PrimaryENI:       item.IsPrimaryENI,
+					}
+				}),
+			},
+			Status: networkv1beta2.ERdmaDeviceStatus{
+				ERIs: lo.Map(eri, func(item *types.ERI, index int) networkv1beta2.ERIStatus {
+					return networkv1beta2.ERIStatus{
+						ID:  item.ID,
+						MAC: item.MAC,
 					}
 				}),
 			},
 		}
 	}
+	eriInfos := a.eriInfos
 	agentLog.Info("eri info", "eriInfo", eriInfos, "driver", a.driver.Name())
 	// 2. install eri driver
-	err = a.driver.Install()
+	err := a.driver.Install()
+	a.reportConditions(a.newCondition(networkv1beta2.ConditionDriverInstalled, reasonInstalled, reasonInstallFailed, err))
 	if err != nil {
+		a.eventf(events.DriverInstallFailed, "install erdma driver %s failed: %v", a.driver.Name(), err)
+		a.reportReady(reasonInstallFailed, err)
 		return fmt.Errorf("install eri driver failed, err: %v", err)
 	}
+	a.reportStatus(func(status *networkv1beta2.ERdmaDeviceStatus) {
+		status.Node.Driver = a.driver.Name()
+		status.Node.InstallerVersion = settings.InstallerVersion
+		meta.SetStatusCondition(&status.Conditions, a.driverModeCondition(switchErr))
+	})
 	erdmaDevices := make([]*types.ERdmaDeviceInfo, 0)
-	for _, eriInfo := range eriInfos.Spec.Devices {
-		deviceInfo, err := a.driver.ProbeDevice(&types.ERI{
+	exportedDevices := make([]exportedDevice, 0)
+	nodeDevices := make([]networkv1beta2.NodeDeviceStatus, 0)
+	exclusive := exclusiveERIs(eriInfos.Spec.ERIs, settings.ExclusiveERIs)
+	exclusiveDevices := make([]*deviceplugin.ExclusiveERI, 0)
+	for _, eriInfo := range eriInfos.Spec.ERIs {
+		eriStatus, ok := lo.Find(eriInfos.Status.ERIs, func(item networkv1beta2.ERIStatus) bool {
+			return item.ID == eriInfo.ID
+		})
+		if !ok || !eriAttached(eriStatus) {
+			agentLog.Info("skip eri not attached yet", "eri", eriInfo.ID, "phase", eriStatus.Phase)
+			continue
+		}
+		eri := &types.ERI{
 			ID:            eriInfo.ID,
-			IsPrimaryENI:  eriInfo.IsPrimaryENI,
-			MAC:           eriInfo.MAC,
-			InstanceID:    eriInfo.InstanceID,
+			IsPrimaryENI:  eriInfo.PrimaryENI,
+			MAC:           eriStatus.MAC,
+			InstanceID:    eriInfos.Spec.InstanceID,
 			CardIndex:     eriInfo.NetworkCardIndex,
 			JumboFrame:    eriInfos.Spec.JumboFrame,
-			JumboFrameMTU: a.jumboFrameMTU,
-		})
+			JumboFrameMTU: settings.JumboFrameMTU,
+		}
+		if exclusive[eri.ID] {
+			inHost, err := drivers.ERIInHostNetns(eri.MAC)
+			if err != nil {
+				err = fmt.Errorf("check netns of exclusive eri %s failed, err: %v", eri.ID, err)
+				a.reportReady(reasonProbeFailed, err)
+				return err
+			}
+			if !inHost {
+				// the exclusive device plugin sets it up once the pod ended
+				agentLog.Info("skip exclusive eri in the network namespace of a pod", "eri", eri.ID)
+				exclusiveDevices = append(exclusiveDevices, &deviceplugin.ExclusiveERI{ERI: eri})
+				continue
+			}
+		}
+		deviceInfo, err := a.driver.ProbeDevice(eri)
 		if err != nil {
-			return fmt.Errorf("probe device failed, err: %v", err)
+			probeErr := fmt.Errorf("probe device %s failed, err: %v", eriInfo.ID, err)
+			netdevCond := a.newCondition(networkv1beta2.ConditionNetdevConfigured, reasonConfigured, reasonConfigFailed, nil)
+			if errors.Is(err, drivers.ErrNetDevConfig) {
+				netdevCond = a.newCondition(networkv1beta2.ConditionNetdevConfigured, reasonConfigured, reasonConfigFailed, probeErr)
+			}
+			a.reportConditions(netdevCond,
+				a.newCondition(networkv1beta2.ConditionDevicesProbed, reasonProbed, reasonProbeFailed, probeErr))
+			a.eventf(events.ProbeFailed, "probe erdma device of eri %s failed: %v", eriInfo.ID, err)
+			a.reportReady(reasonProbeFailed, probeErr)
+			return probeErr
 		}
-		erdmaDevices = append(erdmaDevices, deviceInfo)
+		if exclusive[eri.ID] {
+			exclusiveDevices = append(exclusiveDevices, &deviceplugin.ExclusiveERI{ERI: eri, Device: deviceInfo})
+		} else {
+			erdmaDevices = append(erdmaDevices, deviceInfo)
+		}
+		exportedDevices = append(exportedDevices, exportedDevice{eniID: eriInfo.ID, info: deviceInfo})
+		nodeDevices = append(nodeDevices, nodeDeviceStatus(eriInfo.ID, deviceInfo))
 	}
 	agentLog.Info("eri device info", "erdmaDevices", erdmaDevices)
+	a.counters.setDevices(exportedDevices)
+	a.podResources.setDevices(exportedDevices)
+	a.reportStatus(func(status *networkv1beta2.ERdmaDeviceStatus) {
+		status.Node.Devices = nodeDevices
+		meta.SetStatusCondition(&status.Conditions, a.newCondition(networkv1beta2.ConditionNetdevConfigured, reasonConfigured, reasonConfigFailed, nil))
+		meta.SetStatusCondition(&status.Conditions, a.newCondition(networkv1beta2.ConditionDevicesProbed, reasonProbed, reasonProbeFailed, nil))
+	})
 	// 3. config pnet for rdma device
 	// SMC-R pnet setup is best-effort: it is only an acceleration path. On
 	// images where the SMC module is not usable (e.g. the MLNX OFED smc.ko is
 	// incompatible with smc-tools, so smc_pnet reports "SMC module not loaded"),
 	// skip it with a warning instead of failing the whole agent, so basic eRDMA
 	// and the device plugin still come up.
+	var smcrErrs []error
+	smcrCond := a.newCondition(networkv1beta2.ConditionSMCRConfigured, reasonConfigured, reasonNotSupported,
+		fmt.Errorf("no device supports SMC-R with driver %s", a.driver.Name()))
 	for _, deviceInfo := range erdmaDevices {
 		if deviceInfo.Capabilities&types.ERDMA_CAP_SMC_R != 0 {
 			if err = drivers.ConfigSMCPnetForDevice(deviceInfo); err != nil {
 				agentLog.Info("WARNING: skip SMC-R pnet config for device (best-effort)", "device", deviceInfo.Name, "error", err.Error())
+				smcrErrs = append(smcrErrs, fmt.Errorf("%s: %v", deviceInfo.Name, err))
 			}
+			smcrCond = a.newCondition(networkv1beta2.ConditionSMCRConfigured, reasonConfigured, reasonConfigFailed, errors.Join(smcrErrs...))
 		}
 	}
+	a.reportConditions(smcrCond)
 	// 4. enable deviceplugin
-	devicePlugin, err := deviceplugin.NewERDMADevicePlugin(erdmaDevices, a.allocAllDevices, a.devicepluginPreStart, a.driver.Name() == "default")
+	devicePlugin, err := deviceplugin.NewERDMADevicePlugin(erdmaDevices, allocAllDevices, a.devicepluginPreStart, a.driver.Name() == "default")
 	if err != nil {
+		a.reportConditions(a.newCondition(networkv1beta2.ConditionDevicePluginRegistered, reasonRegistered, reasonRegisterFailed, err))
+		a.reportReady(reasonRegisterFailed, err)
 		return fmt.Errorf("new erdma device plugin failed, err: %v", err)
 	}
-	devicePlugin.Serve()
+	err = devicePlugin.Serve()
+	if err == nil && len(exclusiveDevices) > 0 {
+		a.exclusivePlugin, err = deviceplugin.NewExclusiveERDMADevicePlugin(exclusiveDevices, a.exclusiveNetns, a.driver.ProbeDevice)
+		if err == nil {
+			err = a.exclusivePlugin.Serve()
+		}
+		if err != nil {
+			err = fmt.Errorf("exclusive device plugin: %w", err)
+		}
+	}
+	a.reportStatus(func(status *networkv1beta2.ERdmaDeviceStatus) {
+		meta.SetStatusCondition(&status.Conditions, a.newCondition(networkv1beta2.ConditionDevicePluginRegistered, reasonRegistered, reasonRegisterFailed, err))
+		if err == nil {
+			status.ObservedGeneration = eriInfos.Generation
+		}
+	})
+	a.devicePlugin = devicePlugin
+	a.pluginStop = make(chan struct{})
+	go devicePlugin.Watch(a.pluginStop)
+	if a.exclusivePlugin != nil {
+		go a.exclusivePlugin.Watch(a.pluginStop)
+	}
+	a.settings = settings
+	a.eris = attachedERIs(eriInfos)
+	a.policyGeneration = policyGeneration
+	a.reportSettings()
+	var capabilities types.ERdmaCAP
+	for _, device := range exportedDevices {
+		capabilities |= device.info.Capabilities
+	}
+	a.reportNodeFeatures(nodeFeatures{
+		driver:        a.driver.Name(),
+		driverVersion: drivers.ModuleVersion(),
+		eriCount:      len(erdmaDevices) + len(exclusiveDevices),
+		capabilities:  capabilities,
+		jumboFrame:    eriInfos.Spec.JumboFrame,
+		tcp2smc:       drivers.TCP2SMCAvailable(),
+	})
+	if err != nil {
+		a.reportReady(reasonRegisterFailed, err)
+	} else {
+		a.reportReady(reasonNotAttached, notAttachedERIs(eriInfos))
+	}
 	// 5. todo watch & config smc-r and verbs devices
 	return nil
 }
+
+// stopDevicePlugins stops the device plugins, if any.
+func (a *Agent) stopDevicePlugins() {
+	if a.devicePlugin != nil {
+		close(a.pluginStop)
+		if err := a.devicePlugin.Stop(); err != nil {
+			agentLog.Error(err, "failed to stop device plugin")
+		}
+		a.devicePlugin = nil
+	}
+	if a.exclusivePlugin != nil {
+		if err := a.exclusivePlugin.Stop(); err != nil {
+			agentLog.Error(err, "failed to stop exclusive device plugin")
+		}
+		a.exclusivePlugin = nil
+	}
+}
+
+// release stops advertising the erdma devices, removes the erdma labels of the
+// node and unconfigures the netdevs of the secondary ERIs when the ERdmaDevice of the node is deleted, e.g. when
+// the node opts out, and reports it in the DevicesReleased condition for the
+// controller to release the ERIs. device is nil when the ERdmaDevice is gone,
+// the netdevs of the last one are unconfigured then. The devices are set up
+// again once the node has an ERdmaDevice again.
+func (a *Agent) release(device *networkv1beta2.ERdmaDevice) {
+	if device != nil {
+		a.eriInfos = device
+	}
+	a.deleted = true
+	if a.released {
+		return
+	}
+	agentLog.Info("erdma device deleted, release erdma devices")
+	a.stopDevicePlugins()
+	a.counters.setDevices(nil)
+	a.podResources.setDevices(nil)
+	a.reportNodeFeatures(nodeFeatures{})
+	a.reportReady(reasonReleased, errors.New("erdma devices are released"))
+	// the devices are set up again with the next ERdmaDevice
+	a.settings = networkv1beta2.AgentSettings{}
+	a.eris = nil
+	if a.eriInfos == nil {
+		a.released = true
+		return
+	}
+	var errs []error
+	for _, eri := range a.eriInfos.Spec.ERIs {
+		status, ok := lo.Find(a.eriInfos.Status.ERIs, func(item networkv1beta2.ERIStatus) bool {
+			return item.ID == eri.ID
+		})
+		if eri.PrimaryENI || !ok || status.MAC == "" {
+			continue
+		}
+		if err := drivers.ResetNetDevice(status.MAC); err != nil {
+			errs = append(errs, fmt.Errorf("reset netdev of eri %s: %v", eri.ID, err))
+		}
+	}
+	err := errors.Join(errs...)
+	a.released = err == nil
+	if err != nil {
+		agentLog.Error(err, "failed to release erdma devices, will retry")
+	}
+	if device == nil {
+		a.eriInfos = nil
+		return
+	}
+	a.reportConditions(a.newCondition(networkv1beta2.ConditionDevicesReleased, reasonReleased, reasonReleaseFailed, err))
+}
+
+// eventf records an Event on the ERdmaDevice of the node, or on the node in
+// localERIDiscovery mode, where the ERdmaDevice only lives in memory.
+func (a *Agent) eventf(reason events.Reason, messageFmt string, args ...any) {
+	var obj k8sruntime.Object = events.NodeReference(a.kubernetes.NodeName())
+	if a.eriInfos != nil && a.eriInfos.Name != "" {
+		obj = a.eriInfos
+	}
+	a.events.Eventf(obj, reason, messageFmt, args...)
+}
+
+// eriAttached returns whether the ERI is attached to the node. ERIs the
+// controller is attaching, e.g. added after an instance type change, are set
+// up once they are Ready. ERIs without a phase are discovered on the node.
+func eriAttached(status networkv1beta2.ERIStatus) bool {
+	return status.Phase == "" || status.Phase == networkv1beta2.ERIPhaseReady
+}
+
+// attachedERIs returns the ID and MAC of the ERIs of device attached to the
+// node, the devices are set up again when they change.
+func attachedERIs(device *networkv1beta2.ERdmaDevice) []string {
+	if device == nil {
+		return nil
+	}
+	var eris []string
+	for _, eri := range device.Spec.ERIs {
+		status, ok := lo.Find(device.Status.ERIs, func(item networkv1beta2.ERIStatus) bool {
+			return item.ID == eri.ID
+		})
+		if ok && eriAttached(status) {
+			eris = append(eris, eri.ID+"/"+status.MAC)
+		}
+	}
+	return eris
+}
+
+// notAttachedERIs returns an error with the ERIs of device which are not
+// attached to the node yet, nil when all of them are.
+func notAttachedERIs(device *networkv1beta2.ERdmaDevice) error {
+	if len(device.Spec.ERIs) == 0 {
+		return errors.New("no eri in erdma device")
+	}
+	var ids []string
+	for _, eri := range device.Spec.ERIs {
+		status, ok := lo.Find(device.Status.ERIs, func(item networkv1beta2.ERIStatus) bool {
+			return item.ID == eri.ID
+		})
+		if !ok || !eriAttached(status) {
+			ids = append(ids, eri.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return fmt.Errorf("eris %s are not attached yet", strings.Join(ids, ","))
+}
+
+// exclusiveERIs returns the IDs of the count secondary ERIs with the highest
+// network card index, they are allocated as a whole to pods.
+func exclusiveERIs(eris []networkv1beta2.ERISpec, count int) map[string]bool {
+	candidates := lo.Filter(eris, func(item networkv1beta2.ERISpec, _ int) bool {
+		return !item.PrimaryENI
+	})
+	slices.SortFunc(candidates, func(a, b networkv1beta2.ERISpec) int {
+		if a.NetworkCardIndex != b.NetworkCardIndex {
+			return b.NetworkCardIndex - a.NetworkCardIndex
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	exclusive := map[string]bool{}
+	for _, eri := range candidates[:min(max(count, 0), len(candidates))] {
+		exclusive[eri.ID] = true
+	}
+	return exclusive
+}
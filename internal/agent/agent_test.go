@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/consts"
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/k8s"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestExclusiveERIs(t *testing.T) {
+	eris := []networkv1beta2.ERISpec{
+		{ID: "eni-0", NetworkCardIndex: 0, PrimaryENI: true},
+		{ID: "eni-2", NetworkCardIndex: 1},
+		{ID: "eni-1", NetworkCardIndex: 1},
+		{ID: "eni-3", NetworkCardIndex: 0},
+	}
+	assert.Empty(t, exclusiveERIs(eris, 0))
+	assert.Equal(t, map[string]bool{"eni-1": true}, exclusiveERIs(eris, 1))
+	assert.Equal(t, map[string]bool{"eni-1": true, "eni-2": true}, exclusiveERIs(eris, 2))
+	// the primary ENI is never exclusive
+	assert.Equal(t, map[string]bool{"eni-1": true, "eni-2": true, "eni-3": true}, exclusiveERIs(eris, 4))
+}
+
+// fakeKubernetes keeps the status of a single ERdmaDevice and the node.
+type fakeKubernetes struct {
+	k8s.Kubernetes
+	status  networkv1beta2.ERdmaDeviceStatus
+	updates int
+	node    corev1.Node
+}
+
+func (f *fakeKubernetes) UpdateEriStatus(_ string, update func(status *networkv1beta2.ERdmaDeviceStatus)) error {
+	f.updates++
+	update(&f.status)
+	return nil
+}
+
+func (f *fakeKubernetes) UpdateNode(update func(node *corev1.Node)) error {
+	update(&f.node)
+	return nil
+}
+
+func (f *fakeKubernetes) UpdateNodeStatus(update func(node *corev1.Node)) error {
+	update(&f.node)
+	return nil
+}
+
+func TestRelease(t *testing.T) {
+	kubernetes := &fakeKubernetes{node: corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		"kubernetes.io/hostname":                  "node1",
+		"feature.node.kubernetes.io/erdma.driver": "default",
+	}}}}
+	a := &Agent{
+		kubernetes:   kubernetes,
+		counters:     newCounterCollector(),
+		podResources: newPodResourceCollector(),
+		settings:     networkv1beta2.AgentSettings{PreferDriver: "default"},
+		eris:         []string{"eni-1/00:16:3e:ff:ff:fe"},
+	}
+	device := &networkv1beta2.ERdmaDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", DeletionTimestamp: ptr.To(metav1.Now())},
+		Spec: networkv1beta2.ERdmaDeviceSpec{ERIs: []networkv1beta2.ERISpec{
+			{ID: "eni-0", PrimaryENI: true},
+			{ID: "eni-1", NetworkCardIndex: 1},
+		}},
+		Status: networkv1beta2.ERdmaDeviceStatus{ERIs: []networkv1beta2.ERIStatus{
+			{ID: "eni-0", MAC: "00:16:3e:ff:ff:ff"},
+			{ID: "eni-1", MAC: "00:16:3e:ff:ff:fe"},
+		}},
+	}
+
+	a.release(device)
+	assert.True(t, a.deleted)
+	assert.True(t, a.released)
+	assert.Empty(t, a.eris)
+	assert.Equal(t, networkv1beta2.AgentSettings{}, a.settings, "the devices are set up again with the next erdma device")
+	assert.True(t, meta.IsStatusConditionTrue(kubernetes.status.Conditions, networkv1beta2.ConditionDevicesReleased))
+	assert.Equal(t, map[string]string{"kubernetes.io/hostname": "node1"}, kubernetes.node.Labels)
+	assert.Equal(t, corev1.ConditionFalse, kubernetes.node.Status.Conditions[0].Status)
+	assert.Equal(t, reasonReleased, kubernetes.node.Status.Conditions[0].Reason)
+
+	// the devices are released once
+	a.release(device)
+	a.release(nil)
+	assert.Equal(t, 1, kubernetes.updates)
+}
+
+func TestReportReady(t *testing.T) {
+	kubernetes := &fakeKubernetes{node: corev1.Node{Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+		{Key: "node.kubernetes.io/unschedulable", Effect: corev1.TaintEffectNoSchedule},
+		{Key: consts.NodeTaintNotReady, Effect: corev1.TaintEffectNoSchedule},
+	}}}}
+	a := &Agent{kubernetes: kubernetes, notReadyTaint: true}
+	readyCondition := func() corev1.NodeCondition {
+		require.Len(t, kubernetes.node.Status.Conditions, 1)
+		return kubernetes.node.Status.Conditions[0]
+	}
+
+	a.reportReady(reasonProbeFailed, nil)
+	assert.Equal(t, corev1.ConditionTrue, readyCondition().Status)
+	assert.Equal(t, reasonReady, readyCondition().Reason)
+	assert.Equal(t, []corev1.Taint{{Key: "node.kubernetes.io/unschedulable", Effect: corev1.TaintEffectNoSchedule}},
+		kubernetes.node.Spec.Taints)
+
+	// the node is tainted again when the devices fail
+	a.reportReady(reasonProbeFailed, errors.New("probe device eni-1 failed"))
+	assert.Equal(t, corev1.ConditionFalse, readyCondition().Status)
+	assert.Equal(t, reasonProbeFailed, readyCondition().Reason)
+	assert.Equal(t, "probe device eni-1 failed", readyCondition().Message)
+	assert.Len(t, kubernetes.node.Spec.Taints, 2)
+	a.reportReady(reasonProbeFailed, errors.New("probe device eni-1 failed"))
+	assert.Len(t, kubernetes.node.Spec.Taints, 2)
+
+	a.reportReady(reasonProbeFailed, nil)
+	assert.Len(t, kubernetes.node.Spec.Taints, 1)
+	// without notReadyTaint the taint is only removed
+	a.notReadyTaint = false
+	a.reportReady(reasonRegisterFailed, errors.New("register device plugin failed"))
+	assert.Equal(t, corev1.ConditionFalse, readyCondition().Status)
+	assert.Len(t, kubernetes.node.Spec.Taints, 1)
+}
+
+func TestNotAttachedERIs(t *testing.T) {
+	device := &networkv1beta2.ERdmaDevice{
+		Spec: networkv1beta2.ERdmaDeviceSpec{ERIs: []networkv1beta2.ERISpec{{ID: "eni-0"}, {ID: "eni-1"}, {ID: "eni-2"}}},
+		Status: networkv1beta2.ERdmaDeviceStatus{ERIs: []networkv1beta2.ERIStatus{
+			{ID: "eni-0", Phase: networkv1beta2.ERIPhaseReady},
+			{ID: "eni-1", Phase: networkv1beta2.ERIPhasePending},
+		}},
+	}
+	assert.EqualError(t, notAttachedERIs(device), "eris eni-1,eni-2 are not attached yet")
+	device.Status.ERIs[1].Phase = networkv1beta2.ERIPhaseReady
+	device.Status.ERIs = append(device.Status.ERIs, networkv1beta2.ERIStatus{ID: "eni-2"})
+	assert.NoError(t, notAttachedERIs(device))
+}
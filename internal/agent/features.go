@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// nodeFeaturePrefix is the prefix of the erdma labels and annotations of the
+// node, in the namespace of Node Feature Discovery.
+const nodeFeaturePrefix = "feature.node.kubernetes.io/erdma."
+
+// nodeFeatures are the features of the erdma devices set up on the node.
+type nodeFeatures struct {
+	driver        string
+	driverVersion string
+	eriCount      int
+	// capabilities are the capabilities of any of the devices.
+	capabilities types.ERdmaCAP
+	jumboFrame   bool
+	tcp2smc      bool
+}
+
+// labels returns the labels and the annotations of the features, there are
+// none without ERIs. Each capability is a label, the driver version is only a
+// label when it is a valid label value.
+func (f nodeFeatures) labels() (map[string]string, map[string]string) {
+	labels := map[string]string{}
+	annotations := map[string]string{}
+	if f.eriCount == 0 {
+		return labels, annotations
+	}
+	labels[nodeFeaturePrefix+"available"] = "true"
+	labels[nodeFeaturePrefix+"driver"] = f.driver
+	labels[nodeFeaturePrefix+"eri-count"] = strconv.Itoa(f.eriCount)
+	if f.jumboFrame {
+		labels[nodeFeaturePrefix+"jumbo-frame"] = "true"
+	}
+	if f.tcp2smc {
+		labels[nodeFeaturePrefix+"tcp2smc"] = "true"
+	}
+	for _, c := range strings.Split(f.capabilities.String(), ",") {
+		if c != "" {
+			labels[nodeFeaturePrefix+"capability-"+strings.ReplaceAll(strings.ToLower(c), "_", "-")] = "true"
+		}
+	}
+	annotations[nodeFeaturePrefix+"capabilities"] = f.capabilities.String()
+	if f.driverVersion != "" {
+		annotations[nodeFeaturePrefix+"driver-version"] = f.driverVersion
+		if len(validation.IsValidLabelValue(f.driverVersion)) == 0 {
+			labels[nodeFeaturePrefix+"driver-version"] = f.driverVersion
+		}
+	}
+	return labels, annotations
+}
+
+// setNodeFeatures sets the erdma labels and annotations of node, the ones of
+// features which are gone are removed.
+func setNodeFeatures(node *corev1.Node, labels, annotations map[string]string) {
+	node.Labels = setPrefixed(node.Labels, labels)
+	node.Annotations = setPrefixed(node.Annotations, annotations)
+}
+
+// setPrefixed replaces the entries of current with nodeFeaturePrefix by
+// desired.
+func setPrefixed(current, desired map[string]string) map[string]string {
+	for k := range current {
+		if _, ok := desired[k]; !ok && strings.HasPrefix(k, nodeFeaturePrefix) {
+			delete(current, k)
+		}
+	}
+	if current == nil && len(desired) > 0 {
+		current = map[string]string{}
+	}
+	for k, v := range desired {
+		current[k] = v
+	}
+	return current
+}
+
+// reportNodeFeatures sets the erdma labels and annotations of the node to the
+// ones of features. Failures are logged only.
+func (a *Agent) reportNodeFeatures(features nodeFeatures) {
+	labels, annotations := features.labels()
+	err := a.kubernetes.UpdateNode(func(node *corev1.Node) {
+		setNodeFeatures(node, labels, annotations)
+	})
+	if err != nil {
+		agentLog.Error(err, "failed to update erdma features of node")
+	}
+}
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeFeatures(t *testing.T) {
+	labels, annotations := nodeFeatures{
+		driver:        "default",
+		driverVersion: "0.2.37",
+		eriCount:      2,
+		capabilities:  types.ERDMA_CAP_RDMA_CM | types.ERDMA_CAP_SMC_R,
+		jumboFrame:    true,
+	}.labels()
+	assert.Equal(t, map[string]string{
+		"feature.node.kubernetes.io/erdma.available":          "true",
+		"feature.node.kubernetes.io/erdma.driver":             "default",
+		"feature.node.kubernetes.io/erdma.driver-version":     "0.2.37",
+		"feature.node.kubernetes.io/erdma.eri-count":          "2",
+		"feature.node.kubernetes.io/erdma.jumbo-frame":        "true",
+		"feature.node.kubernetes.io/erdma.capability-rdma-cm": "true",
+		"feature.node.kubernetes.io/erdma.capability-smc-r":   "true",
+	}, labels)
+	assert.Equal(t, map[string]string{
+		"feature.node.kubernetes.io/erdma.capabilities":   "RDMA_CM,SMC_R",
+		"feature.node.kubernetes.io/erdma.driver-version": "0.2.37",
+	}, annotations)
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		"kubernetes.io/hostname":                            "node1",
+		"feature.node.kubernetes.io/cpu-model.vendor_id":    "Intel",
+		"feature.node.kubernetes.io/erdma.capability-smc-r": "true",
+		"feature.node.kubernetes.io/erdma.tcp2smc":          "true",
+	}}}
+	labels, annotations = nodeFeatures{
+		driver:        "compat",
+		driverVersion: "0.2.37 (custom build)",
+		eriCount:      1,
+		capabilities:  types.ERDMA_CAP_RDMA_CM,
+	}.labels()
+	setNodeFeatures(node, labels, annotations)
+	assert.Equal(t, map[string]string{
+		"kubernetes.io/hostname":                              "node1",
+		"feature.node.kubernetes.io/cpu-model.vendor_id":      "Intel",
+		"feature.node.kubernetes.io/erdma.available":          "true",
+		"feature.node.kubernetes.io/erdma.driver":             "compat",
+		"feature.node.kubernetes.io/erdma.eri-count":          "1",
+		"feature.node.kubernetes.io/erdma.capability-rdma-cm": "true",
+	}, node.Labels)
+	assert.Equal(t, "0.2.37 (custom build)", node.Annotations["feature.node.kubernetes.io/erdma.driver-version"])
+
+	labels, annotations = nodeFeatures{}.labels()
+	setNodeFeatures(node, labels, annotations)
+	assert.Equal(t, map[string]string{
+		"kubernetes.io/hostname":                         "node1",
+		"feature.node.kubernetes.io/cpu-model.vendor_id": "Intel",
+	}, node.Labels)
+	assert.Empty(t, node.Annotations)
+}
@@ -0,0 +1,249 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/deviceplugin"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/drivers"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/samber/lo"
+)
+
+// deviceLabels are the labels of the counters of an erdma device.
+var deviceLabels = []string{"rdma_device", "netdev", "mac", "eni_id", "numa"}
+
+// counterDescs are the metrics of the counters of each source.
+var counterDescs = map[string]*prometheus.Desc{
+	drivers.CounterSourceHW: prometheus.NewDesc("erdma_hw_counter",
+		"Driver counter of a port of an erdma device from its hw_counters in sysfs.",
+		append(deviceLabels, "port", "counter"), nil),
+	drivers.CounterSourcePort: prometheus.NewDesc("erdma_port_counter",
+		"Counter of a port of an erdma device from its counters in sysfs.",
+		append(deviceLabels, "port", "counter"), nil),
+	drivers.CounterSourceNetlink: prometheus.NewDesc("erdma_netlink_statistic",
+		"RDMA netlink statistic of a port of an erdma device.",
+		append(deviceLabels, "port", "counter"), nil),
+	drivers.CounterSourceEadm: prometheus.NewDesc("erdma_eadm_statistic",
+		"Statistic of an erdma device reported by eadm.",
+		append(deviceLabels, "counter"), nil),
+}
+
+// counterSources read the counters of an rdma device, sysfs reads the
+// hw_counters and the counters.
+var counterSources = map[string]func(device string) ([]drivers.DeviceCounter, error){
+	"sysfs":                      drivers.SysfsCounters,
+	drivers.CounterSourceNetlink: drivers.NetlinkStatistics,
+	drivers.CounterSourceEadm:    drivers.EadmStatistics,
+}
+
+// exportedDevice is an erdma device set up by the agent.
+type exportedDevice struct {
+	eniID string
+	info  *types.ERdmaDeviceInfo
+}
+
+// counterCollector collects the counters of the erdma devices set up by the
+// agent when it is scraped.
+type counterCollector struct {
+	lock    sync.RWMutex
+	devices []exportedDevice
+	sources map[string]func(device string) ([]drivers.DeviceCounter, error)
+
+	scrapeErrors *prometheus.CounterVec
+}
+
+var _ prometheus.Collector = &counterCollector{}
+
+func newCounterCollector() *counterCollector {
+	return &counterCollector{
+		sources: counterSources,
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "erdma_counter_scrape_errors_total",
+			Help: "Number of failed reads of the counters of an erdma device by source.",
+		}, []string{"source"}),
+	}
+}
+
+// setDevices sets the devices whose counters are collected.
+func (c *counterCollector) setDevices(devices []exportedDevice) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.devices = devices
+}
+
+func (c *counterCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range counterDescs {
+		ch <- desc
+	}
+	c.scrapeErrors.Describe(ch)
+}
+
+func (c *counterCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.RLock()
+	devices := c.devices
+	c.lock.RUnlock()
+	for _, device := range devices {
+		labels := []string{device.info.Name, device.info.NetDev, device.info.MAC, device.eniID, strconv.FormatInt(device.info.NUMA, 10)}
+		for source, read := range c.sources {
+			counters, err := read(device.info.Name)
+			if err != nil {
+				agentLog.V(1).Info("failed to read erdma device counters", "device", device.info.Name, "source", source, "error", err.Error())
+				c.scrapeErrors.WithLabelValues(source).Inc()
+				continue
+			}
+			for _, counter := range counters {
+				desc, ok := counterDescs[counter.Source]
+				if !ok {
+					continue
+				}
+				values := append([]string{}, labels...)
+				if counter.Source != drivers.CounterSourceEadm {
+					values = append(values, counter.Port)
+				}
+				values = append(values, counter.Name)
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.UntypedValue, counter.Value, values...)
+			}
+		}
+	}
+	c.scrapeErrors.Collect(ch)
+}
+
+// podLabels are the labels of the rdma resources of a container.
+var podLabels = []string{"namespace", "pod", "container", "rdma_device"}
+
+var (
+	podQueuePairsDesc = prometheus.NewDesc("erdma_pod_queue_pairs",
+		"Number of queue pairs of the processes of a container on an erdma device.", podLabels, nil)
+	podCompletionQueuesDesc = prometheus.NewDesc("erdma_pod_completion_queues",
+		"Number of completion queues of the processes of a container on an erdma device.", podLabels, nil)
+	podMemoryRegionsDesc = prometheus.NewDesc("erdma_pod_memory_regions",
+		"Number of memory regions of the processes of a container on an erdma device.", podLabels, nil)
+	podMemoryRegionBytesDesc = prometheus.NewDesc("erdma_pod_memory_region_bytes",
+		"Total length of the memory regions of the processes of a container on an erdma device.", podLabels, nil)
+)
+
+// podResourceCollector collects the rdma resources of the containers on the
+// erdma devices set up by the agent when it is scraped.
+type podResourceCollector struct {
+	lock    sync.RWMutex
+	devices map[string]bool
+	// resources returns the rdma resources of the containers on all rdma
+	// devices of the node.
+	resources func() ([]deviceplugin.PodRdmaResources, error)
+
+	scrapeErrors prometheus.Counter
+}
+
+var _ prometheus.Collector = &podResourceCollector{}
+
+func newPodResourceCollector() *podResourceCollector {
+	return &podResourceCollector{
+		resources: func() ([]deviceplugin.PodRdmaResources, error) {
+			resources, err := drivers.RdmaResources()
+			if err != nil {
+				return nil, err
+			}
+			return deviceplugin.GetPodRdmaResources(resources)
+		},
+		scrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "erdma_pod_resource_scrape_errors_total",
+			Help: "Number of failed reads of the rdma resources of the containers.",
+		}),
+	}
+}
+
+// setDevices sets the devices whose rdma resources are collected.
+func (c *podResourceCollector) setDevices(devices []exportedDevice) {
+	names := map[string]bool{}
+	for _, device := range devices {
+		names[device.info.Name] = true
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.devices = names
+}
+
+// list returns the rdma resources of the containers on the erdma devices.
+func (c *podResourceCollector) list() ([]deviceplugin.PodRdmaResources, error) {
+	c.lock.RLock()
+	devices := c.devices
+	c.lock.RUnlock()
+	if len(devices) == 0 {
+		return nil, nil
+	}
+	resources, err := c.resources()
+	if err != nil {
+		c.scrapeErrors.Inc()
+		return nil, err
+	}
+	return lo.Filter(resources, func(item deviceplugin.PodRdmaResources, _ int) bool {
+		return devices[item.Device]
+	}), nil
+}
+
+func (c *podResourceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- podQueuePairsDesc
+	ch <- podCompletionQueuesDesc
+	ch <- podMemoryRegionsDesc
+	ch <- podMemoryRegionBytesDesc
+	c.scrapeErrors.Describe(ch)
+}
+
+func (c *podResourceCollector) Collect(ch chan<- prometheus.Metric) {
+	resources, err := c.list()
+	if err != nil {
+		agentLog.V(1).Info("failed to read rdma resources of pods", "error", err.Error())
+	}
+	for _, res := range resources {
+		labels := []string{res.Namespace, res.Pod, res.Container, res.Device}
+		ch <- prometheus.MustNewConstMetric(podQueuePairsDesc, prometheus.GaugeValue, float64(res.QueuePairs), labels...)
+		ch <- prometheus.MustNewConstMetric(podCompletionQueuesDesc, prometheus.GaugeValue, float64(res.CompletionQueues), labels...)
+		ch <- prometheus.MustNewConstMetric(podMemoryRegionsDesc, prometheus.GaugeValue, float64(res.MemoryRegions), labels...)
+		ch <- prometheus.MustNewConstMetric(podMemoryRegionBytesDesc, prometheus.GaugeValue, float64(res.MemoryRegionBytes), labels...)
+	}
+	c.scrapeErrors.Collect(ch)
+}
+
+// ServeHTTP serves the rdma resources of the containers as json.
+func (c *podResourceCollector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	resources, err := c.list()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if resources == nil {
+		resources = []deviceplugin.PodRdmaResources{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resources); err != nil {
+		agentLog.Error(err, "failed to write rdma resources of pods")
+	}
+}
+
+// serveMetrics serves the counters of the erdma devices, the rdma resources of
+// the pods and the process metrics of the agent on bindAddress, the rdma
+// resources of the pods are also served as json on /debug/rdma-resources.
+func serveMetrics(bindAddress string, counters *counterCollector, podResources *podResourceCollector) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		counters,
+		podResources,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.Handle("/debug/rdma-resources", podResources)
+	agentLog.Info("serving metrics", "address", bindAddress)
+	err := http.ListenAndServe(bindAddress, mux) // nolint:gosec
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		agentLog.Error(err, "failed to serve metrics")
+	}
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/deviceplugin"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/drivers"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterCollector(t *testing.T) {
+	c := newCounterCollector()
+	c.sources = map[string]func(device string) ([]drivers.DeviceCounter, error){
+		"sysfs": func(device string) ([]drivers.DeviceCounter, error) {
+			return []drivers.DeviceCounter{
+				{Source: drivers.CounterSourceHW, Port: "1", Name: "retrans_cnt", Value: 12},
+				{Source: drivers.CounterSourcePort, Port: "1", Name: "port_xmit_data", Value: 1024},
+			}, nil
+		},
+		drivers.CounterSourceEadm: func(device string) ([]drivers.DeviceCounter, error) {
+			return nil, errors.New("eadm failed")
+		},
+	}
+	c.setDevices([]exportedDevice{{
+		eniID: "eni-1",
+		info:  &types.ERdmaDeviceInfo{Name: "erdma_0", NetDev: "eth1", MAC: "00:16:3e:00:00:01", NUMA: 1},
+	}})
+
+	expected := `
+# HELP erdma_counter_scrape_errors_total Number of failed reads of the counters of an erdma device by source.
+# TYPE erdma_counter_scrape_errors_total counter
+erdma_counter_scrape_errors_total{source="eadm"} 1
+# HELP erdma_hw_counter Driver counter of a port of an erdma device from its hw_counters in sysfs.
+# TYPE erdma_hw_counter untyped
+erdma_hw_counter{counter="retrans_cnt",eni_id="eni-1",mac="00:16:3e:00:00:01",netdev="eth1",numa="1",port="1",rdma_device="erdma_0"} 12
+# HELP erdma_port_counter Counter of a port of an erdma device from its counters in sysfs.
+# TYPE erdma_port_counter untyped
+erdma_port_counter{counter="port_xmit_data",eni_id="eni-1",mac="00:16:3e:00:00:01",netdev="eth1",numa="1",port="1",rdma_device="erdma_0"} 1024
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected)))
+}
+
+func TestPodResourceCollector(t *testing.T) {
+	c := newPodResourceCollector()
+	c.resources = func() ([]deviceplugin.PodRdmaResources, error) {
+		return []deviceplugin.PodRdmaResources{
+			{Namespace: "default", Pod: "train-0", Container: "trainer", Device: "erdma_0",
+				QueuePairs: 2, CompletionQueues: 1, MemoryRegions: 2, MemoryRegionBytes: 12288, PIDs: []int{100}},
+			{Namespace: "default", Pod: "train-0", Container: "trainer", Device: "mlx5_0", QueuePairs: 8},
+		}, nil
+	}
+	c.setDevices([]exportedDevice{{eniID: "eni-1", info: &types.ERdmaDeviceInfo{Name: "erdma_0"}}})
+
+	expected := `
+# HELP erdma_pod_memory_region_bytes Total length of the memory regions of the processes of a container on an erdma device.
+# TYPE erdma_pod_memory_region_bytes gauge
+erdma_pod_memory_region_bytes{container="trainer",namespace="default",pod="train-0",rdma_device="erdma_0"} 12288
+# HELP erdma_pod_queue_pairs Number of queue pairs of the processes of a container on an erdma device.
+# TYPE erdma_pod_queue_pairs gauge
+erdma_pod_queue_pairs{container="trainer",namespace="default",pod="train-0",rdma_device="erdma_0"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "erdma_pod_queue_pairs", "erdma_pod_memory_region_bytes"))
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/rdma-resources", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"namespace":"default","pod":"train-0","podUID":"","container":"trainer","device":"erdma_0",
+		"queuePairs":2,"completionQueues":1,"memoryRegions":2,"memoryRegionBytes":12288,"pids":[100]}]`, rec.Body.String())
+
+	c.resources = func() ([]deviceplugin.PodRdmaResources, error) {
+		return nil, errors.New("rdma not found")
+	}
+	rec = httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/rdma-resources", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+)
+
+// effectiveSettings overlays the agent settings of the cluster policy and then
+// the node profile settings of device on the flag settings, and returns them
+// with the policy generation they come from.
+func (a *Agent) effectiveSettings(policy *networkv1beta2.ERdmaClusterPolicy, device *networkv1beta2.ERdmaDevice) (networkv1beta2.AgentSettings, int64) {
+	settings := a.flagSettings
+	var generation int64
+	if policy != nil {
+		settings = overlayPolicy(settings, &policy.Spec.Agent)
+		generation = policy.Generation
+	}
+	if device != nil {
+		if device.Spec.Driver != "" {
+			settings.PreferDriver = device.Spec.Driver
+		}
+		if device.Spec.JumboFrameMTU > 0 {
+			settings.JumboFrameMTU = device.Spec.JumboFrameMTU
+		}
+	}
+	return settings, generation
+}
+
+func overlayPolicy(settings networkv1beta2.AgentSettings, agentPolicy *networkv1beta2.AgentPolicy) networkv1beta2.AgentSettings {
+	if agentPolicy.PreferDriver != nil {
+		settings.PreferDriver = *agentPolicy.PreferDriver
+	}
+	if agentPolicy.JumboFrameMTU != nil {
+		settings.JumboFrameMTU = *agentPolicy.JumboFrameMTU
+	}
+	if agentPolicy.ExposedLocalERIs != nil {
+		settings.ExposedLocalERIs = agentPolicy.ExposedLocalERIs
+	}
+	if agentPolicy.AllocateAllDevices != nil {
+		settings.AllocateAllDevices = *agentPolicy.AllocateAllDevices
+	}
+	if agentPolicy.InstallerVersion != "" {
+		settings.InstallerVersion = agentPolicy.InstallerVersion
+	}
+	if agentPolicy.ExclusiveERIs != nil {
+		settings.ExclusiveERIs = *agentPolicy.ExclusiveERIs
+	}
+	return settings
+}
+
+// reportSettings reports the settings the agent runs with in the ERdmaDevice
+// status, the controller aggregates them in the cluster policy status.
+func (a *Agent) reportSettings() {
+	settings := a.settings
+	a.reportStatus(func(status *networkv1beta2.ERdmaDeviceStatus) {
+		status.Node.PolicyGeneration = a.policyGeneration
+		status.Node.Settings = &settings
+	})
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/consts"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/drivers"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/k8s"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+)
+
+// condition reasons reported by the agent
+const (
+	reasonInstalled      = "Installed"
+	reasonInstallFailed  = "InstallFailed"
+	reasonProbed         = "Probed"
+	reasonProbeFailed    = "ProbeFailed"
+	reasonConfigured     = "Configured"
+	reasonConfigFailed   = "ConfigFailed"
+	reasonRegistered     = "Registered"
+	reasonRegisterFailed = "RegisterFailed"
+	reasonNotSupported   = "NotSupported"
+	reasonApplied        = "Applied"
+	reasonModuleInUse    = "ModuleInUse"
+	reasonReleased       = "Released"
+	reasonReleaseFailed  = "ReleaseFailed"
+	reasonReady          = "Ready"
+	reasonNotAttached    = "NotAttached"
+)
+
+// reportStatus applies update to the ERdmaDevice status of this node. It is a
+// no-op in localERIDiscovery mode, where the ERdmaDevice only lives in memory.
+// Failures are logged only, status reporting must not break the data path.
+func (a *Agent) reportStatus(update func(status *networkv1beta2.ERdmaDeviceStatus)) {
+	if a.eriInfos == nil || a.eriInfos.Name == "" {
+		return
+	}
+	if err := a.kubernetes.UpdateEriStatus(a.eriInfos.Name, update); err != nil {
+		agentLog.Error(err, "failed to update erdma device status", "name", a.eriInfos.Name)
+	}
+}
+
+// reportConditions sets the given conditions on the ERdmaDevice status.
+func (a *Agent) reportConditions(conditions ...metav1.Condition) {
+	a.reportStatus(func(status *networkv1beta2.ERdmaDeviceStatus) {
+		for _, cond := range conditions {
+			meta.SetStatusCondition(&status.Conditions, cond)
+		}
+	})
+}
+
+// newCondition builds a condition for the current ERdmaDevice generation, it
+// is True with successReason when err is nil, otherwise False with failReason
+// and the error as message.
+func (a *Agent) newCondition(condType, successReason, failReason string, err error) metav1.Condition {
+	cond := metav1.Condition{
+		Type:               condType,
+		Status:             metav1.ConditionTrue,
+		Reason:             successReason,
+		ObservedGeneration: a.eriInfos.Generation,
+	}
+	if err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = failReason
+		cond.Message = err.Error()
+	}
+	return cond
+}
+
+// nodeDeviceStatus converts a probed device to its status representation.
+func nodeDeviceStatus(id string, info *types.ERdmaDeviceInfo) networkv1beta2.NodeDeviceStatus {
+	devStatus := networkv1beta2.NodeDeviceStatus{
+		ID:           id,
+		MAC:          info.MAC,
+		RdmaDevice:   info.Name,
+		NetDevice:    info.NetDev,
+		DevPaths:     info.DevPaths,
+		NUMA:         info.NUMA,
+		Capabilities: capabilities(info.Capabilities),
+	}
+	mtu, ip, err := drivers.GetLinkState(info.MAC)
+	if err != nil {
+		agentLog.Info("cannot get link state for device", "device", info.Name, "error", err.Error())
+		return devStatus
+	}
+	devStatus.MTU = mtu
+	devStatus.IP = ip
+	return devStatus
+}
+
+// capabilities converts the capability bits of a device to the API enum list.
+func capabilities(caps types.ERdmaCAP) []networkv1beta2.ERdmaCapability {
+	var ret []networkv1beta2.ERdmaCapability
+	for _, c := range strings.Split(caps.String(), ",") {
+		if c != "" {
+			ret = append(ret, networkv1beta2.ERdmaCapability(c))
+		}
+	}
+	return ret
+}
+
+// driverModeCondition reports the driver the agent runs with, switchErr is
+// why the switch to the driver of the settings is deferred.
+func (a *Agent) driverModeCondition(switchErr error) metav1.Condition {
+	cond := a.newCondition(networkv1beta2.ConditionDriverModeApplied, reasonApplied, reasonModuleInUse, switchErr)
+	if switchErr == nil {
+		cond.Message = fmt.Sprintf("driver %s loaded", a.driver.Name())
+	}
+	return cond
+}
+
+// reportReady sets the ERdmaReady condition of the node, True once the driver
+// is installed, all ERIs are probed and the device plugin is registered,
+// otherwise False with failReason and err as message. The not-ready taint is
+// removed once the node is ready, and put back on failures with
+// notReadyTaint. Failures are logged only.
+func (a *Agent) reportReady(failReason string, err error) {
+	cond := corev1.NodeCondition{
+		Type:    consts.NodeConditionERdmaReady,
+		Status:  corev1.ConditionTrue,
+		Reason:  reasonReady,
+		Message: "erdma devices are ready",
+	}
+	if err != nil {
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = failReason
+		cond.Message = err.Error()
+	}
+	if updateErr := a.kubernetes.UpdateNodeStatus(func(node *corev1.Node) {
+		k8s.SetNodeCondition(node, cond)
+	}); updateErr != nil {
+		agentLog.Error(updateErr, "failed to update erdma ready condition of node")
+	}
+	// the agent does not taint the node when the devices are released, the
+	// node opted out of erdma
+	if err != nil && (!a.notReadyTaint || failReason == reasonReleased) {
+		return
+	}
+	if updateErr := a.kubernetes.UpdateNode(func(node *corev1.Node) {
+		k8s.SetNotReadyTaint(node, err != nil)
+	}); updateErr != nil {
+		agentLog.Error(updateErr, "failed to update erdma not-ready taint of node")
+	}
+}
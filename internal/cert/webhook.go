@@ -19,6 +19,7 @@ import (
 
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -33,6 +34,15 @@ const (
 	caCertKey = "ca.crt"
 )
 
+// conversionCRDs are the CRDs converted by the controller webhook server
+var conversionCRDs = []string{
+	"erdmadevices.network.alibabacloud.com",
+}
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;create
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations;validatingwebhookconfigurations,verbs=get;patch
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;patch
+
 // SyncCert sync cert for webhook
 func SyncCert(ctx context.Context, c client.Client, ns, name, domain, certDir string) error {
 	secretName := fmt.Sprintf("%s-webhook-cert", name)
@@ -102,9 +112,27 @@ func SyncCert(ctx context.Context, c client.Client, ns, name, domain, certDir st
 	}
 
 	// update webhook
+	err = syncMutatingWebhookCA(ctx, c, ns, name, caCertBytes)
+	if err != nil {
+		return err
+	}
+	err = syncValidatingWebhookCA(ctx, c, name, caCertBytes)
+	if err != nil {
+		return err
+	}
+	return syncConversionWebhookCA(ctx, c, caCertBytes)
+}
+
+// syncMutatingWebhookCA patches the ca bundle of the mutating webhook, it is
+// skipped when the mutating webhook is not deployed.
+func syncMutatingWebhookCA(ctx context.Context, c client.Client, ns, name string, caCertBytes []byte) error {
 	mutatingWebhook := &admissionregistrationv1.MutatingWebhookConfiguration{}
-	err = c.Get(ctx, types.NamespacedName{Namespace: ns, Name: name}, mutatingWebhook)
+	err := c.Get(ctx, types.NamespacedName{Namespace: ns, Name: name}, mutatingWebhook)
 	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("MutatingWebhook not found, skip update ca bundle", "name", name)
+			return nil
+		}
 		return err
 	}
 	if len(mutatingWebhook.Webhooks) == 0 {
@@ -121,20 +149,7 @@ func SyncCert(ctx context.Context, c client.Client, ns, name, domain, certDir st
 		mutatingWebhook.Webhooks[i].ClientConfig.CABundle = caCertBytes
 	}
 	if changed {
-		err = wait.ExponentialBackoffWithContext(ctx, wait.Backoff{
-			Duration: 1 * time.Second,
-			Steps:    3,
-			Factor:   2,
-			Jitter:   1.1,
-		}, func(ctx context.Context) (done bool, err error) {
-			innerErr := c.Patch(ctx, mutatingWebhook, client.StrategicMergeFrom(oldMutatingWebhook))
-			if innerErr != nil {
-				log.Error(innerErr, "error patch ca")
-				return false, nil
-			}
-			return true, nil
-		})
-
+		err = patchWithBackoff(ctx, c, mutatingWebhook, client.StrategicMergeFrom(oldMutatingWebhook))
 		if err != nil {
 			return err
 		}
@@ -143,6 +158,81 @@ func SyncCert(ctx context.Context, c client.Client, ns, name, domain, certDir st
 	return nil
 }
 
+// syncValidatingWebhookCA patches the ca bundle of the validating webhook, it
+// is skipped when the validating webhook is not deployed.
+func syncValidatingWebhookCA(ctx context.Context, c client.Client, name string, caCertBytes []byte) error {
+	validatingWebhook := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	err := c.Get(ctx, types.NamespacedName{Name: name}, validatingWebhook)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("ValidatingWebhook not found, skip update ca bundle", "name", name)
+			return nil
+		}
+		return err
+	}
+	oldValidatingWebhook := validatingWebhook.DeepCopy()
+	changed := false
+	for i, hook := range validatingWebhook.Webhooks {
+		if bytes.Equal(hook.ClientConfig.CABundle, caCertBytes) {
+			continue
+		}
+		changed = true
+		validatingWebhook.Webhooks[i].ClientConfig.CABundle = caCertBytes
+	}
+	if changed {
+		err = patchWithBackoff(ctx, c, validatingWebhook, client.MergeFrom(oldValidatingWebhook))
+		if err != nil {
+			return err
+		}
+		log.Info("update ValidatingWebhook ca bundle success")
+	}
+	return nil
+}
+
+// syncConversionWebhookCA patches the ca bundle of the conversion webhook of
+// the CRDs served by the controller, CRDs without webhook conversion are
+// skipped.
+func syncConversionWebhookCA(ctx context.Context, c client.Client, caCertBytes []byte) error {
+	for _, crdName := range conversionCRDs {
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		err := c.Get(ctx, types.NamespacedName{Name: crdName}, crd)
+		if err != nil {
+			return fmt.Errorf("error get crd %s, %w", crdName, err)
+		}
+		if crd.Spec.Conversion == nil || crd.Spec.Conversion.Strategy != apiextensionsv1.WebhookConverter ||
+			crd.Spec.Conversion.Webhook == nil || crd.Spec.Conversion.Webhook.ClientConfig == nil {
+			continue
+		}
+		if bytes.Equal(crd.Spec.Conversion.Webhook.ClientConfig.CABundle, caCertBytes) {
+			continue
+		}
+		oldCRD := crd.DeepCopy()
+		crd.Spec.Conversion.Webhook.ClientConfig.CABundle = caCertBytes
+		err = patchWithBackoff(ctx, c, crd, client.MergeFrom(oldCRD))
+		if err != nil {
+			return err
+		}
+		log.Info("update CRD conversion webhook ca bundle success", "crd", crdName)
+	}
+	return nil
+}
+
+func patchWithBackoff(ctx context.Context, c client.Client, obj client.Object, patch client.Patch) error {
+	return wait.ExponentialBackoffWithContext(ctx, wait.Backoff{
+		Duration: 1 * time.Second,
+		Steps:    3,
+		Factor:   2,
+		Jitter:   1.1,
+	}, func(ctx context.Context) (done bool, err error) {
+		innerErr := c.Patch(ctx, obj, patch)
+		if innerErr != nil {
+			log.Error(innerErr, "error patch ca")
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
 func GenerateCerts(serviceNamespace, serviceName, clusterDomain string) (*corev1.Secret, error) {
 	var caPEM, serverCertPEM, serverPrivateKeyPEM *bytes.Buffer
 	ca := &x509.Certificate{
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sync/atomic"
 
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/utils"
 	"k8s.io/utils/ptr"
@@ -21,7 +22,11 @@ const (
 var configLog = ctrl.Log.WithName("config")
 
 var (
-	cfg        *types.Config
+	// fileCfg is the config file, cfg is the current config with the cluster
+	// policy applied on it. A config is never modified once stored, it is
+	// replaced as a whole.
+	fileCfg    *types.Config
+	cfg        atomic.Pointer[types.Config]
 	credential *types.Credentials
 )
 
@@ -32,20 +37,22 @@ func getRegion() (string, error) {
 
 func InitConfig(configPath, credentialPath string) error {
 	var err error
-	cfg, err = parseConfig(configPath)
+	fileCfg, err = parseConfig(configPath)
 	if err != nil {
 		return err
 	}
+	cfg.Store(fileCfg)
 	credential, err = parseCredential(credentialPath)
 	if err != nil {
 		return err
 	}
-	configLog.Info("init config", "config", cfg)
+	configLog.Info("init config", "config", fileCfg)
 	return nil
 }
 
+// GetConfig returns the current config, callers must not modify it.
 func GetConfig() *types.Config {
-	return cfg
+	return cfg.Load()
 }
 
 func GetCredential() *types.Credentials {
@@ -74,6 +81,24 @@ func parseConfig(configPath string) (*types.Config, error) {
 	if erdmaConfig.WaitNodeReadyTimeoutSeconds == 0 {
 		erdmaConfig.WaitNodeReadyTimeoutSeconds = 300
 	}
+	if erdmaConfig.ECSQPS <= 0 {
+		erdmaConfig.ECSQPS = 10
+	}
+	if erdmaConfig.ECSBurst <= 0 {
+		erdmaConfig.ECSBurst = 20
+	}
+	if erdmaConfig.OrphanERIGC.IntervalSeconds == 0 {
+		erdmaConfig.OrphanERIGC.IntervalSeconds = 600
+	}
+	if erdmaConfig.OrphanERIGC.GracePeriodSeconds == 0 {
+		erdmaConfig.OrphanERIGC.GracePeriodSeconds = 3600
+	}
+	if erdmaConfig.ManagedSecurityGroup.Name == "" {
+		erdmaConfig.ManagedSecurityGroup.Name = "alibabacloud-erdma-controller"
+	}
+	if erdmaConfig.ManagedSecurityGroup.RepairIntervalSeconds == 0 {
+		erdmaConfig.ManagedSecurityGroup.RepairIntervalSeconds = 600
+	}
 	if erdmaConfig.Region == "" {
 		configLog.Info("region is not set, try to get region from metaserver")
 		erdmaConfig.Region, err = getRegion()
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+)
+
+// startCfg is the config the controller started with, see InitClusterPolicy.
+var startCfg *types.Config
+
+// InitClusterPolicy applies the cluster policy when the controller starts,
+// including the settings which are only read at startup, see restartOnly.
+func InitClusterPolicy(policy *v1beta2.ControllerPolicy) {
+	startCfg = nil
+	ApplyClusterPolicy(policy)
+	startCfg = GetConfig()
+}
+
+// ApplyClusterPolicy overlays the controller settings of the cluster policy on
+// the config file and makes it the current config, a nil policy restores the
+// config file. Once the controller started, the settings which are only read
+// at startup keep their startup values, see RestartRequired. It reports
+// whether the current config changed.
+func ApplyClusterPolicy(policy *v1beta2.ControllerPolicy) bool {
+	newCfg := mergePolicy(fileCfg, policy)
+	if startCfg != nil {
+		for _, setting := range restartOnly {
+			*setting.field(newCfg) = *setting.field(startCfg)
+		}
+	}
+	oldCfg := cfg.Swap(newCfg)
+	changed := !reflect.DeepEqual(oldCfg, newCfg)
+	if changed {
+		configLog.Info("apply cluster policy", "config", newCfg)
+	}
+	return changed
+}
+
+// restartOnly are the settings which only take effect when the controller
+// starts, by their name in the cluster policy.
+var restartOnly = []struct {
+	name  string
+	field func(*types.Config) *string
+}{
+	{"region", func(c *types.Config) *string { return &c.Region }},
+	{"controllerNamespace", func(c *types.Config) *string { return &c.ControllerNamespace }},
+	{"controllerName", func(c *types.Config) *string { return &c.ControllerName }},
+	{"clusterDomain", func(c *types.Config) *string { return &c.ClusterDomain }},
+	{"certDir", func(c *types.Config) *string { return &c.CertDir }},
+}
+
+// RestartRequired returns the names of the settings of the cluster policy
+// which differ from the ones the controller started with, they only take
+// effect when the controller restarts.
+func RestartRequired(policy *v1beta2.ControllerPolicy) []string {
+	if startCfg == nil {
+		return nil
+	}
+	newCfg := mergePolicy(fileCfg, policy)
+	var names []string
+	for _, setting := range restartOnly {
+		if *setting.field(newCfg) != *setting.field(startCfg) {
+			names = append(names, setting.name)
+		}
+	}
+	return names
+}
+
+func mergePolicy(base *types.Config, policy *v1beta2.ControllerPolicy) *types.Config {
+	if base == nil {
+		base = &types.Config{}
+	}
+	merged := *base
+	merged.NodeSelector = maps.Clone(base.NodeSelector)
+	merged.VSwitches = maps.Clone(base.VSwitches)
+	merged.ManagedSecurityGroup.Rules = slices.Clone(base.ManagedSecurityGroup.Rules)
+	if policy == nil {
+		return &merged
+	}
+	if policy.Region != "" {
+		merged.Region = policy.Region
+	}
+	if policy.ManageNonOwnedERIs != nil {
+		merged.ManageNonOwnedERIs = *policy.ManageNonOwnedERIs
+	}
+	if policy.ControllerNamespace != "" {
+		merged.ControllerNamespace = policy.ControllerNamespace
+	}
+	if policy.ControllerName != "" {
+		merged.ControllerName = policy.ControllerName
+	}
+	if policy.ClusterDomain != "" {
+		merged.ClusterDomain = policy.ClusterDomain
+	}
+	if policy.CertDir != "" {
+		merged.CertDir = policy.CertDir
+	}
+	if policy.EnableDevicePlugin != nil {
+		merged.EnableDevicePlugin = policy.EnableDevicePlugin
+	}
+	if policy.EnableWebhook != nil {
+		merged.EnableWebhook = policy.EnableWebhook
+	}
+	if policy.SMCInitImage != "" {
+		merged.SMCInitImage = policy.SMCInitImage
+	}
+	if policy.EnableInitContainerInject != nil {
+		merged.EnableInitContainerInject = policy.EnableInitContainerInject
+	}
+	if policy.NodeSelector != nil {
+		merged.NodeSelector = maps.Clone(policy.NodeSelector)
+	}
+	if policy.WaitNodeReadyTimeoutSeconds != nil {
+		merged.WaitNodeReadyTimeoutSeconds = *policy.WaitNodeReadyTimeoutSeconds
+	}
+	if gc := policy.OrphanERIGC; gc != nil {
+		if gc.Enabled != nil {
+			merged.OrphanERIGC.Enabled = *gc.Enabled
+		}
+		if gc.IntervalSeconds != nil {
+			merged.OrphanERIGC.IntervalSeconds = *gc.IntervalSeconds
+		}
+		if gc.GracePeriodSeconds != nil {
+			merged.OrphanERIGC.GracePeriodSeconds = *gc.GracePeriodSeconds
+		}
+		if gc.ReportOnly != nil {
+			merged.OrphanERIGC.ReportOnly = *gc.ReportOnly
+		}
+	}
+	if policy.RebalanceQueuePairs != nil {
+		merged.RebalanceQueuePairs = *policy.RebalanceQueuePairs
+	}
+	if policy.VSwitches != nil {
+		merged.VSwitches = maps.Clone(policy.VSwitches)
+	}
+	if policy.VSwitchSelectionPolicy != nil {
+		merged.VSwitchSelectionPolicy = *policy.VSwitchSelectionPolicy
+	}
+	if policy.EnableIPv6 != nil {
+		merged.EnableIPv6 = *policy.EnableIPv6
+	}
+	if policy.DryRun != nil {
+		merged.DryRun = *policy.DryRun
+	}
+	if sg := policy.ManagedSecurityGroup; sg != nil {
+		if sg.Enabled != nil {
+			merged.ManagedSecurityGroup.Enabled = *sg.Enabled
+		}
+		if sg.Name != "" {
+			merged.ManagedSecurityGroup.Name = sg.Name
+		}
+		if sg.Rules != nil {
+			merged.ManagedSecurityGroup.Rules = make([]types.SecurityGroupRule, 0, len(sg.Rules))
+			for _, rule := range sg.Rules {
+				merged.ManagedSecurityGroup.Rules = append(merged.ManagedSecurityGroup.Rules, types.SecurityGroupRule(rule))
+			}
+		}
+		if sg.RepairIntervalSeconds != nil {
+			merged.ManagedSecurityGroup.RepairIntervalSeconds = *sg.RepairIntervalSeconds
+		}
+	}
+	return &merged
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/ptr"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+)
+
+func TestMergePolicy(t *testing.T) {
+	base := &types.Config{
+		Region:                      "cn-hangzhou",
+		ManageNonOwnedERIs:          true,
+		EnableWebhook:               ptr.To(true),
+		EnableDevicePlugin:          ptr.To(true),
+		NodeSelector:                map[string]string{"a": "b"},
+		WaitNodeReadyTimeoutSeconds: 300,
+		ManagedSecurityGroup:        types.ManagedSecurityGroup{Name: "erdma"},
+	}
+	tests := []struct {
+		name     string
+		policy   *v1beta2.ControllerPolicy
+		expected *types.Config
+	}{
+		{
+			name:     "no policy",
+			policy:   nil,
+			expected: base,
+		},
+		{
+			name:     "empty policy",
+			policy:   &v1beta2.ControllerPolicy{},
+			expected: base,
+		},
+		{
+			name: "override",
+			policy: &v1beta2.ControllerPolicy{
+				ManageNonOwnedERIs:          ptr.To(false),
+				EnableWebhook:               ptr.To(false),
+				NodeSelector:                map[string]string{"c": "d"},
+				WaitNodeReadyTimeoutSeconds: ptr.To(0),
+				OrphanERIGC:                 &v1beta2.OrphanERIGCPolicy{Enabled: ptr.To(true), ReportOnly: ptr.To(true)},
+				RebalanceQueuePairs:         ptr.To(true),
+				VSwitches:                   map[string][]string{"cn-hangzhou-k": {"vsw-1", "vsw-2"}},
+				VSwitchSelectionPolicy:      ptr.To("ordered"),
+				EnableIPv6:                  ptr.To(true),
+				DryRun:                      ptr.To(true),
+				ManagedSecurityGroup: &v1beta2.ManagedSecurityGroupPolicy{
+					Enabled: ptr.To(true),
+					Rules:   []v1beta2.SecurityGroupRule{{Protocol: "TCP", PortRange: "22/22", CIDR: "10.0.0.0/8"}},
+				},
+			},
+			expected: &types.Config{
+				Region:                      "cn-hangzhou",
+				ManageNonOwnedERIs:          false,
+				EnableWebhook:               ptr.To(false),
+				EnableDevicePlugin:          ptr.To(true),
+				NodeSelector:                map[string]string{"c": "d"},
+				WaitNodeReadyTimeoutSeconds: 0,
+				OrphanERIGC:                 types.OrphanERIGC{Enabled: true, ReportOnly: true},
+				RebalanceQueuePairs:         true,
+				VSwitches:                   map[string][]string{"cn-hangzhou-k": {"vsw-1", "vsw-2"}},
+				VSwitchSelectionPolicy:      "ordered",
+				EnableIPv6:                  true,
+				DryRun:                      true,
+				ManagedSecurityGroup: types.ManagedSecurityGroup{
+					Enabled: true,
+					Name:    "erdma",
+					Rules:   []types.SecurityGroupRule{{Protocol: "TCP", PortRange: "22/22", CIDR: "10.0.0.0/8"}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, mergePolicy(base, tt.policy))
+		})
+	}
+}
+
+func TestApplyClusterPolicy(t *testing.T) {
+	fileCfg = &types.Config{Region: "cn-hangzhou", NodeSelector: map[string]string{"a": "b"}}
+	cfg.Store(fileCfg)
+	defer func() {
+		fileCfg = nil
+		cfg.Store(nil)
+	}()
+
+	assert.False(t, ApplyClusterPolicy(nil))
+	assert.True(t, ApplyClusterPolicy(&v1beta2.ControllerPolicy{NodeSelector: map[string]string{"c": "d"}}))
+	assert.Equal(t, map[string]string{"c": "d"}, GetConfig().NodeSelector)
+	assert.Equal(t, map[string]string{"a": "b"}, fileCfg.NodeSelector)
+	assert.False(t, ApplyClusterPolicy(&v1beta2.ControllerPolicy{NodeSelector: map[string]string{"c": "d"}}))
+	assert.True(t, ApplyClusterPolicy(nil))
+	assert.Equal(t, map[string]string{"a": "b"}, GetConfig().NodeSelector)
+}
+
+func TestRestartRequired(t *testing.T) {
+	fileCfg = &types.Config{Region: "cn-hangzhou", CertDir: "/certs"}
+	cfg.Store(fileCfg)
+	defer func() {
+		fileCfg = nil
+		startCfg = nil
+		cfg.Store(nil)
+	}()
+
+	InitClusterPolicy(&v1beta2.ControllerPolicy{Region: "cn-beijing"})
+	assert.Equal(t, "cn-beijing", GetConfig().Region)
+	assert.Empty(t, RestartRequired(&v1beta2.ControllerPolicy{Region: "cn-beijing"}))
+
+	// the settings read at startup keep their startup values
+	policy := &v1beta2.ControllerPolicy{Region: "cn-shanghai", CertDir: "/tmp", NodeSelector: map[string]string{"a": "b"}}
+	assert.True(t, ApplyClusterPolicy(policy))
+	assert.Equal(t, "cn-beijing", GetConfig().Region)
+	assert.Equal(t, "/certs", GetConfig().CertDir)
+	assert.Equal(t, map[string]string{"a": "b"}, GetConfig().NodeSelector)
+	assert.Equal(t, []string{"region", "certDir"}, RestartRequired(policy))
+	assert.Equal(t, []string{"region"}, RestartRequired(nil))
+}
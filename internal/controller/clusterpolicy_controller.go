@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/config"
+)
+
+// ClusterPolicyReconciler applies the ERdmaClusterPolicy on the controller
+// config of every replica, its status is written by the leader with
+// ClusterPolicyStatusReconciler.
+type ClusterPolicyReconciler struct {
+	client.Client
+
+	// ConfigEvents receives an event whenever the controller config changed,
+	// it should be buffered, the event is dropped when one is still pending.
+	ConfigEvents chan<- event.GenericEvent
+}
+
+// +kubebuilder:rbac:groups=network.alibabacloud.com,resources=erdmaclusterpolicies,verbs=get;list;watch
+
+func (r *ClusterPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	policyLogger := log.FromContext(ctx).WithName("cluster-policy")
+
+	policy := &networkv1beta2.ERdmaClusterPolicy{}
+	err := r.Client.Get(ctx, req.NamespacedName, policy)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		policy = nil
+	}
+
+	var controllerPolicy *networkv1beta2.ControllerPolicy
+	if policy != nil {
+		controllerPolicy = &policy.Spec.Controller
+	}
+	if config.ApplyClusterPolicy(controllerPolicy) {
+		policyLogger.Info("controller config changed by cluster policy")
+		r.notifyConfigChanged(policy)
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *ClusterPolicyReconciler) notifyConfigChanged(policy *networkv1beta2.ERdmaClusterPolicy) {
+	if r.ConfigEvents == nil {
+		return
+	}
+	if policy == nil {
+		policy = &networkv1beta2.ERdmaClusterPolicy{}
+		policy.Name = networkv1beta2.DefaultClusterPolicyName
+	}
+	select {
+	case r.ConfigEvents <- event.GenericEvent{Object: policy}:
+	default:
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("cluster-policy-controller").
+		// the config is used by the webhooks on every replica
+		WithOptions(controller.Options{NeedLeaderElection: ptr.To(false)}).
+		Watches(&networkv1beta2.ERdmaClusterPolicy{}, handler.EnqueueRequestsFromMapFunc(defaultClusterPolicy)).
+		Complete(r)
+}
+
+// ClusterPolicyStatusReconciler aggregates the effective agent settings of
+// each node in the status of the ERdmaClusterPolicy, and reports the settings
+// waiting for a restart of the controller. It only runs on the leader.
+type ClusterPolicyStatusReconciler struct {
+	client.Client
+}
+
+// +kubebuilder:rbac:groups=network.alibabacloud.com,resources=erdmaclusterpolicies/status,verbs=get;update;patch
+
+func (r *ClusterPolicyStatusReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	policy := &networkv1beta2.ERdmaClusterPolicy{}
+	err := r.Client.Get(ctx, req.NamespacedName, policy)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	devices := &networkv1beta2.ERdmaDeviceList{}
+	err = r.Client.List(ctx, devices)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	status := networkv1beta2.ERdmaClusterPolicyStatus{
+		ObservedGeneration: policy.Generation,
+	}
+	for _, device := range devices.Items {
+		if device.Status.Node.Settings == nil {
+			continue
+		}
+		status.Nodes = append(status.Nodes, networkv1beta2.NodePolicyStatus{
+			NodeName:         device.Name,
+			PolicyGeneration: device.Status.Node.PolicyGeneration,
+			Settings:         *device.Status.Node.Settings,
+		})
+	}
+	sort.Slice(status.Nodes, func(i, j int) bool {
+		return status.Nodes[i].NodeName < status.Nodes[j].NodeName
+	})
+	status.Conditions = slices.Clone(policy.Status.Conditions)
+	restartRequired := metav1.Condition{
+		Type:               networkv1beta2.ConditionRestartRequired,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Applied",
+		ObservedGeneration: policy.Generation,
+	}
+	if names := config.RestartRequired(&policy.Spec.Controller); len(names) > 0 {
+		restartRequired.Status = metav1.ConditionTrue
+		restartRequired.Reason = "RestartOnlySettingsChanged"
+		restartRequired.Message = fmt.Sprintf("%s only take effect when the controller restarts", strings.Join(names, ", "))
+	}
+	meta.SetStatusCondition(&status.Conditions, restartRequired)
+	if equality.Semantic.DeepEqual(status, policy.Status) {
+		return ctrl.Result{}, nil
+	}
+	policy.Status = status
+	return ctrl.Result{}, r.Client.Status().Update(ctx, policy)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterPolicyStatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("cluster-policy-status-controller").
+		Watches(&networkv1beta2.ERdmaClusterPolicy{}, handler.EnqueueRequestsFromMapFunc(defaultClusterPolicy)).
+		Watches(&networkv1beta2.ERdmaDevice{}, handler.EnqueueRequestsFromMapFunc(
+			func(context.Context, client.Object) []reconcile.Request {
+				return []reconcile.Request{{NamespacedName: k8stypes.NamespacedName{Name: networkv1beta2.DefaultClusterPolicyName}}}
+			})).
+		Complete(r)
+}
+
+// defaultClusterPolicy requeues the default ERdmaClusterPolicy, other
+// policies are ignored.
+func defaultClusterPolicy(_ context.Context, obj client.Object) []reconcile.Request {
+	if obj.GetName() != networkv1beta2.DefaultClusterPolicyName {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(obj)}}
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/config"
+)
+
+func TestClusterPolicyReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = networkv1beta2.AddToScheme(scheme)
+
+	policy := &networkv1beta2.ERdmaClusterPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: networkv1beta2.DefaultClusterPolicyName, Generation: 3},
+		Spec: networkv1beta2.ERdmaClusterPolicySpec{
+			Controller: networkv1beta2.ControllerPolicy{
+				NodeSelector: map[string]string{"erdma": "true"},
+			},
+			Agent: networkv1beta2.AgentPolicy{
+				JumboFrameMTU: ptr.To(9000),
+			},
+		},
+	}
+	devices := []client.Object{
+		&networkv1beta2.ERdmaDevice{
+			ObjectMeta: metav1.ObjectMeta{Name: "node2"},
+			Status: networkv1beta2.ERdmaDeviceStatus{Node: networkv1beta2.NodeStatus{
+				PolicyGeneration: 3,
+				Settings:         &networkv1beta2.AgentSettings{JumboFrameMTU: 9000},
+			}},
+		},
+		&networkv1beta2.ERdmaDevice{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status: networkv1beta2.ERdmaDeviceStatus{Node: networkv1beta2.NodeStatus{
+				PolicyGeneration: 2,
+				Settings:         &networkv1beta2.AgentSettings{JumboFrameMTU: 8500},
+			}},
+		},
+		// agent not running yet
+		&networkv1beta2.ERdmaDevice{
+			ObjectMeta: metav1.ObjectMeta{Name: "node3"},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(append(devices, policy)...).
+		WithStatusSubresource(&networkv1beta2.ERdmaClusterPolicy{}).
+		Build()
+	configEvents := make(chan event.GenericEvent, 1)
+	r := &ClusterPolicyReconciler{Client: c, ConfigEvents: configEvents}
+	statusReconciler := &ClusterPolicyStatusReconciler{Client: c}
+	defer config.ApplyClusterPolicy(nil)
+
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(policy)}
+	_, err := r.Reconcile(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"erdma": "true"}, config.GetConfig().NodeSelector)
+	assert.Len(t, configEvents, 1)
+
+	// the config reconciler does not write the status
+	updated := &networkv1beta2.ERdmaClusterPolicy{}
+	assert.NoError(t, c.Get(context.Background(), req.NamespacedName, updated))
+	assert.Zero(t, updated.Status.ObservedGeneration)
+
+	_, err = statusReconciler.Reconcile(context.Background(), req)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Get(context.Background(), req.NamespacedName, updated))
+	for i := range updated.Status.Conditions {
+		updated.Status.Conditions[i].LastTransitionTime = metav1.Time{}
+	}
+	assert.Equal(t, networkv1beta2.ERdmaClusterPolicyStatus{
+		ObservedGeneration: 3,
+		Nodes: []networkv1beta2.NodePolicyStatus{
+			{NodeName: "node1", PolicyGeneration: 2, Settings: networkv1beta2.AgentSettings{JumboFrameMTU: 8500}},
+			{NodeName: "node2", PolicyGeneration: 3, Settings: networkv1beta2.AgentSettings{JumboFrameMTU: 9000}},
+		},
+		Conditions: []metav1.Condition{{
+			Type:               networkv1beta2.ConditionRestartRequired,
+			Status:             metav1.ConditionFalse,
+			Reason:             "Applied",
+			ObservedGeneration: 3,
+		}},
+	}, updated.Status)
+
+	// unchanged config does not requeue nodes again
+	<-configEvents
+	_, err = r.Reconcile(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Len(t, configEvents, 0)
+
+	// deleting the policy restores the config file
+	assert.NoError(t, c.Delete(context.Background(), updated))
+	_, err = r.Reconcile(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Nil(t, config.GetConfig().NodeSelector)
+	assert.Len(t, configEvents, 1)
+}
@@ -105,6 +105,7 @@ func (s *stsTokenCredential) GetCredential() (*credentials.CredentialModel, erro
 	if s.sessionCredential == nil || s.needUpdateCredential() {
 		err := s.updateCredential()
 		if err != nil {
+			credentialRefreshFailuresTotal.Inc()
 			if s.credentialExpiration.After(time.Now()) {
 				// credential still valid
 			} else {
@@ -0,0 +1,390 @@
+package controller
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
+	"github.com/alibabacloud-go/endpoint-util/service"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/aliyun/credentials-go/credentials"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var ecsLog = ctrl.Log.WithName("ECS")
+
+// ecsNetworks are the endpoint networks of ECS in the order they are tried.
+var ecsNetworks = []string{"vpc", "public"}
+
+// ecsPageSize is the page size of the paginated ECS calls.
+const ecsPageSize = 100
+
+// vSwitchPageSize is the page size of DescribeVSwitches, which pages by
+// number with at most 50 vSwitches a page.
+const vSwitchPageSize = 50
+
+// ecsBackoff is the backoff of retried ECS calls.
+var ecsBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    5,
+	Cap:      10 * time.Second,
+}
+
+// ecsErrorClass classifies a failed ECS call for retry.
+type ecsErrorClass int
+
+const (
+	// ecsErrorPermanent fails the same when retried.
+	ecsErrorPermanent ecsErrorClass = iota
+	// ecsErrorThrottled is rejected by the flow control of ECS before it is
+	// processed.
+	ecsErrorThrottled
+	// ecsErrorTransient may succeed when retried, but the call may have been
+	// processed.
+	ecsErrorTransient
+	// ecsErrorUnreachable failed to connect to the endpoint, the call has not
+	// been sent.
+	ecsErrorUnreachable
+)
+
+// ecsTransientCodes are the error codes of ECS which may succeed when retried.
+var ecsTransientCodes = map[string]bool{
+	"InternalError":         true,
+	"UnknownError":          true,
+	"ServiceUnavailable":    true,
+	"OperationConflict":     true,
+	"LastTokenProcessing":   true,
+	"IdempotenceProcessing": true,
+}
+
+func classifyECSError(err error) ecsErrorClass {
+	var sdkErr *tea.SDKError
+	if errors.As(err, &sdkErr) {
+		code := tea.StringValue(sdkErr.Code)
+		switch {
+		case strings.HasPrefix(code, "Throttling"):
+			return ecsErrorThrottled
+		case ecsTransientCodes[code] || tea.IntValue(sdkErr.StatusCode) >= 500:
+			return ecsErrorTransient
+		}
+		return ecsErrorPermanent
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	if errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial") {
+		return ecsErrorUnreachable
+	}
+	return ecsErrorTransient
+}
+
+// ECS is the ECS API used by the controller, it is implemented by the ECS
+// access layer and by the in-memory backend of the fakeecs package.
+type ECS interface {
+	DescribeInstances(req *ecs.DescribeInstancesRequest) (*ecs.DescribeInstancesResponse, error)
+	DescribeInstanceTypes(req *ecs.DescribeInstanceTypesRequest) (*ecs.DescribeInstanceTypesResponse, error)
+	DescribeInstanceAttribute(req *ecs.DescribeInstanceAttributeRequest) (*ecs.DescribeInstanceAttributeResponse, error)
+	// DescribeNetworkInterfaces returns the ENIs of all pages matching req.
+	DescribeNetworkInterfaces(req *ecs.DescribeNetworkInterfacesRequest) ([]*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, error)
+	CreateNetworkInterface(req *ecs.CreateNetworkInterfaceRequest) (*ecs.CreateNetworkInterfaceResponse, error)
+	AttachNetworkInterface(req *ecs.AttachNetworkInterfaceRequest) (*ecs.AttachNetworkInterfaceResponse, error)
+	DetachNetworkInterface(req *ecs.DetachNetworkInterfaceRequest) (*ecs.DetachNetworkInterfaceResponse, error)
+	DeleteNetworkInterface(req *ecs.DeleteNetworkInterfaceRequest) (*ecs.DeleteNetworkInterfaceResponse, error)
+	ModifyNetworkInterfaceAttribute(req *ecs.ModifyNetworkInterfaceAttributeRequest) (*ecs.ModifyNetworkInterfaceAttributeResponse, error)
+	TagResources(req *ecs.TagResourcesRequest) (*ecs.TagResourcesResponse, error)
+	UntagResources(req *ecs.UntagResourcesRequest) (*ecs.UntagResourcesResponse, error)
+	// DescribeVSwitches returns the vSwitches of all pages matching req.
+	DescribeVSwitches(req *ecs.DescribeVSwitchesRequest) ([]*ecs.DescribeVSwitchesResponseBodyVSwitchesVSwitch, error)
+	// AssignIpv6Addresses is not idempotent, the addresses of a retried call
+	// may be assigned twice.
+	AssignIpv6Addresses(req *ecs.AssignIpv6AddressesRequest) (*ecs.AssignIpv6AddressesResponse, error)
+	// DescribeSecurityGroups returns the security groups of all pages matching req.
+	DescribeSecurityGroups(req *ecs.DescribeSecurityGroupsRequest) ([]*ecs.DescribeSecurityGroupsResponseBodySecurityGroupsSecurityGroup, error)
+	CreateSecurityGroup(req *ecs.CreateSecurityGroupRequest) (*ecs.CreateSecurityGroupResponse, error)
+	// DescribeSecurityGroupRules returns the rules of all pages of the
+	// security group of req.
+	DescribeSecurityGroupRules(req *ecs.DescribeSecurityGroupAttributeRequest) ([]*ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission, error)
+	AuthorizeSecurityGroup(req *ecs.AuthorizeSecurityGroupRequest) (*ecs.AuthorizeSecurityGroupResponse, error)
+	AuthorizeSecurityGroupEgress(req *ecs.AuthorizeSecurityGroupEgressRequest) (*ecs.AuthorizeSecurityGroupEgressResponse, error)
+	RevokeSecurityGroup(req *ecs.RevokeSecurityGroupRequest) (*ecs.RevokeSecurityGroupResponse, error)
+	RevokeSecurityGroupEgress(req *ecs.RevokeSecurityGroupEgressRequest) (*ecs.RevokeSecurityGroupEgressResponse, error)
+}
+
+var _ ECS = &ecsClient{}
+
+// ecsClient is the access layer of the ECS API shared by the reconcilers. All
+// calls share a token bucket rate limit, throttled calls are retried with
+// backoff, list calls are paginated, and the calls fall back between the VPC
+// and the public endpoint when one is unreachable.
+type ecsClient struct {
+	// clients are the clients of the endpoints in ecsNetworks, the active
+	// one is used until it is unreachable.
+	clients []*ecs.Client
+	active  atomic.Int32
+	limiter flowcontrol.RateLimiter
+	backoff wait.Backoff
+}
+
+func newECSClient(regionID string, cred credentials.Credential, qps float32, burst int) (*ecsClient, error) {
+	c := &ecsClient{
+		limiter: flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+		backoff: ecsBackoff,
+	}
+	for _, network := range ecsNetworks {
+		endpoint, err := service.GetEndpointRules(tea.String("ecs"), tea.String(regionID), tea.String("regional"), tea.String(network), nil)
+		if err != nil {
+			return nil, err
+		}
+		client, err := ecs.NewClient(&openapi.Config{
+			RegionId:     ptr.To(regionID),
+			UserAgent:    ptr.To("AlibabaCloud/ERdma-Controller/0.1"),
+			Credential:   cred,
+			EndpointType: tea.String("regional"),
+			Network:      tea.String(network),
+			Endpoint:     endpoint,
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.clients = append(c.clients, client)
+	}
+	return c, nil
+}
+
+// ecsCall calls fn with the active client under the rate limit. Throttled
+// calls and calls to an unreachable endpoint are retried with backoff, the
+// latter with the other endpoint, transient failures are only retried when
+// the call is idempotent. Each request is counted in the ECS metrics of
+// action.
+func ecsCall[T any](c *ecsClient, action string, idempotent bool, fn func(client *ecs.Client) (T, error)) (T, error) {
+	backoff := c.backoff
+	for {
+		c.limiter.Accept()
+		active := c.active.Load()
+		start := time.Now()
+		resp, err := fn(c.clients[active])
+		ecsRequestDuration.WithLabelValues(action).Observe(time.Since(start).Seconds())
+		ecsRequestsTotal.WithLabelValues(action, ecsErrorCode(err)).Inc()
+		if err == nil {
+			return resp, nil
+		}
+		class := classifyECSError(err)
+		if class == ecsErrorUnreachable && len(c.clients) > 1 {
+			next := (active + 1) % int32(len(c.clients))
+			if c.active.CompareAndSwap(active, next) {
+				ecsLog.Info("ecs endpoint unreachable, fall back to another endpoint",
+					"from", ecsNetworks[active], "to", ecsNetworks[next], "error", err.Error())
+			}
+		}
+		retry := class == ecsErrorThrottled || class == ecsErrorUnreachable || (class == ecsErrorTransient && idempotent)
+		if !retry || backoff.Steps <= 1 {
+			return resp, err
+		}
+		delay := backoff.Step()
+		ecsLog.Info("retry ecs call", "action", action, "after", delay, "error", err.Error())
+		time.Sleep(delay)
+	}
+}
+
+func (c *ecsClient) DescribeInstances(req *ecs.DescribeInstancesRequest) (*ecs.DescribeInstancesResponse, error) {
+	return ecsCall(c, "DescribeInstances", true, func(client *ecs.Client) (*ecs.DescribeInstancesResponse, error) {
+		return client.DescribeInstances(req)
+	})
+}
+
+func (c *ecsClient) DescribeInstanceTypes(req *ecs.DescribeInstanceTypesRequest) (*ecs.DescribeInstanceTypesResponse, error) {
+	return ecsCall(c, "DescribeInstanceTypes", true, func(client *ecs.Client) (*ecs.DescribeInstanceTypesResponse, error) {
+		return client.DescribeInstanceTypes(req)
+	})
+}
+
+func (c *ecsClient) DescribeInstanceAttribute(req *ecs.DescribeInstanceAttributeRequest) (*ecs.DescribeInstanceAttributeResponse, error) {
+	return ecsCall(c, "DescribeInstanceAttribute", true, func(client *ecs.Client) (*ecs.DescribeInstanceAttributeResponse, error) {
+		return client.DescribeInstanceAttribute(req)
+	})
+}
+
+// DescribeNetworkInterfaces returns the ENIs of all pages matching req.
+func (c *ecsClient) DescribeNetworkInterfaces(req *ecs.DescribeNetworkInterfacesRequest) ([]*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, error) {
+	pageReq := *req
+	pageReq.PageNumber = nil
+	pageReq.PageSize = nil
+	pageReq.MaxResults = ptr.To(int32(ecsPageSize))
+	var enis []*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet
+	for {
+		resp, err := ecsCall(c, "DescribeNetworkInterfaces", true, func(client *ecs.Client) (*ecs.DescribeNetworkInterfacesResponse, error) {
+			return client.DescribeNetworkInterfaces(&pageReq)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.Body.NetworkInterfaceSets != nil {
+			enis = append(enis, resp.Body.NetworkInterfaceSets.NetworkInterfaceSet...)
+		}
+		if tea.StringValue(resp.Body.NextToken) == "" {
+			return enis, nil
+		}
+		pageReq.NextToken = resp.Body.NextToken
+	}
+}
+
+// CreateNetworkInterface is not idempotent, it is only retried when throttled.
+func (c *ecsClient) CreateNetworkInterface(req *ecs.CreateNetworkInterfaceRequest) (*ecs.CreateNetworkInterfaceResponse, error) {
+	return ecsCall(c, "CreateNetworkInterface", false, func(client *ecs.Client) (*ecs.CreateNetworkInterfaceResponse, error) {
+		return client.CreateNetworkInterface(req)
+	})
+}
+
+func (c *ecsClient) AttachNetworkInterface(req *ecs.AttachNetworkInterfaceRequest) (*ecs.AttachNetworkInterfaceResponse, error) {
+	return ecsCall(c, "AttachNetworkInterface", true, func(client *ecs.Client) (*ecs.AttachNetworkInterfaceResponse, error) {
+		return client.AttachNetworkInterface(req)
+	})
+}
+
+func (c *ecsClient) DetachNetworkInterface(req *ecs.DetachNetworkInterfaceRequest) (*ecs.DetachNetworkInterfaceResponse, error) {
+	return ecsCall(c, "DetachNetworkInterface", true, func(client *ecs.Client) (*ecs.DetachNetworkInterfaceResponse, error) {
+		return client.DetachNetworkInterface(req)
+	})
+}
+
+func (c *ecsClient) DeleteNetworkInterface(req *ecs.DeleteNetworkInterfaceRequest) (*ecs.DeleteNetworkInterfaceResponse, error) {
+	return ecsCall(c, "DeleteNetworkInterface", true, func(client *ecs.Client) (*ecs.DeleteNetworkInterfaceResponse, error) {
+		return client.DeleteNetworkInterface(req)
+	})
+}
+
+func (c *ecsClient) ModifyNetworkInterfaceAttribute(req *ecs.ModifyNetworkInterfaceAttributeRequest) (*ecs.ModifyNetworkInterfaceAttributeResponse, error) {
+	return ecsCall(c, "ModifyNetworkInterfaceAttribute", true, func(client *ecs.Client) (*ecs.ModifyNetworkInterfaceAttributeResponse, error) {
+		return client.ModifyNetworkInterfaceAttribute(req)
+	})
+}
+
+func (c *ecsClient) TagResources(req *ecs.TagResourcesRequest) (*ecs.TagResourcesResponse, error) {
+	return ecsCall(c, "TagResources", true, func(client *ecs.Client) (*ecs.TagResourcesResponse, error) {
+		return client.TagResources(req)
+	})
+}
+
+func (c *ecsClient) UntagResources(req *ecs.UntagResourcesRequest) (*ecs.UntagResourcesResponse, error) {
+	return ecsCall(c, "UntagResources", true, func(client *ecs.Client) (*ecs.UntagResourcesResponse, error) {
+		return client.UntagResources(req)
+	})
+}
+
+// DescribeVSwitches returns the vSwitches of all pages matching req.
+func (c *ecsClient) DescribeVSwitches(req *ecs.DescribeVSwitchesRequest) ([]*ecs.DescribeVSwitchesResponseBodyVSwitchesVSwitch, error) {
+	pageReq := *req
+	pageReq.PageNumber = ptr.To(int32(1))
+	pageReq.PageSize = ptr.To(int32(vSwitchPageSize))
+	var vSwitches []*ecs.DescribeVSwitchesResponseBodyVSwitchesVSwitch
+	for {
+		resp, err := ecsCall(c, "DescribeVSwitches", true, func(client *ecs.Client) (*ecs.DescribeVSwitchesResponse, error) {
+			return client.DescribeVSwitches(&pageReq)
+		})
+		if err != nil {
+			return nil, err
+		}
+		var page []*ecs.DescribeVSwitchesResponseBodyVSwitchesVSwitch
+		if resp.Body.VSwitches != nil {
+			page = resp.Body.VSwitches.VSwitch
+		}
+		vSwitches = append(vSwitches, page...)
+		if len(page) < vSwitchPageSize || len(vSwitches) >= int(tea.Int32Value(resp.Body.TotalCount)) {
+			return vSwitches, nil
+		}
+		pageReq.PageNumber = ptr.To(*pageReq.PageNumber + 1)
+	}
+}
+
+// DescribeSecurityGroups returns the security groups of all pages matching req.
+func (c *ecsClient) DescribeSecurityGroups(req *ecs.DescribeSecurityGroupsRequest) ([]*ecs.DescribeSecurityGroupsResponseBodySecurityGroupsSecurityGroup, error) {
+	pageReq := *req
+	pageReq.PageNumber = nil
+	pageReq.PageSize = nil
+	pageReq.MaxResults = ptr.To(int32(ecsPageSize))
+	var groups []*ecs.DescribeSecurityGroupsResponseBodySecurityGroupsSecurityGroup
+	for {
+		resp, err := ecsCall(c, "DescribeSecurityGroups", true, func(client *ecs.Client) (*ecs.DescribeSecurityGroupsResponse, error) {
+			return client.DescribeSecurityGroups(&pageReq)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.Body.SecurityGroups != nil {
+			groups = append(groups, resp.Body.SecurityGroups.SecurityGroup...)
+		}
+		if tea.StringValue(resp.Body.NextToken) == "" {
+			return groups, nil
+		}
+		pageReq.NextToken = resp.Body.NextToken
+	}
+}
+
+// CreateSecurityGroup is not idempotent, it is only retried when throttled.
+func (c *ecsClient) CreateSecurityGroup(req *ecs.CreateSecurityGroupRequest) (*ecs.CreateSecurityGroupResponse, error) {
+	return ecsCall(c, "CreateSecurityGroup", false, func(client *ecs.Client) (*ecs.CreateSecurityGroupResponse, error) {
+		return client.CreateSecurityGroup(req)
+	})
+}
+
+// DescribeSecurityGroupRules returns the rules of all pages of the security
+// group of req.
+func (c *ecsClient) DescribeSecurityGroupRules(req *ecs.DescribeSecurityGroupAttributeRequest) ([]*ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission, error) {
+	pageReq := *req
+	pageReq.MaxResults = ptr.To(int32(ecsPageSize))
+	var rules []*ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission
+	for {
+		resp, err := ecsCall(c, "DescribeSecurityGroupAttribute", true, func(client *ecs.Client) (*ecs.DescribeSecurityGroupAttributeResponse, error) {
+			return client.DescribeSecurityGroupAttribute(&pageReq)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.Body.Permissions != nil {
+			rules = append(rules, resp.Body.Permissions.Permission...)
+		}
+		if tea.StringValue(resp.Body.NextToken) == "" {
+			return rules, nil
+		}
+		pageReq.NextToken = resp.Body.NextToken
+	}
+}
+
+func (c *ecsClient) AuthorizeSecurityGroup(req *ecs.AuthorizeSecurityGroupRequest) (*ecs.AuthorizeSecurityGroupResponse, error) {
+	return ecsCall(c, "AuthorizeSecurityGroup", true, func(client *ecs.Client) (*ecs.AuthorizeSecurityGroupResponse, error) {
+		return client.AuthorizeSecurityGroup(req)
+	})
+}
+
+func (c *ecsClient) AuthorizeSecurityGroupEgress(req *ecs.AuthorizeSecurityGroupEgressRequest) (*ecs.AuthorizeSecurityGroupEgressResponse, error) {
+	return ecsCall(c, "AuthorizeSecurityGroupEgress", true, func(client *ecs.Client) (*ecs.AuthorizeSecurityGroupEgressResponse, error) {
+		return client.AuthorizeSecurityGroupEgress(req)
+	})
+}
+
+func (c *ecsClient) RevokeSecurityGroup(req *ecs.RevokeSecurityGroupRequest) (*ecs.RevokeSecurityGroupResponse, error) {
+	return ecsCall(c, "RevokeSecurityGroup", true, func(client *ecs.Client) (*ecs.RevokeSecurityGroupResponse, error) {
+		return client.RevokeSecurityGroup(req)
+	})
+}
+
+func (c *ecsClient) RevokeSecurityGroupEgress(req *ecs.RevokeSecurityGroupEgressRequest) (*ecs.RevokeSecurityGroupEgressResponse, error) {
+	return ecsCall(c, "RevokeSecurityGroupEgress", true, func(client *ecs.Client) (*ecs.RevokeSecurityGroupEgressResponse, error) {
+		return client.RevokeSecurityGroupEgress(req)
+	})
+}
+
+// AssignIpv6Addresses is not idempotent, it is only retried when throttled.
+func (c *ecsClient) AssignIpv6Addresses(req *ecs.AssignIpv6AddressesRequest) (*ecs.AssignIpv6AddressesResponse, error) {
+	return ecsCall(c, "AssignIpv6Addresses", false, func(client *ecs.Client) (*ecs.AssignIpv6AddressesResponse, error) {
+		return client.AssignIpv6Addresses(req)
+	})
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestClassifyECSError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected ecsErrorClass
+	}{
+		{name: "throttling", err: &tea.SDKError{Code: tea.String("Throttling.User"), StatusCode: tea.Int(400)}, expected: ecsErrorThrottled},
+		{name: "transient code", err: &tea.SDKError{Code: tea.String("OperationConflict"), StatusCode: tea.Int(403)}, expected: ecsErrorTransient},
+		{name: "server error", err: &tea.SDKError{Code: tea.String("Unknown"), StatusCode: tea.Int(503)}, expected: ecsErrorTransient},
+		{name: "permanent", err: &tea.SDKError{Code: tea.String("InvalidParameter"), StatusCode: tea.Int(400)}, expected: ecsErrorPermanent},
+		{name: "dial", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, expected: ecsErrorUnreachable},
+		{name: "dns", err: &net.DNSError{Err: "no such host"}, expected: ecsErrorUnreachable},
+		{name: "read timeout", err: &net.OpError{Op: "read", Err: errors.New("i/o timeout")}, expected: ecsErrorTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, classifyECSError(tt.err))
+		})
+	}
+}
+
+func TestECSCall(t *testing.T) {
+	newClient := func() *ecsClient {
+		return &ecsClient{
+			clients: []*ecs.Client{{}, {}},
+			limiter: flowcontrol.NewFakeAlwaysRateLimiter(),
+			backoff: wait.Backoff{Steps: 3},
+		}
+	}
+	throttled := &tea.SDKError{Code: tea.String("Throttling"), StatusCode: tea.Int(400)}
+	transient := &tea.SDKError{Code: tea.String("InternalError"), StatusCode: tea.Int(500)}
+
+	t.Run("retry throttled", func(t *testing.T) {
+		calls := 0
+		resp, err := ecsCall(newClient(), "Test", false, func(_ *ecs.Client) (int, error) {
+			calls++
+			if calls < 3 {
+				return 0, throttled
+			}
+			return calls, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, resp)
+	})
+	t.Run("count requests by error code", func(t *testing.T) {
+		calls := 0
+		_, err := ecsCall(newClient(), "TestMetrics", false, func(_ *ecs.Client) (int, error) {
+			calls++
+			if calls < 2 {
+				return 0, throttled
+			}
+			return calls, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1.0, testutil.ToFloat64(ecsRequestsTotal.WithLabelValues("TestMetrics", "Throttling")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(ecsRequestsTotal.WithLabelValues("TestMetrics", "Success")))
+	})
+	t.Run("give up after the backoff steps", func(t *testing.T) {
+		calls := 0
+		_, err := ecsCall(newClient(), "Test", true, func(_ *ecs.Client) (int, error) {
+			calls++
+			return 0, transient
+		})
+		assert.ErrorIs(t, err, transient)
+		assert.Equal(t, 3, calls)
+	})
+	t.Run("no retry of transient errors when not idempotent", func(t *testing.T) {
+		calls := 0
+		_, err := ecsCall(newClient(), "Test", false, func(_ *ecs.Client) (int, error) {
+			calls++
+			return 0, transient
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls)
+	})
+	t.Run("fall back to the other endpoint", func(t *testing.T) {
+		c := newClient()
+		var used []*ecs.Client
+		_, err := ecsCall(c, "Test", false, func(client *ecs.Client) (int, error) {
+			used = append(used, client)
+			if client == c.clients[0] {
+				return 0, &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+			}
+			return 1, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []*ecs.Client{c.clients[0], c.clients[1]}, used)
+		assert.Equal(t, int32(1), c.active.Load())
+	})
+}
@@ -18,29 +18,59 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/samber/lo"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
-	networkv1 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/consts"
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/config"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/events"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
 )
 
+// agentReleaseTimeout is how long the ERIs of an opted out node wait for the
+// agent to release their devices, e.g. when no agent runs on the node.
+const agentReleaseTimeout = 2 * time.Minute
+
 // ERdmaDeviceReconciler reconciles a ERdmaDevice object
 type ERdmaDeviceReconciler struct {
 	client.Client
 	Scheme    *runtime.Scheme
 	EriClient *EriClient
+	// CtrlConfig overrides the current config of the config package.
+	CtrlConfig *types.Config
+	// Recorder records the Events of the ERIs on the ERdmaDevice.
+	Recorder *events.Recorder
+
+	// readyObserved are the UIDs of the ERdmaDevices whose time to all ERIs
+	// Ready is observed by ERdmaDevice name, see observeERIsReady. Entries are
+	// removed with their ERdmaDevice.
+	readyObserved sync.Map
 }
 
 // +kubebuilder:rbac:groups=network.alibabacloud.com,resources=erdmadevices,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=network.alibabacloud.com,resources=erdmadevices/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=network.alibabacloud.com,resources=erdmadevices/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -54,49 +84,272 @@ type ERdmaDeviceReconciler struct {
 func (r *ERdmaDeviceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	erdmaLogger := log.FromContext(ctx).WithName("erdma-controller")
 
-	device := networkv1.ERdmaDevice{}
+	device := networkv1beta2.ERdmaDevice{}
 	err := r.Client.Get(ctx, req.NamespacedName, &device)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			// e.g. removed without the finalizer
+			r.readyObserved.Delete(req.Name)
 			return ctrl.Result{}, nil
 		}
 		erdmaLogger.Error(err, "Failed to get erdma device")
 		return ctrl.Result{}, err
 	}
+	dryRun, err := r.dryRun(ctx, &device)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if dryRun {
+		// nothing is changed in ECS until the node leaves dry-run mode
+		erdmaLogger.Info("node in dry-run mode, skip erdma device", "erdma device", req.Name)
+		return ctrl.Result{RequeueAfter: planRefreshInterval}, nil
+	}
 	if !device.GetDeletionTimestamp().IsZero() {
-		return RemoveERdmaDevices(r.Client, ctx, req.Name)
+		return r.releaseERIs(ctx, &device)
+	}
+	// ERdmaDevices created by older versions have no finalizer
+	if controllerutil.AddFinalizer(&device, erdmaFinalizer) {
+		if err := r.Client.Update(ctx, &device); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 	erdmaLogger.WithValues("erdma device", req).Info("erdma device Added")
 
-	if len(device.Spec.Devices) == len(device.Status.Devices) {
-		eriNeedConfig := lo.ContainsBy(device.Status.Devices, func(item networkv1.DeviceStatus) bool {
-			return item.Status != networkv1.DeviceStatusReady
+	rebalance := r.ctrlConfig() != nil && r.ctrlConfig().RebalanceQueuePairs
+	if rebalance {
+		if err := r.planQueuePairs(ctx, &device); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if len(device.Spec.ERIs) == len(device.Status.ERIs) {
+		eriNeedConfig := lo.ContainsBy(device.Status.ERIs, func(item networkv1beta2.ERIStatus) bool {
+			return item.Phase != networkv1beta2.ERIPhaseReady
 		})
-		if !eriNeedConfig {
+		if !eriNeedConfig && (!rebalance || queuePairsBalanced(&device)) {
+			recordERIPhases(&device)
 			return ctrl.Result{}, nil
 		}
 	}
 
-	eriStatus, err := r.EriClient.EnsureEriForInstance(device.Spec.Devices)
+	eriStatus, err := r.EriClient.WithEvents(r.Recorder, &device).EnsureEriForInstance(&device.Spec, rebalance)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	device.Status.Devices = eriStatus
+	device.Status.ERIs = eriStatus
+	balanced := true
+	if rebalance {
+		balanced = setQueuePairsBalanced(&device)
+	}
 	err = r.Client.Status().Update(ctx, &device)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	if lo.ContainsBy(eriStatus, func(item networkv1.DeviceStatus) bool {
-		return item.Status != networkv1.DeviceStatusReady
+	recordERIPhases(&device)
+	r.observeERIsReady(ctx, &device)
+	if !balanced || lo.ContainsBy(eriStatus, func(item networkv1beta2.ERIStatus) bool {
+		return item.Phase != networkv1beta2.ERIPhaseReady
 	}) {
 		return ctrl.Result{Requeue: true, RequeueAfter: 10 * time.Second}, nil
 	}
 	return ctrl.Result{}, nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// planQueuePairs splits the queue pairs of the instance between the ERIs of
+// the device once per spec generation, and updates the spec with the changes.
+func (r *ERdmaDeviceReconciler) planQueuePairs(ctx context.Context, device *networkv1beta2.ERdmaDevice) error {
+	cond := meta.FindStatusCondition(device.Status.Conditions, networkv1beta2.ConditionQueuePairsBalanced)
+	if cond != nil && cond.ObservedGeneration == device.Generation {
+		return nil
+	}
+	var profile *networkv1beta2.ERdmaNodeProfile
+	if device.Spec.Profile != "" {
+		profile = &networkv1beta2.ERdmaNodeProfile{}
+		err := r.Client.Get(ctx, client.ObjectKey{Name: device.Spec.Profile}, profile)
+		if errors.IsNotFound(err) {
+			profile = nil
+		} else if err != nil {
+			return err
+		}
+	}
+	changes, err := r.EriClient.PlanQueuePairs(&device.Spec, profileLayout(profile))
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	log.FromContext(ctx).Info("rebalance queue pairs of eris", "erdma device", device.Name, "changes", changes)
+	for _, change := range changes {
+		for i := range device.Spec.ERIs {
+			if device.Spec.ERIs[i].ID == change.ID {
+				device.Spec.ERIs[i].QueuePair = change.To
+			}
+		}
+	}
+	status := device.Status.DeepCopy()
+	if err := r.Client.Update(ctx, device); err != nil {
+		return err
+	}
+	device.Status = *status
+	device.Status.QueuePairChanges = changes
+	return nil
+}
+
+// queuePairsBalanced returns whether the QueuePairsBalanced condition is True
+// for the current spec generation.
+func queuePairsBalanced(device *networkv1beta2.ERdmaDevice) bool {
+	cond := meta.FindStatusCondition(device.Status.Conditions, networkv1beta2.ConditionQueuePairsBalanced)
+	return cond != nil && cond.Status == metav1.ConditionTrue && cond.ObservedGeneration == device.Generation
+}
+
+// setQueuePairsBalanced reports whether the queue pair number of each ERI of
+// the device is the one in its spec, and sets the QueuePairsBalanced
+// condition.
+func setQueuePairsBalanced(device *networkv1beta2.ERdmaDevice) bool {
+	queuePairs := lo.SliceToMap(device.Status.ERIs, func(item networkv1beta2.ERIStatus) (string, int) {
+		return item.ID, item.QueuePair
+	})
+	var pending []string
+	for _, eri := range device.Spec.ERIs {
+		if eri.QueuePair != 0 && queuePairs[eri.ID] != eri.QueuePair {
+			pending = append(pending, fmt.Sprintf("%s from %d to %d", eri.ID, queuePairs[eri.ID], eri.QueuePair))
+		}
+	}
+	cond := metav1.Condition{
+		Type:               networkv1beta2.ConditionQueuePairsBalanced,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Balanced",
+		Message:            fmt.Sprintf("queue pair numbers of %d ERIs match the spec", len(device.Spec.ERIs)),
+		ObservedGeneration: device.Generation,
+	}
+	if len(pending) > 0 {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = "Rebalancing"
+		cond.Message = "changing queue pair numbers of " + strings.Join(pending, ", ")
+	}
+	meta.SetStatusCondition(&device.Status.Conditions, cond)
+	return len(pending) == 0
+}
+
+// observeERIsReady observes the time from the creation of the node of device
+// to all of its ERIs Ready, once per ERdmaDevice in the controller process.
+// ERdmaDevices whose ERIs are Ready when the controller starts are not
+// observed.
+func (r *ERdmaDeviceReconciler) observeERIsReady(ctx context.Context, device *networkv1beta2.ERdmaDevice) {
+	if len(device.Status.ERIs) == 0 || lo.ContainsBy(device.Status.ERIs, func(item networkv1beta2.ERIStatus) bool {
+		return item.Phase != networkv1beta2.ERIPhaseReady
+	}) {
+		return
+	}
+	if uid, ok := r.readyObserved.Load(device.Name); ok && uid == device.UID {
+		return
+	}
+	r.readyObserved.Store(device.Name, device.UID)
+	node := &corev1.Node{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: device.Name}, node); err != nil {
+		log.FromContext(ctx).Info("cannot get node of erdma device", "erdma device", device.Name, "error", err.Error())
+		return
+	}
+	nodeERIsReadySeconds.Observe(time.Since(node.CreationTimestamp.Time).Seconds())
+}
+
+// dryRun returns whether the node of device is in dry-run mode, see
+// dryRunNode.
+func (r *ERdmaDeviceReconciler) dryRun(ctx context.Context, device *networkv1beta2.ERdmaDevice) (bool, error) {
+	node := &corev1.Node{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: device.Name}, node); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		node = nil
+	}
+	return dryRunNode(node, r.ctrlConfig()), nil
+}
+
+func (r *ERdmaDeviceReconciler) ctrlConfig() *types.Config {
+	if r.CtrlConfig != nil {
+		return r.CtrlConfig
+	}
+	return config.GetConfig()
+}
+
+// releaseERIs releases the ERIs of a deleted ERdmaDevice, and removes its
+// finalizer once they are all released or retained. The ERIs of an opted out
+// node are released once the agent released their devices, and its converted
+// primary ENI is converted back.
+func (r *ERdmaDeviceReconciler) releaseERIs(ctx context.Context, device *networkv1beta2.ERdmaDevice) (ctrl.Result, error) {
+	erdmaLogger := log.FromContext(ctx).WithName("erdma-controller")
+	if !controllerutil.ContainsFinalizer(device, erdmaFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	optOut := device.Annotations[consts.NodeAnnotationOptOut] == "true"
+	if optOut && !meta.IsStatusConditionTrue(device.Status.Conditions, networkv1beta2.ConditionDevicesReleased) {
+		if waited := time.Since(device.DeletionTimestamp.Time); waited < agentReleaseTimeout {
+			erdmaLogger.Info("waiting for agent to release erdma devices", "erdma device", device.Name, "waited", waited)
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+		erdmaLogger.Info("agent did not release erdma devices in time, release ERIs", "erdma device", device.Name)
+	}
+
+	eriStatus, err := r.EriClient.ReleaseERIs(&device.Spec, optOut)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	releasing := lo.CountBy(eriStatus, func(item networkv1beta2.ERIStatus) bool {
+		return item.Phase == networkv1beta2.ERIPhaseReleasing
+	})
+	cond := metav1.Condition{
+		Type:               networkv1beta2.ConditionERIsReleased,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Releasing",
+		Message:            fmt.Sprintf("%d of %d ERIs are releasing", releasing, len(eriStatus)),
+		ObservedGeneration: device.Generation,
+	}
+	if releasing == 0 {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = "Released"
+		cond.Message = fmt.Sprintf("released %d ERIs, retained %d ERIs",
+			lo.CountBy(eriStatus, func(item networkv1beta2.ERIStatus) bool { return item.Phase == networkv1beta2.ERIPhaseReleased }),
+			lo.CountBy(eriStatus, func(item networkv1beta2.ERIStatus) bool { return item.Phase == networkv1beta2.ERIPhaseRetained }))
+	}
+	device.Status.ERIs = eriStatus
+	meta.SetStatusCondition(&device.Status.Conditions, cond)
+	if err := r.Client.Status().Update(ctx, device); err != nil {
+		return ctrl.Result{}, err
+	}
+	recordERIPhases(device)
+	if releasing > 0 {
+		erdmaLogger.Info("waiting for ERIs to be released", "erdma device", device.Name, "eris", eriStatus)
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	erdmaLogger.Info("ERIs released, remove finalizer", "erdma device", device.Name, "eris", eriStatus)
+	controllerutil.RemoveFinalizer(device, erdmaFinalizer)
+	if err := r.Client.Update(ctx, device); client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	}
+	forgetERIPhases(device)
+	r.readyObserved.Delete(device.Name)
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager. The ERdmaDevice
+// of a node is also reconciled when its dry-run annotation changes.
 func (r *ERdmaDeviceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	dryRunChanged := predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return e.ObjectOld.GetAnnotations()[consts.NodeAnnotationDryRun] != e.ObjectNew.GetAnnotations()[consts.NodeAnnotationDryRun]
+		},
+	}
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&networkv1.ERdmaDevice{}).
+		For(&networkv1beta2.ERdmaDevice{}).
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(func(_ context.Context, node client.Object) []reconcile.Request {
+			return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: node.GetName()}}}
+		}), builder.WithPredicates(dryRunChanged)).
 		Complete(r)
 }
@@ -27,7 +27,7 @@ import (
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
-	networkv1 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1"
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
 )
 
 var _ = Describe("ERdmaDevice Controller", func() {
@@ -40,13 +40,13 @@ var _ = Describe("ERdmaDevice Controller", func() {
 			Name:      resourceName,
 			Namespace: "default", // TODO(user):Modify as needed
 		}
-		erdmadevice := &networkv1.ERdmaDevice{}
+		erdmadevice := &networkv1beta2.ERdmaDevice{}
 
 		BeforeEach(func() {
 			By("creating the custom resource for the Kind ERdmaDevice")
 			err := k8sClient.Get(ctx, typeNamespacedName, erdmadevice)
 			if err != nil && errors.IsNotFound(err) {
-				resource := &networkv1.ERdmaDevice{
+				resource := &networkv1beta2.ERdmaDevice{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      resourceName,
 						Namespace: "default",
@@ -59,7 +59,7 @@ var _ = Describe("ERdmaDevice Controller", func() {
 
 		AfterEach(func() {
 			// TODO(user): Cleanup logic after each test, like removing the resource instance.
-			resource := &networkv1.ERdmaDevice{}
+			resource := &networkv1beta2.ERdmaDevice{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
 			Expect(err).NotTo(HaveOccurred())
 
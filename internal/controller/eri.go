@@ -2,20 +2,19 @@ package controller
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
-	networkv1 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1"
-	"github.com/alibabacloud-go/endpoint-util/service"
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
 	"github.com/alibabacloud-go/tea/tea"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/config"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/events"
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
-	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
 	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
 	"github.com/samber/lo"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
@@ -30,16 +29,47 @@ const (
 	// (notably terway CNI), so they will not allocate Pod IPs from it.
 	eriTagExcludedKey   = "terway.alibabacloud.com/excluded"
 	eriTagExcludedValue = "true"
+	// eriTagConvertedKey marks a primary ENI converted to an ERI by the
+	// controller, it is converted back when the node opts out.
+	eriTagConvertedKey   = "erdma.alibabacloud.com/converted-primary-eni"
+	eriTagConvertedValue = "true"
 
 	eniResourceType = "eni"
 
-	trafficModeRDMA = "HighPerformance"
+	trafficModeRDMA     = "HighPerformance"
+	trafficModeStandard = "Standard"
+)
+
+// Policies selecting among the candidate vSwitches of a zone.
+const (
+	// VSwitchSelectionMostAvailableIP selects the vSwitch with the most
+	// available IP addresses, the default.
+	VSwitchSelectionMostAvailableIP = "mostAvailableIP"
+	// VSwitchSelectionOrdered selects the first vSwitch with an available IP
+	// address.
+	VSwitchSelectionOrdered = "ordered"
 )
 
 type EriClient struct {
-	client          *ecs.Client
-	regionID        string
+	client   ECS
+	regionID string
+	// ManagedNonOwned manages ENIs not created by the controller regardless of
+	// the manageNonOwnedENIs setting in the current config.
 	ManagedNonOwned bool
+	// EnableIPv6 assigns IPv6 addresses to the ERIs regardless of the
+	// enableIPv6 setting in the current config.
+	EnableIPv6 bool
+	// SecurityGroup is the managed security group config used instead of the
+	// one in the current config.
+	SecurityGroup *types.ManagedSecurityGroup
+	// securityGroups caches the managed security groups, shared by the copies
+	// of the client.
+	securityGroups *securityGroupCache
+
+	// recorder records the Events of the calls on eventObject, see
+	// WithEvents.
+	recorder    *events.Recorder
+	eventObject runtime.Object
 }
 
 func NewEriClient(k8sClient client.Client) (*EriClient, error) {
@@ -47,41 +77,52 @@ func NewEriClient(k8sClient client.Client) (*EriClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	network := "vpc"
-	if os.Getenv("PUBLIC_NETWORK") == "true" {
-		network = "public"
-	}
-
-	ecsEndpoint, err := service.GetEndpointRules(tea.String("ecs"), tea.String(config.GetConfig().Region), tea.String("regional"), tea.String(network), nil)
-	if err != nil {
-		return nil, err
-	}
-	client, err := ecs.NewClient(&openapi.Config{
-		RegionId:     &config.GetConfig().Region,
-		UserAgent:    ptr.To("AlibabaCloud/ERdma-Controller/0.1"),
-		Credential:   cred,
-		EndpointType: tea.String("regional"),
-		Network:      tea.String(network),
-		Endpoint:     ecsEndpoint,
-	})
+	client, err := newECSClient(config.GetConfig().Region, cred, config.GetConfig().ECSQPS, config.GetConfig().ECSBurst)
 	if err != nil {
 		return nil, err
 	}
 	return &EriClient{
-		regionID:        config.GetConfig().Region,
-		ManagedNonOwned: config.GetConfig().ManageNonOwnedERIs,
-		client:          client,
+		regionID:       config.GetConfig().Region,
+		client:         client,
+		securityGroups: newSecurityGroupCache(),
 	}, nil
 }
 
-func (e *EriClient) InstanceIDFromNode(node *corev1.Node) (string, error) {
-	var instanceID string
-	if node.Spec.ProviderID != "" {
-		providerIDs := strings.Split(node.Spec.ProviderID, ".")
-		if len(providerIDs) == 2 {
-			instanceID = providerIDs[1]
-		}
+// NewEriClientWithECS returns an EriClient calling api in regionID, e.g. the
+// in-memory backend of the fakeecs package.
+func NewEriClientWithECS(api ECS, regionID string) *EriClient {
+	return &EriClient{
+		regionID:       regionID,
+		client:         api,
+		securityGroups: newSecurityGroupCache(),
+	}
+}
+
+// WithEvents returns a copy of the client recording the Events of its calls,
+// e.g. the conversion of a primary ENI, on obj with recorder.
+func (e *EriClient) WithEvents(recorder *events.Recorder, obj runtime.Object) *EriClient {
+	c := *e
+	c.recorder = recorder
+	c.eventObject = obj
+	return &c
+}
+
+func (e *EriClient) eventf(reason events.Reason, messageFmt string, args ...any) {
+	e.recorder.Eventf(e.eventObject, reason, messageFmt, args...)
+}
+
+// instanceIDFromProviderID returns the instance ID in the <region>.<instance>
+// provider ID of a node, empty when it is not in this format.
+func instanceIDFromProviderID(providerID string) string {
+	providerIDs := strings.Split(providerID, ".")
+	if len(providerIDs) == 2 {
+		return providerIDs[1]
 	}
+	return ""
+}
+
+func (e *EriClient) InstanceIDFromNode(node *corev1.Node) (string, error) {
+	instanceID := instanceIDFromProviderID(node.Spec.ProviderID)
 	if instanceID != "" {
 		resp, err := e.client.DescribeInstances(&ecs.DescribeInstancesRequest{
 			RegionId:    ptr.To(e.regionID),
@@ -118,41 +159,57 @@ func (e *EriClient) InstanceIDFromNode(node *corev1.Node) (string, error) {
 	return *resp.Body.Instances.Instance[0].InstanceId, nil
 }
 
-func (e *EriClient) CreateEriForInstance(instanceInfo *ecs.DescribeInstancesResponseBodyInstancesInstance, cardIndex []int, queuePair int) ([]*types.ERI, error) {
-	resp, err := e.client.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{
-		RegionId: ptr.To(e.regionID),
-		Tag: []*ecs.DescribeNetworkInterfacesRequestTag{{
-			Key:   ptr.To(eriTagCreatorKey),
-			Value: ptr.To(eriTagCreatorValue),
-		}, {
-			Key:   ptr.To(eriTagInstanceIdKey),
-			Value: instanceInfo.InstanceId,
-		}},
-		PageSize: ptr.To(int32(100)),
-	})
+// CreateEriForInstance creates an ERI on each of cardIndex with the queue pair
+// number of its card in a vSwitch selected by layout, detached ERIs created for
+// the instance before are reused.
+func (e *EriClient) CreateEriForInstance(instanceInfo *ecs.DescribeInstancesResponseBodyInstancesInstance, cardIndex []int, queuePairs map[int]int, layout ERILayout) ([]*types.ERI, error) {
+	createdENIs, err := e.reusableENIs(instanceInfo.InstanceId, layout)
 	if err != nil {
 		return nil, err
 	}
-	if *resp.StatusCode != 200 {
-		return nil, fmt.Errorf("describe network interface failed, status code: %d", resp.StatusCode)
-	}
 	var eris []*types.ERI
-	for _, eni := range resp.Body.NetworkInterfaceSets.NetworkInterfaceSet {
+	for _, eni := range createdENIs {
 		if len(cardIndex) > 0 {
-			eri := toEri(eni, queuePair)
+			eri := toEri(eni, queuePairs[cardIndex[0]])
 			eri.InstanceID = *instanceInfo.InstanceId
 			eri.CardIndex = cardIndex[0]
 			cardIndex = cardIndex[1:]
 			eris = append(eris, eri)
 		}
 	}
+	var (
+		selector       *vSwitchSelector
+		managedGroupID string
+	)
+	securityGroupIDs := instanceInfo.SecurityGroupIds.SecurityGroupId
+	if len(cardIndex) > 0 {
+		selector, err = e.newVSwitchSelector(instanceInfo, layout)
+		if err != nil {
+			return nil, err
+		}
+		var vpcID string
+		if instanceInfo.VpcAttributes != nil {
+			vpcID = tea.StringValue(instanceInfo.VpcAttributes.VpcId)
+		}
+		managedGroupID, err = e.EnsureSecurityGroup(vpcID)
+		if err != nil {
+			return nil, err
+		}
+		if managedGroupID != "" && !lo.Contains(tea.StringSliceValue(securityGroupIDs), managedGroupID) {
+			securityGroupIDs = append(append([]*string{}, securityGroupIDs...), ptr.To(managedGroupID))
+		}
+	}
 	for len(cardIndex) > 0 {
-		eriResp, err := e.client.CreateNetworkInterface(&ecs.CreateNetworkInterfaceRequest{
+		vSwitchID, err := selector.next()
+		if err != nil {
+			return nil, err
+		}
+		req := &ecs.CreateNetworkInterfaceRequest{
 			NetworkInterfaceName:        ptr.To(fmt.Sprintf("eri-%s-%d", *instanceInfo.InstanceId, cardIndex[0])),
 			NetworkInterfaceTrafficMode: ptr.To(trafficModeRDMA),
-			QueuePairNumber:             ptr.To(int32(queuePair)),
+			QueuePairNumber:             ptr.To(int32(queuePairs[cardIndex[0]])),
 			RegionId:                    ptr.To(e.regionID),
-			SecurityGroupIds:            instanceInfo.SecurityGroupIds.SecurityGroupId,
+			SecurityGroupIds:            securityGroupIDs,
 			Tag: []*ecs.CreateNetworkInterfaceRequestTag{{
 				Key:   ptr.To(eriTagCreatorKey),
 				Value: ptr.To(eriTagCreatorValue),
@@ -163,9 +220,14 @@ func (e *EriClient) CreateEriForInstance(instanceInfo *ecs.DescribeInstancesResp
 				Key:   ptr.To(eriTagExcludedKey),
 				Value: ptr.To(eriTagExcludedValue),
 			}},
-			VSwitchId: instanceInfo.VpcAttributes.VSwitchId,
-		})
+			VSwitchId: ptr.To(vSwitchID),
+		}
+		if e.ipv6Enabled() {
+			req.Ipv6AddressCount = ptr.To(int32(1))
+		}
+		eriResp, err := e.client.CreateNetworkInterface(req)
 		if err != nil {
+			e.forgetSecurityGroup(managedGroupID, err)
 			return nil, err
 		}
 		eris = append(eris, &types.ERI{
@@ -174,31 +236,165 @@ func (e *EriClient) CreateEriForInstance(instanceInfo *ecs.DescribeInstancesResp
 			MAC:          *eriResp.Body.MacAddress,
 			InstanceID:   *instanceInfo.InstanceId,
 			CardIndex:    cardIndex[0],
+			QueuePair:    queuePairs[cardIndex[0]],
 		})
 		cardIndex = cardIndex[1:]
 	}
 	return eris, nil
 }
 
+// createdENIs returns the ENIs the controller created for an instance.
+func (e *EriClient) createdENIs(instanceID *string) ([]*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, error) {
+	return e.client.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{
+		RegionId: ptr.To(e.regionID),
+		Tag: []*ecs.DescribeNetworkInterfacesRequestTag{{
+			Key:   ptr.To(eriTagCreatorKey),
+			Value: ptr.To(eriTagCreatorValue),
+		}, {
+			Key:   ptr.To(eriTagInstanceIdKey),
+			Value: instanceID,
+		}},
+	})
+}
+
+// reusableENIs returns the ENIs the controller created for an instance which
+// can be reused for new ERIs: ENIs which are attached, e.g. the ERIs the
+// instance already has, or planned in layout are not reused.
+func (e *EriClient) reusableENIs(instanceID *string, layout ERILayout) ([]*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, error) {
+	createdENIs, err := e.createdENIs(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Filter(createdENIs, func(item *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, _ int) bool {
+		return tea.StringValue(item.InstanceId) == "" && !lo.Contains(layout.PlannedIDs, tea.StringValue(item.NetworkInterfaceId))
+	}), nil
+}
+
+// vSwitchSelector selects the vSwitches of the ERIs created for an instance.
+type vSwitchSelector struct {
+	// candidates are the candidate vSwitches in order, empty for the vSwitch
+	// of the primary ENI.
+	candidates []string
+	// available are the available IP addresses of the candidates.
+	available map[string]int64
+	policy    string
+	zoneID    string
+	primary   string
+}
+
+// newVSwitchSelector returns the selector of the candidate vSwitches of the
+// zone of the instance in layout, with their available IP addresses.
+// Candidates which are not in the zone and the VPC of the instance are
+// ignored.
+func (e *EriClient) newVSwitchSelector(instanceInfo *ecs.DescribeInstancesResponseBodyInstancesInstance, layout ERILayout) (*vSwitchSelector, error) {
+	selector := &vSwitchSelector{
+		policy: layout.VSwitchPolicy,
+		zoneID: tea.StringValue(instanceInfo.ZoneId),
+	}
+	var vpcID *string
+	if instanceInfo.VpcAttributes != nil {
+		selector.primary = tea.StringValue(instanceInfo.VpcAttributes.VSwitchId)
+		vpcID = instanceInfo.VpcAttributes.VpcId
+	}
+	candidates := layout.VSwitches[selector.zoneID]
+	if len(candidates) == 0 {
+		return selector, nil
+	}
+	vSwitches, err := e.client.DescribeVSwitches(&ecs.DescribeVSwitchesRequest{
+		RegionId: ptr.To(e.regionID),
+		ZoneId:   ptr.To(selector.zoneID),
+		VpcId:    vpcID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describe vswitches of zone %s failed: %v", selector.zoneID, err)
+	}
+	selector.available = map[string]int64{}
+	for _, vSwitch := range vSwitches {
+		if lo.Contains(candidates, tea.StringValue(vSwitch.VSwitchId)) {
+			selector.available[tea.StringValue(vSwitch.VSwitchId)] = tea.Int64Value(vSwitch.AvailableIpAddressCount)
+		}
+	}
+	for _, id := range lo.Uniq(candidates) {
+		if _, ok := selector.available[id]; !ok {
+			eriLog.Info("WARNING: candidate vswitch is not in the zone or vpc of the instance", "vswitch", id, "zone", selector.zoneID, "instance", tea.StringValue(instanceInfo.InstanceId))
+			continue
+		}
+		selector.candidates = append(selector.candidates, id)
+	}
+	if len(selector.candidates) == 0 {
+		return nil, fmt.Errorf("none of the candidate vswitches %v is in zone %s of instance %s", candidates, selector.zoneID, tea.StringValue(instanceInfo.InstanceId))
+	}
+	return selector, nil
+}
+
+// next selects the vSwitch of the next ERI by the policy, and takes one of its
+// available IP addresses.
+func (s *vSwitchSelector) next() (string, error) {
+	if len(s.candidates) == 0 {
+		return s.primary, nil
+	}
+	var selected string
+	for _, id := range s.candidates {
+		if s.available[id] <= 0 {
+			continue
+		}
+		if s.policy == VSwitchSelectionOrdered {
+			selected = id
+			break
+		}
+		if selected == "" || s.available[id] > s.available[selected] {
+			selected = id
+		}
+	}
+	if selected == "" {
+		return "", fmt.Errorf("no available ip address in candidate vswitches %v of zone %s", s.candidates, s.zoneID)
+	}
+	s.available[selected]--
+	return selected, nil
+}
+
 func (e *EriClient) ConvertPrimaryENI(primaryENI string, instanceID string, queuePair int) error {
-	if _, err := e.client.ModifyNetworkInterfaceAttribute(&ecs.ModifyNetworkInterfaceAttributeRequest{
-		RegionId:           ptr.To(e.regionID),
-		NetworkInterfaceId: ptr.To(primaryENI),
-		NetworkInterfaceTrafficConfig: &ecs.ModifyNetworkInterfaceAttributeRequestNetworkInterfaceTrafficConfig{
-			NetworkInterfaceTrafficMode: ptr.To(trafficModeRDMA),
-			// todo: not support dynamic set queue pair number
-			QueuePairNumber: ptr.To(int32(queuePair)),
-		},
-	}); err != nil {
+	// the primary eni is marked first, so that it is converted back on opt-out
+	// even when the controller crashes right after the conversion
+	_, err := e.client.TagResources(&ecs.TagResourcesRequest{
+		RegionId:     ptr.To(e.regionID),
+		ResourceType: ptr.To(eniResourceType),
+		ResourceId:   []*string{ptr.To(primaryENI)},
+		Tag: []*ecs.TagResourcesRequestTag{{
+			Key:   ptr.To(eriTagConvertedKey),
+			Value: ptr.To(eriTagConvertedValue),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("tag primary eni %s as converted: %w", primaryENI, err)
+	}
+	if err := e.setQueuePair(primaryENI, queuePair); err != nil {
 		return err
 	}
+	e.eventf(events.PrimaryENIConverted, "converted primary eni %s of instance %s to eri with %d queue pairs", primaryENI, instanceID, queuePair)
 	if err := e.EnsureEriTags([]string{primaryENI}, instanceID); err != nil {
 		// Best-effort terway-compat tagging; not fatal to RDMA conversion.
 		eriLog.Info("WARNING: skipped terway-compat tags on primary ENI after RDMA convert (best-effort)", "eni", primaryENI, "error", err.Error())
+		e.eventf(events.ERITagsFailed, "skipped terway-compat tags on primary eni %s: %v", primaryENI, err)
 	}
 	return nil
 }
 
+// setQueuePair sets the RDMA traffic mode and the queue pair number of an
+// ENI, the queue pair number of a secondary ENI can only be changed while it
+// is detached.
+func (e *EriClient) setQueuePair(eniID string, queuePair int) error {
+	_, err := e.client.ModifyNetworkInterfaceAttribute(&ecs.ModifyNetworkInterfaceAttributeRequest{
+		RegionId:           ptr.To(e.regionID),
+		NetworkInterfaceId: ptr.To(eniID),
+		NetworkInterfaceTrafficConfig: &ecs.ModifyNetworkInterfaceAttributeRequestNetworkInterfaceTrafficConfig{
+			NetworkInterfaceTrafficMode: ptr.To(trafficModeRDMA),
+			QueuePairNumber:             ptr.To(int32(queuePair)),
+		},
+	})
+	return err
+}
+
 // EnsureEriTags adds the terway-excluded and instance-id tags to the given ENIs.
 // It deliberately does NOT add the creator tag because this method is called on
 // ENIs that may not have been created by erdma-controller (e.g. ECS-console
@@ -233,16 +429,17 @@ func (e *EriClient) EnsureEriTags(eniIDs []string, instanceID string) error {
 	return nil
 }
 
-func (e *EriClient) SelectERIs(instanceID string) ([]*types.ERI, error) {
+// describeInstanceType describes the instance and its instance type.
+func (e *EriClient) describeInstanceType(instanceID string) (*ecs.DescribeInstancesResponse, *ecs.DescribeInstanceTypesResponse, error) {
 	instanceResp, err := e.client.DescribeInstances(&ecs.DescribeInstancesRequest{
 		RegionId:    ptr.To(e.regionID),
 		InstanceIds: ptr.To(fmt.Sprintf("[\"%s\"]", instanceID)),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("cannot found instance %s, %s", instanceID, err)
+		return nil, nil, fmt.Errorf("cannot found instance %s, %s", instanceID, err)
 	}
 	if *instanceResp.Body.TotalCount == 0 {
-		return nil, fmt.Errorf("cannot found instance %s", instanceID)
+		return nil, nil, fmt.Errorf("cannot found instance %s", instanceID)
 	}
 	instanceTypeResp, err := e.client.DescribeInstanceTypes(&ecs.DescribeInstanceTypesRequest{
 		InstanceTypes: []*string{
@@ -250,46 +447,169 @@ func (e *EriClient) SelectERIs(instanceID string) ([]*types.ERI, error) {
 		},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("cannot found instance type %s, %s", *instanceResp.Body.Instances.Instance[0].InstanceType, err)
+		return nil, nil, fmt.Errorf("cannot found instance type %s, %s", *instanceResp.Body.Instances.Instance[0].InstanceType, err)
+	}
+	return instanceResp, instanceTypeResp, nil
+}
+
+// InstanceType returns the instance type of an instance.
+func (e *EriClient) InstanceType(instanceID string) (string, error) {
+	resp, err := e.client.DescribeInstances(&ecs.DescribeInstancesRequest{
+		RegionId:    ptr.To(e.regionID),
+		InstanceIds: ptr.To(fmt.Sprintf("[\"%s\"]", instanceID)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot found instance %s, %s", instanceID, err)
+	}
+	if *resp.Body.TotalCount == 0 {
+		return "", fmt.Errorf("cannot found instance %s", instanceID)
+	}
+	return tea.StringValue(resp.Body.Instances.Instance[0].InstanceType), nil
+}
+
+// SupportsERI returns whether the instance type of an instance has ERI
+// support.
+func (e *EriClient) SupportsERI(instanceID string) (bool, error) {
+	instanceResp, instanceTypeResp, err := e.describeInstanceType(instanceID)
+	if err != nil {
+		return false, err
+	}
+	_, ok := eriCapacityOf(instanceResp, instanceTypeResp)
+	return ok, nil
+}
+
+// NetworkCardCount returns the number of network cards of the instance type
+// of an instance.
+func (e *EriClient) NetworkCardCount(instanceID string) (int, error) {
+	instanceResp, instanceTypeResp, err := e.describeInstanceType(instanceID)
+	if err != nil {
+		return 0, err
 	}
-	var (
-		cardCount      int
-		queuePairCount int
-	)
+	for _, instanceType := range instanceTypeResp.Body.InstanceTypes.InstanceType {
+		if *instanceType.InstanceTypeId != *instanceResp.Body.Instances.Instance[0].InstanceType {
+			continue
+		}
+		if instanceType.NetworkCardQuantity == nil || *instanceType.NetworkCardQuantity < 2 {
+			return 1, nil
+		}
+		return int(*instanceType.NetworkCardQuantity), nil
+	}
+	return 0, fmt.Errorf("cannot found instance type %s", *instanceResp.Body.Instances.Instance[0].InstanceType)
+}
+
+// eriCapacity is the ERI capacity of an instance type.
+type eriCapacity struct {
+	// networkCards is the number of network cards.
+	networkCards int
+	// cardCount is the number of network cards which can have an ERI.
+	cardCount int
+	// queuePairCount is the queue pair number of the instance for all ERIs.
+	queuePairCount int
+}
+
+// eriCapacityOf returns the ERI capacity of the instance type of the
+// instance, false when it has no ERI support.
+func eriCapacityOf(instanceResp *ecs.DescribeInstancesResponse, instanceTypeResp *ecs.DescribeInstanceTypesResponse) (eriCapacity, bool) {
+	var capacity eriCapacity
 	for _, instanceType := range instanceTypeResp.Body.InstanceTypes.InstanceType {
 		if instanceType.EriQuantity == nil {
-			return nil, nil
+			return eriCapacity{}, false
 		}
 		eriQuantity := *instanceType.EriQuantity
 		if *instanceType.InstanceTypeId == *instanceResp.Body.Instances.Instance[0].InstanceType && eriQuantity == 0 {
-			return nil, nil
+			return eriCapacity{}, false
 		}
 		if instanceType.NetworkCardQuantity == nil || *instanceType.NetworkCardQuantity < 2 {
-			cardCount = 1
+			capacity.networkCards = 1
+			capacity.cardCount = 1
 		} else {
-			cardCount = int(min(*instanceType.NetworkCardQuantity, eriQuantity))
+			capacity.networkCards = int(*instanceType.NetworkCardQuantity)
+			capacity.cardCount = int(min(*instanceType.NetworkCardQuantity, eriQuantity))
 		}
-		queuePairCount = int(*instanceType.QueuePairNumber)
+		capacity.queuePairCount = int(*instanceType.QueuePairNumber)
 		// GPU instance max queue pair number is card count * queue pair number
 		if instanceType.GPUAmount != nil && *instanceType.GPUAmount > 0 {
-			queuePairCount = int(*instanceType.QueuePairNumber * int32(cardCount))
+			capacity.queuePairCount = int(*instanceType.QueuePairNumber * int32(capacity.cardCount))
 		}
 	}
+	return capacity, true
+}
 
-	describeENIResponse, err := e.client.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{
+// ERILayout customizes how the ERIs of an instance are planned, the zero value
+// is one ERI per network card up to the ERI quantity of the instance type, with
+// the queue pairs split evenly.
+type ERILayout struct {
+	// Count is the maximum number of ERIs, 0 for no limit.
+	Count int
+	// Cards are the network card indexes to place ERIs on, nil for all cards.
+	Cards []int
+	// Weights are the queue pair weights by network card index, 1 when unset.
+	Weights map[int]int
+	// NoPrimaryENI keeps the primary ENI out of RDMA traffic mode.
+	NoPrimaryENI bool
+	// VSwitches are the candidate vSwitches of the created ERIs by zone ID,
+	// the vSwitch of the primary ENI is used in zones without candidates.
+	VSwitches map[string][]string
+	// VSwitchPolicy selects among the candidate vSwitches, see
+	// VSwitchSelectionMostAvailableIP and VSwitchSelectionOrdered.
+	VSwitchPolicy string
+	// Planned are the queue pairs of the ERIs already planned by network card
+	// index, e.g. when an ERdmaDevice is planned again. No ERI is selected or
+	// created on these cards, and their queue pairs are not split again.
+	Planned map[int]int
+	// PlannedIDs are the IDs of the ERIs already planned, they are not reused
+	// for the ERIs to create.
+	PlannedIDs []string
+}
+
+func (l ERILayout) weight(cardIndex int) int {
+	if w, ok := l.Weights[cardIndex]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// cards returns the network card indexes to place ERIs on for an instance
+// type with networkCards cards and cardCount ERI capable cards.
+func (l ERILayout) cards(networkCards, cardCount int) []int {
+	if l.Cards == nil {
+		return lo.Without(lo.Range(cardCount), lo.Keys(l.Planned)...)
+	}
+	cards := lo.Uniq(lo.Filter(l.Cards, func(cardIndex int, _ int) bool {
+		return cardIndex >= 0 && cardIndex < networkCards
+	}))
+	if len(cards) > cardCount {
+		cards = cards[:cardCount]
+	}
+	return lo.Without(cards, lo.Keys(l.Planned)...)
+}
+
+// SelectERIs plans the ERIs of an instance with layout, adopting the existing
+// ERIs and creating the missing ones. It returns nil when the instance type has
+// no ERI support.
+func (e *EriClient) SelectERIs(instanceID string, layout ERILayout) ([]*types.ERI, error) {
+	instanceResp, instanceTypeResp, err := e.describeInstanceType(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	capacity, ok := eriCapacityOf(instanceResp, instanceTypeResp)
+	if !ok {
+		return nil, nil
+	}
+	networkCards, cardCount, queuePairCount := capacity.networkCards, capacity.cardCount, capacity.queuePairCount
+
+	existENIs, err := e.client.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{
 		RegionId:   ptr.To(e.regionID),
 		InstanceId: ptr.To(instanceID),
-		PageSize:   ptr.To(int32(100)),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("cannot found node eni: %v", err)
 	}
-	existENIs := describeENIResponse.Body.NetworkInterfaceSets.NetworkInterfaceSet
-	selectEriList, needCreate, queuePairNumberConfig, err := e.SelectEriFromExist(existENIs, queuePairCount, cardCount)
+	selectEriList, needCreate, queuePairs, err := e.selectEriFromExist(existENIs, queuePairCount, layout.cards(networkCards, cardCount), layout)
 	if err != nil {
 		return nil, fmt.Errorf("cannot generate eri config list from exist enis: %v", err)
 	}
-	eris, err := e.CreateEriForInstance(instanceResp.Body.Instances.Instance[0], needCreate, queuePairNumberConfig)
+	eris, err := e.CreateEriForInstance(instanceResp.Body.Instances.Instance[0], needCreate, queuePairs.byCard, layout)
 	if err != nil {
 		return nil, err
 	}
@@ -301,11 +621,41 @@ func (e *EriClient) SelectERIs(instanceID string) ([]*types.ERI, error) {
 	// degraded until the next reconcile retries.
 	if err := e.EnsureEriTags(lo.Map(selectEriList, func(item *types.ERI, _ int) string { return item.ID }), instanceID); err != nil {
 		eriLog.Info("WARNING: skipped terway-compat tags on selected ERIs (best-effort)", "instanceID", instanceID, "error", err.Error())
+		e.eventf(events.ERITagsFailed, "skipped terway-compat tags on eris of instance %s: %v", instanceID, err)
 	}
 	return selectEriList, nil
 }
 
+// SelectEriFromExist plans the ERIs of an instance with the default layout,
+// see selectEriFromExist.
 func (e *EriClient) SelectEriFromExist(existENIs []*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, queuePairCount, cardCount int) ([]*types.ERI, []int, int, error) {
+	eriList, needCreate, queuePairs, err := e.selectEriFromExist(existENIs, queuePairCount, lo.Range(cardCount), ERILayout{})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return eriList, needCreate, queuePairs.perCard, nil
+}
+
+// cardQueuePairs are the queue pairs planned for the ERIs to create or
+// convert by network card index.
+type cardQueuePairs struct {
+	byCard map[int]int
+	// perCard is the even split of the remaining queue pairs, used by the
+	// existing ERIs which have no queue pair number.
+	perCard int
+}
+
+func (q cardQueuePairs) get(cardIndex int) int {
+	if queuePair, ok := q.byCard[cardIndex]; ok {
+		return queuePair
+	}
+	return q.perCard
+}
+
+// selectEriFromExist selects the existing ERIs on cards, and plans the cards
+// which need an ERI created or the primary ENI converted, with the queue pairs
+// of the instance left split between them by the layout weights.
+func (e *EriClient) selectEriFromExist(existENIs []*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, queuePairCount int, cards []int, layout ERILayout) ([]*types.ERI, []int, cardQueuePairs, error) {
 	var existQueuePairCount int
 	existERIs := lo.Filter(existENIs, func(item *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, _ int) bool {
 		eri := item.NetworkInterfaceTrafficMode != nil && *item.NetworkInterfaceTrafficMode == trafficModeRDMA
@@ -314,13 +664,23 @@ func (e *EriClient) SelectEriFromExist(existENIs []*ecs.DescribeNetworkInterface
 		}
 		return eri
 	})
+	for cardIndex, queuePair := range layout.Planned {
+		// planned ERIs which are not in RDMA traffic mode yet, e.g. a primary
+		// ENI to convert
+		if !lo.ContainsBy(existERIs, func(item *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet) bool {
+			return eniCardIndex(item) == cardIndex
+		}) {
+			existQueuePairCount += queuePair
+		}
+	}
 	eriLog.Info("exist eri", "existERIs", lo.Map(existERIs, func(item *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, _ int) *types.ERI {
 		return toEri(item, 0)
-	}), "existQueuePairCount", existQueuePairCount, "osMaxQueuePairCount", queuePairCount, "cardCount", cardCount)
+	}), "existQueuePairCount", existQueuePairCount, "osMaxQueuePairCount", queuePairCount, "cards", cards, "layout", layout)
 
 	var (
 		selectedENIs []*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet
 		cardIndexENI = map[int]*types.ERI{}
+		queuePairs   cardQueuePairs
 	)
 
 	for _, eri := range existERIs {
@@ -328,6 +688,9 @@ func (e *EriClient) SelectEriFromExist(existENIs []*ecs.DescribeNetworkInterface
 			continue
 		}
 		eniIndex := eniCardIndex(eri)
+		if _, ok := layout.Planned[eniIndex]; ok || (layout.Cards != nil && !lo.Contains(cards, eniIndex)) {
+			continue
+		}
 		if _, ok := cardIndexENI[eniIndex]; !ok {
 			cardIndexENI[eniIndex] = toEri(eri, 0)
 			selectedENIs = append(selectedENIs, eri)
@@ -335,31 +698,40 @@ func (e *EriClient) SelectEriFromExist(existENIs []*ecs.DescribeNetworkInterface
 	}
 	var needCreateOrConvert []int
 	if existQueuePairCount <= queuePairCount {
-		for i := 0; i < cardCount; i++ {
+		for _, i := range cards {
 			if _, ok := cardIndexENI[i]; !ok {
 				needCreateOrConvert = append(needCreateOrConvert, i)
 			}
 		}
 	}
+	if layout.Count > 0 {
+		needCreateOrConvert = needCreateOrConvert[:max(0, min(len(needCreateOrConvert), layout.Count-len(cardIndexENI)-len(layout.Planned)))]
+	}
 
-	var remainQueuePairCountPerCardIndex int
 	if len(needCreateOrConvert) > 0 {
-		remainQueuePairCountPerCardIndex = (queuePairCount - existQueuePairCount) / len(needCreateOrConvert)
-		if remainQueuePairCountPerCardIndex > 0 {
-			if _, ok := cardIndexENI[0]; !ok {
+		remainQueuePairCount := queuePairCount - existQueuePairCount
+		queuePairs.perCard = remainQueuePairCount / len(needCreateOrConvert)
+		totalWeight := lo.SumBy(needCreateOrConvert, layout.weight)
+		queuePairs.byCard = map[int]int{}
+		needCreateOrConvert = lo.Filter(needCreateOrConvert, func(cardIndex int, _ int) bool {
+			queuePairs.byCard[cardIndex] = remainQueuePairCount * layout.weight(cardIndex) / totalWeight
+			return queuePairs.byCard[cardIndex] > 0
+		})
+		if len(needCreateOrConvert) > 0 {
+			if _, ok := cardIndexENI[0]; !ok && !layout.NoPrimaryENI && lo.Contains(needCreateOrConvert, 0) {
 				// if cardIndex 0 not bind ENI, using primary ENI as cardIndex 0 ENI
 				for _, eni := range existENIs {
 					if eni.Type != nil && *eni.Type == "Primary" {
 						selectedENIs = append(selectedENIs, eni)
-						cardIndexENI[0] = toEri(eni, remainQueuePairCountPerCardIndex)
+						cardIndexENI[0] = toEri(eni, queuePairs.get(0))
 						cardIndex0Idx := lo.IndexOf(needCreateOrConvert, 0)
 						// remove from create list
 						needCreateOrConvert = append(needCreateOrConvert[:cardIndex0Idx], needCreateOrConvert[cardIndex0Idx+1:]...)
 					}
 				}
-			}
-			if len(cardIndexENI) == 0 {
-				return nil, nil, 0, fmt.Errorf("cannot find node primary ENI or existing ENI")
+				if len(cardIndexENI) == 0 {
+					return nil, nil, cardQueuePairs{}, fmt.Errorf("cannot find node primary ENI or existing ENI")
+				}
 			}
 		} else {
 			needCreateOrConvert = nil
@@ -367,88 +739,353 @@ func (e *EriClient) SelectEriFromExist(existENIs []*ecs.DescribeNetworkInterface
 	}
 
 	eriList := lo.Map(selectedENIs, func(item *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, _ int) *types.ERI {
-		return toEri(item, remainQueuePairCountPerCardIndex)
+		return toEri(item, queuePairs.get(eniCardIndex(item)))
 	})
-	if len(eriList) == 0 && len(needCreateOrConvert) == 0 {
-		return nil, nil, 0, fmt.Errorf("cannot create ERI for instance due to no available slot")
+	if len(eriList) == 0 && len(needCreateOrConvert) == 0 && len(layout.Planned) == 0 {
+		return nil, nil, cardQueuePairs{}, fmt.Errorf("cannot create ERI for instance due to no available slot")
 	}
-	return eriList, needCreateOrConvert, remainQueuePairCountPerCardIndex, nil
+	return eriList, needCreateOrConvert, queuePairs, nil
 }
 
-func (e *EriClient) EnsureEriForInstance(devices []networkv1.DeviceInfo) ([]networkv1.DeviceStatus, error) {
-	eniIds := lo.Map(devices, func(item networkv1.DeviceInfo, _ int) *string {
+// PlanQueuePairs splits the queue pairs of the instance between the ERIs in
+// spec by the layout weights, less the queue pairs of the RDMA ENIs of the
+// instance which are not in spec. It returns the changes to the queue pairs in
+// spec.
+func (e *EriClient) PlanQueuePairs(spec *networkv1beta2.ERdmaDeviceSpec, layout ERILayout) ([]networkv1beta2.QueuePairChange, error) {
+	instanceResp, instanceTypeResp, err := e.describeInstanceType(spec.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	capacity, ok := eriCapacityOf(instanceResp, instanceTypeResp)
+	if !ok {
+		return nil, nil
+	}
+	existENIs, err := e.client.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{
+		RegionId:   ptr.To(e.regionID),
+		InstanceId: ptr.To(spec.InstanceID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot found node eni: %v", err)
+	}
+	queuePairCount := capacity.queuePairCount
+	for _, eni := range existENIs {
+		if tea.StringValue(eni.NetworkInterfaceTrafficMode) != trafficModeRDMA ||
+			lo.ContainsBy(spec.ERIs, func(eri networkv1beta2.ERISpec) bool { return eri.ID == tea.StringValue(eni.NetworkInterfaceId) }) {
+			continue
+		}
+		queuePairCount -= int(tea.Int32Value(eni.QueuePairNumber))
+	}
+	return splitQueuePairs(spec.ERIs, queuePairCount, layout), nil
+}
+
+// splitQueuePairs splits queuePairCount between eris by the layout weights of
+// their network cards, and returns the changes to their queue pairs. ERIs
+// which would get no queue pair are left unchanged.
+func splitQueuePairs(eris []networkv1beta2.ERISpec, queuePairCount int, layout ERILayout) []networkv1beta2.QueuePairChange {
+	totalWeight := lo.SumBy(eris, func(eri networkv1beta2.ERISpec) int { return layout.weight(eri.NetworkCardIndex) })
+	if totalWeight == 0 || queuePairCount <= 0 {
+		return nil
+	}
+	var changes []networkv1beta2.QueuePairChange
+	for _, eri := range eris {
+		queuePair := queuePairCount * layout.weight(eri.NetworkCardIndex) / totalWeight
+		if queuePair == 0 || queuePair == eri.QueuePair {
+			continue
+		}
+		changes = append(changes, networkv1beta2.QueuePairChange{ID: eri.ID, From: eri.QueuePair, To: queuePair})
+	}
+	return changes
+}
+
+// EnsureEriForInstance attaches or converts the ERIs in spec and returns the
+// cloud-side status of each of them. With rebalance, the queue pair number of
+// the ERIs in use is changed to the one in spec, the primary ENI in place and
+// the other ERIs by detaching them, changing it and attaching them again. The
+// queue pairs are only increased once no ERI waits for a decrease, so that
+// the ERIs stay within the queue pairs of the instance.
+func (e *EriClient) EnsureEriForInstance(spec *networkv1beta2.ERdmaDeviceSpec, rebalance bool) ([]networkv1beta2.ERIStatus, error) {
+	eniIds := lo.Map(spec.ERIs, func(item networkv1beta2.ERISpec, _ int) *string {
 		return ptr.To(item.ID)
 	})
 	enis, err := e.client.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{
 		NetworkInterfaceId: eniIds,
-		PageSize:           ptr.To(int32(100)),
 		RegionId:           ptr.To(e.regionID),
 	})
 	if err != nil {
 		return nil, err
 	}
-	eniMap := lo.SliceToMap(enis.Body.NetworkInterfaceSets.NetworkInterfaceSet,
+	eniMap := lo.SliceToMap(enis,
 		func(item *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet) (string, *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet) {
 			return *item.NetworkInterfaceId, item
 		},
 	)
-	var devStatus []networkv1.DeviceStatus
-	for _, device := range devices {
-		eniStatus, ok := eniMap[device.ID]
+	// queuePairChange is the change of the queue pair number of an ERI to
+	// apply, 0 when it is unchanged.
+	queuePairChange := func(eri networkv1beta2.ERISpec) int {
+		eni := eniMap[eri.ID]
+		if !rebalance || eri.QueuePair == 0 || eni == nil || tea.StringValue(eni.NetworkInterfaceTrafficMode) != trafficModeRDMA {
+			return 0
+		}
+		return eri.QueuePair - int(tea.Int32Value(eni.QueuePairNumber))
+	}
+	decreasing := lo.ContainsBy(spec.ERIs, func(eri networkv1beta2.ERISpec) bool { return queuePairChange(eri) < 0 })
+	e.joinManagedSecurityGroup(spec.InstanceID, enis)
+
+	var eriStatus []networkv1beta2.ERIStatus
+	for _, eri := range spec.ERIs {
+		eniStatus, ok := eniMap[eri.ID]
 		if !ok {
-			return nil, fmt.Errorf("cannot found eni %s", device.ID)
+			return nil, fmt.Errorf("cannot found eni %s", eri.ID)
 		}
 		if eniStatus.Status == nil {
-			return nil, fmt.Errorf("cannot found eni %s status", device.ID)
+			return nil, fmt.Errorf("cannot found eni %s status", eri.ID)
 		}
-		if *eniStatus.Status == types.ENIStatusInUse && *eniStatus.NetworkInterfaceTrafficMode == trafficModeRDMA {
-			devStatus = append(devStatus, networkv1.DeviceStatus{
-				ID:      device.ID,
-				Status:  networkv1.DeviceStatusReady,
-				Message: "",
-			})
+		status := networkv1beta2.ERIStatus{
+			ID:        eri.ID,
+			MAC:       tea.StringValue(eniStatus.MacAddress),
+			Phase:     networkv1beta2.ERIPhasePending,
+			QueuePair: int(tea.Int32Value(eniStatus.QueuePairNumber)),
+			VSwitchID: tea.StringValue(eniStatus.VSwitchId),
+			IP:        tea.StringValue(eniStatus.PrivateIpAddress),
+			IPv6:      eniIPv6(eniStatus),
 		}
-		if !device.IsPrimaryENI && *eniStatus.Status == types.ENIStatusAvailable {
+		if status.IPv6 == "" && !eri.PrimaryENI && e.ipv6Enabled() {
+			status.IPv6, err = e.assignIPv6(eri.ID)
+			if err != nil {
+				eriLog.Error(err, "assign ipv6 address to eri failed", "eri", eri.ID, "instance", spec.InstanceID)
+				e.eventf(events.IPv6AssignFailed, "assign ipv6 address to eri %s of instance %s failed: %v", eri.ID, spec.InstanceID, err)
+			}
+		}
+		change := queuePairChange(eri)
+		switch {
+		case *eniStatus.Status == types.ENIStatusInUse && tea.StringValue(eniStatus.NetworkInterfaceTrafficMode) == trafficModeRDMA &&
+			(change == 0 || (change > 0 && decreasing)):
+			status.Phase = networkv1beta2.ERIPhaseReady
+			if change != 0 {
+				status.Message = fmt.Sprintf("waiting for other eris to release queue pairs to change queue pair number to %d", eri.QueuePair)
+			}
+		case eri.PrimaryENI && *eniStatus.Status == types.ENIStatusInUse && change != 0:
+			err = e.setQueuePair(eri.ID, eri.QueuePair)
+			if err != nil {
+				status.Phase = networkv1beta2.ERIPhaseFailed
+				status.Message = fmt.Sprintf("change queue pair number to %d failed: %v", eri.QueuePair, err)
+			} else {
+				status.Phase = networkv1beta2.ERIPhaseReady
+				status.QueuePair = eri.QueuePair
+			}
+		case *eniStatus.Status == types.ENIStatusInUse && change != 0:
+			_, err = e.client.DetachNetworkInterface(&ecs.DetachNetworkInterfaceRequest{
+				InstanceId:         ptr.To(spec.InstanceID),
+				NetworkInterfaceId: ptr.To(eri.ID),
+				RegionId:           ptr.To(e.regionID),
+			})
+			status.Phase = networkv1beta2.ERIPhaseRebalancing
+			status.Message = fmt.Sprintf("detaching eri to change queue pair number from %d to %d", status.QueuePair, eri.QueuePair)
+			if err != nil {
+				status.Phase = networkv1beta2.ERIPhaseFailed
+				status.Message = fmt.Sprintf("detach eri to change queue pair number failed: %v", err)
+			}
+		case !eri.PrimaryENI && *eniStatus.Status == types.ENIStatusAvailable:
+			if change != 0 {
+				if err = e.setQueuePair(eri.ID, eri.QueuePair); err != nil {
+					status.Phase = networkv1beta2.ERIPhaseFailed
+					status.Message = fmt.Sprintf("change queue pair number to %d failed: %v", eri.QueuePair, err)
+					break
+				}
+				status.QueuePair = eri.QueuePair
+			}
 			req := ecs.AttachNetworkInterfaceRequest{
-				InstanceId:         ptr.To(device.InstanceID),
-				NetworkInterfaceId: ptr.To(device.ID),
+				InstanceId:         ptr.To(spec.InstanceID),
+				NetworkInterfaceId: ptr.To(eri.ID),
 				RegionId:           ptr.To(e.regionID),
 			}
-			if device.NetworkCardIndex != 0 {
-				req.NetworkCardIndex = ptr.To(int32(device.NetworkCardIndex))
+			if eri.NetworkCardIndex != 0 {
+				req.NetworkCardIndex = ptr.To(int32(eri.NetworkCardIndex))
 			}
 			_, err = e.client.AttachNetworkInterface(&req)
 			if err != nil {
-				devStatus = append(devStatus, networkv1.DeviceStatus{
-					ID:      device.ID,
-					Status:  networkv1.DeviceStatusFailed,
-					Message: err.Error(),
-				})
+				status.Phase = networkv1beta2.ERIPhaseFailed
+				status.Message = err.Error()
+				e.eventf(events.ERIAttachFailed, "attach eri %s to instance %s failed: %v", eri.ID, spec.InstanceID, err)
+			}
+		case eri.PrimaryENI && *eniStatus.Status == types.ENIStatusInUse:
+			err = e.ConvertPrimaryENI(eri.ID, spec.InstanceID, eri.QueuePair)
+			if err != nil {
+				status.Phase = networkv1beta2.ERIPhaseFailed
+				status.Message = err.Error()
+				e.eventf(events.ERIAttachFailed, "convert primary eni %s of instance %s to eri failed: %v", eri.ID, spec.InstanceID, err)
 			} else {
-				devStatus = append(devStatus, networkv1.DeviceStatus{
-					ID:      device.ID,
-					Status:  networkv1.DeviceStatusPending,
-					Message: "",
-				})
+				status.Phase = networkv1beta2.ERIPhaseReady
+				status.QueuePair = eri.QueuePair
 			}
+		default:
+			status.Message = fmt.Sprintf("eni is %s", *eniStatus.Status)
+		}
+		eriStatus = append(eriStatus, status)
+	}
+	return eriStatus, nil
+}
+
+// assignIPv6 assigns an IPv6 address to an ERI, e.g. one adopted or created
+// before IPv6 was enabled, and returns it.
+func (e *EriClient) assignIPv6(eniID string) (string, error) {
+	resp, err := e.client.AssignIpv6Addresses(&ecs.AssignIpv6AddressesRequest{
+		RegionId:           ptr.To(e.regionID),
+		NetworkInterfaceId: ptr.To(eniID),
+		Ipv6AddressCount:   ptr.To(int32(1)),
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Body == nil || resp.Body.Ipv6Sets == nil || len(resp.Body.Ipv6Sets.Ipv6Address) == 0 {
+		return "", nil
+	}
+	return tea.StringValue(resp.Body.Ipv6Sets.Ipv6Address[0]), nil
+}
+
+// joinManagedSecurityGroup adds the ERIs of an instance to the managed
+// security group of their VPC. A failure is recorded but does not fail the
+// ERIs, they are joined again on the next reconcile.
+func (e *EriClient) joinManagedSecurityGroup(instanceID string, enis []*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet) {
+	if e.managedSecurityGroup() == nil {
+		return
+	}
+	for _, eni := range enis {
+		groupID, err := e.EnsureSecurityGroup(tea.StringValue(eni.VpcId))
+		if err == nil && groupID != "" {
+			err = e.joinSecurityGroup(eni, groupID)
+			e.forgetSecurityGroup(groupID, err)
 		}
-		if device.IsPrimaryENI && *eniStatus.Status == types.ENIStatusInUse && *eniStatus.NetworkInterfaceTrafficMode != trafficModeRDMA {
-			err = e.ConvertPrimaryENI(device.ID, device.InstanceID, device.QueuePair)
+		if err != nil {
+			eriLog.Error(err, "join eri to managed security group failed", "eri", tea.StringValue(eni.NetworkInterfaceId), "instance", instanceID)
+			e.eventf(events.SecurityGroupJoinFailed, "join eri %s of instance %s to the managed security group failed: %v", tea.StringValue(eni.NetworkInterfaceId), instanceID, err)
+		}
+	}
+}
+
+// ReleaseERIs detaches and deletes the ERIs in spec created by the controller,
+// adopted and primary ENIs are retained. With restorePrimaryENI, a primary ENI
+// converted by the controller is converted back to the standard traffic mode.
+// It returns the release state of each ERI, the ERIs are released when none
+// of them is Releasing.
+func (e *EriClient) ReleaseERIs(spec *networkv1beta2.ERdmaDeviceSpec, restorePrimaryENI bool) ([]networkv1beta2.ERIStatus, error) {
+	if len(spec.ERIs) == 0 {
+		return nil, nil
+	}
+	enis, err := e.client.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{
+		NetworkInterfaceId: lo.Map(spec.ERIs, func(item networkv1beta2.ERISpec, _ int) *string {
+			return ptr.To(item.ID)
+		}),
+		RegionId: ptr.To(e.regionID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	eniMap := lo.SliceToMap(enis,
+		func(item *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet) (string, *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet) {
+			return *item.NetworkInterfaceId, item
+		},
+	)
+	var eriStatus []networkv1beta2.ERIStatus
+	for _, eri := range spec.ERIs {
+		status := networkv1beta2.ERIStatus{
+			ID:    eri.ID,
+			Phase: networkv1beta2.ERIPhaseReleasing,
+		}
+		eni, ok := eniMap[eri.ID]
+		if !ok {
+			status.Phase = networkv1beta2.ERIPhaseReleased
+			eriStatus = append(eriStatus, status)
+			continue
+		}
+		status.MAC = tea.StringValue(eni.MacAddress)
+		status.QueuePair = int(tea.Int32Value(eni.QueuePairNumber))
+		switch {
+		case (eri.PrimaryENI || tea.StringValue(eni.Type) == "Primary") && restorePrimaryENI && hasTag(eni, eriTagConvertedKey, eriTagConvertedValue):
+			if err = e.restorePrimaryENI(eni); err != nil {
+				status.Message = fmt.Sprintf("convert primary eni back to standard traffic mode failed: %v", err)
+				break
+			}
+			status.Phase = networkv1beta2.ERIPhaseRetained
+			status.Message = "primary eni is converted back to standard traffic mode"
+		case eri.PrimaryENI || tea.StringValue(eni.Type) == "Primary":
+			status.Phase = networkv1beta2.ERIPhaseRetained
+			status.Message = "primary eni is retained"
+		case !createdByController(eni):
+			status.Phase = networkv1beta2.ERIPhaseRetained
+			status.Message = "eni not created by the controller is retained"
+		case tea.StringValue(eni.Status) == types.ENIStatusInUse:
+			_, err = e.client.DetachNetworkInterface(&ecs.DetachNetworkInterfaceRequest{
+				InstanceId:         eni.InstanceId,
+				NetworkInterfaceId: ptr.To(eri.ID),
+				RegionId:           ptr.To(e.regionID),
+			})
+			status.Message = "detaching eni"
 			if err != nil {
-				devStatus = append(devStatus, networkv1.DeviceStatus{
-					ID:      device.ID,
-					Status:  networkv1.DeviceStatusFailed,
-					Message: err.Error(),
-				})
+				status.Message = fmt.Sprintf("detach eni failed: %v", err)
+			}
+		case tea.StringValue(eni.Status) == types.ENIStatusAvailable:
+			if err = e.DeleteERI(eri.ID); err != nil {
+				status.Message = fmt.Sprintf("delete eni failed: %v", err)
 			} else {
-				devStatus = append(devStatus, networkv1.DeviceStatus{
-					ID:     device.ID,
-					Status: networkv1.DeviceStatusReady,
-				})
+				status.Phase = networkv1beta2.ERIPhaseReleased
 			}
+		default:
+			status.Message = fmt.Sprintf("eni is %s", tea.StringValue(eni.Status))
 		}
+		eriStatus = append(eriStatus, status)
 	}
-	return devStatus, nil
+	return eriStatus, nil
+}
+
+// restorePrimaryENI converts a primary ENI converted by the controller back to
+// the standard traffic mode and removes its mark.
+func (e *EriClient) restorePrimaryENI(eni *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet) error {
+	if tea.StringValue(eni.NetworkInterfaceTrafficMode) == trafficModeRDMA {
+		_, err := e.client.ModifyNetworkInterfaceAttribute(&ecs.ModifyNetworkInterfaceAttributeRequest{
+			RegionId:           ptr.To(e.regionID),
+			NetworkInterfaceId: eni.NetworkInterfaceId,
+			NetworkInterfaceTrafficConfig: &ecs.ModifyNetworkInterfaceAttributeRequestNetworkInterfaceTrafficConfig{
+				NetworkInterfaceTrafficMode: ptr.To(trafficModeStandard),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	_, err := e.client.UntagResources(&ecs.UntagResourcesRequest{
+		RegionId:     ptr.To(e.regionID),
+		ResourceType: ptr.To(eniResourceType),
+		ResourceId:   []*string{eni.NetworkInterfaceId},
+		TagKey:       []*string{ptr.To(eriTagConvertedKey)},
+	})
+	return err
+}
+
+// ListDetachedERIs lists the ERIs created by the controller in the region
+// which are not attached to an instance.
+func (e *EriClient) ListDetachedERIs() ([]*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, error) {
+	enis, err := e.client.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{
+		RegionId: ptr.To(e.regionID),
+		Status:   ptr.To(types.ENIStatusAvailable),
+		Tag: []*ecs.DescribeNetworkInterfacesRequestTag{{
+			Key:   ptr.To(eriTagCreatorKey),
+			Value: ptr.To(eriTagCreatorValue),
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describe created enis failed: %v", err)
+	}
+	return enis, nil
+}
+
+// DeleteERI deletes a detached ERI.
+func (e *EriClient) DeleteERI(eniID string) error {
+	_, err := e.client.DeleteNetworkInterface(&ecs.DeleteNetworkInterfaceRequest{
+		NetworkInterfaceId: ptr.To(eniID),
+		RegionId:           ptr.To(e.regionID),
+	})
+	return err
 }
 
 func (e *EriClient) IsJumboFrameEnabled(instanceID string) (bool, error) {
@@ -465,16 +1102,39 @@ func jumboFrameFromAttr(enabled *bool) bool {
 	return enabled != nil && *enabled
 }
 
+func (e *EriClient) ipv6Enabled() bool {
+	return e.EnableIPv6 || (config.GetConfig() != nil && config.GetConfig().EnableIPv6)
+}
+
+// eniIPv6 returns the first IPv6 address of an ENI, empty when it has none.
+func eniIPv6(eni *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet) string {
+	if eni.Ipv6Sets == nil || len(eni.Ipv6Sets.Ipv6Set) == 0 {
+		return ""
+	}
+	return tea.StringValue(eni.Ipv6Sets.Ipv6Set[0].Ipv6Address)
+}
+
 func (e *EriClient) OwnENI(eni *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet) bool {
-	if e.ManagedNonOwned {
+	if e.ManagedNonOwned || (config.GetConfig() != nil && config.GetConfig().ManageNonOwnedERIs) {
 		return true
 	}
+	return createdByController(eni)
+}
+
+// createdByController returns whether the ENI has the creator tag of the
+// controller, regardless of the manageNonOwnedENIs setting.
+func createdByController(eni *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet) bool {
+	return hasTag(eni, eriTagCreatorKey, eriTagCreatorValue)
+}
+
+// hasTag returns whether the ENI has the tag key with value.
+func hasTag(eni *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, key, value string) bool {
 	if eni.Tags == nil || eni.Tags.Tag == nil {
 		return false
 	}
 	return lo.ContainsBy(eni.Tags.Tag, func(tag *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSetTagsTag) bool {
-		return tag.TagKey != nil && *tag.TagKey == eriTagCreatorKey &&
-			tag.TagValue != nil && *tag.TagValue == eriTagCreatorValue
+		return tag.TagKey != nil && *tag.TagKey == key &&
+			tag.TagValue != nil && *tag.TagValue == value
 	})
 }
 
@@ -0,0 +1,651 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/consts"
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/events"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/fakeecs"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+)
+
+// fakeECSEnv is the in-memory ECS backend and a fake client with the node and
+// ERdmaDevice reconcilers on top of them, sharing config.
+type fakeECSEnv struct {
+	backend   *fakeecs.Backend
+	eriClient *EriClient
+	client    client.Client
+	config    *types.Config
+	nodes     *NodeReconciler
+	devices   *ERdmaDeviceReconciler
+}
+
+// newFakeECSEnv returns a fakeECSEnv with the ECS state and the objects objs.
+func newFakeECSEnv(t *testing.T, state *fakeecs.State, objs ...client.Object) *fakeECSEnv {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, networkv1beta2.AddToScheme(scheme))
+
+	backend := fakeecs.New(state)
+	eriClient := NewEriClientWithECS(backend, "cn-hangzhou")
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+		WithStatusSubresource(&networkv1beta2.ERdmaDevice{}, &networkv1beta2.ERdmaPlan{}).Build()
+	config := &types.Config{}
+	return &fakeECSEnv{
+		backend:   backend,
+		eriClient: eriClient,
+		client:    k8sClient,
+		config:    config,
+		nodes:     &NodeReconciler{Client: k8sClient, Scheme: scheme, EriClient: eriClient, CtrlConfig: config},
+		devices:   &ERdmaDeviceReconciler{Client: k8sClient, Scheme: scheme, EriClient: eriClient, CtrlConfig: config},
+	}
+}
+
+// readyNode returns a Ready node of the ECS instance instanceID.
+func readyNode(name, instanceID string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.NodeSpec{ProviderID: "cn-hangzhou." + instanceID},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+// TestERILifecycleWithFakeECS plans the ERIs of a node, attaches them and
+// releases them against the in-memory ECS backend.
+func TestERILifecycleWithFakeECS(t *testing.T) {
+	now := time.Now()
+	node := readyNode("node1", "i-1")
+	env := newFakeECSEnv(t, &fakeecs.State{
+		InstanceTypes:      []fakeecs.InstanceType{{ID: "ecs.ebmgn8v", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 8}},
+		Instances:          []fakeecs.Instance{{ID: "i-1", InstanceType: "ecs.ebmgn8v", PrivateIP: "192.168.0.1"}},
+		AttachDelaySeconds: 5,
+	}, node)
+	env.backend.Now = func() time.Time { return now }
+	eriClient := env.eriClient
+
+	_, err := env.nodes.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)})
+	require.NoError(t, err)
+
+	device := &networkv1beta2.ERdmaDevice{}
+	require.NoError(t, env.client.Get(context.Background(), client.ObjectKey{Name: "node1"}, device))
+	require.Len(t, device.Spec.ERIs, 2)
+	assert.True(t, device.Spec.ERIs[0].PrimaryENI)
+	assert.Equal(t, 1, device.Spec.ERIs[1].NetworkCardIndex)
+	assert.Equal(t, 4, device.Spec.ERIs[1].QueuePair)
+
+	phases := func(status []networkv1beta2.ERIStatus) []networkv1beta2.ERIPhase {
+		var ret []networkv1beta2.ERIPhase
+		for _, s := range status {
+			ret = append(ret, s.Phase)
+		}
+		return ret
+	}
+
+	// the created eri is attached asynchronously
+	status, err := eriClient.EnsureEriForInstance(&device.Spec, false)
+	require.NoError(t, err)
+	assert.Equal(t, []networkv1beta2.ERIPhase{networkv1beta2.ERIPhaseReady, networkv1beta2.ERIPhasePending}, phases(status))
+	now = now.Add(5 * time.Second)
+	status, err = eriClient.EnsureEriForInstance(&device.Spec, false)
+	require.NoError(t, err)
+	assert.Equal(t, []networkv1beta2.ERIPhase{networkv1beta2.ERIPhaseReady, networkv1beta2.ERIPhaseReady}, phases(status))
+
+	// the primary eni is retained, the created eri is detached then deleted
+	status, err = eriClient.ReleaseERIs(&device.Spec, false)
+	require.NoError(t, err)
+	assert.Equal(t, []networkv1beta2.ERIPhase{networkv1beta2.ERIPhaseRetained, networkv1beta2.ERIPhaseReleasing}, phases(status))
+	now = now.Add(5 * time.Second)
+	status, err = eriClient.ReleaseERIs(&device.Spec, false)
+	require.NoError(t, err)
+	assert.Equal(t, []networkv1beta2.ERIPhase{networkv1beta2.ERIPhaseRetained, networkv1beta2.ERIPhaseReleased}, phases(status))
+	detached, err := eriClient.ListDetachedERIs()
+	require.NoError(t, err)
+	assert.Empty(t, detached)
+}
+
+// TestERIEvents records the Events of the ERIs of a node on its ERdmaDevice,
+// a failure retried by the reconciles is recorded once.
+func TestERIEvents(t *testing.T) {
+	node := readyNode("node1", "i-1")
+	env := newFakeECSEnv(t, &fakeecs.State{
+		InstanceTypes: []fakeecs.InstanceType{{ID: "ecs.ebmgn8v", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 8}},
+		Instances:     []fakeecs.Instance{{ID: "i-1", InstanceType: "ecs.ebmgn8v", PrivateIP: "192.168.0.1"}},
+	}, node)
+	env.backend.InjectFault(fakeecs.Fault{Action: "AttachNetworkInterface", Code: "InvalidOperation.InvalidEniState", Times: 2})
+	fakeRecorder := record.NewFakeRecorder(10)
+	env.nodes.Recorder = events.NewRecorder(fakeRecorder)
+	env.devices.Recorder = env.nodes.Recorder
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)}
+	_, err := env.nodes.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	for range 2 {
+		_, err = env.devices.Reconcile(ctx, req)
+		require.NoError(t, err)
+	}
+	device := &networkv1beta2.ERdmaDevice{}
+	require.NoError(t, env.client.Get(ctx, req.NamespacedName, device))
+	require.Len(t, device.Spec.ERIs, 2)
+
+	var recorded []string
+	for len(fakeRecorder.Events) > 0 {
+		recorded = append(recorded, <-fakeRecorder.Events)
+	}
+	require.Len(t, recorded, 2)
+	assert.Equal(t, "Normal PrimaryENIConverted converted primary eni "+device.Spec.ERIs[0].ID+" of instance i-1 to eri with 4 queue pairs", recorded[0])
+	assert.Contains(t, recorded[1], "Warning ERIAttachFailed attach eri "+device.Spec.ERIs[1].ID+" to instance i-1 failed")
+}
+
+// TestERIVSwitchSelection creates the ERIs in the candidate vSwitches of the
+// zone of the instance by their available IP addresses.
+func TestERIVSwitchSelection(t *testing.T) {
+	newBackend := func() *fakeecs.Backend {
+		return fakeecs.New(&fakeecs.State{
+			InstanceTypes: []fakeecs.InstanceType{{ID: "ecs.ebmgn8v", EriQuantity: 3, NetworkCardQuantity: 3, QueuePairNumber: 12}},
+			Instances: []fakeecs.Instance{{ID: "i-1", InstanceType: "ecs.ebmgn8v", PrivateIP: "192.168.0.1",
+				ZoneID: "cn-hangzhou-k", VSwitchID: "vsw-primary"}},
+			VSwitches: []fakeecs.VSwitch{
+				{ID: "vsw-a", ZoneID: "cn-hangzhou-k", AvailableIPs: 1},
+				{ID: "vsw-b", ZoneID: "cn-hangzhou-k", AvailableIPs: 5},
+				{ID: "vsw-c", ZoneID: "cn-hangzhou-j", AvailableIPs: 100},
+			},
+		})
+	}
+	vSwitches := map[string][]string{"cn-hangzhou-k": {"vsw-a", "vsw-b", "vsw-c"}}
+	createdVSwitches := func(eriClient *EriClient, eris []*types.ERI) []string {
+		spec := &networkv1beta2.ERdmaDeviceSpec{InstanceID: "i-1"}
+		for _, eri := range eris {
+			spec.ERIs = append(spec.ERIs, networkv1beta2.ERISpec{ID: eri.ID, NetworkCardIndex: eri.CardIndex, PrimaryENI: eri.IsPrimaryENI})
+		}
+		status, err := eriClient.EnsureEriForInstance(spec, false)
+		require.NoError(t, err)
+		var ret []string
+		for _, s := range status {
+			assert.NotEmpty(t, s.IP)
+			ret = append(ret, s.VSwitchID)
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name      string
+		layout    ERILayout
+		vSwitches []string
+	}{
+		{
+			name:      "primary eni vswitch in other zones",
+			layout:    ERILayout{VSwitches: map[string][]string{"cn-hangzhou-j": {"vsw-c"}}},
+			vSwitches: []string{"vsw-primary", "vsw-primary", "vsw-primary"},
+		},
+		{
+			name:      "most available ip",
+			layout:    ERILayout{VSwitches: vSwitches},
+			vSwitches: []string{"vsw-primary", "vsw-b", "vsw-b"},
+		},
+		{
+			name:      "ordered",
+			layout:    ERILayout{VSwitches: vSwitches, VSwitchPolicy: VSwitchSelectionOrdered},
+			vSwitches: []string{"vsw-primary", "vsw-a", "vsw-b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eriClient := NewEriClientWithECS(newBackend(), "cn-hangzhou")
+			eris, err := eriClient.SelectERIs("i-1", tt.layout)
+			require.NoError(t, err)
+			require.Len(t, eris, 3)
+			assert.Equal(t, tt.vSwitches, createdVSwitches(eriClient, eris))
+		})
+	}
+
+	t.Run("no available ip", func(t *testing.T) {
+		eriClient := NewEriClientWithECS(newBackend(), "cn-hangzhou")
+		_, err := eriClient.SelectERIs("i-1", ERILayout{VSwitches: map[string][]string{"cn-hangzhou-k": {"vsw-a"}}})
+		assert.ErrorContains(t, err, "no available ip address in candidate vswitches [vsw-a] of zone cn-hangzhou-k")
+	})
+}
+
+// TestManagedSecurityGroup joins the created and the primary ERIs of an
+// instance to the managed security group of its VPC, and repairs the rules of
+// the group after they drift.
+func TestManagedSecurityGroup(t *testing.T) {
+	backend := fakeecs.New(&fakeecs.State{
+		InstanceTypes: []fakeecs.InstanceType{{ID: "ecs.ebmgn8v", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 8}},
+		Instances:     []fakeecs.Instance{{ID: "i-1", InstanceType: "ecs.ebmgn8v", PrivateIP: "192.168.0.1", VpcID: "vpc-1"}},
+	})
+	eriClient := NewEriClientWithECS(backend, "cn-hangzhou")
+	eriClient.SecurityGroup = &types.ManagedSecurityGroup{
+		Enabled: true,
+		Name:    "erdma",
+		Rules:   []types.SecurityGroupRule{{Protocol: "tcp", PortRange: "22/22", CIDR: "10.0.0.0/8"}},
+	}
+
+	eris, err := eriClient.SelectERIs("i-1", ERILayout{})
+	require.NoError(t, err)
+	require.Len(t, eris, 2)
+	spec := &networkv1beta2.ERdmaDeviceSpec{InstanceID: "i-1"}
+	for _, eri := range eris {
+		spec.ERIs = append(spec.ERIs, networkv1beta2.ERISpec{ID: eri.ID, NetworkCardIndex: eri.CardIndex, PrimaryENI: eri.IsPrimaryENI})
+	}
+	_, err = eriClient.EnsureEriForInstance(spec, false)
+	require.NoError(t, err)
+
+	groups, err := backend.DescribeSecurityGroups(&ecs.DescribeSecurityGroupsRequest{VpcId: ptr.To("vpc-1")})
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	groupID := *groups[0].SecurityGroupId
+	enis, err := backend.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{})
+	require.NoError(t, err)
+	require.Len(t, enis, 2)
+	for _, eni := range enis {
+		assert.Equal(t, []string{"sg-fake", groupID}, tea.StringSliceValue(eni.SecurityGroupIds.SecurityGroupId), *eni.NetworkInterfaceId)
+	}
+
+	rules := func() []string {
+		permissions, err := backend.DescribeSecurityGroupRules(&ecs.DescribeSecurityGroupAttributeRequest{SecurityGroupId: ptr.To(groupID)})
+		require.NoError(t, err)
+		var ret []string
+		for _, p := range permissions {
+			ret = append(ret, ruleFromPermission(p).key())
+		}
+		sort.Strings(ret)
+		return ret
+	}
+	expected := []string{
+		"ingress|all|-1/-1||" + groupID + "|accept|1",
+		"ingress|tcp|22/22|10.0.0.0/8||accept|1",
+	}
+	assert.Equal(t, expected, rules())
+
+	// drift: the rule between the eris is revoked and another rule is added
+	permissions, err := backend.DescribeSecurityGroupRules(&ecs.DescribeSecurityGroupAttributeRequest{SecurityGroupId: ptr.To(groupID)})
+	require.NoError(t, err)
+	_, err = backend.RevokeSecurityGroup(&ecs.RevokeSecurityGroupRequest{
+		SecurityGroupId:     ptr.To(groupID),
+		SecurityGroupRuleId: []*string{permissions[0].SecurityGroupRuleId},
+	})
+	require.NoError(t, err)
+	_, err = backend.AuthorizeSecurityGroupEgress(&ecs.AuthorizeSecurityGroupEgressRequest{
+		SecurityGroupId: ptr.To(groupID),
+		Permissions: []*ecs.AuthorizeSecurityGroupEgressRequestPermissions{{
+			IpProtocol: ptr.To("UDP"), PortRange: ptr.To("53/53"), DestCidrIp: ptr.To("0.0.0.0/0"),
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, eriClient.RepairSecurityGroups())
+	assert.Equal(t, expected, rules())
+
+	// the group is found again by its tags after a restart
+	restarted := NewEriClientWithECS(backend, "cn-hangzhou")
+	restarted.SecurityGroup = eriClient.SecurityGroup
+	found, err := restarted.EnsureSecurityGroup("vpc-1")
+	require.NoError(t, err)
+	assert.Equal(t, groupID, found)
+
+	// a group deleted by hand is forgotten and created again
+	require.NoError(t, backend.DeleteSecurityGroup(groupID))
+	require.Error(t, eriClient.RepairSecurityGroups())
+	recreated, err := eriClient.EnsureSecurityGroup("vpc-1")
+	require.NoError(t, err)
+	assert.NotEqual(t, groupID, recreated)
+	assert.NotEmpty(t, recreated)
+}
+
+// TestManagedSecurityGroupConcurrent creates the managed security group of a
+// VPC once for concurrent reconciles.
+func TestManagedSecurityGroupConcurrent(t *testing.T) {
+	backend := fakeecs.New(&fakeecs.State{})
+	eriClient := NewEriClientWithECS(backend, "cn-hangzhou")
+	eriClient.SecurityGroup = &types.ManagedSecurityGroup{Enabled: true, Name: "erdma"}
+
+	var wg sync.WaitGroup
+	groupIDs := make([]string, 8)
+	for i := range groupIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			groupID, err := eriClient.EnsureSecurityGroup("vpc-1")
+			assert.NoError(t, err)
+			groupIDs[i] = groupID
+		}()
+	}
+	wg.Wait()
+	groups, err := backend.DescribeSecurityGroups(&ecs.DescribeSecurityGroupsRequest{VpcId: ptr.To("vpc-1")})
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	for _, groupID := range groupIDs {
+		assert.Equal(t, *groups[0].SecurityGroupId, groupID)
+	}
+}
+
+// TestERIIPv6 requests an IPv6 address for the created ERIs and assigns one to
+// the ERIs without, the primary ENI is left alone.
+func TestERIIPv6(t *testing.T) {
+	backend := fakeecs.New(&fakeecs.State{
+		InstanceTypes: []fakeecs.InstanceType{{ID: "ecs.ebmgn8v", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 8}},
+		Instances: []fakeecs.Instance{
+			{ID: "i-1", InstanceType: "ecs.ebmgn8v", PrivateIP: "192.168.0.1"},
+			{ID: "i-2", InstanceType: "ecs.ebmgn8v", PrivateIP: "192.168.0.2"},
+		},
+	})
+	eriClient := NewEriClientWithECS(backend, "cn-hangzhou")
+	ensure := func(instanceID string) []string {
+		eris, err := eriClient.SelectERIs(instanceID, ERILayout{})
+		require.NoError(t, err)
+		require.Len(t, eris, 2)
+		spec := &networkv1beta2.ERdmaDeviceSpec{InstanceID: instanceID}
+		for _, eri := range eris {
+			spec.ERIs = append(spec.ERIs, networkv1beta2.ERISpec{ID: eri.ID, NetworkCardIndex: eri.CardIndex, PrimaryENI: eri.IsPrimaryENI})
+		}
+		status, err := eriClient.EnsureEriForInstance(spec, false)
+		require.NoError(t, err)
+		// the primary eni first
+		ret := make([]string, 2)
+		for i, s := range status {
+			ret[lo.Ternary(spec.ERIs[i].PrimaryENI, 0, 1)] = s.IPv6
+		}
+		return ret
+	}
+	ipv6Count := func(instanceID string) []int {
+		enis, err := backend.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{InstanceId: ptr.To(instanceID)})
+		require.NoError(t, err)
+		var ret []int
+		for _, eni := range enis {
+			ret = append(ret, len(eni.Ipv6Sets.Ipv6Set))
+		}
+		return ret
+	}
+
+	// the eri of i-1 is created before ipv6 is enabled
+	ipv6 := ensure("i-1")
+	assert.Equal(t, []string{"", ""}, ipv6)
+
+	eriClient.EnableIPv6 = true
+	ipv6 = ensure("i-1")
+	assert.Empty(t, ipv6[0])
+	assert.NotEmpty(t, ipv6[1])
+	assert.Equal(t, ipv6, ensure("i-1"), "the address is assigned once")
+	assert.ElementsMatch(t, []int{0, 1}, ipv6Count("i-1"))
+
+	// the eri of i-2 is created with an address
+	ipv6 = ensure("i-2")
+	assert.Empty(t, ipv6[0])
+	assert.NotEmpty(t, ipv6[1])
+	assert.ElementsMatch(t, []int{0, 1}, ipv6Count("i-2"))
+}
+
+// TestERIDryRun plans the ERIs of a node in dry-run mode into its ERdmaPlan
+// without changing anything in ECS, and provisions the node once it leaves
+// dry-run mode.
+func TestERIDryRun(t *testing.T) {
+	node := readyNode("node1", "i-1")
+	env := newFakeECSEnv(t, &fakeecs.State{
+		InstanceTypes: []fakeecs.InstanceType{{ID: "ecs.ebmgn8v", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 8}},
+		Instances:     []fakeecs.Instance{{ID: "i-1", InstanceType: "ecs.ebmgn8v", PrivateIP: "192.168.0.1", VSwitchID: "vsw-primary"}},
+	}, node)
+	env.config.DryRun = true
+	r, k8sClient := env.nodes, env.client
+	enis := func() []string {
+		enis, err := env.backend.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{InstanceId: ptr.To("i-1")})
+		require.NoError(t, err)
+		return lo.Map(enis, func(item *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, _ int) string {
+			return tea.StringValue(item.NetworkInterfaceId) + "/" + tea.StringValue(item.NetworkInterfaceTrafficMode)
+		})
+	}
+	before := enis()
+
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)})
+	require.NoError(t, err)
+	assert.Equal(t, planRefreshInterval, result.RequeueAfter)
+	assert.Equal(t, before, enis(), "nothing is changed in dry-run mode")
+	devices := &networkv1beta2.ERdmaDeviceList{}
+	require.NoError(t, k8sClient.List(context.Background(), devices))
+	assert.Empty(t, devices.Items)
+
+	plan := &networkv1beta2.ERdmaPlan{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Name: "node1"}, plan))
+	assert.Equal(t, "ecs.ebmgn8v", plan.Status.InstanceType)
+	assert.Equal(t, 8, plan.Status.QueuePairCount)
+	require.Len(t, plan.Status.ERIs, 2)
+	assert.Equal(t, networkv1beta2.ERIActionConvertPrimaryENI, plan.Status.ERIs[0].Action)
+	assert.True(t, plan.Status.ERIs[0].PrimaryENI)
+	assert.Equal(t, networkv1beta2.PlannedERI{
+		Action:           networkv1beta2.ERIActionCreate,
+		NetworkCardIndex: 1,
+		QueuePair:        4,
+		VSwitchID:        "vsw-primary",
+	}, plan.Status.ERIs[1])
+
+	// the plans are served by the debug endpoint
+	rec := httptest.NewRecorder()
+	(&PlanHandler{Client: k8sClient}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/erdma-plans?node=node1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"action":"Create"`)
+	rec = httptest.NewRecorder()
+	(&PlanHandler{Client: k8sClient}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/erdma-plans?node=node2", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// the annotation of the node overrides the config
+	node.Annotations = map[string]string{consts.NodeAnnotationDryRun: "false"}
+	require.NoError(t, k8sClient.Update(context.Background(), node))
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)})
+	require.NoError(t, err)
+	require.NoError(t, k8sClient.List(context.Background(), devices))
+	require.Len(t, devices.Items, 1)
+	assert.Len(t, devices.Items[0].Spec.ERIs, 2)
+	err = k8sClient.Get(context.Background(), client.ObjectKey{Name: "node1"}, plan)
+	assert.True(t, errors.IsNotFound(err))
+
+	// the erdmadevice of a node in dry-run mode is not changed in ECS, also
+	// when the node opts out
+	before = enis()
+	deviceRequest := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+	node.Annotations = map[string]string{consts.NodeAnnotationOptOut: "true"}
+	require.NoError(t, k8sClient.Update(context.Background(), node))
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)})
+	require.NoError(t, err)
+	result, err = env.devices.Reconcile(context.Background(), deviceRequest)
+	require.NoError(t, err)
+	assert.Equal(t, planRefreshInterval, result.RequeueAfter)
+	assert.Equal(t, before, enis())
+	device := &networkv1beta2.ERdmaDevice{}
+	require.NoError(t, k8sClient.Get(context.Background(), deviceRequest.NamespacedName, device))
+	assert.True(t, device.DeletionTimestamp.IsZero())
+	assert.Empty(t, device.Status.ERIs)
+
+	// the ERIs are set up once the node leaves dry-run mode
+	node.Annotations = nil
+	require.NoError(t, k8sClient.Update(context.Background(), node))
+	env.config.DryRun = false
+	_, err = env.devices.Reconcile(context.Background(), deviceRequest)
+	require.NoError(t, err)
+	assert.Len(t, enis(), 2)
+}
+
+// TestERIOptOut releases the ERIs of an opted out node once the agent released
+// its devices, and converts its primary ENI back to the standard traffic mode.
+func TestERIOptOut(t *testing.T) {
+	node := readyNode("node1", "i-1")
+	env := newFakeECSEnv(t, &fakeecs.State{
+		InstanceTypes: []fakeecs.InstanceType{{ID: "ecs.ebmgn8v", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 8}},
+		Instances:     []fakeecs.Instance{{ID: "i-1", InstanceType: "ecs.ebmgn8v", PrivateIP: "192.168.0.1"}},
+	}, node)
+	backend, k8sClient, nodeReconciler, deviceReconciler := env.backend, env.client, env.nodes, env.devices
+	nodeRequest := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)}
+	deviceRequest := ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}}
+	enis := func() []string {
+		enis, err := backend.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{InstanceId: ptr.To("i-1")})
+		require.NoError(t, err)
+		return lo.Map(enis, func(item *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, _ int) string {
+			return tea.StringValue(item.Type) + "/" + tea.StringValue(item.NetworkInterfaceTrafficMode) +
+				lo.Ternary(hasTag(item, eriTagConvertedKey, eriTagConvertedValue), "/converted", "")
+		})
+	}
+
+	_, err := nodeReconciler.Reconcile(context.Background(), nodeRequest)
+	require.NoError(t, err)
+	_, err = deviceReconciler.Reconcile(context.Background(), deviceRequest)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Primary/HighPerformance/converted", "Secondary/HighPerformance"}, enis())
+	_, err = deviceReconciler.Reconcile(context.Background(), deviceRequest)
+	require.NoError(t, err)
+	_, observed := deviceReconciler.readyObserved.Load("node1")
+	assert.True(t, observed)
+
+	node.Annotations = map[string]string{consts.NodeAnnotationOptOut: "true"}
+	require.NoError(t, k8sClient.Update(context.Background(), node))
+	_, err = nodeReconciler.Reconcile(context.Background(), nodeRequest)
+	require.NoError(t, err)
+	device := &networkv1beta2.ERdmaDevice{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Name: "node1"}, device))
+	assert.False(t, device.DeletionTimestamp.IsZero())
+	assert.Equal(t, "true", device.Annotations[consts.NodeAnnotationOptOut])
+
+	// the ERIs are released once the agent released the devices
+	result, err := deviceReconciler.Reconcile(context.Background(), deviceRequest)
+	require.NoError(t, err)
+	assert.NotZero(t, result.RequeueAfter)
+	assert.Len(t, enis(), 2)
+	meta.SetStatusCondition(&device.Status.Conditions, metav1.Condition{
+		Type: networkv1beta2.ConditionDevicesReleased, Status: metav1.ConditionTrue, Reason: "Released",
+	})
+	require.NoError(t, k8sClient.Status().Update(context.Background(), device))
+	for i := 0; i < 3; i++ {
+		_, err = deviceReconciler.Reconcile(context.Background(), deviceRequest)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"Primary/Standard"}, enis())
+	err = k8sClient.Get(context.Background(), client.ObjectKey{Name: "node1"}, device)
+	assert.True(t, errors.IsNotFound(err))
+	_, observed = deviceReconciler.readyObserved.Load("node1")
+	assert.False(t, observed, "the removed erdma device is forgotten")
+
+	// the node stays opted out
+	_, err = nodeReconciler.Reconcile(context.Background(), nodeRequest)
+	require.NoError(t, err)
+	devices := &networkv1beta2.ERdmaDeviceList{}
+	require.NoError(t, k8sClient.List(context.Background(), devices))
+	assert.Empty(t, devices.Items)
+}
+
+// TestNotReadyTaint taints a new node until the agent reports its erdma
+// devices ready, nodes without ERI support are never tainted.
+func TestNotReadyTaint(t *testing.T) {
+	newNode := func(name, instanceID string) *corev1.Node {
+		node := readyNode(name, instanceID)
+		node.CreationTimestamp = metav1.Now()
+		node.Status.Conditions = nil
+		return node
+	}
+	env := newFakeECSEnv(t, &fakeecs.State{
+		InstanceTypes: []fakeecs.InstanceType{
+			{ID: "ecs.ebmgn8v", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 8},
+			{ID: "ecs.g8i.large"},
+		},
+		Instances: []fakeecs.Instance{
+			{ID: "i-1", InstanceType: "ecs.ebmgn8v", PrivateIP: "192.168.0.1"},
+			{ID: "i-2", InstanceType: "ecs.g8i.large", PrivateIP: "192.168.0.2"},
+		},
+	}, newNode("node1", "i-1"), newNode("node2", "i-2"))
+	env.config.NotReadyTaint = true
+	env.config.WaitNodeReadyTimeoutSeconds = 600
+	r, k8sClient := env.nodes, env.client
+	taints := func(name string) []string {
+		node := &corev1.Node{}
+		require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Name: name}, node))
+		return lo.Map(node.Spec.Taints, func(item corev1.Taint, _ int) string {
+			return item.Key + ":" + string(item.Effect)
+		})
+	}
+	setReady := func(name string) {
+		node := &corev1.Node{}
+		require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Name: name}, node))
+		node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+		require.NoError(t, k8sClient.Status().Update(context.Background(), node))
+	}
+
+	// the node is tainted while it is not ready yet, the node without ERI
+	// support is not, also after the controller restarted
+	for _, reconciler := range []*NodeReconciler{r, {Client: k8sClient, Scheme: r.Scheme, EriClient: r.EriClient, CtrlConfig: env.config}} {
+		for _, name := range []string{"node1", "node2"} {
+			result, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: name}})
+			require.NoError(t, err)
+			assert.NotZero(t, result.RequeueAfter)
+		}
+		assert.Equal(t, []string{consts.NodeTaintNotReady + ":NoSchedule"}, taints("node1"))
+		assert.Empty(t, taints("node2"), "the instance type has no ERI support")
+	}
+	for _, name := range []string{"node1", "node2"} {
+		setReady(name)
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: name}})
+		require.NoError(t, err)
+	}
+	// the agent removes it once the devices are ready
+	assert.Equal(t, []string{consts.NodeTaintNotReady + ":NoSchedule"}, taints("node1"))
+	assert.Empty(t, taints("node2"))
+
+	// a node whose devices were ready is not tainted again, e.g. after the
+	// controller restarted
+	devices := &networkv1beta2.ERdmaDeviceList{}
+	require.NoError(t, k8sClient.List(context.Background(), devices))
+	require.Len(t, devices.Items, 1)
+	devices.Items[0].Finalizers = nil
+	require.NoError(t, k8sClient.Update(context.Background(), &devices.Items[0]))
+	require.NoError(t, k8sClient.Delete(context.Background(), &devices.Items[0]))
+	node := &corev1.Node{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Name: "node1"}, node))
+	node.Spec.Taints = nil
+	require.NoError(t, k8sClient.Update(context.Background(), node))
+	node.Status.Conditions = append(node.Status.Conditions, corev1.NodeCondition{Type: consts.NodeConditionERdmaReady, Status: corev1.ConditionTrue})
+	require.NoError(t, k8sClient.Status().Update(context.Background(), node))
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}})
+	require.NoError(t, err)
+	assert.Empty(t, taints("node1"))
+
+	// a node is not tainted while its ERdmaDevices cannot be listed, it is
+	// requeued
+	failing := interceptor.NewClient(k8sClient.(client.WithWatch), interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if _, ok := list.(*networkv1beta2.ERdmaDeviceList); ok {
+				return errors.NewServiceUnavailable("unavailable")
+			}
+			return c.List(ctx, list, opts...)
+		},
+	})
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Name: "node1"}, node))
+	node.Status.Conditions = nil
+	require.NoError(t, k8sClient.Status().Update(context.Background(), node))
+	reconciler := &NodeReconciler{Client: failing, Scheme: r.Scheme, EriClient: r.EriClient, CtrlConfig: env.config}
+	_, err = reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}})
+	assert.True(t, errors.IsServiceUnavailable(err))
+	assert.Empty(t, taints("node1"))
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
+	"github.com/alibabacloud-go/tea/tea"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/config"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+)
+
+var gcLog = ctrl.Log.WithName("eri-gc")
+
+// minOrphanERIGCInterval is the lower bound of the sweep interval.
+const minOrphanERIGCInterval = time.Minute
+
+// orphanERIClient lists and deletes the detached ERIs created by the controller.
+type orphanERIClient interface {
+	ListDetachedERIs() ([]*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, error)
+	DeleteERI(eniID string) error
+}
+
+// orphanState is the state of an orphaned ERI across sweeps.
+type orphanState struct {
+	firstSeen time.Time
+	reported  bool
+}
+
+// OrphanERICollector periodically deletes the detached ERIs created by the
+// controller which are orphaned for the grace period, e.g. left by a failed
+// creation, a crashed reconcile or a deleted instance. It only acts when
+// orphanERIGC is enabled in the config, and only reports them in dry-run mode.
+type OrphanERICollector struct {
+	client    client.Client
+	eriClient orphanERIClient
+	// orphans are the orphaned ERIs seen by the last sweep, the grace period
+	// starts again after a controller restart.
+	orphans map[string]orphanState
+	now     func() time.Time
+}
+
+func NewOrphanERICollector(c client.Client, eriClient *EriClient) *OrphanERICollector {
+	return &OrphanERICollector{
+		client:    c,
+		eriClient: eriClient,
+		orphans:   map[string]orphanState{},
+		now:       time.Now,
+	}
+}
+
+// Start implements manager.Runnable, it sweeps until ctx is done.
+func (g *OrphanERICollector) Start(ctx context.Context) error {
+	for {
+		cfg := config.GetConfig()
+		gc := cfg.OrphanERIGC
+		// nothing is deleted in dry-run mode
+		gc.ReportOnly = gc.ReportOnly || cfg.DryRun
+		if gc.Enabled {
+			if err := g.sweep(ctx, gc); err != nil {
+				gcLog.Error(err, "sweep orphaned eris failed, will retry")
+			}
+		} else {
+			clear(g.orphans)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(max(time.Duration(gc.IntervalSeconds)*time.Second, minOrphanERIGCInterval)):
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (g *OrphanERICollector) NeedLeaderElection() bool {
+	return true
+}
+
+func (g *OrphanERICollector) sweep(ctx context.Context, gc types.OrphanERIGC) error {
+	enis, err := g.eriClient.ListDetachedERIs()
+	if err != nil {
+		return err
+	}
+	devices := &networkv1beta2.ERdmaDeviceList{}
+	if err = g.client.List(ctx, devices); err != nil {
+		return err
+	}
+	nodes := &corev1.NodeList{}
+	if err = g.client.List(ctx, nodes); err != nil {
+		return err
+	}
+
+	now := g.now()
+	gracePeriod := time.Duration(gc.GracePeriodSeconds) * time.Second
+	orphans := map[string]orphanState{}
+	for _, id := range orphanERIs(enis, devices.Items, nodes.Items) {
+		state, ok := g.orphans[id]
+		if !ok {
+			state = orphanState{firstSeen: now}
+			gcLog.Info("found orphaned eri", "eri", id, "gracePeriod", gracePeriod)
+		}
+		if now.Sub(state.firstSeen) < gracePeriod {
+			orphans[id] = state
+			continue
+		}
+		if gc.ReportOnly {
+			if !state.reported {
+				gcLog.Info("orphaned eri not deleted in report-only mode", "eri", id, "orphanedSince", state.firstSeen)
+				orphanERIsTotal.WithLabelValues("reported").Inc()
+				state.reported = true
+			}
+			orphans[id] = state
+			continue
+		}
+		if err = g.eriClient.DeleteERI(id); err != nil {
+			gcLog.Error(err, "delete orphaned eri failed, will retry", "eri", id)
+			orphanERIsTotal.WithLabelValues("failed").Inc()
+			orphans[id] = state
+			continue
+		}
+		gcLog.Info("deleted orphaned eri", "eri", id, "orphanedSince", state.firstSeen)
+		orphanERIsTotal.WithLabelValues("deleted").Inc()
+	}
+	g.orphans = orphans
+	return nil
+}
+
+// orphanERIs returns the IDs of the detached ERIs which are neither in the spec
+// of an ERdmaDevice nor tagged for a node without an ERdmaDevice, the ERIs of
+// such a node are adopted when its ERdmaDevice is created.
+func orphanERIs(enis []*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet,
+	devices []networkv1beta2.ERdmaDevice, nodes []corev1.Node) []string {
+	used := sets.New[string]()
+	planned := sets.New[string]()
+	for _, device := range devices {
+		planned.Insert(device.Name)
+		for _, eri := range device.Spec.ERIs {
+			used.Insert(eri.ID)
+		}
+	}
+	pendingInstances := sets.New[string]()
+	for _, node := range nodes {
+		if planned.Has(node.Name) {
+			continue
+		}
+		if instanceID := instanceIDFromProviderID(node.Spec.ProviderID); instanceID != "" {
+			pendingInstances.Insert(instanceID)
+		}
+	}
+
+	var orphans []string
+	for _, eni := range enis {
+		id := tea.StringValue(eni.NetworkInterfaceId)
+		if used.Has(id) || pendingInstances.Has(eniTagValue(eni, eriTagInstanceIdKey)) {
+			continue
+		}
+		orphans = append(orphans, id)
+	}
+	return orphans
+}
+
+// eniTagValue returns the value of the tag key of the ENI, empty when unset.
+func eniTagValue(eni *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, key string) string {
+	if eni.Tags == nil {
+		return ""
+	}
+	for _, tag := range eni.Tags.Tag {
+		if tea.StringValue(tag.TagKey) == key {
+			return tea.StringValue(tag.TagValue)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+)
+
+type fakeOrphanERIClient struct {
+	enis    []*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet
+	deleted []string
+}
+
+func (f *fakeOrphanERIClient) ListDetachedERIs() ([]*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, error) {
+	return f.enis, nil
+}
+
+func (f *fakeOrphanERIClient) DeleteERI(eniID string) error {
+	f.deleted = append(f.deleted, eniID)
+	return nil
+}
+
+func detachedERI(id, instanceID string) *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet {
+	return &ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet{
+		NetworkInterfaceId: lo.ToPtr(id),
+		Tags: &ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSetTags{
+			Tag: []*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSetTagsTag{
+				{TagKey: lo.ToPtr(eriTagCreatorKey), TagValue: lo.ToPtr(eriTagCreatorValue)},
+				{TagKey: lo.ToPtr(eriTagInstanceIdKey), TagValue: lo.ToPtr(instanceID)},
+			},
+		},
+	}
+}
+
+func TestOrphanERIs(t *testing.T) {
+	enis := []*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet{
+		detachedERI("eni-used", "i-1"),
+		detachedERI("eni-planned", "i-2"),
+		detachedERI("eni-unused", "i-1"),
+		detachedERI("eni-deleted-instance", "i-3"),
+	}
+	devices := []networkv1beta2.ERdmaDevice{{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec:       networkv1beta2.ERdmaDeviceSpec{InstanceID: "i-1", ERIs: []networkv1beta2.ERISpec{{ID: "eni-used"}}},
+	}}
+	nodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1"}, Spec: corev1.NodeSpec{ProviderID: "cn-hangzhou.i-1"}},
+		// node2 has no ERdmaDevice yet, its ERIs are adopted when it is created
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2"}, Spec: corev1.NodeSpec{ProviderID: "cn-hangzhou.i-2"}},
+	}
+	assert.Equal(t, []string{"eni-unused", "eni-deleted-instance"}, orphanERIs(enis, devices, nodes))
+}
+
+func TestOrphanERICollectorSweep(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = networkv1beta2.AddToScheme(scheme)
+
+	now := time.Now()
+	eriClient := &fakeOrphanERIClient{enis: []*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet{
+		detachedERI("eni-1", "i-1"),
+	}}
+	g := &OrphanERICollector{
+		client:    fake.NewClientBuilder().WithScheme(scheme).Build(),
+		eriClient: eriClient,
+		orphans:   map[string]orphanState{},
+		now:       func() time.Time { return now },
+	}
+	gc := types.OrphanERIGC{Enabled: true, GracePeriodSeconds: 60, ReportOnly: true}
+
+	// within the grace period
+	assert.NoError(t, g.sweep(context.Background(), gc))
+	assert.Empty(t, eriClient.deleted)
+	assert.Equal(t, now, g.orphans["eni-1"].firstSeen)
+
+	// report-only past the grace period
+	now = now.Add(time.Minute)
+	assert.NoError(t, g.sweep(context.Background(), gc))
+	assert.Empty(t, eriClient.deleted)
+	assert.True(t, g.orphans["eni-1"].reported)
+
+	gc.ReportOnly = false
+	assert.NoError(t, g.sweep(context.Background(), gc))
+	assert.Equal(t, []string{"eni-1"}, eriClient.deleted)
+	assert.Empty(t, g.orphans)
+}
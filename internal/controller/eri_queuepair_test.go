@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/fakeecs"
+)
+
+func TestSplitQueuePairs(t *testing.T) {
+	eris := []networkv1beta2.ERISpec{
+		{ID: "eni-0", NetworkCardIndex: 0, QueuePair: 8},
+		{ID: "eni-1", NetworkCardIndex: 1, QueuePair: 8},
+	}
+	tests := []struct {
+		name           string
+		eris           []networkv1beta2.ERISpec
+		queuePairCount int
+		layout         ERILayout
+		expected       []networkv1beta2.QueuePairChange
+	}{
+		{
+			name:           "balanced",
+			eris:           eris,
+			queuePairCount: 16,
+		},
+		{
+			name:           "budget decreased",
+			eris:           eris,
+			queuePairCount: 8,
+			expected:       []networkv1beta2.QueuePairChange{{ID: "eni-0", From: 8, To: 4}, {ID: "eni-1", From: 8, To: 4}},
+		},
+		{
+			name:           "weighted",
+			eris:           eris,
+			queuePairCount: 16,
+			layout:         ERILayout{Weights: map[int]int{1: 3}},
+			expected:       []networkv1beta2.QueuePairChange{{ID: "eni-0", From: 8, To: 4}, {ID: "eni-1", From: 8, To: 12}},
+		},
+		{
+			name:           "eri removed",
+			eris:           eris[:1],
+			queuePairCount: 16,
+			expected:       []networkv1beta2.QueuePairChange{{ID: "eni-0", From: 8, To: 16}},
+		},
+		{
+			name:           "no queue pair left",
+			eris:           eris,
+			queuePairCount: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, splitQueuePairs(tt.eris, tt.queuePairCount, tt.layout))
+		})
+	}
+}
+
+// TestRebalanceQueuePairs rebalances the queue pairs of the ERIs of a node
+// after its node profile weights changed, against the in-memory ECS backend.
+func TestRebalanceQueuePairs(t *testing.T) {
+	now := time.Now()
+	node := readyNode("node1", "i-1")
+	env := newFakeECSEnv(t, &fakeecs.State{
+		InstanceTypes:      []fakeecs.InstanceType{{ID: "ecs.ebmgn8v", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 8}},
+		Instances:          []fakeecs.Instance{{ID: "i-1", InstanceType: "ecs.ebmgn8v", PrivateIP: "192.168.0.1"}},
+		AttachDelaySeconds: 5,
+	}, node)
+	env.backend.Now = func() time.Time { return now }
+	k8sClient := env.client
+
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)}
+	_, err := env.nodes.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	env.config.RebalanceQueuePairs = true
+	r := env.devices
+	device := &networkv1beta2.ERdmaDevice{}
+	reconcile := func() {
+		t.Helper()
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+		require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, device))
+	}
+	status := func() map[string]networkv1beta2.ERIStatus {
+		ret := map[string]networkv1beta2.ERIStatus{}
+		for _, eri := range device.Status.ERIs {
+			ret[eri.ID] = eri
+		}
+		return ret
+	}
+
+	// the eris are attached with the even split planned at creation
+	reconcile()
+	now = now.Add(5 * time.Second)
+	reconcile()
+	require.Len(t, device.Spec.ERIs, 2)
+	primary, secondary := device.Spec.ERIs[0].ID, device.Spec.ERIs[1].ID
+	assert.Equal(t, 4, status()[secondary].QueuePair)
+	assert.Equal(t, networkv1beta2.ERIPhaseReady, status()[secondary].Phase)
+	assert.True(t, meta.IsStatusConditionTrue(device.Status.Conditions, networkv1beta2.ConditionQueuePairsBalanced))
+	assert.Equal(t, 2.0, testutil.ToFloat64(erisByPhase.WithLabelValues("node1", string(networkv1beta2.ERIPhaseReady))))
+
+	// the weights of the profile move queue pairs to network card 1
+	require.NoError(t, k8sClient.Create(ctx, &networkv1beta2.ERdmaNodeProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "weighted"},
+		Spec:       networkv1beta2.ERdmaNodeProfileSpec{QueuePairWeights: []networkv1beta2.QueuePairWeight{{NetworkCardIndex: 1, Weight: 3}}},
+	}))
+	device.Spec.Profile = "weighted"
+	// the fake client does not bump the generation on spec changes
+	device.Generation++
+	require.NoError(t, k8sClient.Update(ctx, device))
+
+	// the primary eni releases queue pairs in place first
+	reconcile()
+	assert.Equal(t, []networkv1beta2.QueuePairChange{{ID: primary, From: 4, To: 2}, {ID: secondary, From: 4, To: 6}}, device.Status.QueuePairChanges)
+	assert.Equal(t, 2, status()[primary].QueuePair)
+	assert.Equal(t, networkv1beta2.ERIPhaseReady, status()[secondary].Phase)
+	assert.Equal(t, 4, status()[secondary].QueuePair)
+	assert.False(t, meta.IsStatusConditionTrue(device.Status.Conditions, networkv1beta2.ConditionQueuePairsBalanced))
+
+	// then the secondary eri is detached, changed and attached again
+	reconcile()
+	assert.Equal(t, networkv1beta2.ERIPhaseRebalancing, status()[secondary].Phase)
+	assert.Equal(t, 1.0, testutil.ToFloat64(erisByPhase.WithLabelValues("node1", string(networkv1beta2.ERIPhaseRebalancing))))
+	now = now.Add(5 * time.Second)
+	reconcile()
+	assert.Equal(t, networkv1beta2.ERIPhasePending, status()[secondary].Phase)
+	assert.Equal(t, 6, status()[secondary].QueuePair)
+	now = now.Add(5 * time.Second)
+	reconcile()
+	assert.Equal(t, networkv1beta2.ERIPhaseReady, status()[secondary].Phase)
+	assert.True(t, meta.IsStatusConditionTrue(device.Status.Conditions, networkv1beta2.ConditionQueuePairsBalanced))
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+)
+
+var (
+	// orphanERIsTotal counts the orphaned ERIs handled by the garbage collector,
+	// action is reported, deleted or failed.
+	orphanERIsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "erdma_controller_orphan_eris_total",
+		Help: "Number of orphaned ERIs handled by the garbage collector by action.",
+	}, []string{"action"})
+
+	// ecsRequestsTotal counts the ECS OpenAPI requests, each retry is a
+	// request, code is the error code or Success.
+	ecsRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "erdma_controller_ecs_requests_total",
+		Help: "Number of ECS OpenAPI requests by action and error code.",
+	}, []string{"action", "code"})
+
+	// ecsRequestDuration is the latency of the ECS OpenAPI requests.
+	ecsRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "erdma_controller_ecs_request_duration_seconds",
+		Help:    "Latency of ECS OpenAPI requests by action.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"action"})
+
+	// erisByPhase is the number of ERIs of each node by the phase in the
+	// status of its ERdmaDevice.
+	erisByPhase = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "erdma_controller_eris",
+		Help: "Number of ERIs of a node by phase.",
+	}, []string{"node", "phase"})
+
+	// nodeERIsReadySeconds is the time from the creation of a node to all of
+	// its ERIs Ready, observed once per ERdmaDevice.
+	nodeERIsReadySeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "erdma_controller_node_eris_ready_seconds",
+		Help:    "Time from the creation of a node to all of its ERIs Ready.",
+		Buckets: prometheus.ExponentialBuckets(10, 2, 10),
+	})
+
+	// credentialRefreshFailuresTotal counts the failed refreshes of the
+	// ram_role_sts credential.
+	credentialRefreshFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "erdma_controller_credential_refresh_failures_total",
+		Help: "Number of failed refreshes of the ram_role_sts credential.",
+	})
+
+	// unsupportedNodes is the number of nodes skipped because their instance
+	// type has no ERI support.
+	unsupportedNodes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "erdma_controller_unsupported_nodes",
+		Help: "Number of nodes skipped because their instance type has no ERI support.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		orphanERIsTotal,
+		ecsRequestsTotal,
+		ecsRequestDuration,
+		erisByPhase,
+		nodeERIsReadySeconds,
+		credentialRefreshFailuresTotal,
+		unsupportedNodes,
+	)
+}
+
+// ecsErrorCode returns the code label of the result of an ECS request.
+func ecsErrorCode(err error) string {
+	if err == nil {
+		return "Success"
+	}
+	var sdkErr *tea.SDKError
+	if errors.As(err, &sdkErr) && tea.StringValue(sdkErr.Code) != "" {
+		return tea.StringValue(sdkErr.Code)
+	}
+	if classifyECSError(err) == ecsErrorUnreachable {
+		return "Unreachable"
+	}
+	return "Unknown"
+}
+
+// recordERIPhases sets the number of ERIs of the node of device by phase.
+func recordERIPhases(device *networkv1beta2.ERdmaDevice) {
+	erisByPhase.DeletePartialMatch(prometheus.Labels{"node": device.Name})
+	for _, eri := range device.Status.ERIs {
+		erisByPhase.WithLabelValues(device.Name, string(eri.Phase)).Inc()
+	}
+}
+
+// forgetERIPhases removes the ERIs of the node of a removed device.
+func forgetERIPhases(device *networkv1beta2.ERdmaDevice) {
+	erisByPhase.DeletePartialMatch(prometheus.Labels{"node": device.Name})
+}
@@ -2,9 +2,14 @@ package controller
 
 import (
 	"context"
+	"maps"
 	"sync"
 	"time"
 
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/consts"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/config"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/events"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/k8s"
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
 	"github.com/go-logr/logr"
 	"github.com/samber/lo"
@@ -14,6 +19,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	v1 "k8s.io/api/core/v1"
@@ -22,33 +28,48 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
-	networkv1 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1"
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
 )
 
 const (
 	erdmaFinalizer = "network.alibabacloud.com/erdma-controller"
+
+	// instanceTypePollInterval is the interval the instance type of a node
+	// without the instance type label is checked for a resize.
+	instanceTypePollInterval = 10 * time.Minute
 )
 
 // NodeReconciler reconciles a ERdmaDevice object
 type NodeReconciler struct {
 	client.Client
-	Scheme     *runtime.Scheme
-	EriClient  *EriClient
+	Scheme    *runtime.Scheme
+	EriClient *EriClient
+	// CtrlConfig overrides the current config of the config package.
 	CtrlConfig *types.Config
+	// ConfigEvents requeue all nodes when the controller config changed.
+	ConfigEvents <-chan event.GenericEvent
+	// Recorder records the Events of the ERI provisioning on the Node.
+	Recorder *events.Recorder
 
 	// taggedENIs tracks which ENIs have already been backfilled with the
 	// terway-compat tags during this controller process lifetime, so that
 	// existing ERdmaDevice CRs only trigger one TagResources call per ENI
 	// across all reconcile passes.
 	taggedENIs sync.Map
+	// unsupportedNodes are the names of the nodes skipped because their
+	// instance type has no ERI support.
+	unsupportedNodes sync.Map
 }
 
 // +kubebuilder:rbac:groups=network.alibabacloud.com,resources=erdmadevices,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=network.alibabacloud.com,resources=erdmadevices/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=network.alibabacloud.com,resources=erdmadevices/finalizers,verbs=update
+// +kubebuilder:rbac:groups=network.alibabacloud.com,resources=erdmaplans,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=network.alibabacloud.com,resources=erdmaplans/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -66,16 +87,25 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	err := r.Client.Get(ctx, req.NamespacedName, &node)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			r.setUnsupported(req.Name, false)
 			return RemoveERdmaDevices(r.Client, ctx, req.Name)
 		}
 		erdmaLogger.Error(err, "Failed to get node")
 		return ctrl.Result{}, err
 	}
 	if !node.GetDeletionTimestamp().IsZero() {
+		r.setUnsupported(req.Name, false)
 		return RemoveERdmaDevices(r.Client, ctx, req.Name)
 	}
+	if r.optedOut(&node) {
+		r.setUnsupported(req.Name, false)
+		return r.optOut(ctx, &node)
+	}
+	if err := r.taintNotReady(ctx, &node); err != nil {
+		return ctrl.Result{}, err
+	}
 	if !isNodeReady(&node) {
-		timeout := time.Duration(r.CtrlConfig.WaitNodeReadyTimeoutSeconds) * time.Second
+		timeout := time.Duration(r.ctrlConfig().WaitNodeReadyTimeoutSeconds) * time.Second
 		elapsed := time.Since(node.CreationTimestamp.Time)
 		if elapsed < timeout {
 			erdmaLogger.Info("Node is not ready, waiting", "node", req.Name, "elapsed", elapsed)
@@ -90,30 +120,57 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	erdmaDevices := networkv1.ERdmaDeviceList{}
+	erdmaDevices := networkv1beta2.ERdmaDeviceList{}
 	err = r.Client.List(ctx, &erdmaDevices, client.MatchingLabels{
 		"alibabacloud.com/instance-id": instanceID,
 	})
 	if err != nil {
 		return ctrl.Result{}, err
 	}
+	if lo.ContainsBy(erdmaDevices.Items, func(item networkv1beta2.ERdmaDevice) bool {
+		return !item.DeletionTimestamp.IsZero()
+	}) {
+		// e.g. the node opted in again while its ERIs are released
+		erdmaLogger.Info("waiting for deleted erdma device to be released", "node", req.Name)
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+	profile, err := selectNodeProfile(ctx, r.Client, &node)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	instanceType, err := r.instanceType(&node, instanceID)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
 	if len(erdmaDevices.Items) == 0 {
-		eri, err := r.EriClient.SelectERIs(instanceID)
+		// in dry-run mode the ERIs of a new node are only planned
+		if r.dryRun(&node) {
+			if err := r.untaintNotReady(ctx, &node); err != nil {
+				return ctrl.Result{}, err
+			}
+			return r.planNode(ctx, &node, instanceID, r.eriLayout(profile))
+		}
+		if err := r.removePlan(ctx, node.Name); err != nil {
+			return ctrl.Result{}, err
+		}
+		eri, err := r.EriClient.WithEvents(r.Recorder, &node).SelectERIs(instanceID, r.eriLayout(profile))
 		if err != nil {
 			return ctrl.Result{}, err
 		}
+		r.setUnsupported(node.Name, eri == nil)
 		if eri == nil {
 			erdmaLogger.Info("node not support erdma", "name", node.Name, "instance-id", instanceID)
-			return ctrl.Result{}, nil
+			return ctrl.Result{}, r.untaintNotReady(ctx, &node)
 		}
 		jumboFrame, err := r.EriClient.IsJumboFrameEnabled(instanceID)
 		if err != nil {
 			erdmaLogger.Error(err, "failed to check jumbo frame status, defaulting to false")
 			jumboFrame = false
 		}
-		erdmaDevice := networkv1.ERdmaDevice{
+		erdmaDevice := networkv1beta2.ERdmaDevice{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: node.Name,
+				Name:       node.Name,
+				Finalizers: []string{erdmaFinalizer},
 				OwnerReferences: []metav1.OwnerReference{
 					{
 						APIVersion: node.APIVersion,
@@ -127,26 +184,27 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 					"alibabacloud.com/nodename":    node.Name,
 				},
 			},
-			Spec: networkv1.ERdmaDeviceSpec{
-				JumboFrame: jumboFrame,
-				Devices: lo.Map(eri, func(item *types.ERI, index int) networkv1.DeviceInfo {
-					return networkv1.DeviceInfo{
-						InstanceID:       item.InstanceID,
-						MAC:              item.MAC,
-						IsPrimaryENI:     item.IsPrimaryENI,
+			Spec: networkv1beta2.ERdmaDeviceSpec{
+				InstanceID:   instanceID,
+				InstanceType: instanceType,
+				JumboFrame:   jumboFrame,
+				ERIs: lo.Map(eri, func(item *types.ERI, index int) networkv1beta2.ERISpec {
+					return networkv1beta2.ERISpec{
 						ID:               item.ID,
 						NetworkCardIndex: item.CardIndex,
 						QueuePair:        item.QueuePair,
+						PrimaryENI:       item.IsPrimaryENI,
 					}
 				}),
 			},
 		}
+		applyProfileSettings(&erdmaDevice.Spec, profile)
 		err = r.Client.Create(ctx, &erdmaDevice)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 		_ = wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, 2*time.Second, true, func(ctx context.Context) (bool, error) {
-			dev := &networkv1.ERdmaDevice{}
+			dev := &networkv1beta2.ERdmaDevice{}
 			err := r.Client.Get(ctx, k8stypes.NamespacedName{
 				Name: erdmaDevice.Name,
 			}, dev)
@@ -159,18 +217,128 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		return ctrl.Result{}, nil
 	}
 
+	// The ERI layout is only planned when the ERdmaDevice is created and when
+	// the instance type changes, the agent settings of the profile follow its
+	// changes. In dry-run mode the layout is planned once the node leaves it.
+	dryRun := r.dryRun(&node)
+	for i := range erdmaDevices.Items {
+		device := &erdmaDevices.Items[i]
+		var changed bool
+		if !dryRun {
+			if changed, err = r.replanInstanceType(&node, device, instanceType, profile, erdmaLogger); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		if applyProfileSettings(&device.Spec, profile) {
+			erdmaLogger.Info("update erdma device with node profile", "device", device.Name, "profile", device.Spec.Profile)
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err := r.Client.Update(ctx, device); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	// Existing ERdmaDevice CR path: backfill terway-compat tags once per
 	// controller lifetime so old nodes provisioned before this feature also
 	// stop conflicting with terway.
-	r.backfillEriTags(erdmaDevices.Items, instanceID, erdmaLogger)
+	if !dryRun {
+		r.backfillEriTags(&node, erdmaDevices.Items, instanceID, erdmaLogger)
+	}
 
+	if _, ok := node.Labels[v1.LabelInstanceTypeStable]; !ok {
+		return ctrl.Result{RequeueAfter: instanceTypePollInterval}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
-func (r *NodeReconciler) backfillEriTags(devices []networkv1.ERdmaDevice, instanceID string, logger logr.Logger) {
+// setUnsupported records whether the node is skipped because its instance
+// type has no ERI support.
+func (r *NodeReconciler) setUnsupported(name string, unsupported bool) {
+	if unsupported {
+		r.unsupportedNodes.Store(name, struct{}{})
+	} else {
+		r.unsupportedNodes.Delete(name)
+	}
+	count := 0
+	r.unsupportedNodes.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	unsupportedNodes.Set(float64(count))
+}
+
+// instanceType returns the instance type of the node from its instance type
+// label, or from ECS when the node has no such label.
+func (r *NodeReconciler) instanceType(node *v1.Node, instanceID string) (string, error) {
+	if instanceType, ok := node.Labels[v1.LabelInstanceTypeStable]; ok && instanceType != "" {
+		return instanceType, nil
+	}
+	return r.EriClient.InstanceType(instanceID)
+}
+
+// replanInstanceType plans the ERI layout of device again when the instance
+// type of its node is not the one the layout is planned for, e.g. after an
+// ECS resize. ERIs are only planned on the network cards without an ERI in
+// the spec and added to it, the existing ERIs are kept. It reports whether
+// the spec changed.
+func (r *NodeReconciler) replanInstanceType(node *v1.Node, device *networkv1beta2.ERdmaDevice, instanceType string,
+	profile *networkv1beta2.ERdmaNodeProfile, logger logr.Logger) (bool, error) {
+	switch device.Spec.InstanceType {
+	case instanceType:
+		return false, nil
+	case "":
+		// ERdmaDevices created by older versions are planned for the current
+		// instance type
+		device.Spec.InstanceType = instanceType
+		return true, nil
+	}
+	logger.Info("instance type changed, plan erdma device again", "device", device.Name,
+		"from", device.Spec.InstanceType, "to", instanceType)
+	layout := r.eriLayout(profile)
+	layout.Planned = lo.SliceToMap(device.Spec.ERIs, func(item networkv1beta2.ERISpec) (int, int) {
+		return item.NetworkCardIndex, item.QueuePair
+	})
+	layout.PlannedIDs = lo.Map(device.Spec.ERIs, func(item networkv1beta2.ERISpec, _ int) string {
+		return item.ID
+	})
+	eris, err := r.EriClient.WithEvents(r.Recorder, node).SelectERIs(device.Spec.InstanceID, layout)
+	if err != nil {
+		return false, err
+	}
+	added := addMissingERIs(&device.Spec, eris)
+	logger.Info("planned erdma device for the new instance type", "device", device.Name, "instanceType", instanceType, "added", added)
+	device.Spec.InstanceType = instanceType
+	return true, nil
+}
+
+// addMissingERIs adds the ERIs on the network cards without an ERI in spec,
+// and returns the IDs of the added ERIs.
+func addMissingERIs(spec *networkv1beta2.ERdmaDeviceSpec, eris []*types.ERI) []string {
+	var added []string
+	for _, eri := range eris {
+		if lo.ContainsBy(spec.ERIs, func(item networkv1beta2.ERISpec) bool {
+			return item.ID == eri.ID || item.NetworkCardIndex == eri.CardIndex
+		}) {
+			continue
+		}
+		spec.ERIs = append(spec.ERIs, networkv1beta2.ERISpec{
+			ID:               eri.ID,
+			NetworkCardIndex: eri.CardIndex,
+			QueuePair:        eri.QueuePair,
+			PrimaryENI:       eri.IsPrimaryENI,
+		})
+		added = append(added, eri.ID)
+	}
+	return added
+}
+
+func (r *NodeReconciler) backfillEriTags(node *v1.Node, devices []networkv1beta2.ERdmaDevice, instanceID string, logger logr.Logger) {
 	var pending []string
 	for _, dev := range devices {
-		for _, d := range dev.Spec.Devices {
+		for _, d := range dev.Spec.ERIs {
 			if d.ID == "" {
 				continue
 			}
@@ -192,13 +360,16 @@ func (r *NodeReconciler) backfillEriTags(devices []networkv1.ERdmaDevice, instan
 		// managed RAM role may lack ecs:TagResources. Log a warning instead of
 		// an error (which would emit a noisy stack trace) and retry next reconcile.
 		logger.Info("WARNING: skipped terway-compat tag backfill on existing ERIs (best-effort, will retry)", "enis", pending, "instanceID", instanceID, "error", err.Error())
+		r.Recorder.Eventf(node, events.ERITagsFailed, "skipped terway-compat tag backfill on eris %v: %v", pending, err)
 		return
 	}
 	logger.Info("backfilled terway-compat tags on existing ERIs", "enis", pending, "instanceID", instanceID)
 }
 
+// RemoveERdmaDevices deletes the ERdmaDevices of a node, the ERdmaDevice
+// controller releases their ERIs before the finalizer is removed.
 func RemoveERdmaDevices(erdmaClient client.Client, ctx context.Context, nodeName string) (ctrl.Result, error) {
-	erdmaDevices := networkv1.ERdmaDeviceList{}
+	erdmaDevices := networkv1beta2.ERdmaDeviceList{}
 	err := erdmaClient.List(ctx, &erdmaDevices, client.MatchingLabels{
 		"alibabacloud.com/nodename": nodeName,
 	})
@@ -206,33 +377,138 @@ func RemoveERdmaDevices(erdmaClient client.Client, ctx context.Context, nodeName
 		return ctrl.Result{}, err
 	}
 
-	if len(erdmaDevices.Items) == 0 {
-		return ctrl.Result{}, nil
-	}
-	// todo remove erdma device
-	for _, device := range erdmaDevices.Items {
-		device.Finalizers = []string{}
-		controllerutil.RemoveFinalizer(&device, erdmaFinalizer)
-
-		update := device.DeepCopy()
-		_, err := controllerutil.CreateOrPatch(ctx, erdmaClient, update, func() error {
-			update.ObjectMeta = device.ObjectMeta
-			return nil
-		})
-		if err != nil {
+	for _, erdmaDevice := range erdmaDevices.Items {
+		if !erdmaDevice.DeletionTimestamp.IsZero() {
+			continue
+		}
+		err := erdmaClient.Delete(ctx, &erdmaDevice)
+		if client.IgnoreNotFound(err) != nil {
 			return ctrl.Result{}, err
 		}
 	}
+	return ctrl.Result{}, nil
+}
 
-	for _, erdmaDevice := range erdmaDevices.Items {
-		err := erdmaClient.Delete(ctx, &erdmaDevice)
-		if err != nil {
+// optedOut returns whether the node is opted out of erdma, by the opt-out
+// annotation or by leaving the node selector.
+func (r *NodeReconciler) optedOut(node *v1.Node) bool {
+	return !r.OwnNode(node) || node.Annotations[consts.NodeAnnotationOptOut] == "true"
+}
+
+// optOut marks the ERdmaDevices of an opted out node and deletes them: the
+// agent unconfigures the netdevs and stops advertising the devices, then the
+// ERIs created by the controller are released and a converted primary ENI is
+// converted back, see ERdmaDeviceReconciler.releaseERIs.
+func (r *NodeReconciler) optOut(ctx context.Context, node *v1.Node) (ctrl.Result, error) {
+	if err := r.removePlan(ctx, node.Name); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.untaintNotReady(ctx, node); err != nil {
+		return ctrl.Result{}, err
+	}
+	if r.dryRun(node) {
+		// the ERIs are released once the node leaves dry-run mode
+		log.FromContext(ctx).Info("node opted out in dry-run mode, keep erdma device", "node", node.Name)
+		return ctrl.Result{}, nil
+	}
+	erdmaDevices := networkv1beta2.ERdmaDeviceList{}
+	err := r.Client.List(ctx, &erdmaDevices, client.MatchingLabels{
+		"alibabacloud.com/nodename": node.Name,
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for i := range erdmaDevices.Items {
+		device := &erdmaDevices.Items[i]
+		if !device.DeletionTimestamp.IsZero() {
+			continue
+		}
+		log.FromContext(ctx).Info("node opted out, delete erdma device", "node", node.Name, "device", device.Name)
+		if device.Annotations[consts.NodeAnnotationOptOut] != "true" {
+			if device.Annotations == nil {
+				device.Annotations = map[string]string{}
+			}
+			device.Annotations[consts.NodeAnnotationOptOut] = "true"
+			if err := r.Client.Update(ctx, device); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		if err := r.Client.Delete(ctx, device); client.IgnoreNotFound(err) != nil {
 			return ctrl.Result{}, err
 		}
 	}
 	return ctrl.Result{}, nil
 }
 
+// taintNotReady puts the not-ready taint on a node with notReadyTaint until
+// the agent reports its erdma devices ready and removes it, so that pods do
+// not land on it before. Only new nodes are tainted: nodes without an
+// ERdmaDevice whose erdma devices were never ready, nodes in dry-run mode are
+// not. The ERI support of the instance type is checked in ECS before, so that
+// nodes without it are never tainted.
+func (r *NodeReconciler) taintNotReady(ctx context.Context, node *v1.Node) error {
+	if !r.ctrlConfig().NotReadyTaint || r.dryRun(node) || hasNotReadyTaint(node) ||
+		k8s.NodeConditionTrue(node, consts.NodeConditionERdmaReady) {
+		return nil
+	}
+	hasDevice, err := r.hasERdmaDevice(ctx, node.Name)
+	if err != nil || hasDevice {
+		return err
+	}
+	if _, unsupported := r.unsupportedNodes.Load(node.Name); unsupported {
+		return nil
+	}
+	instanceID, err := r.EriClient.InstanceIDFromNode(node)
+	if err != nil {
+		// e.g. the node has no provider ID and address yet, it is requeued
+		// while it is not ready
+		log.FromContext(ctx).Info("cannot find instance of node, not tainted yet", "node", node.Name, "error", err.Error())
+		return nil
+	}
+	supported, err := r.EriClient.SupportsERI(instanceID)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		r.setUnsupported(node.Name, true)
+		return nil
+	}
+	return r.patchNotReadyTaint(ctx, node, true)
+}
+
+func hasNotReadyTaint(node *v1.Node) bool {
+	return lo.ContainsBy(node.Spec.Taints, func(taint v1.Taint) bool {
+		return taint.Key == consts.NodeTaintNotReady
+	})
+}
+
+// untaintNotReady removes the not-ready taint of a node which gets no erdma
+// devices, e.g. without ERI support, in dry-run mode or opted out.
+func (r *NodeReconciler) untaintNotReady(ctx context.Context, node *v1.Node) error {
+	return r.patchNotReadyTaint(ctx, node, false)
+}
+
+func (r *NodeReconciler) patchNotReadyTaint(ctx context.Context, node *v1.Node, tainted bool) error {
+	patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	if !k8s.SetNotReadyTaint(node, tainted) {
+		return nil
+	}
+	log.FromContext(ctx).Info("update erdma not-ready taint of node", "node", node.Name, "tainted", tainted)
+	return r.Client.Patch(ctx, node, patch)
+}
+
+// hasERdmaDevice returns whether the node has an ERdmaDevice.
+func (r *NodeReconciler) hasERdmaDevice(ctx context.Context, name string) (bool, error) {
+	erdmaDevices := networkv1beta2.ERdmaDeviceList{}
+	err := r.Client.List(ctx, &erdmaDevices, client.MatchingLabels{
+		"alibabacloud.com/nodename": name,
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(erdmaDevices.Items) > 0, nil
+}
+
 func isNodeReady(node *v1.Node) bool {
 	for _, cond := range node.Status.Conditions {
 		if cond.Type == v1.NodeReady {
@@ -242,11 +518,26 @@ func isNodeReady(node *v1.Node) bool {
 	return false
 }
 
+// eriLayout is the ERI layout of profile with the vSwitches of the config.
+func (r *NodeReconciler) eriLayout(profile *networkv1beta2.ERdmaNodeProfile) ERILayout {
+	layout := profileLayout(profile)
+	layout.VSwitches = r.ctrlConfig().VSwitches
+	layout.VSwitchPolicy = r.ctrlConfig().VSwitchSelectionPolicy
+	return layout
+}
+
+func (r *NodeReconciler) ctrlConfig() *types.Config {
+	if r.CtrlConfig != nil {
+		return r.CtrlConfig
+	}
+	return config.GetConfig()
+}
+
 func (r *NodeReconciler) OwnNode(node *v1.Node) bool {
 	if node == nil {
 		return false
 	}
-	for k, v := range r.CtrlConfig.NodeSelector {
+	for k, v := range r.ctrlConfig().NodeSelector {
 		if node.Labels[k] != v {
 			return false
 		}
@@ -255,6 +546,15 @@ func (r *NodeReconciler) OwnNode(node *v1.Node) bool {
 }
 
 func (r *NodeReconciler) PredictNodeUpdate(oldNode, newNode *v1.Node) bool {
+	if r.OwnNode(oldNode) && !r.OwnNode(newNode) {
+		// the node left the node selector, it is opted out
+		return true
+	}
+	if oldNode.Annotations[consts.NodeAnnotationDryRun] != newNode.Annotations[consts.NodeAnnotationDryRun] {
+		// also for nodes which left the node selector in dry-run mode, they
+		// are opted out once they leave dry-run mode
+		return true
+	}
 	if !r.OwnNode(newNode) {
 		return false
 	}
@@ -262,6 +562,10 @@ func (r *NodeReconciler) PredictNodeUpdate(oldNode, newNode *v1.Node) bool {
 		return true
 	}
 
+	if oldNode.Annotations[consts.NodeAnnotationOptOut] != newNode.Annotations[consts.NodeAnnotationOptOut] {
+		return true
+	}
+
 	if newNode.DeletionTimestamp != nil {
 		return true
 	}
@@ -270,14 +574,33 @@ func (r *NodeReconciler) PredictNodeUpdate(oldNode, newNode *v1.Node) bool {
 		return true
 	}
 
-	return oldNode.Spec.ProviderID != newNode.Spec.ProviderID
+	if oldNode.Spec.ProviderID != newNode.Spec.ProviderID {
+		return true
+	}
+
+	if oldNode.Labels[v1.LabelInstanceTypeStable] != newNode.Labels[v1.LabelInstanceTypeStable] {
+		return true
+	}
+
+	return !maps.Equal(oldNode.Labels, newNode.Labels) && r.nodeProfileName(oldNode) != r.nodeProfileName(newNode)
+}
+
+// nodeProfileName returns the name of the ERdmaNodeProfile of node.
+func (r *NodeReconciler) nodeProfileName(node *v1.Node) string {
+	profile, err := selectNodeProfile(context.Background(), r.Client, node)
+	if err != nil || profile == nil {
+		return ""
+	}
+	return profile.Name
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *NodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	pred := predicate.TypedFuncs[*v1.Node]{
 		CreateFunc: func(e event.TypedCreateEvent[*v1.Node]) bool {
-			return r.OwnNode(e.Object)
+			// nodes which left the node selector while the controller was
+			// down are opted out
+			return r.reconciledNode(context.Background(), e.Object)
 		},
 		DeleteFunc: func(e event.TypedDeleteEvent[*v1.Node]) bool {
 			return r.OwnNode(e.Object)
@@ -293,5 +616,48 @@ func (r *NodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if err != nil {
 		return err
 	}
-	return c.Watch(source.Kind(mgr.GetCache(), &v1.Node{}, &handler.TypedEnqueueRequestForObject[*v1.Node]{}, pred))
+	err = c.Watch(source.Kind(mgr.GetCache(), &v1.Node{}, &handler.TypedEnqueueRequestForObject[*v1.Node]{}, pred))
+	if err != nil {
+		return err
+	}
+	err = c.Watch(source.Kind(mgr.GetCache(), &networkv1beta2.ERdmaNodeProfile{},
+		handler.TypedEnqueueRequestsFromMapFunc(func(ctx context.Context, _ *networkv1beta2.ERdmaNodeProfile) []reconcile.Request {
+			return r.ownedNodeRequests(ctx, nil)
+		})))
+	if err != nil {
+		return err
+	}
+	if r.ConfigEvents == nil {
+		return nil
+	}
+	return c.Watch(source.Channel(r.ConfigEvents, handler.EnqueueRequestsFromMapFunc(r.ownedNodeRequests)))
+}
+
+// ownedNodeRequests requeues all nodes selected by the current config and the
+// nodes with an ERdmaDevice, e.g. after the node selector or a node profile
+// changed.
+func (r *NodeReconciler) ownedNodeRequests(ctx context.Context, _ client.Object) []reconcile.Request {
+	nodes := &v1.NodeList{}
+	if err := r.Client.List(ctx, nodes); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list nodes")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range nodes.Items {
+		if r.reconciledNode(ctx, &nodes.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: k8stypes.NamespacedName{Name: nodes.Items[i].Name}})
+		}
+	}
+	return requests
+}
+
+// reconciledNode returns whether node is selected by the node selector or has
+// an ERdmaDevice to release. Nodes whose ERdmaDevices cannot be listed are
+// reconciled, Reconcile decides on them.
+func (r *NodeReconciler) reconciledNode(ctx context.Context, node *v1.Node) bool {
+	if r.OwnNode(node) {
+		return true
+	}
+	hasDevice, err := r.hasERdmaDevice(ctx, node.Name)
+	return err != nil || hasDevice
 }
@@ -5,13 +5,20 @@ import (
 	"testing"
 	"time"
 
-	networkv1 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/consts"
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/fakeecs"
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
 
+	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -140,7 +147,7 @@ func TestPredictNodeUpdate(t *testing.T) {
 		expected bool
 	}{
 		{
-			name: "New node not owned",
+			name: "Node left the node selector",
 			oldNode: &v1.Node{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "node1",
@@ -157,8 +164,51 @@ func TestPredictNodeUpdate(t *testing.T) {
 					},
 				},
 			},
+			expected: true,
+		},
+		{
+			name: "New node not owned",
+			oldNode: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+					Labels: map[string]string{
+						"test-key": "not-owned",
+					},
+				},
+			},
+			newNode: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+					Labels: map[string]string{
+						"test-key": "different-value",
+					},
+				},
+			},
 			expected: false,
 		},
+		{
+			name: "Opt-out annotation changed",
+			oldNode: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+					Labels: map[string]string{
+						"test-key": "test-value",
+					},
+				},
+			},
+			newNode: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+					Labels: map[string]string{
+						"test-key": "test-value",
+					},
+					Annotations: map[string]string{
+						consts.NodeAnnotationOptOut: "true",
+					},
+				},
+			},
+			expected: true,
+		},
 		{
 			name: "Old node not owned, new node owned",
 			oldNode: &v1.Node{
@@ -286,6 +336,28 @@ func TestPredictNodeUpdate(t *testing.T) {
 			},
 			expected: true,
 		},
+		{
+			name: "Instance type changed",
+			oldNode: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+					Labels: map[string]string{
+						"test-key":                 "test-value",
+						v1.LabelInstanceTypeStable: "ecs.ebmgn7e.32xlarge",
+					},
+				},
+			},
+			newNode: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+					Labels: map[string]string{
+						"test-key":                 "test-value",
+						v1.LabelInstanceTypeStable: "ecs.ebmgn8v.48xlarge",
+					},
+				},
+			},
+			expected: true,
+		},
 	}
 
 	reconciler := &NodeReconciler{
@@ -309,7 +381,7 @@ func TestPredictNodeUpdate(t *testing.T) {
 func TestReconcileNodeReadyGate(t *testing.T) {
 	scheme := runtime.NewScheme()
 	_ = clientgoscheme.AddToScheme(scheme)
-	_ = networkv1.AddToScheme(scheme)
+	_ = networkv1beta2.AddToScheme(scheme)
 
 	tests := []struct {
 		name            string
@@ -404,3 +476,151 @@ func TestReconcileNodeReadyGate(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcileInstanceTypeChange(t *testing.T) {
+	node := readyNode("node1", "i-1")
+	node.Labels = map[string]string{v1.LabelInstanceTypeStable: "ecs.small"}
+	env := newFakeECSEnv(t, &fakeecs.State{
+		InstanceTypes: []fakeecs.InstanceType{
+			{ID: "ecs.small", EriQuantity: 1, NetworkCardQuantity: 1, QueuePairNumber: 8},
+			{ID: "ecs.large", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 16},
+		},
+		Instances: []fakeecs.Instance{{ID: "i-1", InstanceType: "ecs.small", PrivateIP: "192.168.0.1"}},
+	}, node)
+	backend, fakeClient, r := env.backend, env.client, env.nodes
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)}
+	device := &networkv1beta2.ERdmaDevice{}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, req.NamespacedName, device))
+	assert.Equal(t, "ecs.small", device.Spec.InstanceType)
+	require.Len(t, device.Spec.ERIs, 1)
+	primary := device.Spec.ERIs[0]
+
+	// the instance is resized to a type with two network cards
+	require.NoError(t, backend.ResizeInstance("i-1", "ecs.large"))
+	node.Labels[v1.LabelInstanceTypeStable] = "ecs.large"
+	require.NoError(t, fakeClient.Update(ctx, node))
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+
+	require.NoError(t, fakeClient.Get(ctx, req.NamespacedName, device))
+	assert.Equal(t, "ecs.large", device.Spec.InstanceType)
+	require.Len(t, device.Spec.ERIs, 2)
+	assert.Equal(t, primary, device.Spec.ERIs[0])
+	assert.Equal(t, 1, device.Spec.ERIs[1].NetworkCardIndex)
+	assert.Equal(t, 8, device.Spec.ERIs[1].QueuePair)
+}
+
+// TestReconcileInstanceTypeChangeNoLeak replans a node whose card 0 ERI is
+// already in its spec, no ERI is created for it again.
+func TestReconcileInstanceTypeChangeNoLeak(t *testing.T) {
+	node := readyNode("node1", "i-1")
+	node.Labels = map[string]string{v1.LabelInstanceTypeStable: "ecs.small"}
+	env := newFakeECSEnv(t, &fakeecs.State{
+		InstanceTypes: []fakeecs.InstanceType{
+			{ID: "ecs.small", EriQuantity: 1, NetworkCardQuantity: 1, QueuePairNumber: 8},
+			{ID: "ecs.large", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 16},
+		},
+		Instances: []fakeecs.Instance{{ID: "i-1", InstanceType: "ecs.small", PrivateIP: "192.168.0.1"}},
+	}, node)
+	backend, fakeClient, r := env.backend, env.client, env.nodes
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)}
+	enis := func() int {
+		// the created ERIs are not attached yet
+		enis, err := backend.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{})
+		require.NoError(t, err)
+		return len(enis)
+	}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, 1, enis())
+
+	// the converted primary ENI on card 0 is not planned again by a profile
+	// which no longer allows the conversion
+	require.NoError(t, fakeClient.Create(ctx, &networkv1beta2.ERdmaNodeProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "no-primary"},
+		Spec:       networkv1beta2.ERdmaNodeProfileSpec{AllowPrimaryENIConversion: ptr.To(false)},
+	}))
+	require.NoError(t, backend.ResizeInstance("i-1", "ecs.large"))
+	node.Labels[v1.LabelInstanceTypeStable] = "ecs.large"
+	require.NoError(t, fakeClient.Update(ctx, node))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	device := &networkv1beta2.ERdmaDevice{}
+	require.NoError(t, fakeClient.Get(ctx, req.NamespacedName, device))
+	require.Len(t, device.Spec.ERIs, 2)
+	assert.Equal(t, 1, device.Spec.ERIs[1].NetworkCardIndex)
+	assert.Equal(t, 2, enis())
+}
+
+// TestReconcileInstanceTypeChangeCreatedERI replans a node with a created ERI
+// attached on card 1, it is kept on its card and an ERI is created on the new
+// card.
+func TestReconcileInstanceTypeChangeCreatedERI(t *testing.T) {
+	node := readyNode("node1", "i-1")
+	node.Labels = map[string]string{v1.LabelInstanceTypeStable: "ecs.m"}
+	env := newFakeECSEnv(t, &fakeecs.State{
+		InstanceTypes: []fakeecs.InstanceType{
+			{ID: "ecs.m", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 16},
+			{ID: "ecs.l", EriQuantity: 3, NetworkCardQuantity: 3, QueuePairNumber: 24},
+		},
+		Instances: []fakeecs.Instance{{ID: "i-1", InstanceType: "ecs.m", PrivateIP: "192.168.0.1"}},
+	}, node)
+	backend, fakeClient, r := env.backend, env.client, env.nodes
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)}
+	device := &networkv1beta2.ERdmaDevice{}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	_, err = env.devices.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, fakeClient.Get(ctx, req.NamespacedName, device))
+	require.Len(t, device.Spec.ERIs, 2)
+	created := device.Spec.ERIs[1]
+	assert.Equal(t, 1, created.NetworkCardIndex)
+	attached, err := backend.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{InstanceId: ptr.To("i-1")})
+	require.NoError(t, err)
+	require.Len(t, attached, 2)
+
+	require.NoError(t, backend.ResizeInstance("i-1", "ecs.l"))
+	node.Labels[v1.LabelInstanceTypeStable] = "ecs.l"
+	require.NoError(t, fakeClient.Update(ctx, node))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	require.NoError(t, fakeClient.Get(ctx, req.NamespacedName, device))
+	assert.Equal(t, "ecs.l", device.Spec.InstanceType)
+	require.Len(t, device.Spec.ERIs, 3)
+	assert.Equal(t, created, device.Spec.ERIs[1])
+	assert.Equal(t, 2, device.Spec.ERIs[2].NetworkCardIndex)
+	assert.NotEqual(t, created.ID, device.Spec.ERIs[2].ID)
+}
+
+func TestReconcileUnsupportedNode(t *testing.T) {
+	node := readyNode("node1", "i-1")
+	env := newFakeECSEnv(t, &fakeecs.State{
+		InstanceTypes: []fakeecs.InstanceType{{ID: "ecs.g7", EriQuantity: 0, NetworkCardQuantity: 1, QueuePairNumber: 8}},
+		Instances:     []fakeecs.Instance{{ID: "i-1", InstanceType: "ecs.g7", PrivateIP: "192.168.0.1"}},
+	}, node)
+	fakeClient, r := env.client, env.nodes
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(unsupportedNodes))
+
+	// the skipped node is forgotten once it is deleted
+	require.NoError(t, fakeClient.Delete(ctx, node))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, testutil.ToFloat64(unsupportedNodes))
+}
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+)
+
+// +kubebuilder:rbac:groups=network.alibabacloud.com,resources=erdmanodeprofiles,verbs=get;list;watch
+
+// selectNodeProfile returns the ERdmaNodeProfile of node, the one with the
+// highest priority and then the smallest name among the profiles selecting
+// it, nil when no profile selects it.
+func selectNodeProfile(ctx context.Context, c client.Client, node *v1.Node) (*networkv1beta2.ERdmaNodeProfile, error) {
+	profiles := &networkv1beta2.ERdmaNodeProfileList{}
+	if err := c.List(ctx, profiles); err != nil {
+		return nil, err
+	}
+	var selected *networkv1beta2.ERdmaNodeProfile
+	for i := range profiles.Items {
+		profile := &profiles.Items[i]
+		selector, err := metav1.LabelSelectorAsSelector(&profile.Spec.NodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node selector of erdma node profile %s: %v", profile.Name, err)
+		}
+		if !selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		if selected == nil || profile.Spec.Priority > selected.Spec.Priority ||
+			(profile.Spec.Priority == selected.Spec.Priority && profile.Name < selected.Name) {
+			selected = profile
+		}
+	}
+	return selected, nil
+}
+
+// profileLayout is the ERI layout of profile, the default layout for nil.
+func profileLayout(profile *networkv1beta2.ERdmaNodeProfile) ERILayout {
+	if profile == nil {
+		return ERILayout{}
+	}
+	layout := ERILayout{
+		Cards: profile.Spec.NetworkCards,
+		Weights: lo.SliceToMap(profile.Spec.QueuePairWeights, func(item networkv1beta2.QueuePairWeight) (int, int) {
+			return item.NetworkCardIndex, item.Weight
+		}),
+		NoPrimaryENI: profile.Spec.AllowPrimaryENIConversion != nil && !*profile.Spec.AllowPrimaryENIConversion,
+	}
+	if profile.Spec.ERICount != nil {
+		layout.Count = *profile.Spec.ERICount
+	}
+	return layout
+}
+
+// applyProfileSettings sets the agent settings of profile on spec, and
+// returns whether spec changed. Without a profile, settings set on spec by
+// hand are kept, they are only cleared when the node leaves its profile.
+func applyProfileSettings(spec *networkv1beta2.ERdmaDeviceSpec, profile *networkv1beta2.ERdmaNodeProfile) bool {
+	if profile == nil && spec.Profile == "" {
+		return false
+	}
+	var name, driver string
+	var jumboFrameMTU int
+	if profile != nil {
+		name = profile.Name
+		driver = profile.Spec.Driver
+		jumboFrameMTU = lo.FromPtr(profile.Spec.JumboFrameMTU)
+	}
+	changed := spec.Profile != name || spec.Driver != driver || spec.JumboFrameMTU != jumboFrameMTU
+	spec.Profile = name
+	spec.Driver = driver
+	spec.JumboFrameMTU = jumboFrameMTU
+	return changed
+}
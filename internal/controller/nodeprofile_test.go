@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+)
+
+func TestSelectNodeProfile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = networkv1beta2.AddToScheme(scheme)
+
+	profile := func(name string, priority int32, matchLabels map[string]string) *networkv1beta2.ERdmaNodeProfile {
+		return &networkv1beta2.ERdmaNodeProfile{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: networkv1beta2.ERdmaNodeProfileSpec{
+				NodeSelector: metav1.LabelSelector{MatchLabels: matchLabels},
+				Priority:     priority,
+			},
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		profile("all", 0, nil),
+		profile("gpu-b", 10, map[string]string{"pool": "gpu"}),
+		profile("gpu-a", 10, map[string]string{"pool": "gpu"}),
+		profile("cpu", 10, map[string]string{"pool": "cpu"}),
+	).Build()
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected string
+	}{
+		{name: "highest priority, smallest name", labels: map[string]string{"pool": "gpu"}, expected: "gpu-a"},
+		{name: "empty selector selects all nodes", labels: map[string]string{"pool": "other"}, expected: "all"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := selectNodeProfile(context.Background(), c, &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: tt.labels}})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, selected.Name)
+		})
+	}
+}
+
+func TestApplyProfileSettings(t *testing.T) {
+	spec := &networkv1beta2.ERdmaDeviceSpec{}
+	profile := &networkv1beta2.ERdmaNodeProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "gpu"},
+		Spec:       networkv1beta2.ERdmaNodeProfileSpec{Driver: "compat", JumboFrameMTU: ptr.To(9000)},
+	}
+	assert.True(t, applyProfileSettings(spec, profile))
+	assert.Equal(t, &networkv1beta2.ERdmaDeviceSpec{Profile: "gpu", Driver: "compat", JumboFrameMTU: 9000}, spec)
+	assert.False(t, applyProfileSettings(spec, profile))
+	assert.True(t, applyProfileSettings(spec, nil))
+	assert.Equal(t, &networkv1beta2.ERdmaDeviceSpec{}, spec)
+
+	spec = &networkv1beta2.ERdmaDeviceSpec{Driver: "ofed"}
+	assert.False(t, applyProfileSettings(spec, nil))
+	assert.Equal(t, "ofed", spec.Driver)
+}
+
+func TestSelectEriFromExistWithLayout(t *testing.T) {
+	primary := &ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet{
+		NetworkInterfaceId: lo.ToPtr("eni-primary"),
+		Type:               lo.ToPtr("Primary"),
+		MacAddress:         lo.ToPtr("00:16:3e:00:00:00"),
+	}
+	tests := []struct {
+		name               string
+		layout             ERILayout
+		networkCards       int
+		cardCount          int
+		expectedERIs       []string
+		expectedNeedCreate []int
+		expectedQueuePairs map[int]int
+	}{
+		{
+			name:               "default layout converts the primary ENI",
+			networkCards:       2,
+			cardCount:          2,
+			expectedERIs:       []string{"eni-primary"},
+			expectedNeedCreate: []int{1},
+			expectedQueuePairs: map[int]int{0: 16, 1: 16},
+		},
+		{
+			name:               "weights and no primary ENI",
+			layout:             ERILayout{Weights: map[int]int{1: 3}, NoPrimaryENI: true},
+			networkCards:       2,
+			cardCount:          2,
+			expectedERIs:       []string{},
+			expectedNeedCreate: []int{0, 1},
+			expectedQueuePairs: map[int]int{0: 8, 1: 24},
+		},
+		{
+			name:               "single ERI on a selected card",
+			layout:             ERILayout{Count: 1, Cards: []int{1, 2, 5}},
+			networkCards:       4,
+			cardCount:          4,
+			expectedERIs:       []string{},
+			expectedNeedCreate: []int{1},
+			expectedQueuePairs: map[int]int{1: 32},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &EriClient{ManagedNonOwned: true}
+			eris, needCreate, queuePairs, err := e.selectEriFromExist(
+				[]*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet{primary},
+				32, tt.layout.cards(tt.networkCards, tt.cardCount), tt.layout)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedERIs, lo.Map(eris, func(item *types.ERI, _ int) string { return item.ID }))
+			assert.Equal(t, tt.expectedNeedCreate, needCreate)
+			assert.Equal(t, tt.expectedQueuePairs, queuePairs.byCard)
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/consts"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+)
+
+// planRefreshInterval is the interval the plan of a node in dry-run mode is
+// computed again.
+const planRefreshInterval = 10 * time.Minute
+
+// PlanERIs plans the ERIs of an instance with layout like SelectERIs, without
+// changing anything in ECS: the ERIs to adopt, whether the primary ENI is
+// converted, the ERIs to create with their vSwitch, and the queue pairs of
+// each of them. The ENI APIs have no DryRun parameter, the plan is only
+// computed from Describe calls.
+func (e *EriClient) PlanERIs(instanceID string, layout ERILayout) (*networkv1beta2.ERdmaPlanStatus, error) {
+	instanceResp, instanceTypeResp, err := e.describeInstanceType(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	instance := instanceResp.Body.Instances.Instance[0]
+	plan := &networkv1beta2.ERdmaPlanStatus{
+		InstanceID:   instanceID,
+		InstanceType: tea.StringValue(instance.InstanceType),
+	}
+	capacity, ok := eriCapacityOf(instanceResp, instanceTypeResp)
+	if !ok {
+		plan.Message = fmt.Sprintf("instance type %s has no ERI support", plan.InstanceType)
+		return plan, nil
+	}
+	plan.QueuePairCount = capacity.queuePairCount
+
+	existENIs, err := e.client.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{
+		RegionId:   ptr.To(e.regionID),
+		InstanceId: ptr.To(instanceID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot found node eni: %v", err)
+	}
+	selected, needCreate, queuePairs, err := e.selectEriFromExist(existENIs, capacity.queuePairCount, layout.cards(capacity.networkCards, capacity.cardCount), layout)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate eri config list from exist enis: %v", err)
+	}
+	trafficModes := lo.SliceToMap(existENIs, func(item *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet) (string, string) {
+		return tea.StringValue(item.NetworkInterfaceId), tea.StringValue(item.NetworkInterfaceTrafficMode)
+	})
+	for _, eri := range selected {
+		action := networkv1beta2.ERIActionAdopt
+		if eri.IsPrimaryENI && trafficModes[eri.ID] != trafficModeRDMA {
+			action = networkv1beta2.ERIActionConvertPrimaryENI
+		}
+		plan.ERIs = append(plan.ERIs, networkv1beta2.PlannedERI{
+			Action:           action,
+			ID:               eri.ID,
+			NetworkCardIndex: eri.CardIndex,
+			QueuePair:        eri.QueuePair,
+			PrimaryENI:       eri.IsPrimaryENI,
+		})
+	}
+
+	// the detached ERIs created for the instance before are reused, see
+	// CreateEriForInstance
+	createdENIs, err := e.reusableENIs(instance.InstanceId, layout)
+	if err != nil {
+		return nil, err
+	}
+	for _, eni := range createdENIs {
+		if len(needCreate) == 0 {
+			break
+		}
+		plan.ERIs = append(plan.ERIs, networkv1beta2.PlannedERI{
+			Action:           networkv1beta2.ERIActionAdopt,
+			ID:               tea.StringValue(eni.NetworkInterfaceId),
+			NetworkCardIndex: needCreate[0],
+			QueuePair:        toEri(eni, queuePairs.byCard[needCreate[0]]).QueuePair,
+		})
+		needCreate = needCreate[1:]
+	}
+	if len(needCreate) == 0 {
+		return plan, nil
+	}
+	selector, err := e.newVSwitchSelector(instance, layout)
+	if err != nil {
+		return nil, err
+	}
+	for _, cardIndex := range needCreate {
+		vSwitchID, err := selector.next()
+		if err != nil {
+			return nil, err
+		}
+		plan.ERIs = append(plan.ERIs, networkv1beta2.PlannedERI{
+			Action:           networkv1beta2.ERIActionCreate,
+			NetworkCardIndex: cardIndex,
+			QueuePair:        queuePairs.byCard[cardIndex],
+			VSwitchID:        vSwitchID,
+		})
+	}
+	return plan, nil
+}
+
+// dryRun returns whether the ERIs of node are only planned, see dryRunNode.
+func (r *NodeReconciler) dryRun(node *v1.Node) bool {
+	return dryRunNode(node, r.ctrlConfig())
+}
+
+// dryRunNode returns whether nothing is changed in ECS for node, from the
+// dry-run annotation of the node or else cfg. node is nil for a deleted node.
+func dryRunNode(node *v1.Node, cfg *types.Config) bool {
+	if node != nil {
+		if value, ok := node.Annotations[consts.NodeAnnotationDryRun]; ok {
+			if dryRun, err := strconv.ParseBool(value); err == nil {
+				return dryRun
+			}
+		}
+	}
+	return cfg != nil && cfg.DryRun
+}
+
+// planNode writes the plan of the ERIs of node to its ERdmaPlan, a failed
+// plan is written with its error as message. The plan is computed again
+// periodically.
+func (r *NodeReconciler) planNode(ctx context.Context, node *v1.Node, instanceID string, layout ERILayout) (ctrl.Result, error) {
+	status, err := r.EriClient.PlanERIs(instanceID, layout)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to plan erdma device", "node", node.Name)
+		status = &networkv1beta2.ERdmaPlanStatus{InstanceID: instanceID, Message: err.Error()}
+	}
+	status.PlanTime = metav1.Now()
+
+	plan := &networkv1beta2.ERdmaPlan{}
+	err = r.Client.Get(ctx, k8stypes.NamespacedName{Name: node.Name}, plan)
+	if errors.IsNotFound(err) {
+		plan = &networkv1beta2.ERdmaPlan{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: node.Name,
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "v1",
+					Kind:       "Node",
+					Name:       node.Name,
+					UID:        node.UID,
+				}},
+			},
+		}
+		err = r.Client.Create(ctx, plan)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	plan.Status = *status
+	if err = r.Client.Status().Update(ctx, plan); err != nil {
+		return ctrl.Result{}, err
+	}
+	log.FromContext(ctx).Info("planned erdma device in dry-run mode", "node", node.Name, "plan", plan.Status)
+	return ctrl.Result{RequeueAfter: planRefreshInterval}, nil
+}
+
+// removePlan removes the ERdmaPlan of a node which is no longer in dry-run
+// mode.
+func (r *NodeReconciler) removePlan(ctx context.Context, name string) error {
+	plan := &networkv1beta2.ERdmaPlan{}
+	err := r.Client.Get(ctx, k8stypes.NamespacedName{Name: name}, plan)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return client.IgnoreNotFound(r.Client.Delete(ctx, plan))
+}
+
+// PlanHandler serves the ERdmaPlans as json, the plan of a single node with
+// the node query parameter.
+type PlanHandler struct {
+	Client client.Reader
+}
+
+func (h *PlanHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	plans := &networkv1beta2.ERdmaPlanList{}
+	if err := h.Client.List(req.Context(), plans); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := map[string]networkv1beta2.ERdmaPlanStatus{}
+	node := req.URL.Query().Get("node")
+	for _, plan := range plans.Items {
+		if node == "" || plan.Name == node {
+			result[plan.Name] = plan.Status
+		}
+	}
+	if node != "" && len(result) == 0 {
+		http.Error(w, fmt.Sprintf("no plan of node %s", node), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Log.Error(err, "failed to write erdma plans")
+	}
+}
+
+// PlanServer serves PlanHandler on /debug/erdma-plans of its own address, so
+// that the plans are reachable without the metrics server. It runs on every
+// replica.
+type PlanServer struct {
+	addr    string
+	handler *PlanHandler
+}
+
+func NewPlanServer(addr string, reader client.Reader) *PlanServer {
+	return &PlanServer{addr: addr, handler: &PlanHandler{Client: reader}}
+}
+
+// Start implements manager.Runnable, it serves until ctx is done.
+func (s *PlanServer) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/erdma-plans", s.handler)
+	server := &http.Server{Addr: s.addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	log.FromContext(ctx).Info("serving erdma plans", "addr", s.addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (s *PlanServer) NeedLeaderElection() bool {
+	return false
+}
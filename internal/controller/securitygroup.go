@@ -0,0 +1,434 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/samber/lo"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/config"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+)
+
+var sgLog = ctrl.Log.WithName("security-group")
+
+const (
+	// securityGroupTagKey tags the managed security group with its name, the
+	// controller finds the group of a VPC by this tag and the creator tag.
+	securityGroupTagKey = "erdma.alibabacloud.com/security-group"
+
+	directionIngress = "ingress"
+	directionEgress  = "egress"
+
+	protocolAll      = "ALL"
+	portRangeAll     = "-1/-1"
+	policyAccept     = "accept"
+	priorityHighest  = 1
+	securityGroupAll = "all"
+
+	// minSecurityGroupRepairInterval is the lower bound of the repair interval.
+	minSecurityGroupRepairInterval = time.Minute
+
+	errCodeSecurityGroupNotFound = "InvalidSecurityGroupId.NotFound"
+)
+
+// securityGroupCache is the managed security group of each VPC, it is shared
+// by the copies of an EriClient.
+type securityGroupCache struct {
+	lock sync.Mutex
+	// groups are the IDs of the groups by VPC and group name.
+	groups map[securityGroupKey]string
+	// ensuring serializes finding or creating the group of a key.
+	ensuring map[securityGroupKey]*sync.Mutex
+}
+
+type securityGroupKey struct {
+	vpcID, name string
+}
+
+func newSecurityGroupCache() *securityGroupCache {
+	return &securityGroupCache{groups: map[securityGroupKey]string{}, ensuring: map[securityGroupKey]*sync.Mutex{}}
+}
+
+// lockKey locks the key until the returned func is called, so that a group is
+// only created once by concurrent reconciles.
+func (c *securityGroupCache) lockKey(key securityGroupKey) func() {
+	if c == nil {
+		return func() {}
+	}
+	c.lock.Lock()
+	keyLock, ok := c.ensuring[key]
+	if !ok {
+		keyLock = &sync.Mutex{}
+		c.ensuring[key] = keyLock
+	}
+	c.lock.Unlock()
+	keyLock.Lock()
+	return keyLock.Unlock
+}
+
+// get returns the cached group, a nil cache caches nothing.
+func (c *securityGroupCache) get(key securityGroupKey) string {
+	if c == nil {
+		return ""
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.groups[key]
+}
+
+func (c *securityGroupCache) set(key securityGroupKey, groupID string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.groups[key] = groupID
+}
+
+// forget drops groupID from the cache, e.g. after it was deleted in ECS.
+func (c *securityGroupCache) forget(groupID string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	maps.DeleteFunc(c.groups, func(_ securityGroupKey, id string) bool { return id == groupID })
+}
+
+// list returns the cached groups of name.
+func (c *securityGroupCache) list(name string) map[securityGroupKey]string {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return lo.PickBy(c.groups, func(key securityGroupKey, _ string) bool { return key.name == name })
+}
+
+// managedSecurityGroup returns the managed security group config, nil when it
+// is disabled.
+func (e *EriClient) managedSecurityGroup() *types.ManagedSecurityGroup {
+	sg := e.SecurityGroup
+	if sg == nil && config.GetConfig() != nil {
+		sg = &config.GetConfig().ManagedSecurityGroup
+	}
+	if sg == nil || !sg.Enabled {
+		return nil
+	}
+	return sg
+}
+
+// EnsureSecurityGroup returns the managed security group of vpcID, empty when
+// it is disabled. The group is found by its tags or created, and its rules are
+// repaired the first time it is found. It is found again once ECS reported it
+// not found, see forgetSecurityGroup.
+func (e *EriClient) EnsureSecurityGroup(vpcID string) (string, error) {
+	sg := e.managedSecurityGroup()
+	if sg == nil || vpcID == "" {
+		return "", nil
+	}
+	key := securityGroupKey{vpcID: vpcID, name: sg.Name}
+	if groupID := e.securityGroups.get(key); groupID != "" {
+		return groupID, nil
+	}
+	defer e.securityGroups.lockKey(key)()
+	if groupID := e.securityGroups.get(key); groupID != "" {
+		return groupID, nil
+	}
+	groups, err := e.client.DescribeSecurityGroups(&ecs.DescribeSecurityGroupsRequest{
+		RegionId: ptr.To(e.regionID),
+		VpcId:    ptr.To(vpcID),
+		Tag: []*ecs.DescribeSecurityGroupsRequestTag{{
+			Key:   ptr.To(eriTagCreatorKey),
+			Value: ptr.To(eriTagCreatorValue),
+		}, {
+			Key:   ptr.To(securityGroupTagKey),
+			Value: ptr.To(sg.Name),
+		}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("describe security group %s of vpc %s failed: %v", sg.Name, vpcID, err)
+	}
+	var groupID string
+	if len(groups) > 0 {
+		groupID = tea.StringValue(groups[0].SecurityGroupId)
+	} else {
+		resp, err := e.client.CreateSecurityGroup(&ecs.CreateSecurityGroupRequest{
+			RegionId:          ptr.To(e.regionID),
+			VpcId:             ptr.To(vpcID),
+			SecurityGroupName: ptr.To(sg.Name),
+			SecurityGroupType: ptr.To("normal"),
+			Description:       ptr.To("security group of the eris managed by alibabacloud-erdma-controller"),
+			Tag: []*ecs.CreateSecurityGroupRequestTag{{
+				Key:   ptr.To(eriTagCreatorKey),
+				Value: ptr.To(eriTagCreatorValue),
+			}, {
+				Key:   ptr.To(securityGroupTagKey),
+				Value: ptr.To(sg.Name),
+			}},
+		})
+		if err != nil {
+			return "", fmt.Errorf("create security group %s in vpc %s failed: %v", sg.Name, vpcID, err)
+		}
+		groupID = tea.StringValue(resp.Body.SecurityGroupId)
+		sgLog.Info("created security group", "securityGroup", groupID, "name", sg.Name, "vpc", vpcID)
+	}
+	if err = e.RepairSecurityGroup(groupID, sg.Rules); err != nil {
+		return "", err
+	}
+	e.securityGroups.set(key, groupID)
+	return groupID, nil
+}
+
+// RepairSecurityGroup authorizes the missing rules of the managed security
+// group groupID and revokes the rules which are neither the one allowing the
+// traffic inside the group nor one of rules.
+func (e *EriClient) RepairSecurityGroup(groupID string, rules []types.SecurityGroupRule) error {
+	permissions, err := e.client.DescribeSecurityGroupRules(&ecs.DescribeSecurityGroupAttributeRequest{
+		RegionId:        ptr.To(e.regionID),
+		SecurityGroupId: ptr.To(groupID),
+		Direction:       ptr.To(securityGroupAll),
+	})
+	if err != nil {
+		return fmt.Errorf("describe rules of security group %s failed: %w", groupID, err)
+	}
+	desired := desiredSecurityGroupRules(groupID, rules)
+	existing := map[string]bool{}
+	var revokeIngress, revokeEgress []*string
+	for _, permission := range permissions {
+		rule := ruleFromPermission(permission)
+		key := rule.key()
+		if _, ok := desired[key]; ok && !existing[key] {
+			existing[key] = true
+			continue
+		}
+		if rule.direction == directionEgress {
+			revokeEgress = append(revokeEgress, permission.SecurityGroupRuleId)
+		} else {
+			revokeIngress = append(revokeIngress, permission.SecurityGroupRuleId)
+		}
+	}
+	var authorizeIngress []*ecs.AuthorizeSecurityGroupRequestPermissions
+	var authorizeEgress []*ecs.AuthorizeSecurityGroupEgressRequestPermissions
+	for key, rule := range desired {
+		if existing[key] {
+			continue
+		}
+		if rule.direction == directionEgress {
+			authorizeEgress = append(authorizeEgress, &ecs.AuthorizeSecurityGroupEgressRequestPermissions{
+				IpProtocol:  ptr.To(rule.protocol),
+				PortRange:   ptr.To(rule.portRange),
+				DestCidrIp:  lo.EmptyableToPtr(rule.cidr),
+				DestGroupId: lo.EmptyableToPtr(rule.groupID),
+				Policy:      ptr.To(rule.policy),
+				Priority:    ptr.To(rule.priority),
+				Description: lo.EmptyableToPtr(rule.description),
+			})
+		} else {
+			authorizeIngress = append(authorizeIngress, &ecs.AuthorizeSecurityGroupRequestPermissions{
+				IpProtocol:    ptr.To(rule.protocol),
+				PortRange:     ptr.To(rule.portRange),
+				SourceCidrIp:  lo.EmptyableToPtr(rule.cidr),
+				SourceGroupId: lo.EmptyableToPtr(rule.groupID),
+				Policy:        ptr.To(rule.policy),
+				Priority:      ptr.To(rule.priority),
+				Description:   lo.EmptyableToPtr(rule.description),
+			})
+		}
+	}
+
+	if len(revokeIngress) > 0 {
+		sgLog.Info("revoking drifted ingress rules", "securityGroup", groupID, "rules", tea.StringSliceValue(revokeIngress))
+		if _, err = e.client.RevokeSecurityGroup(&ecs.RevokeSecurityGroupRequest{
+			RegionId:            ptr.To(e.regionID),
+			SecurityGroupId:     ptr.To(groupID),
+			SecurityGroupRuleId: revokeIngress,
+		}); err != nil {
+			return fmt.Errorf("revoke ingress rules of security group %s failed: %v", groupID, err)
+		}
+	}
+	if len(revokeEgress) > 0 {
+		sgLog.Info("revoking drifted egress rules", "securityGroup", groupID, "rules", tea.StringSliceValue(revokeEgress))
+		if _, err = e.client.RevokeSecurityGroupEgress(&ecs.RevokeSecurityGroupEgressRequest{
+			RegionId:            ptr.To(e.regionID),
+			SecurityGroupId:     ptr.To(groupID),
+			SecurityGroupRuleId: revokeEgress,
+		}); err != nil {
+			return fmt.Errorf("revoke egress rules of security group %s failed: %v", groupID, err)
+		}
+	}
+	if len(authorizeIngress) > 0 {
+		sgLog.Info("authorizing missing ingress rules", "securityGroup", groupID, "count", len(authorizeIngress))
+		if _, err = e.client.AuthorizeSecurityGroup(&ecs.AuthorizeSecurityGroupRequest{
+			RegionId:        ptr.To(e.regionID),
+			SecurityGroupId: ptr.To(groupID),
+			Permissions:     authorizeIngress,
+		}); err != nil {
+			return fmt.Errorf("authorize ingress rules of security group %s failed: %v", groupID, err)
+		}
+	}
+	if len(authorizeEgress) > 0 {
+		sgLog.Info("authorizing missing egress rules", "securityGroup", groupID, "count", len(authorizeEgress))
+		if _, err = e.client.AuthorizeSecurityGroupEgress(&ecs.AuthorizeSecurityGroupEgressRequest{
+			RegionId:        ptr.To(e.regionID),
+			SecurityGroupId: ptr.To(groupID),
+			Permissions:     authorizeEgress,
+		}); err != nil {
+			return fmt.Errorf("authorize egress rules of security group %s failed: %v", groupID, err)
+		}
+	}
+	return nil
+}
+
+// RepairSecurityGroups repairs the rules of the managed security groups found
+// or created since the controller started.
+func (e *EriClient) RepairSecurityGroups() error {
+	sg := e.managedSecurityGroup()
+	if sg == nil {
+		return nil
+	}
+	var errs []string
+	for key, groupID := range e.securityGroups.list(sg.Name) {
+		if err := e.RepairSecurityGroup(groupID, sg.Rules); err != nil {
+			e.forgetSecurityGroup(groupID, err)
+			errs = append(errs, fmt.Sprintf("vpc %s: %v", key.vpcID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("repair security groups failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// forgetSecurityGroup drops groupID from the cache when err reports it not
+// found, e.g. when it was deleted by hand, so that it is created again.
+func (e *EriClient) forgetSecurityGroup(groupID string, err error) {
+	var sdkErr *tea.SDKError
+	if groupID == "" || !errors.As(err, &sdkErr) || tea.StringValue(sdkErr.Code) != errCodeSecurityGroupNotFound {
+		return
+	}
+	sgLog.Info("managed security group not found, forget it", "securityGroup", groupID)
+	e.securityGroups.forget(groupID)
+}
+
+// joinSecurityGroup adds the ENI to groupID, keeping its other groups.
+func (e *EriClient) joinSecurityGroup(eni *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, groupID string) error {
+	var groups []*string
+	if eni.SecurityGroupIds != nil {
+		groups = eni.SecurityGroupIds.SecurityGroupId
+	}
+	if lo.Contains(tea.StringSliceValue(groups), groupID) {
+		return nil
+	}
+	_, err := e.client.ModifyNetworkInterfaceAttribute(&ecs.ModifyNetworkInterfaceAttributeRequest{
+		RegionId:           ptr.To(e.regionID),
+		NetworkInterfaceId: eni.NetworkInterfaceId,
+		SecurityGroupId:    append(append([]*string{}, groups...), ptr.To(groupID)),
+	})
+	return err
+}
+
+// securityGroupRule is a normalized rule of the managed security group, cidr
+// or groupID is the peer of the rule.
+type securityGroupRule struct {
+	direction, protocol, portRange, cidr, groupID, policy, priority, description string
+}
+
+// key identifies the rule regardless of its description and the case of its
+// values, which ECS changes.
+func (r securityGroupRule) key() string {
+	return strings.ToLower(strings.Join([]string{r.direction, r.protocol, r.portRange, r.cidr, r.groupID, r.policy, r.priority}, "|"))
+}
+
+// desiredSecurityGroupRules returns the rule allowing the traffic inside
+// groupID and rules with their defaults, by key.
+func desiredSecurityGroupRules(groupID string, rules []types.SecurityGroupRule) map[string]securityGroupRule {
+	desired := map[string]securityGroupRule{}
+	intra := securityGroupRule{
+		direction:   directionIngress,
+		protocol:    protocolAll,
+		portRange:   portRangeAll,
+		groupID:     groupID,
+		policy:      policyAccept,
+		priority:    strconv.Itoa(priorityHighest),
+		description: "traffic between the eris",
+	}
+	desired[intra.key()] = intra
+	for _, r := range rules {
+		rule := securityGroupRule{
+			direction:   lo.CoalesceOrEmpty(strings.ToLower(r.Direction), directionIngress),
+			protocol:    strings.ToUpper(r.Protocol),
+			portRange:   lo.CoalesceOrEmpty(r.PortRange, portRangeAll),
+			cidr:        r.CIDR,
+			policy:      lo.CoalesceOrEmpty(strings.ToLower(r.Policy), policyAccept),
+			priority:    strconv.Itoa(lo.CoalesceOrEmpty(r.Priority, priorityHighest)),
+			description: r.Description,
+		}
+		desired[rule.key()] = rule
+	}
+	return desired
+}
+
+func ruleFromPermission(permission *ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission) securityGroupRule {
+	rule := securityGroupRule{
+		direction: tea.StringValue(permission.Direction),
+		protocol:  tea.StringValue(permission.IpProtocol),
+		portRange: tea.StringValue(permission.PortRange),
+		policy:    tea.StringValue(permission.Policy),
+		priority:  tea.StringValue(permission.Priority),
+	}
+	if rule.direction == directionEgress {
+		rule.cidr = tea.StringValue(permission.DestCidrIp)
+		rule.groupID = tea.StringValue(permission.DestGroupId)
+	} else {
+		rule.cidr = tea.StringValue(permission.SourceCidrIp)
+		rule.groupID = tea.StringValue(permission.SourceGroupId)
+	}
+	return rule
+}
+
+// SecurityGroupRepairer periodically repairs the rules of the managed
+// security groups, e.g. after they are changed in the console. It only acts
+// when managedSecurityGroup is enabled in the config, and not in dry-run mode.
+type SecurityGroupRepairer struct {
+	eriClient *EriClient
+}
+
+func NewSecurityGroupRepairer(eriClient *EriClient) *SecurityGroupRepairer {
+	return &SecurityGroupRepairer{eriClient: eriClient}
+}
+
+// Start implements manager.Runnable, it repairs until ctx is done.
+func (r *SecurityGroupRepairer) Start(ctx context.Context) error {
+	for {
+		cfg := config.GetConfig()
+		sg := cfg.ManagedSecurityGroup
+		// the rules are not changed in dry-run mode
+		if !cfg.DryRun {
+			if err := r.eriClient.RepairSecurityGroups(); err != nil {
+				sgLog.Error(err, "repair security groups failed, will retry")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(max(time.Duration(sg.RepairIntervalSeconds)*time.Second, minSecurityGroupRepairInterval)):
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (r *SecurityGroupRepairer) NeedLeaderElection() bool {
+	return true
+}
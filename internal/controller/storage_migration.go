@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+)
+
+var migrationLog = ctrl.Log.WithName("storage-migration")
+
+const erdmaDeviceCRDName = "erdmadevices.network.alibabacloud.com"
+
+// StorageVersionMigrator rewrites all ERdmaDevices in the storage version
+// once the controller becomes leader, and then drops the old versions from
+// the CRD storedVersions, so that old versions can be removed from the CRD
+// later.
+type StorageVersionMigrator struct {
+	Client client.Client
+}
+
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions/status,verbs=update;patch
+
+// Start implements manager.Runnable, it retries until the migration succeeds.
+func (m *StorageVersionMigrator) Start(ctx context.Context) error {
+	err := wait.PollUntilContextCancel(ctx, 10*time.Second, true, func(ctx context.Context) (bool, error) {
+		if err := m.migrate(ctx); err != nil {
+			migrationLog.Error(err, "migrate erdma device storage version failed, will retry")
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (m *StorageVersionMigrator) NeedLeaderElection() bool {
+	return true
+}
+
+func (m *StorageVersionMigrator) migrate(ctx context.Context) error {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	err := m.Client.Get(ctx, client.ObjectKey{Name: erdmaDeviceCRDName}, crd)
+	if err != nil {
+		return fmt.Errorf("get crd %s failed: %v", erdmaDeviceCRDName, err)
+	}
+	storageVersion := networkv1beta2.GroupVersion.Version
+	if len(crd.Status.StoredVersions) == 1 && crd.Status.StoredVersions[0] == storageVersion {
+		return nil
+	}
+
+	devices := &networkv1beta2.ERdmaDeviceList{}
+	err = m.Client.List(ctx, devices)
+	if err != nil {
+		return fmt.Errorf("list erdma devices failed: %v", err)
+	}
+	for i := range devices.Items {
+		// a no-op update makes the apiserver write the object in the storage version
+		err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			device := &networkv1beta2.ERdmaDevice{}
+			err := m.Client.Get(ctx, client.ObjectKeyFromObject(&devices.Items[i]), device)
+			if err != nil {
+				return err
+			}
+			return m.Client.Update(ctx, device)
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("migrate erdma device %s failed: %v", devices.Items[i].Name, err)
+		}
+	}
+
+	patch := client.MergeFromWithOptions(crd.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	crd.Status.StoredVersions = []string{storageVersion}
+	err = m.Client.Status().Patch(ctx, crd, patch)
+	if err != nil {
+		return fmt.Errorf("update crd %s stored versions failed: %v", erdmaDeviceCRDName, err)
+	}
+	migrationLog.Info("migrated erdma devices to storage version", "version", storageVersion, "count", len(devices.Items))
+	return nil
+}
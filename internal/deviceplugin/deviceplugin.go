@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
@@ -26,19 +27,35 @@ import (
 )
 
 const (
-	dpSocketPath = "/var/lib/kubelet/device-plugins/%d-erdma.sock"
+	dpSocketPath = "/var/lib/kubelet/device-plugins/%d-%s.sock"
 	rdmaCMDevice = "/dev/infiniband/rdma_cm"
+	// healthPollInterval is the interval the health of the devices is
+	// checked besides on link updates, the rdma port state has no events.
+	healthPollInterval = 5 * time.Second
 )
 
 // ERDMADevicePlugin implements the Kubernetes device plugin API
 type ERDMADevicePlugin struct {
-	socket               string
+	socket string
+	// socketName is the name of the socket without the timestamp prefix,
+	// each resource has its own.
+	socketName           string
+	resourceName         string
 	server               *grpc.Server
 	stop                 chan struct{}
 	devices              map[string]*types.ERdmaDeviceInfo
 	allocAllDevices      bool
 	devicepluginPreStart bool
 	allocRdmaCM          bool
+	// exclusive are the ERIs of the exclusive resource, nil for the shared
+	// erdma resource.
+	exclusive *exclusiveDevices
+	// health returns why an erdma device is unhealthy, nil when it is
+	// healthy.
+	health func(info *types.ERdmaDeviceInfo) error
+	// linkUpdates notifies the updates of the links of the node until done
+	// is closed.
+	linkUpdates func(done <-chan struct{}) (<-chan struct{}, error)
 	sync.Locker
 }
 
@@ -55,7 +72,7 @@ func NewERDMADevicePlugin(devices []*types.ERdmaDeviceInfo, allocAllDevices, dev
 		}
 	}
 
-	pluginEndpoint := fmt.Sprintf(dpSocketPath, time.Now().Unix())
+	pluginEndpoint := fmt.Sprintf(dpSocketPath, time.Now().Unix(), "erdma")
 	if allocRdmaCM {
 		_, err := os.Stat(path.Join("/proc/1/root", rdmaCMDevice))
 		if err != nil {
@@ -64,11 +81,15 @@ func NewERDMADevicePlugin(devices []*types.ERdmaDeviceInfo, allocAllDevices, dev
 	}
 	return &ERDMADevicePlugin{
 		socket:               pluginEndpoint,
+		socketName:           "erdma",
+		resourceName:         types.ResourceName,
 		devices:              devMap,
 		Locker:               &sync.Mutex{},
 		allocAllDevices:      allocAllDevices,
 		devicepluginPreStart: devicepluginPreStart,
 		allocRdmaCM:          allocRdmaCM,
+		health:               drivers.DeviceHealth,
+		linkUpdates:          drivers.SubscribeLinkUpdates,
 		stop:                 make(chan struct{}, 1),
 	}, nil
 }
@@ -129,7 +150,10 @@ func (m *ERDMADevicePlugin) PreStartContainer(ctx context.Context, req *pluginap
 	if len(req.DevicesIDs) == 0 {
 		return &pluginapi.PreStartContainerResponse{}, nil
 	}
-	pod, found, err := getDevPod(req.DevicesIDs[0])
+	if m.exclusive != nil {
+		return &pluginapi.PreStartContainerResponse{}, m.exclusive.preStart(req.DevicesIDs)
+	}
+	pod, found, err := getDevPod(m.resourceName, req.DevicesIDs[0])
 	if err != nil {
 		return nil, err
 	}
@@ -220,38 +244,85 @@ func (m *ERDMADevicePlugin) Register(request pluginapi.RegisterRequest) error {
 
 // ListAndWatch lists devices and update that list according to the health status
 func (m *ERDMADevicePlugin) ListAndWatch(e *pluginapi.Empty, s pluginapi.DevicePlugin_ListAndWatchServer) error {
-	var devs []*pluginapi.Device
-
-	for _, d := range m.devices {
-		for i := 0; i < 200; i++ {
-			devs = append(devs, &pluginapi.Device{ID: fmt.Sprintf("%s/%d", d.Name, i), Health: pluginapi.Healthy,
-				Topology: &pluginapi.TopologyInfo{
-					Nodes: []*pluginapi.NUMANode{
-						{
-							ID: d.NUMA,
-						},
-					},
-				}})
-		}
+	devs, unhealthy := m.listDevices()
+	if len(unhealthy) > 0 {
+		klog.Warningf("unhealthy erdma devices: %v", unhealthy)
 	}
 	err := s.Send(&pluginapi.ListAndWatchResponse{Devices: devs})
 	if err != nil {
 		return err
 	}
-	ticker := time.NewTicker(time.Second * 5)
+	done := make(chan struct{})
+	defer close(done)
+	// the health is still polled without link updates
+	linkUpdates, err := m.linkUpdates(done)
+	if err != nil {
+		klog.Errorf("error watch link updates: %v", err)
+	}
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			err := s.Send(&pluginapi.ListAndWatchResponse{Devices: devs})
-			if err != nil {
-				klog.Errorf("error send device informance: error: %v", err)
-			}
+		case <-linkUpdates:
 		case <-m.stop:
 			return nil
 		}
+		next, nextUnhealthy := m.listDevices()
+		if sameDevices(devs, next) {
+			continue
+		}
+		klog.Infof("erdma devices changed, unhealthy devices: %v", nextUnhealthy)
+		err := s.Send(&pluginapi.ListAndWatchResponse{Devices: next})
+		if err != nil {
+			klog.Errorf("error send device informance: error: %v", err)
+			continue
+		}
+		devs = next
 	}
 }
 
+// listDevices returns the devices of the plugin with their health, and why
+// each unhealthy erdma device or exclusive ERI is unhealthy. The 200 devices
+// of an erdma device are all unhealthy when it is.
+func (m *ERDMADevicePlugin) listDevices() ([]*pluginapi.Device, map[string]string) {
+	var devs []*pluginapi.Device
+	unhealthy := map[string]string{}
+	if m.exclusive != nil {
+		devs = m.exclusive.devices(m.health, unhealthy)
+	}
+	for _, name := range sortedKeys(m.devices) {
+		d := m.devices[name]
+		health := pluginapi.Healthy
+		if err := m.health(d); err != nil {
+			health = pluginapi.Unhealthy
+			unhealthy[d.Name] = err.Error()
+		}
+		for i := 0; i < 200; i++ {
+			devs = append(devs, &pluginapi.Device{ID: fmt.Sprintf("%s/%d", d.Name, i), Health: health,
+				Topology: &pluginapi.TopologyInfo{
+					Nodes: []*pluginapi.NUMANode{
+						{
+							ID: d.NUMA,
+						},
+					},
+				}})
+		}
+	}
+	return devs, unhealthy
+}
+
+// sameDevices returns whether the devices a and b have the same IDs, health
+// and NUMA nodes in the same order.
+func sameDevices(a, b []*pluginapi.Device) bool {
+	return slices.EqualFunc(a, b, func(x, y *pluginapi.Device) bool {
+		return x.ID == y.ID && x.Health == y.Health && slices.EqualFunc(x.GetTopology().GetNodes(), y.GetTopology().GetNodes(),
+			func(u, v *pluginapi.NUMANode) bool {
+				return u.ID == v.ID
+			})
+	})
+}
+
 func (m *ERDMADevicePlugin) GetPreferredAllocation(context.Context, *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
 	return nil, fmt.Errorf("unsupported")
 }
@@ -263,6 +334,9 @@ func (m *ERDMADevicePlugin) Allocate(ctx context.Context, r *pluginapi.AllocateR
 	}
 
 	klog.Infof("Request Containers: %v", r.GetContainerRequests())
+	if m.exclusive != nil {
+		return m.exclusive.allocate(r)
+	}
 	occupied := map[string]interface{}{}
 	for _, req := range r.GetContainerRequests() {
 		devices := map[string][]string{}
@@ -337,7 +411,7 @@ func (m *ERDMADevicePlugin) cleanup() error {
 
 	for _, preSock := range preSocks {
 		klog.Infof("device plugin file info: %+v", preSock)
-		if regexp.MustCompile(".*-erdma.sock").Match([]byte(preSock.Name())) {
+		if regexp.MustCompile(`^\d+-` + regexp.QuoteMeta(m.socketName) + `\.sock$`).MatchString(preSock.Name()) {
 			err = syscall.Unlink(path.Join(pluginapi.DevicePluginPath, preSock.Name()))
 			if err != nil {
 				klog.Errorf("error on clean up previous device plugin listens, %+v", err)
@@ -347,7 +421,7 @@ func (m *ERDMADevicePlugin) cleanup() error {
 	return nil
 }
 
-func (m *ERDMADevicePlugin) watchKubeletRestart() {
+func (m *ERDMADevicePlugin) watchKubeletRestart(stopCh <-chan struct{}) {
 	wait.Until(func() {
 		_, err := os.Stat(m.socket)
 		if err == nil {
@@ -367,7 +441,7 @@ func (m *ERDMADevicePlugin) watchKubeletRestart() {
 				pluginapi.RegisterRequest{
 					Version:      pluginapi.Version,
 					Endpoint:     path.Base(m.socket),
-					ResourceName: types.ResourceName,
+					ResourceName: m.resourceName,
 					Options: &pluginapi.DevicePluginOptions{
 						PreStartRequired: m.devicepluginPreStart,
 					},
@@ -379,11 +453,13 @@ func (m *ERDMADevicePlugin) watchKubeletRestart() {
 			return
 		}
 		klog.Fatalf("error stat socket: %+v", err)
-	}, time.Second*10, make(chan struct{}, 1))
+	}, time.Second*10, stopCh)
 }
 
-// Serve starts the gRPC server and register the device plugin to Kubelet
-func (m *ERDMADevicePlugin) Serve() {
+// Serve starts the gRPC server and register the device plugin to Kubelet.
+// A failed registration is returned after the server is stopped, the kubelet
+// restart watcher started by Watch retries it.
+func (m *ERDMADevicePlugin) Serve() error {
 	err := m.Start()
 	if err != nil {
 		klog.Fatalf("Could not start device plugin: %v", err)
@@ -394,7 +470,7 @@ func (m *ERDMADevicePlugin) Serve() {
 		pluginapi.RegisterRequest{
 			Version:      pluginapi.Version,
 			Endpoint:     path.Base(m.socket),
-			ResourceName: types.ResourceName,
+			ResourceName: m.resourceName,
 			Options: &pluginapi.DevicePluginOptions{
 				PreStartRequired: m.devicepluginPreStart,
 			},
@@ -406,7 +482,18 @@ func (m *ERDMADevicePlugin) Serve() {
 		if stopErr != nil {
 			klog.Fatalf("stop current device plugin server with error: %v", stopErr)
 		}
+		return err
 	}
 	klog.Infof("Registered device plugin with Kubelet")
-	m.watchKubeletRestart()
+	return nil
+}
+
+// Watch blocks and re-registers the device plugin whenever kubelet restarts,
+// until stopCh is closed. The exclusive device plugin also returns the ERIs
+// of the pods which ended to the node.
+func (m *ERDMADevicePlugin) Watch(stopCh <-chan struct{}) {
+	if m.exclusive != nil {
+		go wait.Until(m.exclusive.release, exclusiveReleaseInterval, stopCh)
+	}
+	m.watchKubeletRestart(stopCh)
 }
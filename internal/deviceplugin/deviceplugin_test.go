@@ -0,0 +1,99 @@
+package deviceplugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// fakeListAndWatchServer passes the responses of ListAndWatch to responses.
+type fakeListAndWatchServer struct {
+	grpc.ServerStream
+	responses chan *pluginapi.ListAndWatchResponse
+}
+
+func (s *fakeListAndWatchServer) Send(resp *pluginapi.ListAndWatchResponse) error {
+	s.responses <- resp
+	return nil
+}
+
+func (s *fakeListAndWatchServer) Context() context.Context {
+	return context.Background()
+}
+
+func TestListAndWatchHealth(t *testing.T) {
+	var lock sync.Mutex
+	unhealthy := map[string]error{}
+	linkUpdates := make(chan struct{})
+	m := &ERDMADevicePlugin{
+		devices: map[string]*types.ERdmaDeviceInfo{
+			"erdma_1": {Name: "erdma_1", NUMA: 1},
+			"erdma_0": {Name: "erdma_0"},
+		},
+		health: func(info *types.ERdmaDeviceInfo) error {
+			lock.Lock()
+			defer lock.Unlock()
+			return unhealthy[info.Name]
+		},
+		linkUpdates: func(<-chan struct{}) (<-chan struct{}, error) {
+			return linkUpdates, nil
+		},
+		stop: make(chan struct{}),
+	}
+	server := &fakeListAndWatchServer{responses: make(chan *pluginapi.ListAndWatchResponse, 1)}
+	done := make(chan error)
+	go func() {
+		done <- m.ListAndWatch(&pluginapi.Empty{}, server)
+	}()
+	health := func(resp *pluginapi.ListAndWatchResponse) map[string]string {
+		health := map[string]string{}
+		for _, dev := range resp.Devices {
+			name := dev.ID[:len("erdma_0")]
+			if previous, ok := health[name]; ok {
+				require.Equal(t, previous, dev.Health, "all devices of %s have the same health", name)
+			}
+			health[name] = dev.Health
+		}
+		return health
+	}
+	receive := func() *pluginapi.ListAndWatchResponse {
+		select {
+		case resp := <-server.responses:
+			return resp
+		case <-time.After(time.Second):
+			require.FailNow(t, "no devices sent")
+			return nil
+		}
+	}
+
+	resp := receive()
+	assert.Len(t, resp.Devices, 400)
+	assert.Equal(t, "erdma_0/0", resp.Devices[0].ID)
+	assert.Equal(t, map[string]string{"erdma_0": pluginapi.Healthy, "erdma_1": pluginapi.Healthy}, health(resp))
+
+	// the devices are only sent again when their health changed
+	linkUpdates <- struct{}{}
+	lock.Lock()
+	unhealthy["erdma_1"] = errors.New("netdev eth2 is down")
+	lock.Unlock()
+	linkUpdates <- struct{}{}
+	resp = receive()
+	assert.Equal(t, map[string]string{"erdma_0": pluginapi.Healthy, "erdma_1": pluginapi.Unhealthy}, health(resp))
+	linkUpdates <- struct{}{}
+	select {
+	case <-server.responses:
+		assert.Fail(t, "devices sent without a change")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(m.stop)
+	assert.NoError(t, <-done)
+}
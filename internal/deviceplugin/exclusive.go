@@ -0,0 +1,265 @@
+package deviceplugin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/consts"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/drivers"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+	"github.com/samber/lo"
+	"k8s.io/klog/v2"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// exclusiveReleaseInterval is the interval to check for the exclusive
+	// ERIs of the pods which ended.
+	exclusiveReleaseInterval = 10 * time.Second
+	// hostNetns is the network namespace getPodConfig returns for pods in the
+	// host network.
+	hostNetns = "/proc/1/ns/net"
+)
+
+// ExclusiveERI is an ERI allocated as a whole to a pod, its netdev and rdma
+// device are moved into the network namespace of the pod.
+type ExclusiveERI struct {
+	ERI *types.ERI
+	// Device is the erdma device of the ERI, nil when the agent started while
+	// the ERI was in the network namespace of a pod.
+	Device *types.ERdmaDeviceInfo
+}
+
+// ExclusiveNetns are the network namespaces the exclusive ERIs are moved
+// into. It outlives the device plugin, the ERIs are still returned after the
+// agent sets the devices up again.
+type ExclusiveNetns struct {
+	lock  sync.Mutex
+	netns map[string]string
+}
+
+// NewExclusiveNetns returns an empty ExclusiveNetns.
+func NewExclusiveNetns() *ExclusiveNetns {
+	return &ExclusiveNetns{netns: map[string]string{}}
+}
+
+func (n *ExclusiveNetns) get(eriID string) (string, bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	netns, ok := n.netns[eriID]
+	return netns, ok
+}
+
+func (n *ExclusiveNetns) set(eriID, netns string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.netns[eriID] = netns
+}
+
+func (n *ExclusiveNetns) delete(eriID string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.netns, eriID)
+}
+
+// exclusiveDevices are the ERIs of the exclusive resource, the device ID of
+// an ERI is its ID.
+type exclusiveDevices struct {
+	lock  sync.Mutex
+	eris  map[string]*ExclusiveERI
+	netns *ExclusiveNetns
+	// probe sets up an ERI returned to the node and returns its erdma device.
+	probe func(eri *types.ERI) (*types.ERdmaDeviceInfo, error)
+	// podDevices returns the exclusive ERIs allocated to each pod.
+	podDevices func() (map[string]bool, error)
+}
+
+// NewExclusiveERDMADevicePlugin returns a device plugin advertising each of
+// eris as a device of types.ExclusiveResourceName. The ERI is moved into the
+// network namespace of the pod it is allocated to before its containers
+// start, and returned to the node once the pod ended.
+func NewExclusiveERDMADevicePlugin(eris []*ExclusiveERI, netns *ExclusiveNetns, probe func(eri *types.ERI) (*types.ERdmaDeviceInfo, error)) (*ERDMADevicePlugin, error) {
+	// the pod network namespace is found from the container runtime
+	if err := initCriClient(runtimeEndpoints); err != nil {
+		return nil, err
+	}
+	return &ERDMADevicePlugin{
+		socket:               fmt.Sprintf(dpSocketPath, time.Now().Unix(), "erdma-exclusive"),
+		socketName:           "erdma-exclusive",
+		resourceName:         types.ExclusiveResourceName,
+		devices:              map[string]*types.ERdmaDeviceInfo{},
+		Locker:               &sync.Mutex{},
+		devicepluginPreStart: true,
+		health:               drivers.DeviceHealth,
+		linkUpdates:          drivers.SubscribeLinkUpdates,
+		exclusive: &exclusiveDevices{
+			eris: lo.SliceToMap(eris, func(item *ExclusiveERI) (string, *ExclusiveERI) {
+				return item.ERI.ID, item
+			}),
+			netns: netns,
+			probe: probe,
+			podDevices: func() (map[string]bool, error) {
+				podDevices, err := getResourcePodDevices(types.ExclusiveResourceName)
+				if err != nil {
+					return nil, err
+				}
+				return lo.SliceToMap(lo.Flatten(lo.Values(podDevices)), func(item string) (string, bool) {
+					return item, true
+				}), nil
+			},
+		},
+		stop: make(chan struct{}, 1),
+	}, nil
+}
+
+// devices returns a device per ERI, on the NUMA node of its erdma device. An
+// ERI on the node is unhealthy when its erdma device is, why is added to
+// unhealthy. The ERIs in the network namespace of a pod cannot be checked.
+func (d *exclusiveDevices) devices(health func(info *types.ERdmaDeviceInfo) error, unhealthy map[string]string) []*pluginapi.Device {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	devs := make([]*pluginapi.Device, 0, len(d.eris))
+	for _, id := range sortedKeys(d.eris) {
+		dev := &pluginapi.Device{ID: id, Health: pluginapi.Healthy}
+		if info := d.eris[id].Device; info != nil {
+			dev.Topology = &pluginapi.TopologyInfo{Nodes: []*pluginapi.NUMANode{{ID: info.NUMA}}}
+			if _, inPod := d.netns.get(id); !inPod {
+				if err := health(info); err != nil {
+					dev.Health = pluginapi.Unhealthy
+					unhealthy[id] = err.Error()
+				}
+			}
+		}
+		devs = append(devs, dev)
+	}
+	return devs
+}
+
+// allocate returns the device paths of the erdma devices of the requested
+// ERIs.
+func (d *exclusiveDevices) allocate(r *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	response := &pluginapi.AllocateResponse{}
+	for _, req := range r.GetContainerRequests() {
+		containerResponse := &pluginapi.ContainerAllocateResponse{}
+		for _, id := range req.DevicesIDs {
+			eri, ok := d.eris[id]
+			if !ok {
+				return nil, fmt.Errorf("unknown exclusive eri %s", id)
+			}
+			if eri.Device == nil {
+				return nil, fmt.Errorf("exclusive eri %s is not returned to the node yet", id)
+			}
+			for _, devPath := range eri.Device.DevPaths {
+				containerResponse.Devices = append(containerResponse.Devices, &pluginapi.DeviceSpec{
+					ContainerPath: devPath,
+					HostPath:      devPath,
+					Permissions:   "rw",
+				})
+			}
+			if containerResponse.Envs == nil {
+				containerResponse.Envs = map[string]string{
+					consts.SMCRPNETEnv: drivers.PNetIDFromDevice(eri.Device),
+				}
+			}
+		}
+		response.ContainerResponses = append(response.ContainerResponses, containerResponse)
+	}
+	return response, nil
+}
+
+// preStart moves the ERIs into the network namespace of the pod they are
+// allocated to.
+func (d *exclusiveDevices) preStart(ids []string) error {
+	pod, found, err := getDevPod(types.ExclusiveResourceName, ids[0])
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("can not find pod of exclusive eri %s", ids[0])
+	}
+	podConfig, err := getPodConfig(pod)
+	if err != nil {
+		return fmt.Errorf("can not get pod config %s, err, %v", pod, err)
+	}
+	if podConfig.Netns == hostNetns {
+		return fmt.Errorf("exclusive eris cannot be allocated to pod %s in the host network", pod)
+	}
+	for _, id := range ids {
+		d.lock.Lock()
+		eri, ok := d.eris[id]
+		d.lock.Unlock()
+		if !ok {
+			return fmt.Errorf("unknown exclusive eri %s", id)
+		}
+		if previous, ok := d.netns.get(id); ok && previous != podConfig.Netns {
+			// the previous pod ended but its network namespace is not gone yet
+			if err = drivers.ReturnERIFromNetns(eri.ERI, previous); err != nil {
+				return fmt.Errorf("return exclusive eri %s from %s failed: %v", id, previous, err)
+			}
+			d.netns.delete(id)
+		}
+		if err = drivers.MoveERIToNetns(eri.ERI, podConfig.Netns); err != nil {
+			return fmt.Errorf("move exclusive eri %s into pod %s failed: %v", id, pod, err)
+		}
+		d.netns.set(id, podConfig.Netns)
+		klog.Infof("exclusive eri %s moved into pod %s, netns %s", id, pod, podConfig.Netns)
+	}
+	return nil
+}
+
+// release returns the ERIs which are no longer allocated to a pod to the
+// node and sets them up again.
+func (d *exclusiveDevices) release() {
+	allocated, err := d.podDevices()
+	if err != nil {
+		klog.Errorf("get pods of exclusive eris failed: %v", err)
+		return
+	}
+	d.lock.Lock()
+	eris := lo.Values(d.eris)
+	d.lock.Unlock()
+	for _, eri := range eris {
+		if allocated[eri.ERI.ID] {
+			continue
+		}
+		returned := false
+		if netns, ok := d.netns.get(eri.ERI.ID); ok {
+			if err = drivers.ReturnERIFromNetns(eri.ERI, netns); err != nil {
+				klog.Errorf("return exclusive eri %s from %s failed: %v", eri.ERI.ID, netns, err)
+				continue
+			}
+			d.netns.delete(eri.ERI.ID)
+			returned = true
+		}
+		d.lock.Lock()
+		unknown := eri.Device == nil
+		d.lock.Unlock()
+		if !returned && !unknown {
+			continue
+		}
+		inHost, err := drivers.ERIInHostNetns(eri.ERI.MAC)
+		if err != nil || !inHost {
+			// the kernel returns it once the network namespace is gone
+			continue
+		}
+		device, err := d.probe(eri.ERI)
+		if err != nil {
+			klog.Errorf("set up exclusive eri %s returned to the node failed: %v", eri.ERI.ID, err)
+			continue
+		}
+		d.lock.Lock()
+		eri.Device = device
+		d.lock.Unlock()
+		klog.Infof("exclusive eri %s returned to the node", eri.ERI.ID)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := lo.Keys(m)
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,63 @@
+package deviceplugin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/consts"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+func TestExclusiveDevices(t *testing.T) {
+	d := &exclusiveDevices{
+		eris: map[string]*ExclusiveERI{
+			"eni-2": {
+				ERI: &types.ERI{ID: "eni-2", MAC: "00:16:3e:00:00:02"},
+				Device: &types.ERdmaDeviceInfo{Name: "erdma_1", MAC: "00:16:3e:00:00:02", NUMA: 1,
+					DevPaths: []string{"/dev/infiniband/uverbs1", "/dev/infiniband/rdma_cm"}},
+			},
+			// in the network namespace of a pod when the agent started
+			"eni-1": {ERI: &types.ERI{ID: "eni-1", MAC: "00:16:3e:00:00:01"}},
+		},
+		netns: NewExclusiveNetns(),
+	}
+
+	healthy := func(*types.ERdmaDeviceInfo) error { return nil }
+	assert.Equal(t, []*pluginapi.Device{
+		{ID: "eni-1", Health: pluginapi.Healthy},
+		{ID: "eni-2", Health: pluginapi.Healthy, Topology: &pluginapi.TopologyInfo{Nodes: []*pluginapi.NUMANode{{ID: 1}}}},
+	}, d.devices(healthy, map[string]string{}))
+
+	// the ERIs in the network namespace of a pod are not checked
+	portDown := func(*types.ERdmaDeviceInfo) error { return errors.New("port 1 of erdma_1 is DOWN") }
+	unhealthy := map[string]string{}
+	assert.Equal(t, pluginapi.Unhealthy, d.devices(portDown, unhealthy)[1].Health)
+	assert.Equal(t, map[string]string{"eni-2": "port 1 of erdma_1 is DOWN"}, unhealthy)
+	d.netns.set("eni-2", "/var/run/netns/cni-1")
+	assert.Equal(t, pluginapi.Healthy, d.devices(portDown, map[string]string{})[1].Health)
+	d.netns.delete("eni-2")
+
+	resp, err := d.allocate(&pluginapi.AllocateRequest{ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+		{DevicesIDs: []string{"eni-2"}},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, []*pluginapi.ContainerAllocateResponse{{
+		Devices: []*pluginapi.DeviceSpec{
+			{ContainerPath: "/dev/infiniband/uverbs1", HostPath: "/dev/infiniband/uverbs1", Permissions: "rw"},
+			{ContainerPath: "/dev/infiniband/rdma_cm", HostPath: "/dev/infiniband/rdma_cm", Permissions: "rw"},
+		},
+		Envs: map[string]string{consts.SMCRPNETEnv: "00163E000002"},
+	}}, resp.ContainerResponses)
+
+	_, err = d.allocate(&pluginapi.AllocateRequest{ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+		{DevicesIDs: []string{"eni-1"}},
+	}})
+	assert.Error(t, err)
+	_, err = d.allocate(&pluginapi.AllocateRequest{ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+		{DevicesIDs: []string{"eni-3"}},
+	}})
+	assert.Error(t, err)
+}
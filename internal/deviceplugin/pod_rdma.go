@@ -0,0 +1,216 @@
+package deviceplugin
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/drivers"
+	"github.com/samber/lo"
+	k8sType "k8s.io/apimachinery/pkg/types"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// procPath is the proc filesystem of the node, the agent runs in the host pid
+// namespace.
+var procPath = "/proc"
+
+var (
+	// cgroupContainerID matches the container id at the end of the cgroup path
+	// of a container for the cgroupfs and systemd cgroup drivers, e.g.
+	// /kubepods/burstable/pod<uid>/<id> or
+	// /kubepods.slice/.../cri-containerd-<id>.scope.
+	cgroupContainerID = regexp.MustCompile(`([0-9a-f]{64})(\.scope)?$`)
+	// cgroupPodUID matches the pod uid in the cgroup path of a container, the
+	// systemd cgroup driver replaces the dashes by underscores.
+	cgroupPodUID = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+)
+
+// PodRdmaResources is the number of rdma resources of the processes of a
+// container on an rdma device.
+type PodRdmaResources struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	PodUID    string `json:"podUID"`
+	Container string `json:"container"`
+	Device    string `json:"device"`
+	// AllocatedDevices are the erdma devices allocated to the pod by the
+	// device plugin.
+	AllocatedDevices []string `json:"allocatedDevices,omitempty"`
+	QueuePairs       int      `json:"queuePairs"`
+	CompletionQueues int      `json:"completionQueues"`
+	MemoryRegions    int      `json:"memoryRegions"`
+	// MemoryRegionBytes is the total length of the memory regions.
+	MemoryRegionBytes uint64 `json:"memoryRegionBytes"`
+	PIDs              []int  `json:"pids"`
+}
+
+// podResolver maps the processes owning rdma resources to their containers and
+// pods.
+type podResolver struct {
+	procPath       string
+	listContainers func() ([]*runtimeapi.Container, error)
+	podDevices     func() (map[k8sType.NamespacedName][]string, error)
+}
+
+// GetPodRdmaResources groups the rdma resources owned by processes of
+// containers by container and device. The container of a process is found from
+// its cgroup, the pod of the container from the container runtime and the
+// erdma devices of the pod from the kubelet pod resources. Resources of the
+// kernel and of processes outside of a container are skipped.
+func GetPodRdmaResources(resources []drivers.RdmaResource) ([]PodRdmaResources, error) {
+	if err := initCriClient(runtimeEndpoints); err != nil {
+		return nil, err
+	}
+	resolver := &podResolver{
+		procPath: procPath,
+		listContainers: func() ([]*runtimeapi.Container, error) {
+			return criClient.ListContainers(nil)
+		},
+		podDevices: getPodDevices,
+	}
+	return resolver.resolve(resources)
+}
+
+func (r *podResolver) resolve(resources []drivers.RdmaResource) ([]PodRdmaResources, error) {
+	type cgroupInfo struct {
+		podUID, containerID string
+	}
+	processes := map[int]*cgroupInfo{}
+	inContainer := false
+	for _, res := range resources {
+		if res.PID == 0 {
+			continue
+		}
+		if _, ok := processes[res.PID]; ok {
+			continue
+		}
+		podUID, containerID, err := processContainer(r.procPath, res.PID)
+		if err != nil || containerID == "" {
+			// the process exited after the resources were listed or runs on the host
+			processes[res.PID] = nil
+			continue
+		}
+		processes[res.PID] = &cgroupInfo{podUID: podUID, containerID: containerID}
+		inContainer = true
+	}
+	if !inContainer {
+		return nil, nil
+	}
+
+	containers, err := r.listContainers()
+	if err != nil {
+		return nil, fmt.Errorf("list containers failed: %w", err)
+	}
+	containerByID := map[string]*runtimeapi.Container{}
+	for _, c := range containers {
+		containerByID[c.Id] = c
+	}
+	podDevices, err := r.podDevices()
+	if err != nil {
+		return nil, fmt.Errorf("get pod resources failed: %w", err)
+	}
+
+	type key struct {
+		containerID, device string
+	}
+	usages := map[key]*PodRdmaResources{}
+	for _, res := range resources {
+		info := processes[res.PID]
+		if info == nil {
+			continue
+		}
+		container, ok := containerByID[info.containerID]
+		if !ok {
+			continue
+		}
+		k := key{containerID: info.containerID, device: res.Device}
+		usage, ok := usages[k]
+		if !ok {
+			pod := k8sType.NamespacedName{
+				Namespace: container.Labels["io.kubernetes.pod.namespace"],
+				Name:      container.Labels["io.kubernetes.pod.name"],
+			}
+			usage = &PodRdmaResources{
+				Namespace:        pod.Namespace,
+				Pod:              pod.Name,
+				PodUID:           container.Labels["io.kubernetes.pod.uid"],
+				Container:        container.Labels["io.kubernetes.container.name"],
+				Device:           res.Device,
+				AllocatedDevices: podDevices[pod],
+			}
+			if usage.PodUID == "" {
+				usage.PodUID = info.podUID
+			}
+			if usage.Container == "" && container.Metadata != nil {
+				usage.Container = container.Metadata.Name
+			}
+			usages[k] = usage
+		}
+		switch res.Kind {
+		case drivers.ResourceQP:
+			usage.QueuePairs++
+		case drivers.ResourceCQ:
+			usage.CompletionQueues++
+		case drivers.ResourceMR:
+			usage.MemoryRegions++
+			usage.MemoryRegionBytes += res.MRLen
+		}
+		if !lo.Contains(usage.PIDs, res.PID) {
+			usage.PIDs = append(usage.PIDs, res.PID)
+		}
+	}
+
+	result := make([]PodRdmaResources, 0, len(usages))
+	for _, usage := range usages {
+		sort.Ints(usage.PIDs)
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Pod != b.Pod {
+			return a.Pod < b.Pod
+		}
+		if a.Container != b.Container {
+			return a.Container < b.Container
+		}
+		return a.Device < b.Device
+	})
+	return result, nil
+}
+
+// processContainer returns the pod uid and the container id of a process from
+// its cgroup, both empty when the process is not in a container.
+func processContainer(proc string, pid int) (podUID, containerID string, err error) {
+	f, err := os.Open(filepath.Join(proc, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		match := cgroupContainerID.FindStringSubmatch(parts[2])
+		if match == nil {
+			continue
+		}
+		containerID = match[1]
+		if uid := cgroupPodUID.FindStringSubmatch(parts[2]); uid != nil {
+			podUID = strings.ReplaceAll(uid[1], "_", "-")
+		}
+		return podUID, containerID, nil
+	}
+	return "", "", scanner.Err()
+}
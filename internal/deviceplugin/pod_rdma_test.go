@@ -0,0 +1,80 @@
+package deviceplugin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8sType "k8s.io/apimachinery/pkg/types"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestPodRdmaResources(t *testing.T) {
+	proc := t.TempDir()
+	writeCgroup := func(pid, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(proc, pid), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(proc, pid, "cgroup"), []byte(content), 0o644))
+	}
+	cid1 := strings.Repeat("a", 64)
+	cid2 := strings.Repeat("b", 64)
+	// cgroup v2 with the systemd driver
+	writeCgroup("100", "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1b2c3d4e_0000_1111_2222_333344445555.slice/cri-containerd-"+cid1+".scope\n")
+	// cgroup v1 with the cgroupfs driver
+	writeCgroup("200", "12:memory:/kubepods/besteffort/pod9b2c3d4e-0000-1111-2222-333344445555/"+cid2+"\n11:cpu:/kubepods/besteffort/pod9b2c3d4e-0000-1111-2222-333344445555/"+cid2+"\n")
+	writeCgroup("300", "0::/system.slice/sshd.service\n")
+
+	resolver := &podResolver{
+		procPath: proc,
+		listContainers: func() ([]*runtimeapi.Container, error) {
+			return []*runtimeapi.Container{
+				{Id: cid1, Metadata: &runtimeapi.ContainerMetadata{Name: "trainer"}, Labels: map[string]string{
+					"io.kubernetes.pod.namespace": "default",
+					"io.kubernetes.pod.name":      "train-0",
+				}},
+				{Id: cid2, Labels: map[string]string{
+					"io.kubernetes.pod.namespace":  "default",
+					"io.kubernetes.pod.name":       "bench",
+					"io.kubernetes.pod.uid":        "9b2c3d4e-0000-1111-2222-333344445555",
+					"io.kubernetes.container.name": "ib",
+				}},
+			}, nil
+		},
+		podDevices: func() (map[k8sType.NamespacedName][]string, error) {
+			return map[k8sType.NamespacedName][]string{
+				{Namespace: "default", Name: "train-0"}: {"erdma_0"},
+			}, nil
+		},
+	}
+
+	usages, err := resolver.resolve([]drivers.RdmaResource{
+		{Kind: drivers.ResourceQP, Device: "erdma_0", PID: 100},
+		{Kind: drivers.ResourceQP, Device: "erdma_0", PID: 100},
+		{Kind: drivers.ResourceCQ, Device: "erdma_0", PID: 100},
+		{Kind: drivers.ResourceMR, Device: "erdma_0", PID: 100, MRLen: 4096},
+		{Kind: drivers.ResourceMR, Device: "erdma_0", PID: 100, MRLen: 8192},
+		{Kind: drivers.ResourceQP, Device: "erdma_1", PID: 200},
+		{Kind: drivers.ResourceQP, Device: "erdma_0", PID: 300},
+		{Kind: drivers.ResourceQP, Device: "erdma_0", PID: 400},
+		{Kind: drivers.ResourceQP, Device: "erdma_0", Comm: "[smc]"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []PodRdmaResources{
+		{
+			Namespace: "default", Pod: "bench", PodUID: "9b2c3d4e-0000-1111-2222-333344445555", Container: "ib",
+			Device: "erdma_1", QueuePairs: 1, PIDs: []int{200},
+		},
+		{
+			Namespace: "default", Pod: "train-0", PodUID: "1b2c3d4e-0000-1111-2222-333344445555", Container: "trainer",
+			Device: "erdma_0", AllocatedDevices: []string{"erdma_0"},
+			QueuePairs: 2, CompletionQueues: 1, MemoryRegions: 2, MemoryRegionBytes: 12288, PIDs: []int{100},
+		},
+	}, usages)
+
+	usages, err = resolver.resolve([]drivers.RdmaResource{{Kind: drivers.ResourceQP, Device: "erdma_0", PID: 300}})
+	require.NoError(t, err)
+	assert.Empty(t, usages)
+}
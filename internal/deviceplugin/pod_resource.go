@@ -17,6 +17,12 @@ const (
 )
 
 func getPodDevices() (map[k8sType.NamespacedName][]string, error) {
+	return getResourcePodDevices(types.ResourceName)
+}
+
+// getResourcePodDevices returns the devices of resourceName allocated to each
+// pod by the kubelet.
+func getResourcePodDevices(resourceName string) (map[k8sType.NamespacedName][]string, error) {
 	grpcConn, closeFunc, err := dial(defaultPodResourcesPath, defaultPodResourcesTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("error dialing resource socket: %v, %v", defaultPodResourcesPath, err)
@@ -36,7 +42,7 @@ func getPodDevices() (map[k8sType.NamespacedName][]string, error) {
 		var res []string
 		for _, c := range pr.Containers {
 			lo.ForEach(c.Devices, func(item *v1.ContainerDevices, _ int) {
-				if item.ResourceName == types.ResourceName {
+				if item.ResourceName == resourceName {
 					res = append(res, item.DeviceIds...)
 				}
 			})
@@ -49,8 +55,8 @@ func getPodDevices() (map[k8sType.NamespacedName][]string, error) {
 	return podDevices, nil
 }
 
-func getDevPod(devId string) (k8sType.NamespacedName, bool, error) {
-	podDevices, err := getPodDevices()
+func getDevPod(resourceName, devId string) (k8sType.NamespacedName, bool, error) {
+	podDevices, err := getResourcePodDevices(resourceName)
 	if err != nil {
 		return k8sType.NamespacedName{}, false, err
 	}
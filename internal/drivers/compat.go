@@ -37,9 +37,8 @@ func (d *CompatDriver) Install() error {
 		}
 	}
 	execMethod := nodeExec()
-	_, err := execMethod("if [ -f /sys/module/erdma/parameters/compat_mode ] && [ \"N\" == $(cat /sys/module/erdma/parameters/compat_mode) ]; then rmmod erdma && modprobe erdma compat_mode=Y; else modprobe erdma compat_mode=Y; fi")
-	if err != nil {
-		return fmt.Errorf("install erdma driver failed: %v", err)
+	if err := loadERdmaModule(execMethod, true); err != nil {
+		return err
 	}
 	loadNvidiaPeermem(execMethod)
 	return EnsureSMCR(execMethod)
@@ -57,7 +56,7 @@ func (d *CompatDriver) ProbeDevice(eri *types.ERI) (*types.ERdmaDeviceInfo, erro
 		if link.Attrs().HardwareAddr.String() == eri.MAC {
 			err := EnsureNetDevice(link, eri)
 			if err != nil {
-				return nil, fmt.Errorf("ensure net device failed: %v", err)
+				return nil, fmt.Errorf("%w: %v", ErrNetDevConfig, err)
 			}
 			rdmaLink, err := GetERdmaFromLink(link)
 			if err != nil {
@@ -77,6 +76,7 @@ func (d *CompatDriver) ProbeDevice(eri *types.ERI) (*types.ERdmaDeviceInfo, erro
 			return &types.ERdmaDeviceInfo{
 				Name:         rdmaLink.Attrs.Name,
 				MAC:          eri.MAC,
+				NetDev:       link.Attrs().Name,
 				DevPaths:     devPaths,
 				NUMA:         numa,
 				Capabilities: types.ERDMA_CAP_VERBS | types.ERDMA_CAP_OOB | types.ERDMA_CAP_SMC_R,
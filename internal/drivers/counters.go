@@ -0,0 +1,165 @@
+package drivers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Counter sources of an erdma device.
+const (
+	// CounterSourceHW are the driver counters in the hw_counters directory of
+	// each port.
+	CounterSourceHW = "hw_counters"
+	// CounterSourcePort are the standard counters in the counters directory of
+	// each port.
+	CounterSourcePort = "counters"
+	// CounterSourceNetlink are the statistics of the RDMA netlink interface,
+	// read with the rdma tool of iproute2.
+	CounterSourceNetlink = "netlink"
+	// CounterSourceEadm are the statistics of the eadm tool of the erdma
+	// driver.
+	CounterSourceEadm = "eadm"
+)
+
+// infinibandClassPath is the sysfs class directory of the rdma devices.
+var infinibandClassPath = "/sys/class/infiniband"
+
+// DeviceCounter is a counter of an erdma device.
+type DeviceCounter struct {
+	Source string
+	// Port is the port of the device, empty for counters of the device.
+	Port  string
+	Name  string
+	Value float64
+}
+
+// SysfsCounters returns the hw_counters and counters of each port of the rdma
+// device.
+func SysfsCounters(device string) ([]DeviceCounter, error) {
+	portsDir := filepath.Join(infinibandClassPath, device, "ports")
+	ports, err := os.ReadDir(portsDir)
+	if err != nil {
+		return nil, fmt.Errorf("read ports of %s failed: %w", device, err)
+	}
+	var counters []DeviceCounter
+	for _, port := range ports {
+		for _, source := range []string{CounterSourceHW, CounterSourcePort} {
+			values, err := readCounterDir(filepath.Join(portsDir, port.Name(), source))
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range sortedKeys(values) {
+				counters = append(counters, DeviceCounter{Source: source, Port: port.Name(), Name: name, Value: values[name]})
+			}
+		}
+	}
+	return counters, nil
+}
+
+// readCounterDir reads the counter files of dir, a missing dir has no
+// counters and files which are not a number are skipped.
+func readCounterDir(dir string) (map[string]float64, error) {
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read counters %s failed: %w", dir, err)
+	}
+	values := map[string]float64{}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			// some counters are not readable on every device
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+		if err != nil {
+			continue
+		}
+		values[file.Name()] = value
+	}
+	return values, nil
+}
+
+// NetlinkStatistics returns the RDMA netlink statistics of each port of the
+// rdma device.
+func NetlinkStatistics(device string) ([]DeviceCounter, error) {
+	output, err := exec.Command("rdma", "-j", "statistic", "show", "link", device).Output()
+	if err != nil {
+		return nil, fmt.Errorf("show rdma statistic of %s failed: %w", device, err)
+	}
+	return parseNetlinkStatistics(output)
+}
+
+// parseNetlinkStatistics parses the json output of `rdma statistic show`, a
+// list of the numeric statistics of each port.
+func parseNetlinkStatistics(output []byte) ([]DeviceCounter, error) {
+	var links []map[string]any
+	if err := json.Unmarshal(output, &links); err != nil {
+		return nil, fmt.Errorf("parse rdma statistic failed: %w", err)
+	}
+	var counters []DeviceCounter
+	for _, link := range links {
+		var port string
+		if p, ok := link["port"].(float64); ok {
+			port = strconv.Itoa(int(p))
+		}
+		for _, name := range sortedKeys(link) {
+			value, ok := link[name].(float64)
+			if !ok || name == "port" || name == "ifindex" {
+				continue
+			}
+			counters = append(counters, DeviceCounter{Source: CounterSourceNetlink, Port: port, Name: name, Value: value})
+		}
+	}
+	return counters, nil
+}
+
+// EadmStatistics returns the statistics of the rdma device from eadm, none
+// when eadm is not installed on the node.
+func EadmStatistics(device string) ([]DeviceCounter, error) {
+	output, err := nodeExec()(fmt.Sprintf("if command -v eadm >/dev/null 2>&1; then eadm stat -d %q -l; fi", device))
+	if err != nil {
+		return nil, fmt.Errorf("eadm stat of %s failed: %w", device, err)
+	}
+	return parseEadmStatistics(output), nil
+}
+
+// parseEadmStatistics parses the `name: value` or `name value` lines of the
+// eadm stat output, other lines are skipped.
+func parseEadmStatistics(output string) []DeviceCounter {
+	var counters []DeviceCounter
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(strings.Replace(scanner.Text(), ":", " ", 1))
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		counters = append(counters, DeviceCounter{Source: CounterSourceEadm, Name: fields[0], Value: value})
+	}
+	return counters
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
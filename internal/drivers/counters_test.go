@@ -0,0 +1,63 @@
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSysfsCounters(t *testing.T) {
+	root := t.TempDir()
+	old := infinibandClassPath
+	infinibandClassPath = root
+	defer func() { infinibandClassPath = old }()
+
+	write := func(path, content string) {
+		path = filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	write("erdma_0/ports/1/hw_counters/retrans_cnt", "12\n")
+	write("erdma_0/ports/1/hw_counters/listen_create_cnt", "3\n")
+	write("erdma_0/ports/1/counters/port_xmit_data", "1024\n")
+	write("erdma_0/ports/1/counters/invalid", "N/A\n")
+
+	counters, err := SysfsCounters("erdma_0")
+	require.NoError(t, err)
+	assert.Equal(t, []DeviceCounter{
+		{Source: CounterSourceHW, Port: "1", Name: "listen_create_cnt", Value: 3},
+		{Source: CounterSourceHW, Port: "1", Name: "retrans_cnt", Value: 12},
+		{Source: CounterSourcePort, Port: "1", Name: "port_xmit_data", Value: 1024},
+	}, counters)
+
+	_, err = SysfsCounters("erdma_1")
+	assert.Error(t, err)
+}
+
+func TestParseNetlinkStatistics(t *testing.T) {
+	counters, err := parseNetlinkStatistics([]byte(`[{"ifindex":0,"ifname":"erdma_0","port":1,"tx_bytes":100,"rx_bytes":200}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []DeviceCounter{
+		{Source: CounterSourceNetlink, Port: "1", Name: "rx_bytes", Value: 200},
+		{Source: CounterSourceNetlink, Port: "1", Name: "tx_bytes", Value: 100},
+	}, counters)
+
+	_, err = parseNetlinkStatistics([]byte("rdma: unknown command"))
+	assert.Error(t, err)
+}
+
+func TestParseEadmStatistics(t *testing.T) {
+	output := `Device erdma_0 statistics:
+tx_reqs_cnt: 10
+rx_packets_cnt 20
+hw_version: v1.2
+`
+	assert.Equal(t, []DeviceCounter{
+		{Source: CounterSourceEadm, Name: "tx_reqs_cnt", Value: 10},
+		{Source: CounterSourceEadm, Name: "rx_packets_cnt", Value: 20},
+	}, parseEadmStatistics(output))
+	assert.Empty(t, parseEadmStatistics(""))
+}
@@ -37,9 +37,8 @@ func (d *DefaultDriver) Install() error {
 		}
 	}
 	execMethod := nodeExec()
-	_, err := execMethod("if [ -f /sys/module/erdma/parameters/compat_mode ] && [ \"Y\" == $(cat /sys/module/erdma/parameters/compat_mode) ]; then rmmod erdma &&  modprobe erdma compat_mode=N; else modprobe erdma compat_mode=N; fi")
-	if err != nil {
-		return fmt.Errorf("install erdma driver failed: %v", err)
+	if err := loadERdmaModule(execMethod, false); err != nil {
+		return err
 	}
 	return EnsureSMCR(execMethod)
 }
@@ -57,7 +56,7 @@ func (d *DefaultDriver) ProbeDevice(eri *types.ERI) (*types.ERdmaDeviceInfo, err
 		if link.Attrs().HardwareAddr.String() == eri.MAC {
 			err := EnsureNetDevice(link, eri)
 			if err != nil {
-				return nil, fmt.Errorf("ensure net device failed: %v", err)
+				return nil, fmt.Errorf("%w: %v", ErrNetDevConfig, err)
 			}
 			rdmaLink, err := GetERdmaFromLink(link)
 			if err != nil {
@@ -77,6 +76,7 @@ func (d *DefaultDriver) ProbeDevice(eri *types.ERI) (*types.ERdmaDeviceInfo, err
 			return &types.ERdmaDeviceInfo{
 				Name:         rdmaLink.Attrs.Name,
 				MAC:          eri.MAC,
+				NetDev:       link.Attrs().Name,
 				DevPaths:     devPaths,
 				NUMA:         numa,
 				Capabilities: types.ERDMA_CAP_VERBS | types.ERDMA_CAP_RDMA_CM | types.ERDMA_CAP_SMC_R,
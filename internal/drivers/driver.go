@@ -1,6 +1,7 @@
 package drivers
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
@@ -16,6 +17,10 @@ var (
 	driverLog = ctrl.Log.WithName("Driver")
 )
 
+// ErrNetDevConfig is wrapped by ProbeDevice when the ERI netdev could not be
+// configured, so callers can tell it apart from a missing rdma device.
+var ErrNetDevConfig = errors.New("ensure net device failed")
+
 type ERdmaDriver interface {
 	Install() error
 	ProbeDevice(eri *types.ERI) (*types.ERdmaDeviceInfo, error)
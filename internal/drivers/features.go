@@ -0,0 +1,34 @@
+package drivers
+
+import (
+	"os"
+	"strings"
+)
+
+var (
+	// moduleVersionPath is the version of the loaded erdma module.
+	moduleVersionPath = "/sys/module/erdma/version"
+	// tcp2smcPath is the sysctl redirecting TCP traffic to SMC-R, it is only
+	// there on kernels supporting it with the smc module loaded.
+	tcp2smcPath = "/proc/sys/net/smc/tcp2smc"
+)
+
+// ModuleVersion returns the version of the loaded erdma module, empty when it
+// is not loaded or has no version.
+func ModuleVersion() string {
+	version, err := os.ReadFile(moduleVersionPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			driverLog.Info("cannot read erdma module version", "error", err.Error())
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(version))
+}
+
+// TCP2SMCAvailable returns whether tcp2smc is available in the network
+// namespace of the agent, the one of the node.
+func TCP2SMCAvailable() bool {
+	_, err := os.Stat(tcp2smcPath)
+	return err == nil
+}
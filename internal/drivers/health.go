@@ -0,0 +1,64 @@
+package drivers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+)
+
+// Healthy states of port 1 of an erdma device in sysfs.
+const (
+	portStateActive = "ACTIVE"
+	portPhysStateUp = "LinkUp"
+)
+
+// DeviceHealth returns why the erdma device is unhealthy, nil when it is
+// healthy: see PortHealth and NetDeviceHealth.
+func DeviceHealth(info *types.ERdmaDeviceInfo) error {
+	if err := PortHealth(info.Name); err != nil {
+		return err
+	}
+	return NetDeviceHealth(info.MAC)
+}
+
+// PortHealth returns why port 1 of the rdma device is unhealthy, nil when its
+// state is ACTIVE and its physical state LinkUp. The rdma device is gone when
+// the erdma module is removed.
+func PortHealth(device string) error {
+	if _, err := os.Stat(filepath.Join(infinibandClassPath, device)); os.IsNotExist(err) {
+		return fmt.Errorf("rdma device %s not found", device)
+	}
+	portDir := filepath.Join(infinibandClassPath, device, "ports", "1")
+	state, err := readPortState(filepath.Join(portDir, "state"))
+	if err != nil {
+		return err
+	}
+	if state != portStateActive {
+		return fmt.Errorf("port 1 of %s is %s", device, state)
+	}
+	physState, err := readPortState(filepath.Join(portDir, "phys_state"))
+	if err != nil {
+		return err
+	}
+	if physState != portPhysStateUp {
+		return fmt.Errorf("physical port 1 of %s is %s", device, physState)
+	}
+	return nil
+}
+
+// readPortState returns the name of the state in a port state file, e.g.
+// ACTIVE of "4: ACTIVE".
+func readPortState(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read port state failed: %v", err)
+	}
+	state := strings.TrimSpace(string(content))
+	if _, name, ok := strings.Cut(state, ":"); ok {
+		state = strings.TrimSpace(name)
+	}
+	return state, nil
+}
@@ -0,0 +1,34 @@
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortHealth(t *testing.T) {
+	root := t.TempDir()
+	old := infinibandClassPath
+	infinibandClassPath = root
+	defer func() { infinibandClassPath = old }()
+
+	write := func(path, content string) {
+		path = filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	write("erdma_0/ports/1/state", "4: ACTIVE\n")
+	write("erdma_0/ports/1/phys_state", "5: LinkUp\n")
+	assert.NoError(t, PortHealth("erdma_0"))
+
+	write("erdma_0/ports/1/state", "1: DOWN\n")
+	assert.EqualError(t, PortHealth("erdma_0"), "port 1 of erdma_0 is DOWN")
+	write("erdma_0/ports/1/state", "4: ACTIVE\n")
+	write("erdma_0/ports/1/phys_state", "3: Disabled\n")
+	assert.EqualError(t, PortHealth("erdma_0"), "physical port 1 of erdma_0 is Disabled")
+
+	assert.EqualError(t, PortHealth("erdma_1"), "rdma device erdma_1 not found")
+}
@@ -0,0 +1,79 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrModuleInUse is returned when the erdma module has to be reloaded with
+// another compat_mode but is held by rdma users, the loaded module is kept.
+var ErrModuleInUse = errors.New("erdma module is in use")
+
+// compatModes is the compat_mode each driver loads the erdma module with.
+var compatModes = map[string]bool{
+	defaultDriver:    false,
+	"compat":         true,
+	defaultGPUDriver: true,
+}
+
+func compatModeParam(compat bool) string {
+	if compat {
+		return "Y"
+	}
+	return "N"
+}
+
+// loadedCompatMode returns the compat_mode of the loaded erdma module, empty
+// when the module is not loaded or has no compat_mode parameter.
+func loadedCompatMode(exec func(string) (string, error)) (string, error) {
+	out, err := exec("cat /sys/module/erdma/parameters/compat_mode 2>/dev/null || true")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// checkModuleReload returns whether loading the erdma module with compat needs
+// the loaded module to be removed first, and ErrModuleInUse when it does but
+// the module is in use.
+func checkModuleReload(exec func(string) (string, error), compat bool) (bool, error) {
+	loaded, err := loadedCompatMode(exec)
+	if err != nil {
+		return false, fmt.Errorf("read erdma compat_mode failed: %v", err)
+	}
+	if loaded == "" || loaded == compatModeParam(compat) {
+		return false, nil
+	}
+	out, err := exec("cat /sys/module/erdma/refcnt 2>/dev/null || echo 0")
+	if err != nil {
+		return true, fmt.Errorf("read erdma refcnt failed: %v", err)
+	}
+	refcnt, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return true, fmt.Errorf("invalid erdma refcnt %q: %v", out, err)
+	}
+	if refcnt > 0 {
+		return true, fmt.Errorf("%w: loaded with compat_mode=%s, %d users", ErrModuleInUse, loaded, refcnt)
+	}
+	return true, nil
+}
+
+// loadERdmaModule loads the erdma module with compat, a module loaded with the
+// other compat_mode is removed first when it is not in use.
+func loadERdmaModule(exec func(string) (string, error), compat bool) error {
+	reload, err := checkModuleReload(exec, compat)
+	if err != nil {
+		return err
+	}
+	if reload {
+		if _, err = exec("rmmod erdma"); err != nil {
+			return fmt.Errorf("remove erdma module failed: %v", err)
+		}
+	}
+	if _, err = exec("modprobe erdma compat_mode=" + compatModeParam(compat)); err != nil {
+		return fmt.Errorf("install erdma driver failed: %v", err)
+	}
+	return nil
+}
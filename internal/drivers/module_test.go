@@ -0,0 +1,71 @@
+package drivers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeModule records the commands run on an erdma module loaded with
+// compatMode and held by refcnt users.
+type fakeModule struct {
+	compatMode string
+	refcnt     string
+	cmds       []string
+}
+
+func (f *fakeModule) exec(cmd string) (string, error) {
+	switch {
+	case strings.Contains(cmd, "parameters/compat_mode"):
+		return f.compatMode + "\n", nil
+	case strings.Contains(cmd, "refcnt"):
+		return f.refcnt + "\n", nil
+	}
+	f.cmds = append(f.cmds, cmd)
+	return "", nil
+}
+
+func TestLoadERdmaModule(t *testing.T) {
+	tests := []struct {
+		name         string
+		module       fakeModule
+		compat       bool
+		expectedCmds []string
+		inUse        bool
+	}{
+		{
+			name:         "not loaded",
+			compat:       true,
+			expectedCmds: []string{"modprobe erdma compat_mode=Y"},
+		},
+		{
+			name:         "loaded with the same compat_mode",
+			module:       fakeModule{compatMode: "N", refcnt: "2"},
+			expectedCmds: []string{"modprobe erdma compat_mode=N"},
+		},
+		{
+			name:         "reload unused module",
+			module:       fakeModule{compatMode: "N", refcnt: "0"},
+			compat:       true,
+			expectedCmds: []string{"rmmod erdma", "modprobe erdma compat_mode=Y"},
+		},
+		{
+			name:   "module in use",
+			module: fakeModule{compatMode: "Y", refcnt: "3"},
+			inUse:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadERdmaModule(tt.module.exec, tt.compat)
+			if tt.inUse {
+				assert.ErrorIs(t, err, ErrModuleInUse)
+				assert.Empty(t, tt.module.cmds)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedCmds, tt.module.cmds)
+		})
+	}
+}
@@ -3,13 +3,18 @@
 package drivers
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/utils"
 	"github.com/samber/lo"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -17,6 +22,10 @@ const (
 	cidrURL    = "http://100.100.100.200/latest/meta-data/network/interfaces/macs/%s/vswitch-cidr-block"
 	gatewayURL = "http://100.100.100.200/latest/meta-data/network/interfaces/macs/%s/gateway"
 
+	ipv6sURL       = "http://100.100.100.200/latest/meta-data/network/interfaces/macs/%s/ipv6s"
+	ipv6CidrURL    = "http://100.100.100.200/latest/meta-data/network/interfaces/macs/%s/vswitch-ipv6-cidr-block"
+	ipv6GatewayURL = "http://100.100.100.200/latest/meta-data/network/interfaces/macs/%s/ipv6-gateway"
+
 	defaultMetric  = 200
 	metricAddition = 1
 )
@@ -30,6 +39,10 @@ type route struct {
 type netConf struct {
 	ipAddr *net.IPNet
 	routes []*route
+	// ipv6Addr and ipv6Routes are the IPv6 config of an ENI with an IPv6
+	// address, nil otherwise.
+	ipv6Addr   *net.IPNet
+	ipv6Routes []*route
 }
 
 func EnsureNetDevice(link netlink.Link, eri *types.ERI) error {
@@ -41,6 +54,12 @@ func EnsureNetDevice(link netlink.Link, eri *types.ERI) error {
 	if err != nil {
 		return err
 	}
+	return ensureNetDevice(link, eri, conf)
+}
+
+// ensureNetDevice configures conf on the netdev of a secondary ERI in the
+// network namespace of the calling thread.
+func ensureNetDevice(link netlink.Link, eri *types.ERI, conf *netConf) (err error) {
 	mtu := jumboMTU(eri)
 	if link.Attrs().OperState != netlink.OperUp || !hasNonLinkLocalAddr(link) {
 		driverLog.Info("link down, try to up it", "link", link.Attrs().Name)
@@ -59,11 +78,15 @@ func EnsureNetDevice(link netlink.Link, eri *types.ERI) error {
 				netlink.LinkSetDown(link) // nolint:errcheck
 			}
 		}()
-		err = ensureAddress(link, conf)
+		err = ensureAddress(link, conf.ipAddr)
+		if err != nil {
+			return err
+		}
+		err = ensureRoutes(link, conf.routes, netlink.FAMILY_V4)
 		if err != nil {
 			return err
 		}
-		err = ensureRoutes(link, conf.routes)
+		err = ensureIPv6(link, conf)
 		if err != nil {
 			return err
 		}
@@ -88,8 +111,21 @@ func EnsureNetDevice(link netlink.Link, eri *types.ERI) error {
 		if !addrConfig {
 			driverLog.Error(fmt.Errorf("ip address not found in netlink, check the terway ENI writelist config: https://help.aliyun.com/zh/ack/ack-managed-and-ack-dedicated/user-guide/configure-a-whitelist-for-an-eni"), "ip address config not expect", "ip", conf.ipAddr, "link", eri.MAC)
 		}
+		// an IPv6 address assigned after the link is up is added without
+		// touching the IPv4 config
+		return ensureIPv6(link, conf)
+	}
+}
+
+// ensureIPv6 configures the IPv6 address and routes of conf, if any.
+func ensureIPv6(link netlink.Link, conf *netConf) error {
+	if conf.ipv6Addr == nil {
 		return nil
 	}
+	if err := ensureAddress(link, conf.ipv6Addr); err != nil {
+		return fmt.Errorf("add ipv6 address failed: %v", err)
+	}
+	return ensureRoutes(link, conf.ipv6Routes, netlink.FAMILY_V6)
 }
 
 func ensureMTU(link netlink.Link, mtu int) error {
@@ -103,30 +139,53 @@ func ensureUpLink(link netlink.Link) error {
 	return netlink.LinkSetUp(link)
 }
 
-func ensureAddress(link netlink.Link, conf *netConf) error {
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+func ensureAddress(link netlink.Link, ipAddr *net.IPNet) error {
+	family := ipFamily(ipAddr.IP)
+	addrs, err := netlink.AddrList(link, family)
 	if err != nil {
 		return err
 	}
 	for _, addr := range addrs {
-		if addr.IP.String() == conf.ipAddr.IP.String() {
+		if addr.IP.String() == ipAddr.IP.String() {
 			return nil
 		}
 	}
 
-	err = netlink.AddrAdd(link, &netlink.Addr{IPNet: conf.ipAddr})
+	nlAddr := &netlink.Addr{IPNet: ipAddr}
+	if family == netlink.FAMILY_V6 {
+		// the address is unique in the vpc, skip the duplicate address
+		// detection so that it is usable at once
+		nlAddr.Flags = unix.IFA_F_NODAD
+	}
+	err = netlink.AddrAdd(link, nlAddr)
 	if err != nil {
 		return err
 	}
 	// remove auto create route, fixme when netlink support noprefixroute
-	autoCreateRouteCidr := *conf.ipAddr
+	autoCreateRouteCidr := *ipAddr
 	autoCreateRouteCidr.IP = autoCreateRouteCidr.IP.Mask(autoCreateRouteCidr.Mask)
 	netlink.RouteDel(&netlink.Route{Dst: &autoCreateRouteCidr, LinkIndex: link.Attrs().Index}) // nolint:errcheck
 	return nil
 }
 
-func ensureRoutes(link netlink.Link, routes []*route) error {
-	nlRoutes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+func ipFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+// isDefaultDst returns whether dst is the default route of its family.
+func isDefaultDst(dst *net.IPNet) bool {
+	if dst == nil {
+		return true
+	}
+	ones, _ := dst.Mask.Size()
+	return ones == 0
+}
+
+func ensureRoutes(link netlink.Link, routes []*route, family int) error {
+	nlRoutes, err := netlink.RouteList(link, family)
 	if err != nil {
 		return fmt.Errorf("list route failed: %v", err)
 	}
@@ -178,8 +237,7 @@ func ensureRoutes(link netlink.Link, routes []*route) error {
 		}
 		var found bool
 		for _, nr := range nlRoutes {
-			if (r.destination == nil && nr.Dst == nil) ||
-				(r.destination == nil && nr.Dst.String() == "0.0.0.0/0") ||
+			if (r.destination == nil && isDefaultDst(nr.Dst)) ||
 				(r.destination != nil && nr.Dst != nil && r.destination.String() == nr.Dst.String()) {
 				found = true
 				if r.metric != nr.Priority {
@@ -216,15 +274,14 @@ func ensureRoutes(link netlink.Link, routes []*route) error {
 }
 
 func genRoutesForAddr(gateway net.IP, cidr *net.IPNet) ([]*route, error) {
-	existRoutes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	existRoutes, err := netlink.RouteList(nil, ipFamily(gateway))
 	if err != nil {
 		return nil, err
 	}
 	selectMetric := func(r *route) int {
 		existMetric := 0
 		lo.ForEach(existRoutes, func(route netlink.Route, index int) {
-			if (route.Dst == nil && r.destination == nil) ||
-				(r.destination == nil && route.Dst.String() == "0.0.0.0/0") ||
+			if (r.destination == nil && isDefaultDst(route.Dst)) ||
 				(route.Dst != nil && r.destination != nil && route.Dst.String() == r.destination.String()) {
 				if route.Priority > existMetric {
 					existMetric = route.Priority
@@ -303,5 +360,149 @@ func getNetConfFromMetadata(mac string) (*netConf, error) {
 		return nil, err
 	}
 	conf.routes = routes
+	conf.ipv6Addr, conf.ipv6Routes, err = getIPv6ConfFromMetadata(mac)
+	if err != nil {
+		return nil, err
+	}
 	return conf, nil
 }
+
+// getIPv6ConfFromMetadata returns the IPv6 address and routes of an ENI, nil
+// when it has no IPv6 address.
+func getIPv6ConfFromMetadata(mac string) (*net.IPNet, []*route, error) {
+	addrs, err := utils.GetStrFromMetadata(fmt.Sprintf(ipv6sURL, mac))
+	if err != nil {
+		var metadataErr *utils.Error
+		if errors.As(err, &metadataErr) && metadataErr.Code == strconv.Itoa(http.StatusNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	ip := parseIPv6s(addrs)
+	if ip == nil {
+		return nil, nil, nil
+	}
+	cidr, err := utils.GetStrFromMetadata(fmt.Sprintf(ipv6CidrURL, mac))
+	if err != nil {
+		return nil, nil, err
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ipv6 cidr: %s", cidr)
+	}
+	gw, err := utils.GetStrFromMetadata(fmt.Sprintf(ipv6GatewayURL, mac))
+	if err != nil {
+		return nil, nil, err
+	}
+	gateway := net.ParseIP(gw)
+	if gateway == nil {
+		return nil, nil, fmt.Errorf("invalid ipv6 gateway address: %s", gw)
+	}
+	routes, err := genRoutesForAddr(gateway, ipNet)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &net.IPNet{IP: ip, Mask: ipNet.Mask}, routes, nil
+}
+
+// parseIPv6s returns the first address of the ipv6s metadata of an ENI, a
+// list like [2408:4005:3ab:1a00::1,2408:4005:3ab:1a00::2], nil when it has
+// none.
+func parseIPv6s(addrs string) net.IP {
+	for _, addr := range strings.Split(strings.Trim(strings.TrimSpace(addrs), "[]"), ",") {
+		ip := net.ParseIP(strings.TrimSpace(addr))
+		if ip != nil && ip.To4() == nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+// GetLinkState returns the MTU and the first IPv4 address currently
+// configured on the netdev with the given MAC.
+func GetLinkState(mac string) (int, string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return 0, "", fmt.Errorf("list link failed: %v", err)
+	}
+	link, ok := lo.Find(links, func(link netlink.Link) bool {
+		return link.Attrs().HardwareAddr.String() == mac
+	})
+	if !ok {
+		return 0, "", fmt.Errorf("link with mac %s not found", mac)
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return 0, "", fmt.Errorf("list addr for %s failed: %v", link.Attrs().Name, err)
+	}
+	var ip string
+	if len(addrs) > 0 {
+		ip = addrs[0].IP.String()
+	}
+	return link.Attrs().MTU, ip, nil
+}
+
+// ResetNetDevice removes the addresses of the netdev with the given MAC and
+// sets it down, e.g. before its ERI is released. The routes through it are
+// removed with its addresses. It is a no-op when there is no such netdev, e.g.
+// when the ERI is already detached or in the network namespace of a pod.
+func ResetNetDevice(mac string) error {
+	link, err := linkByMAC(mac)
+	if err != nil || link == nil {
+		return err
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("list addr for %s failed: %v", link.Attrs().Name, err)
+	}
+	for _, addr := range addrs {
+		if addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if err = netlink.AddrDel(link, &addr); err != nil {
+			return fmt.Errorf("delete addr %s of %s failed: %v", addr.IPNet, link.Attrs().Name, err)
+		}
+	}
+	driverLog.Info("reset netdev", "link", link.Attrs().Name, "mac", mac)
+	return netlink.LinkSetDown(link)
+}
+
+// NetDeviceHealth returns why the netdev with the given MAC cannot carry
+// traffic, nil when it is up and its operational state is up or unknown.
+func NetDeviceHealth(mac string) error {
+	link, err := linkByMAC(mac)
+	if err != nil {
+		return err
+	}
+	if link == nil {
+		return fmt.Errorf("netdev of %s not found", mac)
+	}
+	attrs := link.Attrs()
+	if attrs.Flags&net.FlagUp == 0 {
+		return fmt.Errorf("netdev %s is down", attrs.Name)
+	}
+	if attrs.OperState != netlink.OperUp && attrs.OperState != netlink.OperUnknown {
+		return fmt.Errorf("netdev %s is %s", attrs.Name, attrs.OperState)
+	}
+	return nil
+}
+
+// SubscribeLinkUpdates notifies the updates of the links of the node until
+// done is closed, pending notifications are coalesced.
+func SubscribeLinkUpdates(done <-chan struct{}) (<-chan struct{}, error) {
+	updates := make(chan netlink.LinkUpdate)
+	if err := netlink.LinkSubscribe(updates, done); err != nil {
+		return nil, fmt.Errorf("subscribe link updates failed: %v", err)
+	}
+	notify := make(chan struct{}, 1)
+	go func() {
+		// updates is closed once done is closed or the subscription failed
+		for range updates {
+			select {
+			case notify <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return notify, nil
+}
@@ -0,0 +1,16 @@
+//go:build linux
+
+package drivers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIPv6s(t *testing.T) {
+	assert.Equal(t, "2408:4005:3ab:1a00::1", parseIPv6s("[2408:4005:3ab:1a00::1,2408:4005:3ab:1a00::2]").String())
+	assert.Equal(t, "2408:4005:3ab:1a00::1", parseIPv6s("2408:4005:3ab:1a00::1").String())
+	assert.Nil(t, parseIPv6s("[]"))
+	assert.Nil(t, parseIPv6s("[192.168.0.1]"))
+}
@@ -10,3 +10,19 @@ import (
 func EnsureNetDevice(link netlink.Link, eri *types.ERI) error {
 	return nil
 }
+
+func GetLinkState(mac string) (int, string, error) {
+	return 0, "", nil
+}
+
+func ResetNetDevice(mac string) error {
+	return nil
+}
+
+func NetDeviceHealth(mac string) error {
+	return nil
+}
+
+func SubscribeLinkUpdates(done <-chan struct{}) (<-chan struct{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,164 @@
+//go:build linux
+
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// hostNetnsPath is the network namespace of the node, the agent runs in the
+// host pid namespace.
+const hostNetnsPath = "/proc/1/ns/net"
+
+// rdmaNetnsExclusive is the rdma subsystem netns mode where an rdma device
+// only lives in one network namespace, in shared mode it is visible in all of
+// them and cannot be moved.
+const rdmaNetnsExclusive = "exclusive"
+
+// MoveERIToNetns moves the netdev of a secondary ERI and its rdma device into
+// the network namespace netnsPath on the node, and configures the address and
+// routes of the ERI there. An ERI already in the namespace is only configured.
+func MoveERIToNetns(eri *types.ERI, netnsPath string) error {
+	conf, err := getNetConfFromMetadata(eri.MAC)
+	if err != nil {
+		return err
+	}
+	target, err := netns.GetFromPath(path.Join("/proc/1/root", netnsPath))
+	if err != nil {
+		return fmt.Errorf("open netns %s failed: %v", netnsPath, err)
+	}
+	defer target.Close()
+	link, err := linkByMAC(eri.MAC)
+	if err != nil {
+		return err
+	}
+	if link != nil {
+		driverLog.Info("move eri into netns", "eri", eri.ID, "link", link.Attrs().Name, "netns", netnsPath)
+		if err = moveLink(link, target); err != nil {
+			return err
+		}
+	}
+	return inNetns(target, func() error {
+		link, err := linkByMAC(eri.MAC)
+		if err != nil {
+			return err
+		}
+		if link == nil {
+			return fmt.Errorf("netdev of eri %s not found in netns %s", eri.ID, netnsPath)
+		}
+		return ensureNetDevice(link, eri, conf)
+	})
+}
+
+// ReturnERIFromNetns moves the netdev of an ERI and its rdma device from the
+// network namespace netnsPath back to the node. The kernel returns them by
+// itself when the namespace is gone.
+func ReturnERIFromNetns(eri *types.ERI, netnsPath string) error {
+	source, err := netns.GetFromPath(path.Join("/proc/1/root", netnsPath))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("open netns %s failed: %v", netnsPath, err)
+	}
+	defer source.Close()
+	host, err := netns.GetFromPath(hostNetnsPath)
+	if err != nil {
+		return fmt.Errorf("open host netns failed: %v", err)
+	}
+	defer host.Close()
+	return inNetns(source, func() error {
+		link, err := linkByMAC(eri.MAC)
+		if err != nil || link == nil {
+			return err
+		}
+		driverLog.Info("return eri from netns", "eri", eri.ID, "link", link.Attrs().Name, "netns", netnsPath)
+		if err = netlink.LinkSetDown(link); err != nil {
+			return fmt.Errorf("set link %s down failed: %v", link.Attrs().Name, err)
+		}
+		return moveLink(link, host)
+	})
+}
+
+// ERIInHostNetns returns whether the netdev of an ERI is in the network
+// namespace of the node, it is not while the ERI is allocated to a pod.
+func ERIInHostNetns(mac string) (bool, error) {
+	link, err := linkByMAC(mac)
+	return link != nil, err
+}
+
+// moveLink moves link and its rdma device into the network namespace ns. In
+// shared rdma netns mode only the netdev is moved, the rdma device is visible
+// in ns anyway.
+func moveLink(link netlink.Link, ns netns.NsHandle) error {
+	rdmaLink, err := GetERdmaFromLink(link)
+	if err != nil {
+		return err
+	}
+	mode, err := netlink.RdmaSystemGetNetnsMode()
+	if err != nil {
+		return fmt.Errorf("get rdma netns mode failed: %v", err)
+	}
+	if mode == rdmaNetnsExclusive {
+		if err = netlink.RdmaLinkSetNsFd(rdmaLink, uint32(ns)); err != nil {
+			return fmt.Errorf("move rdma device %s failed: %v", rdmaLink.Attrs.Name, err)
+		}
+	} else {
+		driverLog.Info("rdma netns mode is not exclusive, only move the netdev", "mode", mode, "rdmaDevice", rdmaLink.Attrs.Name)
+	}
+	if err = netlink.LinkSetNsFd(link, int(ns)); err != nil {
+		return fmt.Errorf("move link %s failed: %v", link.Attrs().Name, err)
+	}
+	return nil
+}
+
+// linkByMAC returns the netdev with the given MAC in the network namespace of
+// the calling thread, nil if there is none.
+func linkByMAC(mac string) (netlink.Link, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("list link failed: %v", err)
+	}
+	for _, link := range links {
+		if _, ok := link.(*netlink.Device); !ok {
+			continue
+		}
+		if link.Attrs().HardwareAddr.String() == mac {
+			return link, nil
+		}
+	}
+	return nil, nil
+}
+
+// inNetns runs fn with the calling goroutine locked to a thread in the network
+// namespace ns. The thread is left locked and exits with the goroutine when
+// it cannot be switched back.
+func inNetns(ns netns.NsHandle, fn func() error) error {
+	runtime.LockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("get current netns failed: %v", err)
+	}
+	defer origin.Close()
+	if err = netns.Set(ns); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("enter netns failed: %v", err)
+	}
+	defer func() {
+		if err := netns.Set(origin); err != nil {
+			driverLog.Error(err, "failed to switch back to the origin netns")
+			return
+		}
+		runtime.UnlockOSThread()
+	}()
+	return fn()
+}
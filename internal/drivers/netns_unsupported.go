@@ -0,0 +1,17 @@
+//go:build !linux
+
+package drivers
+
+import "github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/types"
+
+func MoveERIToNetns(eri *types.ERI, netnsPath string) error {
+	return nil
+}
+
+func ReturnERIFromNetns(eri *types.ERI, netnsPath string) error {
+	return nil
+}
+
+func ERIInHostNetns(mac string) (bool, error) {
+	return true, nil
+}
@@ -30,12 +30,11 @@ func (d *OFEDDriver) Install() error {
 			return err
 		}
 	}
-	_, err := execMethod("if [ -f /sys/module/erdma/parameters/compat_mode ] && [ \"N\" == $(cat /sys/module/erdma/parameters/compat_mode) ]; then rmmod erdma && modprobe erdma compat_mode=Y; else modprobe erdma compat_mode=Y; fi")
-	if err != nil {
-		return fmt.Errorf("install erdma driver failed: %v", err)
+	if err := loadERdmaModule(execMethod, true); err != nil {
+		return err
 	}
 
-	_, err = execMethod("modprobe erdma")
+	_, err := execMethod("modprobe erdma")
 	if err != nil {
 		return fmt.Errorf("install erdma driver failed: %v", err)
 	}
@@ -55,7 +54,7 @@ func (d *OFEDDriver) ProbeDevice(eri *types.ERI) (*types.ERdmaDeviceInfo, error)
 		if link.Attrs().HardwareAddr.String() == eri.MAC {
 			err := EnsureNetDevice(link, eri)
 			if err != nil {
-				return nil, fmt.Errorf("ensure net device failed: %v", err)
+				return nil, fmt.Errorf("%w: %v", ErrNetDevConfig, err)
 			}
 			rdmaLink, err := GetERdmaFromLink(link)
 			if err != nil {
@@ -75,6 +74,7 @@ func (d *OFEDDriver) ProbeDevice(eri *types.ERI) (*types.ERdmaDeviceInfo, error)
 			return &types.ERdmaDeviceInfo{
 				Name:         rdmaLink.Attrs.Name,
 				MAC:          eri.MAC,
+				NetDev:       link.Attrs().Name,
 				DevPaths:     devPaths,
 				NUMA:         numa,
 				Capabilities: types.ERDMA_CAP_VERBS | types.ERDMA_CAP_OOB,
@@ -0,0 +1,70 @@
+package drivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+// Kinds of the rdma resources tracked by the RDMA netlink interface.
+const (
+	ResourceQP = "qp"
+	ResourceCQ = "cq"
+	ResourceMR = "mr"
+)
+
+// RdmaResource is a queue pair, completion queue or memory region of an rdma
+// device.
+type RdmaResource struct {
+	Kind   string
+	Device string
+	// PID is the process owning the resource, 0 for resources of the kernel.
+	PID  int
+	Comm string
+	// MRLen is the length of a memory region in bytes.
+	MRLen uint64
+}
+
+// RdmaResources returns the queue pairs, completion queues and memory regions
+// of all rdma devices of the node.
+func RdmaResources() ([]RdmaResource, error) {
+	var resources []RdmaResource
+	for _, kind := range []string{ResourceQP, ResourceCQ, ResourceMR} {
+		output, err := exec.Command("rdma", "-j", "resource", "show", kind).Output()
+		if err != nil {
+			return nil, fmt.Errorf("show rdma resource %s failed: %w", kind, err)
+		}
+		res, err := parseRdmaResources(kind, output)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, res...)
+	}
+	return resources, nil
+}
+
+// parseRdmaResources parses the json output of `rdma resource show <kind>`, a
+// list of the resources with the device in ifname and the owner in pid and
+// comm.
+func parseRdmaResources(kind string, output []byte) ([]RdmaResource, error) {
+	var entries []struct {
+		IfName string `json:"ifname"`
+		PID    int    `json:"pid"`
+		Comm   string `json:"comm"`
+		MRLen  uint64 `json:"mrlen"`
+	}
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return nil, fmt.Errorf("parse rdma resource %s failed: %w", kind, err)
+	}
+	resources := make([]RdmaResource, 0, len(entries))
+	for _, entry := range entries {
+		resources = append(resources, RdmaResource{
+			Kind:   kind,
+			Device: entry.IfName,
+			PID:    entry.PID,
+			Comm:   entry.Comm,
+			MRLen:  entry.MRLen,
+		})
+	}
+	return resources, nil
+}
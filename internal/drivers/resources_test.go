@@ -0,0 +1,20 @@
+package drivers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRdmaResources(t *testing.T) {
+	resources, err := parseRdmaResources(ResourceMR, []byte(`[{"ifindex":0,"ifname":"erdma_0","mrn":4,"mrlen":4096,"pdn":3,"pid":1234,"comm":"ib_write_bw"},{"ifindex":0,"ifname":"erdma_0","mrn":1,"mrlen":0,"pdn":0,"comm":"[smc]"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []RdmaResource{
+		{Kind: ResourceMR, Device: "erdma_0", PID: 1234, Comm: "ib_write_bw", MRLen: 4096},
+		{Kind: ResourceMR, Device: "erdma_0", Comm: "[smc]"},
+	}, resources)
+
+	_, err = parseRdmaResources(ResourceQP, []byte("rdma: unknown command"))
+	assert.Error(t, err)
+}
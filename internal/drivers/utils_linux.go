@@ -330,3 +330,15 @@ func SelectERIs(exposedLocalERIs []string) ([]*types.ERI, error) {
 
 	return selectEriList, nil
 }
+
+// CheckDriverSwitch returns ErrModuleInUse when the driver named name loads the
+// erdma module with another compat_mode than the loaded one, and the loaded
+// module is in use so it cannot be reloaded.
+func CheckDriverSwitch(name string) error {
+	compat, ok := compatModes[name]
+	if !ok {
+		return nil
+	}
+	_, err := checkModuleReload(nodeExec(), compat)
+	return err
+}
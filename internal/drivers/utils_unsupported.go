@@ -13,3 +13,11 @@ func hostExec(cmd string) (string, error) {
 	driverLog.Error(nil, "host exec is not supported on this platform")
 	return "", nil
 }
+
+func CheckDriverSwitch(name string) error {
+	return nil
+}
+
+func nodeExec() func(string) (string, error) {
+	return hostExec
+}
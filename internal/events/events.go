@@ -0,0 +1,133 @@
+// Package events records the Kubernetes Events of the controller and the
+// agent on Nodes and ERdmaDevices.
+package events
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+)
+
+// Reason is the reason of an Event. The reasons are stable, alerting matches
+// them.
+type Reason string
+
+const (
+	// ERIAttachFailed is recorded when an ERI cannot be attached to or
+	// converted on its instance.
+	ERIAttachFailed Reason = "ERIAttachFailed"
+	// PrimaryENIConverted is recorded when the primary ENI of an instance is
+	// converted to an ERI.
+	PrimaryENIConverted Reason = "PrimaryENIConverted"
+	// ERITagsFailed is recorded when the terway-compat tags cannot be added
+	// to ERIs.
+	ERITagsFailed Reason = "ERITagsFailed"
+	// DriverInstallFailed is recorded when the agent cannot install the erdma
+	// driver.
+	DriverInstallFailed Reason = "DriverInstallFailed"
+	// ProbeFailed is recorded when the agent cannot probe or set up the erdma
+	// device of an ERI.
+	ProbeFailed Reason = "ProbeFailed"
+	// SecurityGroupJoinFailed is recorded when an ERI cannot be joined to the
+	// managed security group.
+	SecurityGroupJoinFailed Reason = "SecurityGroupJoinFailed"
+	// IPv6AssignFailed is recorded when an IPv6 address cannot be assigned to
+	// an ERI.
+	IPv6AssignFailed Reason = "IPv6AssignFailed"
+)
+
+// eventTypes are the Event types of the reasons.
+var eventTypes = map[Reason]string{
+	ERIAttachFailed:         corev1.EventTypeWarning,
+	PrimaryENIConverted:     corev1.EventTypeNormal,
+	ERITagsFailed:           corev1.EventTypeWarning,
+	DriverInstallFailed:     corev1.EventTypeWarning,
+	ProbeFailed:             corev1.EventTypeWarning,
+	SecurityGroupJoinFailed: corev1.EventTypeWarning,
+	IPv6AssignFailed:        corev1.EventTypeWarning,
+}
+
+const (
+	// dedupWindow is how long an Event is not recorded again on an object.
+	dedupWindow = 10 * time.Minute
+	// dedupCacheSize is the number of recorded Events remembered for
+	// deduplication.
+	dedupCacheSize = 4096
+)
+
+// Recorder records Events with the type of their reason. The same Event is
+// recorded on an object once per dedupWindow, so that reconciles retrying a
+// failure do not flood the Events. A nil Recorder records nothing.
+type Recorder struct {
+	recorder record.EventRecorder
+	recorded *cache.LRUExpireCache
+}
+
+// NewRecorder returns a Recorder recording the Events with recorder.
+func NewRecorder(recorder record.EventRecorder) *Recorder {
+	return newRecorder(recorder, clock.RealClock{})
+}
+
+func newRecorder(recorder record.EventRecorder, clock cache.Clock) *Recorder {
+	return &Recorder{
+		recorder: recorder,
+		recorded: cache.NewLRUExpireCacheWithClock(dedupCacheSize, clock),
+	}
+}
+
+// Eventf records an Event of reason on obj. Events which only differ in their
+// error arguments are the same Event, e.g. ECS errors carry a request ID.
+func (r *Recorder) Eventf(obj runtime.Object, reason Reason, messageFmt string, args ...any) {
+	if r == nil || obj == nil {
+		return
+	}
+	if node, ok := obj.(*corev1.Node); ok {
+		obj = NodeReference(node.Name)
+	}
+	key := dedupKey(obj, reason, messageFmt, args)
+	if _, ok := r.recorded.Get(key); ok {
+		return
+	}
+	r.recorded.Add(key, struct{}{}, dedupWindow)
+	eventType, ok := eventTypes[reason]
+	if !ok {
+		eventType = corev1.EventTypeNormal
+	}
+	r.recorder.Eventf(obj, eventType, string(reason), messageFmt, args...)
+}
+
+// NodeReference returns the reference of the Events on a Node. Like the
+// kubelet, the node name is used as UID, `kubectl describe node` looks up the
+// Events of a Node by it.
+func NodeReference(name string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Node",
+		Name:       name,
+		UID:        k8stypes.UID(name),
+	}
+}
+
+func dedupKey(obj runtime.Object, reason Reason, messageFmt string, args []any) string {
+	var object string
+	if ref, ok := obj.(*corev1.ObjectReference); ok {
+		object = fmt.Sprintf("%s/%s/%s/%s", ref.Kind, ref.Namespace, ref.Name, ref.UID)
+	} else if accessor, err := meta.Accessor(obj); err == nil {
+		object = fmt.Sprintf("%T/%s/%s/%s", obj, accessor.GetNamespace(), accessor.GetName(), accessor.GetUID())
+	}
+	key := fmt.Sprintf("%s/%s/%s", object, reason, messageFmt)
+	for _, arg := range args {
+		if _, ok := arg.(error); ok {
+			continue
+		}
+		key += fmt.Sprintf("/%v", arg)
+	}
+	return key
+}
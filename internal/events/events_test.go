@@ -0,0 +1,64 @@
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+)
+
+func drain(recorder *record.FakeRecorder) []string {
+	var ret []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			ret = append(ret, e)
+		default:
+			return ret
+		}
+	}
+}
+
+func TestRecorderDeduplicates(t *testing.T) {
+	fake := record.NewFakeRecorder(10)
+	clock := clocktesting.NewFakeClock(time.Now())
+	r := newRecorder(fake, clock)
+	device := &networkv1beta2.ERdmaDevice{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid1"}}
+
+	r.Eventf(device, ERIAttachFailed, "attach eri %s failed: %v", "eni-1", errors.New("RequestId: 1"))
+	r.Eventf(device, ERIAttachFailed, "attach eri %s failed: %v", "eni-1", errors.New("RequestId: 2"))
+	r.Eventf(device, ERIAttachFailed, "attach eri %s failed: %v", "eni-2", errors.New("RequestId: 3"))
+	r.Eventf(device, PrimaryENIConverted, "converted primary eni %s", "eni-0")
+	assert.Equal(t, []string{
+		"Warning ERIAttachFailed attach eri eni-1 failed: RequestId: 1",
+		"Warning ERIAttachFailed attach eri eni-2 failed: RequestId: 3",
+		"Normal PrimaryENIConverted converted primary eni eni-0",
+	}, drain(fake))
+
+	// the event is recorded again after the dedup window
+	clock.Step(dedupWindow + time.Second)
+	r.Eventf(device, ERIAttachFailed, "attach eri %s failed: %v", "eni-1", errors.New("RequestId: 4"))
+	assert.Equal(t, []string{"Warning ERIAttachFailed attach eri eni-1 failed: RequestId: 4"}, drain(fake))
+}
+
+func TestRecorderNode(t *testing.T) {
+	fake := record.NewFakeRecorder(10)
+	fake.IncludeObject = true
+	r := newRecorder(fake, clocktesting.NewFakeClock(time.Now()))
+
+	r.Eventf(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid1"}}, DriverInstallFailed, "install driver failed")
+	r.Eventf(NodeReference("node1"), DriverInstallFailed, "install driver failed")
+	assert.Equal(t, []string{
+		"Warning DriverInstallFailed install driver failed involvedObject{kind=Node,apiVersion=v1}",
+	}, drain(fake), "the events of a node are recorded on its name")
+
+	var nilRecorder *Recorder
+	nilRecorder.Eventf(NodeReference("node1"), ProbeFailed, "probe failed")
+}
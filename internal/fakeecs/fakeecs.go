@@ -0,0 +1,952 @@
+// Package fakeecs is an in-memory ECS backend for running the controller
+// without an Alibaba Cloud account, e.g. on kind or envtest. It keeps the
+// instances, instance types and ENIs in memory, attaches and detaches ENIs
+// asynchronously, and fails calls with injected errors.
+package fakeecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/samber/lo"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/yaml"
+)
+
+var fakeLog = ctrl.Log.WithName("fake-ecs")
+
+// ENI status and type values of ECS.
+const (
+	StatusAvailable = "Available"
+	StatusInUse     = "InUse"
+	StatusAttaching = "Attaching"
+	StatusDetaching = "Detaching"
+
+	TypePrimary   = "Primary"
+	TypeSecondary = "Secondary"
+
+	trafficModeStandard = "Standard"
+
+	defaultVpcID = "vpc-fake"
+)
+
+// InstanceType is an ECS instance type.
+type InstanceType struct {
+	ID                  string `json:"id"`
+	EriQuantity         int32  `json:"eriQuantity"`
+	NetworkCardQuantity int32  `json:"networkCardQuantity"`
+	QueuePairNumber     int32  `json:"queuePairNumber"`
+	GPUAmount           int32  `json:"gpuAmount,omitempty"`
+}
+
+// Instance is an ECS instance, its primary ENI is created with it.
+type Instance struct {
+	ID              string `json:"id"`
+	InstanceType    string `json:"instanceType"`
+	PrivateIP       string `json:"privateIP"`
+	ZoneID          string `json:"zoneID,omitempty"`
+	VpcID           string `json:"vpcID,omitempty"`
+	VSwitchID       string `json:"vSwitchID,omitempty"`
+	SecurityGroupID string `json:"securityGroupID,omitempty"`
+	JumboFrame      bool   `json:"jumboFrame,omitempty"`
+}
+
+// VSwitch is a vSwitch, each ENI created in it takes one of its available IP
+// addresses. ENIs in vSwitches not in the state take no IP address.
+type VSwitch struct {
+	ID           string `json:"id"`
+	ZoneID       string `json:"zoneID"`
+	VpcID        string `json:"vpcID,omitempty"`
+	AvailableIPs int64  `json:"availableIPs"`
+}
+
+// Fault fails the calls of Action, all actions when empty, with the ECS error
+// Code. It fails Times calls, or all calls when Times is 0.
+type Fault struct {
+	Action     string `json:"action,omitempty"`
+	Code       string `json:"code"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Times      int    `json:"times,omitempty"`
+}
+
+// State is the initial state of the backend.
+type State struct {
+	InstanceTypes []InstanceType `json:"instanceTypes"`
+	Instances     []Instance     `json:"instances"`
+	VSwitches     []VSwitch      `json:"vSwitches,omitempty"`
+	// DefaultInstanceType creates an instance of this type for a private IP
+	// which matches no instance, so that any node gets an instance, e.g. the
+	// nodes of a kind cluster.
+	DefaultInstanceType string `json:"defaultInstanceType,omitempty"`
+	// AttachDelaySeconds is how long an ENI stays Attaching or Detaching.
+	AttachDelaySeconds int     `json:"attachDelaySeconds,omitempty"`
+	Faults             []Fault `json:"faults,omitempty"`
+}
+
+// LoadState reads a State from a YAML or JSON file.
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read fake ecs state %s failed: %v", path, err)
+	}
+	state := &State{}
+	if err = yaml.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parse fake ecs state %s failed: %v", path, err)
+	}
+	return state, nil
+}
+
+type eni struct {
+	id               string
+	mac              string
+	eniType          string
+	status           string
+	instanceID       string
+	networkCardIndex int32
+	trafficMode      string
+	queuePair        int32
+	vSwitchID        string
+	vpcID            string
+	ip               string
+	ipv6             []string
+	securityGroupIDs []string
+	tags             map[string]string
+	// settleAt is when an Attaching or Detaching ENI settles.
+	settleAt time.Time
+}
+
+// securityGroup is a security group created by CreateSecurityGroup, the
+// groups of the instances are not kept.
+type securityGroup struct {
+	id    string
+	name  string
+	vpcID string
+	tags  map[string]string
+	rules []*ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission
+}
+
+// Backend is the in-memory ECS backend, it implements controller.ECS.
+type Backend struct {
+	mu                  sync.Mutex
+	instanceTypes       map[string]InstanceType
+	instances           map[string]*Instance
+	enis                map[string]*eni
+	vSwitches           map[string]*VSwitch
+	securityGroups      map[string]*securityGroup
+	deletedGroups       map[string]bool
+	defaultInstanceType string
+	attachDelay         time.Duration
+	faults              []*Fault
+	seq                 int
+	// Now is the clock of the backend, it can be replaced in tests.
+	Now func() time.Time
+}
+
+// New returns a backend with state.
+func New(state *State) *Backend {
+	b := &Backend{
+		instanceTypes:       map[string]InstanceType{},
+		instances:           map[string]*Instance{},
+		enis:                map[string]*eni{},
+		vSwitches:           map[string]*VSwitch{},
+		securityGroups:      map[string]*securityGroup{},
+		deletedGroups:       map[string]bool{},
+		defaultInstanceType: state.DefaultInstanceType,
+		attachDelay:         time.Duration(state.AttachDelaySeconds) * time.Second,
+		Now:                 time.Now,
+	}
+	for _, instanceType := range state.InstanceTypes {
+		b.instanceTypes[instanceType.ID] = instanceType
+	}
+	for _, vSwitch := range state.VSwitches {
+		if vSwitch.VpcID == "" {
+			vSwitch.VpcID = defaultVpcID
+		}
+		b.vSwitches[vSwitch.ID] = &vSwitch
+	}
+	for _, instance := range state.Instances {
+		b.AddInstance(instance)
+	}
+	for _, fault := range state.Faults {
+		b.InjectFault(fault)
+	}
+	return b
+}
+
+// AddInstance adds an instance with its primary ENI.
+func (b *Backend) AddInstance(instance Instance) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addInstance(instance)
+}
+
+func (b *Backend) addInstance(instance Instance) *Instance {
+	if instance.ID == "" {
+		instance.ID = b.newID("i-fake")
+	}
+	if instance.VSwitchID == "" {
+		instance.VSwitchID = "vsw-fake"
+	}
+	if instance.VpcID == "" {
+		instance.VpcID = defaultVpcID
+	}
+	if instance.SecurityGroupID == "" {
+		instance.SecurityGroupID = "sg-fake"
+	}
+	b.instances[instance.ID] = &instance
+	primary := b.newENI(instance.VSwitchID, []string{instance.SecurityGroupID})
+	primary.eniType = TypePrimary
+	primary.status = StatusInUse
+	primary.instanceID = instance.ID
+	fakeLog.Info("add instance", "instance", instance.ID, "instanceType", instance.InstanceType, "primaryENI", primary.id)
+	return &instance
+}
+
+// ResizeInstance changes the instance type of an instance, its ENIs are kept.
+func (b *Backend) ResizeInstance(instanceID, instanceType string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instance, ok := b.instances[instanceID]
+	if !ok {
+		return notFound("InvalidInstanceId.NotFound", instanceID)
+	}
+	if _, ok = b.instanceTypes[instanceType]; !ok {
+		return notFound("InvalidInstanceType.NotFound", instanceType)
+	}
+	fakeLog.Info("resize instance", "instance", instanceID, "from", instance.InstanceType, "to", instanceType)
+	instance.InstanceType = instanceType
+	return nil
+}
+
+// DeleteSecurityGroup deletes a security group created by CreateSecurityGroup,
+// e.g. by hand in the console. ENIs cannot join it anymore.
+func (b *Backend) DeleteSecurityGroup(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.securityGroups[id]; !ok {
+		return notFound("InvalidSecurityGroupId.NotFound", id)
+	}
+	fakeLog.Info("delete security group", "securityGroup", id)
+	delete(b.securityGroups, id)
+	b.deletedGroups[id] = true
+	return nil
+}
+
+// InjectFault adds a fault, faults are matched in the order they are added.
+func (b *Backend) InjectFault(fault Fault) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.faults = append(b.faults, &fault)
+}
+
+// ClearFaults removes all faults.
+func (b *Backend) ClearFaults() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.faults = nil
+}
+
+func (b *Backend) newID(prefix string) string {
+	b.seq++
+	return fmt.Sprintf("%s%06d", prefix, b.seq)
+}
+
+func (b *Backend) newENI(vSwitchID string, securityGroupIDs []string) *eni {
+	e := &eni{
+		id:               b.newID("eni-fake"),
+		eniType:          TypeSecondary,
+		status:           StatusAvailable,
+		trafficMode:      trafficModeStandard,
+		vSwitchID:        vSwitchID,
+		securityGroupIDs: securityGroupIDs,
+		vpcID:            b.vpcOf(vSwitchID),
+		tags:             map[string]string{},
+	}
+	e.mac = fmt.Sprintf("00:16:3e:%02x:%02x:%02x", byte(b.seq>>16), byte(b.seq>>8), byte(b.seq))
+	e.ip = fmt.Sprintf("10.%d.%d.%d", byte(b.seq>>16), byte(b.seq>>8), byte(b.seq))
+	if vSwitch, ok := b.vSwitches[vSwitchID]; ok {
+		vSwitch.AvailableIPs--
+	}
+	b.enis[e.id] = e
+	return e
+}
+
+// vpcOf returns the VPC of a vSwitch in the state, or of the instances in the
+// vSwitch.
+func (b *Backend) vpcOf(vSwitchID string) string {
+	if vSwitch, ok := b.vSwitches[vSwitchID]; ok {
+		return vSwitch.VpcID
+	}
+	for _, instance := range b.instances {
+		if instance.VSwitchID == vSwitchID {
+			return instance.VpcID
+		}
+	}
+	return defaultVpcID
+}
+
+// begin takes the lock, settles the ENIs in transition and fails the call
+// with the first matching fault. The caller must unlock when err is nil.
+func (b *Backend) begin(action string) error {
+	b.mu.Lock()
+	now := b.Now()
+	for _, e := range b.enis {
+		if e.settleAt.IsZero() || now.Before(e.settleAt) {
+			continue
+		}
+		switch e.status {
+		case StatusAttaching:
+			e.status = StatusInUse
+		case StatusDetaching:
+			e.status = StatusAvailable
+			e.instanceID = ""
+			e.networkCardIndex = 0
+		}
+		e.settleAt = time.Time{}
+	}
+	for i, fault := range b.faults {
+		if fault.Action != "" && fault.Action != action {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				b.faults = append(b.faults[:i], b.faults[i+1:]...)
+			}
+		}
+		b.mu.Unlock()
+		statusCode := fault.StatusCode
+		if statusCode == 0 {
+			statusCode = http.StatusBadRequest
+		}
+		return sdkError(statusCode, fault.Code, "injected fault of "+action)
+	}
+	return nil
+}
+
+func sdkError(statusCode int, code, message string) error {
+	return &tea.SDKError{
+		StatusCode: tea.Int(statusCode),
+		Code:       tea.String(code),
+		Message:    tea.String(message),
+	}
+}
+
+func notFound(code, id string) error {
+	return sdkError(http.StatusNotFound, code, fmt.Sprintf("%s not found", id))
+}
+
+// parseIDs parses the JSON array of IDs used by the Describe calls.
+func parseIDs(ids *string) ([]string, error) {
+	if ids == nil || *ids == "" {
+		return nil, nil
+	}
+	var ret []string
+	if err := json.Unmarshal([]byte(*ids), &ret); err != nil {
+		return nil, sdkError(http.StatusBadRequest, "InvalidParameter", fmt.Sprintf("invalid id list %s", *ids))
+	}
+	return ret, nil
+}
+
+func (b *Backend) DescribeInstances(req *ecs.DescribeInstancesRequest) (*ecs.DescribeInstancesResponse, error) {
+	if err := b.begin("DescribeInstances"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	ids, err := parseIDs(req.InstanceIds)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := parseIDs(req.PrivateIpAddresses)
+	if err != nil {
+		return nil, err
+	}
+	var instances []*Instance
+	for _, instance := range b.instances {
+		if (ids == nil || lo.Contains(ids, instance.ID)) && (ips == nil || lo.Contains(ips, instance.PrivateIP)) {
+			instances = append(instances, instance)
+		}
+	}
+	if len(instances) == 0 && len(ips) == 1 && ids == nil && b.defaultInstanceType != "" {
+		instances = append(instances, b.addInstance(Instance{InstanceType: b.defaultInstanceType, PrivateIP: ips[0]}))
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	return &ecs.DescribeInstancesResponse{
+		StatusCode: ptr.To(int32(http.StatusOK)),
+		Body: &ecs.DescribeInstancesResponseBody{
+			TotalCount: ptr.To(int32(len(instances))),
+			Instances: &ecs.DescribeInstancesResponseBodyInstances{
+				Instance: lo.Map(instances, func(instance *Instance, _ int) *ecs.DescribeInstancesResponseBodyInstancesInstance {
+					return &ecs.DescribeInstancesResponseBodyInstancesInstance{
+						InstanceId:   ptr.To(instance.ID),
+						InstanceType: ptr.To(instance.InstanceType),
+						ZoneId:       ptr.To(instance.ZoneID),
+						SecurityGroupIds: &ecs.DescribeInstancesResponseBodyInstancesInstanceSecurityGroupIds{
+							SecurityGroupId: []*string{ptr.To(instance.SecurityGroupID)},
+						},
+						VpcAttributes: &ecs.DescribeInstancesResponseBodyInstancesInstanceVpcAttributes{
+							VpcId:     ptr.To(instance.VpcID),
+							VSwitchId: ptr.To(instance.VSwitchID),
+							PrivateIpAddress: &ecs.DescribeInstancesResponseBodyInstancesInstanceVpcAttributesPrivateIpAddress{
+								IpAddress: []*string{ptr.To(instance.PrivateIP)},
+							},
+						},
+					}
+				}),
+			},
+		},
+	}, nil
+}
+
+func (b *Backend) DescribeInstanceTypes(req *ecs.DescribeInstanceTypesRequest) (*ecs.DescribeInstanceTypesResponse, error) {
+	if err := b.begin("DescribeInstanceTypes"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	var instanceTypes []*ecs.DescribeInstanceTypesResponseBodyInstanceTypesInstanceType
+	for _, id := range req.InstanceTypes {
+		instanceType, ok := b.instanceTypes[tea.StringValue(id)]
+		if !ok {
+			continue
+		}
+		instanceTypes = append(instanceTypes, &ecs.DescribeInstanceTypesResponseBodyInstanceTypesInstanceType{
+			InstanceTypeId:      ptr.To(instanceType.ID),
+			EriQuantity:         ptr.To(instanceType.EriQuantity),
+			NetworkCardQuantity: ptr.To(instanceType.NetworkCardQuantity),
+			QueuePairNumber:     ptr.To(instanceType.QueuePairNumber),
+			GPUAmount:           ptr.To(instanceType.GPUAmount),
+		})
+	}
+	return &ecs.DescribeInstanceTypesResponse{
+		StatusCode: ptr.To(int32(http.StatusOK)),
+		Body: &ecs.DescribeInstanceTypesResponseBody{
+			InstanceTypes: &ecs.DescribeInstanceTypesResponseBodyInstanceTypes{InstanceType: instanceTypes},
+		},
+	}, nil
+}
+
+func (b *Backend) DescribeInstanceAttribute(req *ecs.DescribeInstanceAttributeRequest) (*ecs.DescribeInstanceAttributeResponse, error) {
+	if err := b.begin("DescribeInstanceAttribute"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	instance, ok := b.instances[tea.StringValue(req.InstanceId)]
+	if !ok {
+		return nil, notFound("InvalidInstanceId.NotFound", tea.StringValue(req.InstanceId))
+	}
+	return &ecs.DescribeInstanceAttributeResponse{
+		StatusCode: ptr.To(int32(http.StatusOK)),
+		Body: &ecs.DescribeInstanceAttributeResponseBody{
+			InstanceId:       ptr.To(instance.ID),
+			InstanceType:     ptr.To(instance.InstanceType),
+			EnableJumboFrame: ptr.To(instance.JumboFrame),
+		},
+	}, nil
+}
+
+// DescribeNetworkInterfaces returns the ENIs matching the IDs, instance,
+// status, type and tags of req, all in one page.
+func (b *Backend) DescribeNetworkInterfaces(req *ecs.DescribeNetworkInterfacesRequest) ([]*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet, error) {
+	if err := b.begin("DescribeNetworkInterfaces"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	ids := lo.Map(req.NetworkInterfaceId, func(id *string, _ int) string { return tea.StringValue(id) })
+	var enis []*ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet
+	for _, e := range b.enis {
+		switch {
+		case len(ids) > 0 && !lo.Contains(ids, e.id),
+			req.InstanceId != nil && *req.InstanceId != e.instanceID,
+			req.Status != nil && *req.Status != e.status,
+			req.Type != nil && *req.Type != e.eniType,
+			!lo.EveryBy(req.Tag, func(tag *ecs.DescribeNetworkInterfacesRequestTag) bool {
+				value, ok := e.tags[tea.StringValue(tag.Key)]
+				return ok && (tag.Value == nil || *tag.Value == value)
+			}):
+			continue
+		}
+		enis = append(enis, e.toECS())
+	}
+	sort.Slice(enis, func(i, j int) bool { return *enis[i].NetworkInterfaceId < *enis[j].NetworkInterfaceId })
+	return enis, nil
+}
+
+func (e *eni) toECS() *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet {
+	set := &ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSet{
+		NetworkInterfaceId:          ptr.To(e.id),
+		MacAddress:                  ptr.To(e.mac),
+		Type:                        ptr.To(e.eniType),
+		Status:                      ptr.To(e.status),
+		NetworkInterfaceTrafficMode: ptr.To(e.trafficMode),
+		QueuePairNumber:             ptr.To(e.queuePair),
+		VSwitchId:                   ptr.To(e.vSwitchID),
+		VpcId:                       ptr.To(e.vpcID),
+		PrivateIpAddress:            ptr.To(e.ip),
+		Ipv6Sets: &ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSetIpv6Sets{
+			Ipv6Set: lo.Map(e.ipv6, func(ip string, _ int) *ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSetIpv6SetsIpv6Set {
+				return &ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSetIpv6SetsIpv6Set{Ipv6Address: ptr.To(ip)}
+			}),
+		},
+		SecurityGroupIds: &ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSetSecurityGroupIds{
+			SecurityGroupId: lo.Map(e.securityGroupIDs, func(id string, _ int) *string { return ptr.To(id) }),
+		},
+		Tags: &ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSetTags{},
+	}
+	keys := lo.Keys(e.tags)
+	sort.Strings(keys)
+	for _, key := range keys {
+		set.Tags.Tag = append(set.Tags.Tag, &ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSetTagsTag{
+			TagKey:   ptr.To(key),
+			TagValue: ptr.To(e.tags[key]),
+		})
+	}
+	if e.instanceID != "" {
+		set.InstanceId = ptr.To(e.instanceID)
+		set.Attachment = &ecs.DescribeNetworkInterfacesResponseBodyNetworkInterfaceSetsNetworkInterfaceSetAttachment{
+			InstanceId:       ptr.To(e.instanceID),
+			NetworkCardIndex: ptr.To(e.networkCardIndex),
+		}
+	}
+	return set
+}
+
+func (b *Backend) CreateNetworkInterface(req *ecs.CreateNetworkInterfaceRequest) (*ecs.CreateNetworkInterfaceResponse, error) {
+	if err := b.begin("CreateNetworkInterface"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	if vSwitch, ok := b.vSwitches[tea.StringValue(req.VSwitchId)]; ok && vSwitch.AvailableIPs <= 0 {
+		return nil, sdkError(http.StatusForbidden, "InvalidVSwitchId.IpNotEnough", fmt.Sprintf("vswitch %s has no available ip", vSwitch.ID))
+	}
+	if err := b.checkSecurityGroups(req.SecurityGroupIds); err != nil {
+		return nil, err
+	}
+	e := b.newENI(tea.StringValue(req.VSwitchId), lo.Map(req.SecurityGroupIds, func(id *string, _ int) string { return tea.StringValue(id) }))
+	if req.NetworkInterfaceTrafficMode != nil {
+		e.trafficMode = *req.NetworkInterfaceTrafficMode
+	}
+	e.queuePair = tea.Int32Value(req.QueuePairNumber)
+	b.assignIPv6(e, int(tea.Int32Value(req.Ipv6AddressCount)))
+	for _, tag := range req.Tag {
+		e.tags[tea.StringValue(tag.Key)] = tea.StringValue(tag.Value)
+	}
+	fakeLog.Info("create eni", "eni", e.id, "trafficMode", e.trafficMode, "queuePair", e.queuePair)
+	return &ecs.CreateNetworkInterfaceResponse{
+		StatusCode: ptr.To(int32(http.StatusOK)),
+		Body: &ecs.CreateNetworkInterfaceResponseBody{
+			NetworkInterfaceId: ptr.To(e.id),
+			MacAddress:         ptr.To(e.mac),
+			Status:             ptr.To(e.status),
+		},
+	}, nil
+}
+
+// AttachNetworkInterface attaches an Available ENI, it stays Attaching for
+// the attach delay.
+func (b *Backend) AttachNetworkInterface(req *ecs.AttachNetworkInterfaceRequest) (*ecs.AttachNetworkInterfaceResponse, error) {
+	if err := b.begin("AttachNetworkInterface"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	e, ok := b.enis[tea.StringValue(req.NetworkInterfaceId)]
+	if !ok {
+		return nil, notFound("InvalidEniId.NotFound", tea.StringValue(req.NetworkInterfaceId))
+	}
+	if _, ok = b.instances[tea.StringValue(req.InstanceId)]; !ok {
+		return nil, notFound("InvalidInstanceId.NotFound", tea.StringValue(req.InstanceId))
+	}
+	if e.status != StatusAvailable {
+		return nil, sdkError(http.StatusForbidden, "InvalidOperation.InvalidEniState", fmt.Sprintf("eni %s is %s", e.id, e.status))
+	}
+	e.instanceID = *req.InstanceId
+	e.networkCardIndex = tea.Int32Value(req.NetworkCardIndex)
+	b.transit(e, StatusAttaching, StatusInUse)
+	return &ecs.AttachNetworkInterfaceResponse{StatusCode: ptr.To(int32(http.StatusOK))}, nil
+}
+
+// DetachNetworkInterface detaches a secondary InUse ENI, it stays Detaching
+// for the attach delay.
+func (b *Backend) DetachNetworkInterface(req *ecs.DetachNetworkInterfaceRequest) (*ecs.DetachNetworkInterfaceResponse, error) {
+	if err := b.begin("DetachNetworkInterface"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	e, ok := b.enis[tea.StringValue(req.NetworkInterfaceId)]
+	if !ok {
+		return nil, notFound("InvalidEniId.NotFound", tea.StringValue(req.NetworkInterfaceId))
+	}
+	if e.eniType == TypePrimary || e.status != StatusInUse {
+		return nil, sdkError(http.StatusForbidden, "InvalidOperation.InvalidEniState", fmt.Sprintf("%s eni %s is %s", e.eniType, e.id, e.status))
+	}
+	b.transit(e, StatusDetaching, StatusAvailable)
+	if e.status == StatusAvailable {
+		e.instanceID = ""
+		e.networkCardIndex = 0
+	}
+	return &ecs.DetachNetworkInterfaceResponse{StatusCode: ptr.To(int32(http.StatusOK))}, nil
+}
+
+// transit moves e to status, and to settled after the attach delay.
+func (b *Backend) transit(e *eni, status, settled string) {
+	if b.attachDelay == 0 {
+		e.status = settled
+		return
+	}
+	e.status = status
+	e.settleAt = b.Now().Add(b.attachDelay)
+}
+
+func (b *Backend) DeleteNetworkInterface(req *ecs.DeleteNetworkInterfaceRequest) (*ecs.DeleteNetworkInterfaceResponse, error) {
+	if err := b.begin("DeleteNetworkInterface"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	e, ok := b.enis[tea.StringValue(req.NetworkInterfaceId)]
+	if !ok {
+		return nil, notFound("InvalidEniId.NotFound", tea.StringValue(req.NetworkInterfaceId))
+	}
+	if e.status != StatusAvailable {
+		return nil, sdkError(http.StatusForbidden, "InvalidOperation.InvalidEniState", fmt.Sprintf("eni %s is %s", e.id, e.status))
+	}
+	delete(b.enis, e.id)
+	if vSwitch, ok := b.vSwitches[e.vSwitchID]; ok {
+		vSwitch.AvailableIPs++
+	}
+	fakeLog.Info("delete eni", "eni", e.id)
+	return &ecs.DeleteNetworkInterfaceResponse{StatusCode: ptr.To(int32(http.StatusOK))}, nil
+}
+
+func (b *Backend) ModifyNetworkInterfaceAttribute(req *ecs.ModifyNetworkInterfaceAttributeRequest) (*ecs.ModifyNetworkInterfaceAttributeResponse, error) {
+	if err := b.begin("ModifyNetworkInterfaceAttribute"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	e, ok := b.enis[tea.StringValue(req.NetworkInterfaceId)]
+	if !ok {
+		return nil, notFound("InvalidEniId.NotFound", tea.StringValue(req.NetworkInterfaceId))
+	}
+	if err := b.checkSecurityGroups(req.SecurityGroupId); err != nil {
+		return nil, err
+	}
+	if traffic := req.NetworkInterfaceTrafficConfig; traffic != nil {
+		// the queue pair number of a secondary eni is only changed detached
+		if traffic.QueuePairNumber != nil && *traffic.QueuePairNumber != e.queuePair &&
+			e.eniType == TypeSecondary && e.status != StatusAvailable {
+			return nil, sdkError(http.StatusForbidden, "InvalidOperation.InvalidEniState",
+				fmt.Sprintf("queue pair number of eni %s cannot be changed while it is %s", e.id, e.status))
+		}
+		if traffic.NetworkInterfaceTrafficMode != nil {
+			e.trafficMode = *traffic.NetworkInterfaceTrafficMode
+		}
+		if traffic.QueuePairNumber != nil {
+			e.queuePair = *traffic.QueuePairNumber
+		}
+	}
+	if len(req.SecurityGroupId) > 0 {
+		e.securityGroupIDs = lo.Map(req.SecurityGroupId, func(id *string, _ int) string { return tea.StringValue(id) })
+	}
+	return &ecs.ModifyNetworkInterfaceAttributeResponse{StatusCode: ptr.To(int32(http.StatusOK))}, nil
+}
+
+// assignIPv6 assigns count IPv6 addresses to e.
+func (b *Backend) assignIPv6(e *eni, count int) []string {
+	var assigned []string
+	for range count {
+		b.seq++
+		assigned = append(assigned, fmt.Sprintf("fd00::%x", b.seq))
+	}
+	e.ipv6 = append(e.ipv6, assigned...)
+	return assigned
+}
+
+func (b *Backend) AssignIpv6Addresses(req *ecs.AssignIpv6AddressesRequest) (*ecs.AssignIpv6AddressesResponse, error) {
+	if err := b.begin("AssignIpv6Addresses"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	e, ok := b.enis[tea.StringValue(req.NetworkInterfaceId)]
+	if !ok {
+		return nil, notFound("InvalidEniId.NotFound", tea.StringValue(req.NetworkInterfaceId))
+	}
+	assigned := b.assignIPv6(e, int(tea.Int32Value(req.Ipv6AddressCount)))
+	return &ecs.AssignIpv6AddressesResponse{
+		StatusCode: ptr.To(int32(http.StatusOK)),
+		Body: &ecs.AssignIpv6AddressesResponseBody{
+			NetworkInterfaceId: ptr.To(e.id),
+			Ipv6Sets: &ecs.AssignIpv6AddressesResponseBodyIpv6Sets{
+				Ipv6Address: lo.Map(assigned, func(ip string, _ int) *string { return ptr.To(ip) }),
+			},
+		},
+	}, nil
+}
+
+func (b *Backend) TagResources(req *ecs.TagResourcesRequest) (*ecs.TagResourcesResponse, error) {
+	if err := b.begin("TagResources"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	for _, id := range req.ResourceId {
+		e, ok := b.enis[tea.StringValue(id)]
+		if !ok {
+			return nil, notFound("InvalidResourceId.NotFound", tea.StringValue(id))
+		}
+		for _, tag := range req.Tag {
+			e.tags[tea.StringValue(tag.Key)] = tea.StringValue(tag.Value)
+		}
+	}
+	return &ecs.TagResourcesResponse{StatusCode: ptr.To(int32(http.StatusOK))}, nil
+}
+
+func (b *Backend) UntagResources(req *ecs.UntagResourcesRequest) (*ecs.UntagResourcesResponse, error) {
+	if err := b.begin("UntagResources"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	for _, id := range req.ResourceId {
+		e, ok := b.enis[tea.StringValue(id)]
+		if !ok {
+			return nil, notFound("InvalidResourceId.NotFound", tea.StringValue(id))
+		}
+		for _, key := range req.TagKey {
+			delete(e.tags, tea.StringValue(key))
+		}
+	}
+	return &ecs.UntagResourcesResponse{StatusCode: ptr.To(int32(http.StatusOK))}, nil
+}
+
+// DescribeVSwitches returns the vSwitches matching the ID and zone of req, all
+// in one page.
+func (b *Backend) DescribeVSwitches(req *ecs.DescribeVSwitchesRequest) ([]*ecs.DescribeVSwitchesResponseBodyVSwitchesVSwitch, error) {
+	if err := b.begin("DescribeVSwitches"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	var vSwitches []*ecs.DescribeVSwitchesResponseBodyVSwitchesVSwitch
+	for _, vSwitch := range b.vSwitches {
+		if (req.VSwitchId != nil && *req.VSwitchId != vSwitch.ID) || (req.ZoneId != nil && *req.ZoneId != vSwitch.ZoneID) {
+			continue
+		}
+		vSwitches = append(vSwitches, &ecs.DescribeVSwitchesResponseBodyVSwitchesVSwitch{
+			VSwitchId:               ptr.To(vSwitch.ID),
+			ZoneId:                  ptr.To(vSwitch.ZoneID),
+			AvailableIpAddressCount: ptr.To(vSwitch.AvailableIPs),
+			Status:                  ptr.To("Available"),
+		})
+	}
+	sort.Slice(vSwitches, func(i, j int) bool { return *vSwitches[i].VSwitchId < *vSwitches[j].VSwitchId })
+	return vSwitches, nil
+}
+
+// DescribeSecurityGroups returns the security groups created by
+// CreateSecurityGroup matching the VPC and tags of req, all in one page.
+func (b *Backend) DescribeSecurityGroups(req *ecs.DescribeSecurityGroupsRequest) ([]*ecs.DescribeSecurityGroupsResponseBodySecurityGroupsSecurityGroup, error) {
+	if err := b.begin("DescribeSecurityGroups"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	var groups []*ecs.DescribeSecurityGroupsResponseBodySecurityGroupsSecurityGroup
+	for _, sg := range b.securityGroups {
+		switch {
+		case req.VpcId != nil && *req.VpcId != sg.vpcID,
+			!lo.EveryBy(req.Tag, func(tag *ecs.DescribeSecurityGroupsRequestTag) bool {
+				value, ok := sg.tags[tea.StringValue(tag.Key)]
+				return ok && (tag.Value == nil || *tag.Value == value)
+			}):
+			continue
+		}
+		groups = append(groups, &ecs.DescribeSecurityGroupsResponseBodySecurityGroupsSecurityGroup{
+			SecurityGroupId:   ptr.To(sg.id),
+			SecurityGroupName: ptr.To(sg.name),
+			VpcId:             ptr.To(sg.vpcID),
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool { return *groups[i].SecurityGroupId < *groups[j].SecurityGroupId })
+	return groups, nil
+}
+
+func (b *Backend) CreateSecurityGroup(req *ecs.CreateSecurityGroupRequest) (*ecs.CreateSecurityGroupResponse, error) {
+	if err := b.begin("CreateSecurityGroup"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	sg := &securityGroup{
+		id:    b.newID("sg-fake"),
+		name:  tea.StringValue(req.SecurityGroupName),
+		vpcID: tea.StringValue(req.VpcId),
+		tags:  map[string]string{},
+	}
+	for _, tag := range req.Tag {
+		sg.tags[tea.StringValue(tag.Key)] = tea.StringValue(tag.Value)
+	}
+	b.securityGroups[sg.id] = sg
+	fakeLog.Info("create security group", "securityGroup", sg.id, "name", sg.name, "vpc", sg.vpcID)
+	return &ecs.CreateSecurityGroupResponse{
+		StatusCode: ptr.To(int32(http.StatusOK)),
+		Body:       &ecs.CreateSecurityGroupResponseBody{SecurityGroupId: ptr.To(sg.id)},
+	}, nil
+}
+
+// DescribeSecurityGroupRules returns the rules of the security group of req
+// in the direction of req, all in one page.
+func (b *Backend) DescribeSecurityGroupRules(req *ecs.DescribeSecurityGroupAttributeRequest) ([]*ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission, error) {
+	if err := b.begin("DescribeSecurityGroupAttribute"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	sg, err := b.securityGroup(req.SecurityGroupId)
+	if err != nil {
+		return nil, err
+	}
+	direction := tea.StringValue(req.Direction)
+	return lo.Filter(sg.rules, func(rule *ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission, _ int) bool {
+		return direction == "" || direction == "all" || direction == *rule.Direction
+	}), nil
+}
+
+// checkSecurityGroups rejects the security groups deleted by
+// DeleteSecurityGroup, other unknown groups like the one of the instances are
+// accepted.
+func (b *Backend) checkSecurityGroups(ids []*string) error {
+	for _, id := range ids {
+		if b.deletedGroups[tea.StringValue(id)] {
+			return notFound("InvalidSecurityGroupId.NotFound", tea.StringValue(id))
+		}
+	}
+	return nil
+}
+
+func (b *Backend) securityGroup(id *string) (*securityGroup, error) {
+	sg, ok := b.securityGroups[tea.StringValue(id)]
+	if !ok {
+		return nil, notFound("InvalidSecurityGroupId.NotFound", tea.StringValue(id))
+	}
+	return sg, nil
+}
+
+// authorize adds rule to sg as ECS returns it, a rule which is already in sg
+// is not added again.
+func (b *Backend) authorize(sg *securityGroup, rule *ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission) {
+	rule.IpProtocol = ptr.To(strings.ToUpper(tea.StringValue(rule.IpProtocol)))
+	if policy := strings.ToLower(tea.StringValue(rule.Policy)); policy == "drop" {
+		rule.Policy = ptr.To("Drop")
+	} else {
+		rule.Policy = ptr.To("Accept")
+	}
+	if tea.StringValue(rule.Priority) == "" {
+		rule.Priority = ptr.To("1")
+	}
+	same := func(a, b *ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission) bool {
+		return tea.StringValue(a.Direction) == tea.StringValue(b.Direction) &&
+			tea.StringValue(a.IpProtocol) == tea.StringValue(b.IpProtocol) &&
+			tea.StringValue(a.PortRange) == tea.StringValue(b.PortRange) &&
+			tea.StringValue(a.SourceCidrIp) == tea.StringValue(b.SourceCidrIp) &&
+			tea.StringValue(a.SourceGroupId) == tea.StringValue(b.SourceGroupId) &&
+			tea.StringValue(a.DestCidrIp) == tea.StringValue(b.DestCidrIp) &&
+			tea.StringValue(a.DestGroupId) == tea.StringValue(b.DestGroupId) &&
+			tea.StringValue(a.Policy) == tea.StringValue(b.Policy) &&
+			tea.StringValue(a.Priority) == tea.StringValue(b.Priority)
+	}
+	if lo.ContainsBy(sg.rules, func(existing *ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission) bool {
+		return same(existing, rule)
+	}) {
+		return
+	}
+	rule.SecurityGroupRuleId = ptr.To(b.newID("sgr-fake"))
+	sg.rules = append(sg.rules, rule)
+}
+
+// revoke removes the rules of sg by ID.
+func (b *Backend) revoke(sg *securityGroup, ids []*string) {
+	sg.rules = lo.Reject(sg.rules, func(rule *ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission, _ int) bool {
+		return lo.Contains(tea.StringSliceValue(ids), *rule.SecurityGroupRuleId)
+	})
+}
+
+// AuthorizeSecurityGroup adds the ingress rules in the Permissions of req.
+func (b *Backend) AuthorizeSecurityGroup(req *ecs.AuthorizeSecurityGroupRequest) (*ecs.AuthorizeSecurityGroupResponse, error) {
+	if err := b.begin("AuthorizeSecurityGroup"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	sg, err := b.securityGroup(req.SecurityGroupId)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range req.Permissions {
+		b.authorize(sg, &ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission{
+			Direction:     ptr.To("ingress"),
+			IpProtocol:    p.IpProtocol,
+			PortRange:     p.PortRange,
+			SourceCidrIp:  p.SourceCidrIp,
+			SourceGroupId: p.SourceGroupId,
+			Policy:        p.Policy,
+			Priority:      p.Priority,
+			Description:   p.Description,
+		})
+	}
+	return &ecs.AuthorizeSecurityGroupResponse{StatusCode: ptr.To(int32(http.StatusOK))}, nil
+}
+
+// AuthorizeSecurityGroupEgress adds the egress rules in the Permissions of
+// req.
+func (b *Backend) AuthorizeSecurityGroupEgress(req *ecs.AuthorizeSecurityGroupEgressRequest) (*ecs.AuthorizeSecurityGroupEgressResponse, error) {
+	if err := b.begin("AuthorizeSecurityGroupEgress"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	sg, err := b.securityGroup(req.SecurityGroupId)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range req.Permissions {
+		b.authorize(sg, &ecs.DescribeSecurityGroupAttributeResponseBodyPermissionsPermission{
+			Direction:   ptr.To("egress"),
+			IpProtocol:  p.IpProtocol,
+			PortRange:   p.PortRange,
+			DestCidrIp:  p.DestCidrIp,
+			DestGroupId: p.DestGroupId,
+			Policy:      p.Policy,
+			Priority:    p.Priority,
+			Description: p.Description,
+		})
+	}
+	return &ecs.AuthorizeSecurityGroupEgressResponse{StatusCode: ptr.To(int32(http.StatusOK))}, nil
+}
+
+// RevokeSecurityGroup removes the rules in the SecurityGroupRuleId of req.
+func (b *Backend) RevokeSecurityGroup(req *ecs.RevokeSecurityGroupRequest) (*ecs.RevokeSecurityGroupResponse, error) {
+	if err := b.begin("RevokeSecurityGroup"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	sg, err := b.securityGroup(req.SecurityGroupId)
+	if err != nil {
+		return nil, err
+	}
+	b.revoke(sg, req.SecurityGroupRuleId)
+	return &ecs.RevokeSecurityGroupResponse{StatusCode: ptr.To(int32(http.StatusOK))}, nil
+}
+
+// RevokeSecurityGroupEgress removes the rules in the SecurityGroupRuleId of
+// req.
+func (b *Backend) RevokeSecurityGroupEgress(req *ecs.RevokeSecurityGroupEgressRequest) (*ecs.RevokeSecurityGroupEgressResponse, error) {
+	if err := b.begin("RevokeSecurityGroupEgress"); err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+	sg, err := b.securityGroup(req.SecurityGroupId)
+	if err != nil {
+		return nil, err
+	}
+	b.revoke(sg, req.SecurityGroupRuleId)
+	return &ecs.RevokeSecurityGroupEgressResponse{StatusCode: ptr.To(int32(http.StatusOK))}, nil
+}
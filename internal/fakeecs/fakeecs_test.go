@@ -0,0 +1,108 @@
+package fakeecs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	ecs "github.com/alibabacloud-go/ecs-20140526/v4/client"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+)
+
+func newTestBackend(now *time.Time) *Backend {
+	b := New(&State{
+		InstanceTypes:       []InstanceType{{ID: "ecs.ebmgn8v", EriQuantity: 2, NetworkCardQuantity: 2, QueuePairNumber: 8}},
+		Instances:           []Instance{{ID: "i-1", InstanceType: "ecs.ebmgn8v", PrivateIP: "192.168.0.1"}},
+		DefaultInstanceType: "ecs.ebmgn8v",
+		AttachDelaySeconds:  5,
+	})
+	b.Now = func() time.Time { return *now }
+	return b
+}
+
+func TestAttachDetach(t *testing.T) {
+	now := time.Now()
+	b := newTestBackend(&now)
+
+	created, err := b.CreateNetworkInterface(&ecs.CreateNetworkInterfaceRequest{
+		NetworkInterfaceTrafficMode: ptr.To("HighPerformance"),
+		QueuePairNumber:             ptr.To(int32(8)),
+		Tag:                         []*ecs.CreateNetworkInterfaceRequestTag{{Key: ptr.To("creator"), Value: ptr.To("test")}},
+	})
+	require.NoError(t, err)
+	id := *created.Body.NetworkInterfaceId
+	status := func() string {
+		enis, err := b.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{NetworkInterfaceId: []*string{ptr.To(id)}})
+		require.NoError(t, err)
+		require.Len(t, enis, 1)
+		return *enis[0].Status
+	}
+
+	_, err = b.AttachNetworkInterface(&ecs.AttachNetworkInterfaceRequest{InstanceId: ptr.To("i-1"), NetworkInterfaceId: ptr.To(id), NetworkCardIndex: ptr.To(int32(1))})
+	require.NoError(t, err)
+	assert.Equal(t, StatusAttaching, status())
+	_, err = b.DeleteNetworkInterface(&ecs.DeleteNetworkInterfaceRequest{NetworkInterfaceId: ptr.To(id)})
+	assert.Error(t, err, "an attaching eni cannot be deleted")
+
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, StatusInUse, status())
+	enis, err := b.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{
+		InstanceId: ptr.To("i-1"),
+		Tag:        []*ecs.DescribeNetworkInterfacesRequestTag{{Key: ptr.To("creator"), Value: ptr.To("test")}},
+	})
+	require.NoError(t, err)
+	require.Len(t, enis, 1)
+	assert.Equal(t, int32(1), *enis[0].Attachment.NetworkCardIndex)
+
+	_, err = b.DetachNetworkInterface(&ecs.DetachNetworkInterfaceRequest{InstanceId: ptr.To("i-1"), NetworkInterfaceId: ptr.To(id)})
+	require.NoError(t, err)
+	assert.Equal(t, StatusDetaching, status())
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, StatusAvailable, status())
+	_, err = b.DeleteNetworkInterface(&ecs.DeleteNetworkInterfaceRequest{NetworkInterfaceId: ptr.To(id)})
+	assert.NoError(t, err)
+}
+
+func TestDescribeInstancesDefaultInstanceType(t *testing.T) {
+	now := time.Now()
+	b := newTestBackend(&now)
+
+	resp, err := b.DescribeInstances(&ecs.DescribeInstancesRequest{PrivateIpAddresses: ptr.To(`["10.0.0.1"]`)})
+	require.NoError(t, err)
+	require.Equal(t, int32(1), *resp.Body.TotalCount)
+	instanceID := *resp.Body.Instances.Instance[0].InstanceId
+
+	// the instance is kept with its primary eni
+	resp, err = b.DescribeInstances(&ecs.DescribeInstancesRequest{InstanceIds: ptr.To(`["` + instanceID + `"]`)})
+	require.NoError(t, err)
+	assert.Equal(t, "ecs.ebmgn8v", *resp.Body.Instances.Instance[0].InstanceType)
+	enis, err := b.DescribeNetworkInterfaces(&ecs.DescribeNetworkInterfacesRequest{InstanceId: ptr.To(instanceID)})
+	require.NoError(t, err)
+	require.Len(t, enis, 1)
+	assert.Equal(t, TypePrimary, *enis[0].Type)
+}
+
+func TestInjectFault(t *testing.T) {
+	now := time.Now()
+	b := newTestBackend(&now)
+	b.InjectFault(Fault{Action: "DescribeInstanceAttribute", Code: "Throttling.User", Times: 1})
+
+	_, err := b.DescribeInstanceAttribute(&ecs.DescribeInstanceAttributeRequest{InstanceId: ptr.To("i-1")})
+	var sdkErr *tea.SDKError
+	require.True(t, errors.As(err, &sdkErr))
+	assert.Equal(t, "Throttling.User", *sdkErr.Code)
+	assert.Equal(t, 400, *sdkErr.StatusCode)
+
+	_, err = b.DescribeInstanceAttribute(&ecs.DescribeInstanceAttributeRequest{InstanceId: ptr.To("i-1")})
+	assert.NoError(t, err, "the fault is removed after its times")
+
+	b.InjectFault(Fault{Code: "InternalError", StatusCode: 500})
+	_, err = b.TagResources(&ecs.TagResourcesRequest{})
+	assert.Error(t, err)
+	b.ClearFaults()
+	_, err = b.TagResources(&ecs.TagResourcesRequest{})
+	assert.NoError(t, err)
+}
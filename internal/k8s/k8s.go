@@ -6,14 +6,25 @@ import (
 	"os"
 	"time"
 
-	v1 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1"
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
 
 	"github.com/AliyunContainerService/alibabacloud-erdma-controller/internal/consts"
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -25,17 +36,36 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
-	utilruntime.Must(v1.AddToScheme(scheme))
+	utilruntime.Must(v1beta2.AddToScheme(scheme))
 }
 
 type Kubernetes interface {
-	WaitEriInfo() (*v1.ERdmaDevice, error)
+	WaitEriInfo() (*v1beta2.ERdmaDevice, error)
+	// UpdateEriStatus applies update to the latest status of the named
+	// ERdmaDevice and patches it, retrying on conflicts.
+	UpdateEriStatus(name string, update func(status *v1beta2.ERdmaDeviceStatus)) error
+	// UpdateNode applies update to the latest node of the agent and patches
+	// it, retrying on conflicts.
+	UpdateNode(update func(node *corev1.Node)) error
+	// UpdateNodeStatus applies update to the latest status of the node of
+	// the agent and patches it, retrying on conflicts.
+	UpdateNodeStatus(update func(node *corev1.Node)) error
+	// WatchClusterPolicy calls handler with the current ERdmaClusterPolicy and
+	// on every change of it until ctx is done, nil when there is no policy.
+	WatchClusterPolicy(ctx context.Context, handler func(policy *v1beta2.ERdmaClusterPolicy))
+	// WatchERdmaDevice calls handler with the ERdmaDevice of the node and on
+	// every change of it until ctx is done, nil when there is no device.
+	WatchERdmaDevice(ctx context.Context, handler func(device *v1beta2.ERdmaDevice))
+	// NodeName returns the name of the node of the agent.
+	NodeName() string
+	// EventRecorder returns the recorder of the Events of the agent.
+	EventRecorder() record.EventRecorder
 }
 
 func NewKubernetes() (Kubernetes, error) {
 	restConfig := ctrl.GetConfigOrDie()
 	restConfig.UserAgent = consts.UA
-	c, err := client.New(restConfig, client.Options{
+	c, err := client.NewWithWatch(restConfig, client.Options{
 		Scheme: scheme,
 	})
 	if err != nil {
@@ -46,21 +76,37 @@ func NewKubernetes() (Kubernetes, error) {
 	if nodeName == "" {
 		return nil, fmt.Errorf("failed to get NODE_NAME")
 	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
 	return &k8s{
 		nodeName: nodeName,
 		client:   c,
+		recorder: broadcaster.NewRecorder(scheme, corev1.EventSource{Component: "erdma-agent", Host: nodeName}),
 	}, nil
 }
 
 type k8s struct {
 	nodeName string
-	client   client.Client
+	client   client.WithWatch
+	recorder record.EventRecorder
+}
+
+func (k *k8s) NodeName() string {
+	return k.nodeName
 }
 
-func (k *k8s) WaitEriInfo() (*v1.ERdmaDevice, error) {
-	device := &v1.ERdmaDevice{}
+func (k *k8s) EventRecorder() record.EventRecorder {
+	return k.recorder
+}
+
+func (k *k8s) WaitEriInfo() (*v1beta2.ERdmaDevice, error) {
+	device := &v1beta2.ERdmaDevice{}
 	err := wait.PollUntilContextCancel(context.Background(), 1*time.Minute, true, func(context.Context) (bool, error) {
-		erdmaDeviceList := &v1.ERdmaDeviceList{}
+		erdmaDeviceList := &v1beta2.ERdmaDeviceList{}
 		err := k.client.List(context.TODO(), erdmaDeviceList, client.MatchingLabels{
 			"alibabacloud.com/nodename": k.nodeName,
 		}, &client.ListOptions{Raw: &metav1.ListOptions{
@@ -75,6 +121,16 @@ func (k *k8s) WaitEriInfo() (*v1.ERdmaDevice, error) {
 			return false, nil
 		}
 		device = &erdmaDeviceList.Items[0]
+		// the MAC of each ERI is filled in status by the controller once it
+		// is observed in the cloud
+		for _, eri := range device.Spec.ERIs {
+			if !lo.ContainsBy(device.Status.ERIs, func(item v1beta2.ERIStatus) bool {
+				return item.ID == eri.ID && item.MAC != ""
+			}) {
+				k8sLog.Info("waiting for erdma device status", "eri", eri.ID)
+				return false, nil
+			}
+		}
 		return true, nil
 	})
 	if err != nil {
@@ -82,3 +138,103 @@ func (k *k8s) WaitEriInfo() (*v1.ERdmaDevice, error) {
 	}
 	return device, nil
 }
+
+func (k *k8s) UpdateEriStatus(name string, update func(status *v1beta2.ERdmaDeviceStatus)) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		device := &v1beta2.ERdmaDevice{}
+		err := k.client.Get(context.TODO(), client.ObjectKey{Name: name}, device)
+		if err != nil {
+			return err
+		}
+		patch := client.MergeFromWithOptions(device.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		update(&device.Status)
+		return k.client.Status().Patch(context.TODO(), device, patch)
+	})
+}
+
+func (k *k8s) UpdateNode(update func(node *corev1.Node)) error {
+	return k.updateNode(update, false)
+}
+
+func (k *k8s) UpdateNodeStatus(update func(node *corev1.Node)) error {
+	return k.updateNode(update, true)
+}
+
+// updateNode applies update to the latest node of the agent and patches it,
+// or its status subresource with status.
+func (k *k8s) updateNode(update func(node *corev1.Node), status bool) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		node := &corev1.Node{}
+		err := k.client.Get(context.TODO(), client.ObjectKey{Name: k.nodeName}, node)
+		if err != nil {
+			return err
+		}
+		patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		update(node)
+		if status {
+			return k.client.Status().Patch(context.TODO(), node, patch)
+		}
+		return k.client.Patch(context.TODO(), node, patch)
+	})
+}
+
+func (k *k8s) WatchClusterPolicy(ctx context.Context, handler func(policy *v1beta2.ERdmaClusterPolicy)) {
+	k.listWatch(ctx, "erdma cluster policy", &v1beta2.ERdmaClusterPolicyList{}, &client.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", v1beta2.DefaultClusterPolicyName),
+	}, func(obj runtime.Object) {
+		policy, _ := obj.(*v1beta2.ERdmaClusterPolicy)
+		handler(policy)
+	})
+}
+
+func (k *k8s) WatchERdmaDevice(ctx context.Context, handler func(device *v1beta2.ERdmaDevice)) {
+	k.listWatch(ctx, "erdma device", &v1beta2.ERdmaDeviceList{}, &client.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{"alibabacloud.com/nodename": k.nodeName}),
+	}, func(obj runtime.Object) {
+		device, _ := obj.(*v1beta2.ERdmaDevice)
+		handler(device)
+	})
+}
+
+// listWatch lists the single object selected by opts and watches it, handler
+// is called with the object on every change and with nil when there is none.
+func (k *k8s) listWatch(ctx context.Context, kind string, list client.ObjectList, opts *client.ListOptions, handler func(obj runtime.Object)) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		list := list.DeepCopyObject().(client.ObjectList)
+		err := k.client.List(ctx, list, opts)
+		if err != nil {
+			k8sLog.Error(err, "failed to list "+kind)
+			return
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			k8sLog.Error(err, "failed to extract "+kind)
+			return
+		}
+		if len(items) == 0 {
+			handler(nil)
+		} else {
+			handler(items[0])
+		}
+
+		watchOpts := *opts
+		watchOpts.Raw = &metav1.ListOptions{ResourceVersion: list.GetResourceVersion()}
+		w, err := k.client.Watch(ctx, list, &watchOpts)
+		if err != nil {
+			k8sLog.Error(err, "failed to watch "+kind)
+			return
+		}
+		defer w.Stop()
+		for event := range w.ResultChan() {
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				handler(event.Object)
+			case watch.Deleted:
+				handler(nil)
+			case watch.Error:
+				k8sLog.Info(kind+" watch error, rewatch", "error", apierrors.FromObject(event.Object).Error())
+				return
+			}
+		}
+	}, 10*time.Second)
+}
@@ -0,0 +1,60 @@
+package k8s
+
+import (
+	"github.com/AliyunContainerService/alibabacloud-erdma-controller/api/consts"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SetNotReadyTaint adds the not-ready taint to node, or removes it when
+// tainted is false, and returns whether the taints changed.
+func SetNotReadyTaint(node *corev1.Node, tainted bool) bool {
+	for i, taint := range node.Spec.Taints {
+		if taint.Key != consts.NodeTaintNotReady {
+			continue
+		}
+		if tainted {
+			return false
+		}
+		node.Spec.Taints = append(node.Spec.Taints[:i], node.Spec.Taints[i+1:]...)
+		return true
+	}
+	if !tainted {
+		return false
+	}
+	node.Spec.Taints = append(node.Spec.Taints, corev1.Taint{
+		Key:    consts.NodeTaintNotReady,
+		Effect: corev1.TaintEffectNoSchedule,
+	})
+	return true
+}
+
+// SetNodeCondition sets cond on node, the last transition time is only
+// changed with the status of the condition.
+func SetNodeCondition(node *corev1.Node, cond corev1.NodeCondition) {
+	now := metav1.Now()
+	cond.LastHeartbeatTime = now
+	cond.LastTransitionTime = now
+	for i, existing := range node.Status.Conditions {
+		if existing.Type != cond.Type {
+			continue
+		}
+		if existing.Status == cond.Status {
+			cond.LastTransitionTime = existing.LastTransitionTime
+		}
+		node.Status.Conditions[i] = cond
+		return
+	}
+	node.Status.Conditions = append(node.Status.Conditions, cond)
+}
+
+// NodeConditionTrue returns whether the condition of condType of node is
+// True.
+func NodeConditionTrue(node *corev1.Node, condType corev1.NodeConditionType) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == condType {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
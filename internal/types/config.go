@@ -13,6 +13,77 @@ type Config struct {
 	EnableInitContainerInject   *bool             `json:"enableInitContainerInject"`
 	NodeSelector                map[string]string `json:"nodeSelector"`
 	WaitNodeReadyTimeoutSeconds int               `json:"waitNodeReadyTimeoutSeconds"`
+	OrphanERIGC                 OrphanERIGC       `json:"orphanERIGC"`
+	// ECSQPS and ECSBurst are the token bucket rate limit of the ECS calls.
+	ECSQPS   float32 `json:"ecsQPS"`
+	ECSBurst int     `json:"ecsBurst"`
+	// RebalanceQueuePairs applies a new split of the instance queue pairs to
+	// the existing ERIs of a node.
+	RebalanceQueuePairs bool `json:"rebalanceQueuePairs"`
+	// VSwitches are the candidate vSwitches of the ERIs created by the
+	// controller by zone ID, the vSwitch of the primary ENI is used in zones
+	// without candidates.
+	VSwitches map[string][]string `json:"vSwitches"`
+	// VSwitchSelectionPolicy selects among the candidate vSwitches of a zone,
+	// mostAvailableIP or ordered.
+	VSwitchSelectionPolicy string `json:"vSwitchSelectionPolicy"`
+	// EnableIPv6 assigns an IPv6 address to the ERIs, the vSwitches of the
+	// ERIs must have an IPv6 CIDR block.
+	EnableIPv6 bool `json:"enableIPv6"`
+	// ManagedSecurityGroup configures the security group the controller
+	// maintains for the ERIs.
+	ManagedSecurityGroup ManagedSecurityGroup `json:"managedSecurityGroup"`
+	// DryRun changes nothing in ECS: the ERIs of the nodes without an
+	// ERdmaDevice are only planned into an ERdmaPlan, the existing
+	// ERdmaDevices are not reconciled, orphaned ERIs are only reported and
+	// the security groups are not repaired. The dry-run annotation of a node
+	// overrides it for the node.
+	DryRun bool `json:"dryRun"`
+	// NotReadyTaint taints the new nodes with the not-ready taint until the
+	// agent set up their erdma devices.
+	NotReadyTaint bool `json:"notReadyTaint"`
+}
+
+// ManagedSecurityGroup is a security group the controller creates in each VPC
+// of the nodes and joins the ERIs it creates or adopts to. It allows the
+// traffic between its ERIs plus Rules, other rules are revoked.
+type ManagedSecurityGroup struct {
+	Enabled bool `json:"enabled"`
+	// Name is the name of the security group, the controller finds the group
+	// by a tag with the name.
+	Name  string              `json:"name"`
+	Rules []SecurityGroupRule `json:"rules"`
+	// RepairIntervalSeconds is the interval between two repairs of the rules.
+	RepairIntervalSeconds int `json:"repairIntervalSeconds"`
+}
+
+// SecurityGroupRule is a rule of the managed security group.
+type SecurityGroupRule struct {
+	// Direction is ingress, the default, or egress.
+	Direction string `json:"direction"`
+	// Protocol is TCP, UDP, ICMP, GRE or ALL.
+	Protocol string `json:"protocol"`
+	// PortRange is the port range, e.g. 22/22, -1/-1 by default for all
+	// ports.
+	PortRange string `json:"portRange"`
+	// CIDR is the source of an ingress rule or the destination of an egress
+	// rule.
+	CIDR string `json:"cidr"`
+	// Policy is accept, the default, or drop.
+	Policy string `json:"policy"`
+	// Priority is the priority from 1, the default and the highest, to 100.
+	Priority    int    `json:"priority"`
+	Description string `json:"description"`
+}
+
+// OrphanERIGC configures the collector of the detached ERIs created by the
+// controller which no node uses.
+type OrphanERIGC struct {
+	Enabled            bool `json:"enabled"`
+	IntervalSeconds    int  `json:"intervalSeconds"`
+	GracePeriodSeconds int  `json:"gracePeriodSeconds"`
+	// ReportOnly only reports the orphaned ERIs instead of deleting them.
+	ReportOnly bool `json:"reportOnly"`
 }
 
 type Sensitive string
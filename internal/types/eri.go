@@ -47,6 +47,7 @@ func (cap *ERdmaCAP) String() string {
 type ERdmaDeviceInfo struct {
 	Name         string
 	MAC          string
+	NetDev       string
 	DevPaths     []string
 	NUMA         int64
 	Capabilities ERdmaCAP
@@ -54,6 +55,10 @@ type ERdmaDeviceInfo struct {
 
 const ResourceName = "aliyun/erdma"
 
+// ExclusiveResourceName is the resource of the ERIs allocated as a whole to a
+// pod, see the exclusiveERIs agent setting.
+const ExclusiveResourceName = "aliyun/erdma-exclusive"
+
 const (
 	ENIStatusInUse     string = "InUse"
 	ENIStatusAvailable string = "Available"
@@ -0,0 +1,15 @@
+package webhook
+
+import (
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+)
+
+// SetupConversionWebhook registers the /convert endpoint which converts
+// ERdmaDevice between the served versions through the v1beta2 hub.
+func SetupConversionWebhook(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&networkv1beta2.ERdmaDevice{}).
+		Complete()
+}
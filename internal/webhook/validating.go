@@ -0,0 +1,208 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+)
+
+var validatingLog = ctrl.Log.WithName("validating-webhook")
+
+// NetworkCardCounter returns the number of network cards of an ECS instance.
+type NetworkCardCounter interface {
+	NetworkCardCount(instanceID string) (int, error)
+}
+
+// ValidatingHook validates the ERdmaDevice objects and their status, cards
+// is used to check the network card indexes, nil to skip the check. Unlike the
+// mutating webhook it does not depend on enableWebhook.
+func ValidatingHook(cards NetworkCardCounter) *webhook.Admission {
+	return &webhook.Admission{
+		Handler: admission.HandlerFunc(func(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
+			switch req.Kind.Kind {
+			case "ERdmaDevice":
+				return erdmaDeviceWebhook(ctx, &req, cards)
+			}
+			return webhook.Allowed("not care")
+		}),
+	}
+}
+
+func erdmaDeviceWebhook(_ context.Context, req *webhook.AdmissionRequest, cards NetworkCardCounter) webhook.AdmissionResponse {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return webhook.Allowed("not care")
+	}
+	device := &networkv1beta2.ERdmaDevice{}
+	if err := json.Unmarshal(req.Object.Raw, device); err != nil {
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed decoding erdma device: %w", err))
+	}
+	var oldDevice *networkv1beta2.ERdmaDevice
+	if req.Operation == admissionv1.Update {
+		oldDevice = &networkv1beta2.ERdmaDevice{}
+		if err := json.Unmarshal(req.OldObject.Raw, oldDevice); err != nil {
+			return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed decoding old erdma device: %w", err))
+		}
+	}
+
+	var (
+		errs     field.ErrorList
+		warnings []string
+	)
+	if req.SubResource == "status" {
+		errs = validateERdmaDeviceStatus(&device.Status, field.NewPath("status"))
+	} else {
+		errs = validateERdmaDeviceSpec(&device.Spec, field.NewPath("spec"))
+		if oldDevice != nil {
+			errs = append(errs, validateERdmaDeviceSpecUpdate(&device.Spec, &oldDevice.Spec, field.NewPath("spec"))...)
+		}
+		// the card count is only checked when the layout changed, it costs
+		// an ECS call
+		if len(errs) == 0 && cards != nil && device.Spec.InstanceID != "" &&
+			(oldDevice == nil || !sameERILayout(device.Spec.ERIs, oldDevice.Spec.ERIs)) {
+			cardCount, err := cards.NetworkCardCount(device.Spec.InstanceID)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("network card indexes not checked, failed to get network cards of instance %s: %v", device.Spec.InstanceID, err))
+			} else {
+				errs = append(errs, validateNetworkCardIndex(device.Spec.ERIs, cardCount, field.NewPath("spec", "eris"))...)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		validatingLog.Info("deny erdma device", "name", req.Name, "errors", errs.ToAggregate().Error())
+		return webhook.Denied(fmt.Sprintf("invalid erdma device %s: %s", req.Name, errs.ToAggregate().Error()))
+	}
+	return webhook.Allowed("").WithWarnings(warnings...)
+}
+
+func validateERdmaDeviceSpec(spec *networkv1beta2.ERdmaDeviceSpec, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	ids := sets.New[string]()
+	primary := false
+	for i, eri := range spec.ERIs {
+		eriPath := fldPath.Child("eris").Index(i)
+		switch {
+		case eri.ID == "":
+			errs = append(errs, field.Required(eriPath.Child("id"), "ENI ID of the ERI is required"))
+		case ids.Has(eri.ID):
+			errs = append(errs, field.Duplicate(eriPath.Child("id"), eri.ID))
+		}
+		ids.Insert(eri.ID)
+		if eri.QueuePair < 0 {
+			errs = append(errs, field.Invalid(eriPath.Child("queuePair"), eri.QueuePair, "must be greater than or equal to 0"))
+		}
+		if eri.NetworkCardIndex < 0 {
+			errs = append(errs, field.Invalid(eriPath.Child("networkCardIndex"), eri.NetworkCardIndex, "must be greater than or equal to 0"))
+		}
+		if eri.PrimaryENI {
+			if primary {
+				errs = append(errs, field.Invalid(eriPath.Child("primaryENI"), eri.PrimaryENI, "only one ERI can be the primary ENI"))
+			}
+			primary = true
+		}
+	}
+	return errs
+}
+
+func validateERdmaDeviceSpecUpdate(spec, oldSpec *networkv1beta2.ERdmaDeviceSpec, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if oldSpec.InstanceID != "" && spec.InstanceID != oldSpec.InstanceID {
+		errs = append(errs, field.Forbidden(fldPath.Child("instanceID"),
+			fmt.Sprintf("field is immutable, changed from %q to %q", oldSpec.InstanceID, spec.InstanceID)))
+	}
+	oldERIs := map[string]networkv1beta2.ERISpec{}
+	for _, eri := range oldSpec.ERIs {
+		oldERIs[eri.ID] = eri
+	}
+	ids := sets.New[string]()
+	var added []string
+	for i, eri := range spec.ERIs {
+		ids.Insert(eri.ID)
+		oldERI, ok := oldERIs[eri.ID]
+		if !ok {
+			added = append(added, eri.ID)
+			continue
+		}
+		eriPath := fldPath.Child("eris").Index(i)
+		if eri.NetworkCardIndex != oldERI.NetworkCardIndex {
+			errs = append(errs, field.Forbidden(eriPath.Child("networkCardIndex"),
+				fmt.Sprintf("field is immutable for ERI %s, changed from %d to %d", eri.ID, oldERI.NetworkCardIndex, eri.NetworkCardIndex)))
+		}
+		if eri.PrimaryENI != oldERI.PrimaryENI {
+			errs = append(errs, field.Forbidden(eriPath.Child("primaryENI"),
+				fmt.Sprintf("field is immutable for ERI %s, changed from %t to %t", eri.ID, oldERI.PrimaryENI, eri.PrimaryENI)))
+		}
+	}
+	var removed []string
+	for _, eri := range oldSpec.ERIs {
+		if !ids.Has(eri.ID) {
+			removed = append(removed, eri.ID)
+		}
+	}
+	// ERIs can be added or removed, but replacing the ENI of an ERI in one
+	// update would leave the old ENI attached and configured
+	if len(added) > 0 && len(removed) > 0 {
+		errs = append(errs, field.Forbidden(fldPath.Child("eris"),
+			fmt.Sprintf("ENI ID of an ERI is immutable, %v replaced by %v, remove and add ERIs in separate updates", removed, added)))
+	}
+	return errs
+}
+
+func validateNetworkCardIndex(eris []networkv1beta2.ERISpec, cardCount int, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, eri := range eris {
+		if eri.NetworkCardIndex >= cardCount {
+			errs = append(errs, field.Invalid(fldPath.Index(i).Child("networkCardIndex"), eri.NetworkCardIndex,
+				fmt.Sprintf("must be less than the network card count %d of the instance", cardCount)))
+		}
+	}
+	return errs
+}
+
+func validateERdmaDeviceStatus(status *networkv1beta2.ERdmaDeviceStatus, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	macs := map[string]string{}
+	for i, eri := range status.ERIs {
+		eriPath := fldPath.Child("eris").Index(i)
+		if eri.QueuePair < 0 {
+			errs = append(errs, field.Invalid(eriPath.Child("queuePair"), eri.QueuePair, "must be greater than or equal to 0"))
+		}
+		if eri.MAC == "" {
+			continue
+		}
+		hw, err := net.ParseMAC(eri.MAC)
+		if err != nil {
+			errs = append(errs, field.Invalid(eriPath.Child("mac"), eri.MAC, "must be a MAC address"))
+			continue
+		}
+		if id, ok := macs[hw.String()]; ok {
+			errs = append(errs, field.Invalid(eriPath.Child("mac"), eri.MAC, fmt.Sprintf("duplicate MAC address, already used by ERI %s", id)))
+			continue
+		}
+		macs[hw.String()] = eri.ID
+	}
+	return errs
+}
+
+// sameERILayout returns whether the network cards of the ERIs are unchanged.
+func sameERILayout(eris, oldERIs []networkv1beta2.ERISpec) bool {
+	if len(eris) != len(oldERIs) {
+		return false
+	}
+	for i := range eris {
+		if eris[i].ID != oldERIs[i].ID || eris[i].NetworkCardIndex != oldERIs[i].NetworkCardIndex {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	networkv1beta2 "github.com/AliyunContainerService/alibabacloud-erdma-controller/api/v1beta2"
+)
+
+type fakeCardCounter map[string]int
+
+func (f fakeCardCounter) NetworkCardCount(instanceID string) (int, error) {
+	count, ok := f[instanceID]
+	if !ok {
+		return 0, fmt.Errorf("instance %s not found", instanceID)
+	}
+	return count, nil
+}
+
+func newDevice(instanceID string, eris ...networkv1beta2.ERISpec) *networkv1beta2.ERdmaDevice {
+	return &networkv1beta2.ERdmaDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec:       networkv1beta2.ERdmaDeviceSpec{InstanceID: instanceID, ERIs: eris},
+	}
+}
+
+func admissionRequest(t *testing.T, subResource string, device, oldDevice *networkv1beta2.ERdmaDevice) *webhook.AdmissionRequest {
+	raw := func(obj *networkv1beta2.ERdmaDevice) runtime.RawExtension {
+		if obj == nil {
+			return runtime.RawExtension{}
+		}
+		data, err := json.Marshal(obj)
+		assert.NoError(t, err)
+		return runtime.RawExtension{Raw: data}
+	}
+	req := &webhook.AdmissionRequest{AdmissionRequest: admissionv1.AdmissionRequest{
+		Name:        "node1",
+		Kind:        metav1.GroupVersionKind{Kind: "ERdmaDevice"},
+		SubResource: subResource,
+		Operation:   admissionv1.Create,
+		Object:      raw(device),
+		OldObject:   raw(oldDevice),
+	}}
+	if oldDevice != nil {
+		req.Operation = admissionv1.Update
+	}
+	return req
+}
+
+func TestERdmaDeviceWebhook(t *testing.T) {
+	cards := fakeCardCounter{"i-1": 2}
+	eri0 := networkv1beta2.ERISpec{ID: "eni-0", PrimaryENI: true, QueuePair: 8}
+	eri1 := networkv1beta2.ERISpec{ID: "eni-1", NetworkCardIndex: 1, QueuePair: 8}
+
+	tests := []struct {
+		name        string
+		subResource string
+		device      *networkv1beta2.ERdmaDevice
+		oldDevice   *networkv1beta2.ERdmaDevice
+		denied      string
+		warning     bool
+	}{
+		{
+			name:   "valid create",
+			device: newDevice("i-1", eri0, eri1),
+		},
+		{
+			name:   "negative queue pair",
+			device: newDevice("i-1", networkv1beta2.ERISpec{ID: "eni-0", QueuePair: -1}),
+			denied: "spec.eris[0].queuePair: Invalid value: -1",
+		},
+		{
+			name:   "duplicate ENI ID",
+			device: newDevice("i-1", eri0, eri0),
+			denied: `spec.eris[1].id: Duplicate value: "eni-0"`,
+		},
+		{
+			name:   "network card index past the card count",
+			device: newDevice("i-1", eri0, networkv1beta2.ERISpec{ID: "eni-2", NetworkCardIndex: 2}),
+			denied: "must be less than the network card count 2 of the instance",
+		},
+		{
+			name:    "card count unknown",
+			device:  newDevice("i-2", eri0, networkv1beta2.ERISpec{ID: "eni-2", NetworkCardIndex: 2}),
+			warning: true,
+		},
+		{
+			name:      "add ERI",
+			device:    newDevice("i-1", eri0, eri1),
+			oldDevice: newDevice("i-1", eri0),
+		},
+		{
+			name:      "changed instance ID",
+			device:    newDevice("i-2", eri0),
+			oldDevice: newDevice("i-1", eri0),
+			denied:    "spec.instanceID: Forbidden: field is immutable",
+		},
+		{
+			name:      "changed ENI ID",
+			device:    newDevice("i-1", eri0, networkv1beta2.ERISpec{ID: "eni-2", NetworkCardIndex: 1}),
+			oldDevice: newDevice("i-1", eri0, eri1),
+			denied:    "ENI ID of an ERI is immutable, [eni-1] replaced by [eni-2]",
+		},
+		{
+			name:      "changed network card index",
+			device:    newDevice("i-1", eri0, networkv1beta2.ERISpec{ID: "eni-1"}),
+			oldDevice: newDevice("i-1", eri0, eri1),
+			denied:    "spec.eris[1].networkCardIndex: Forbidden: field is immutable for ERI eni-1",
+		},
+		{
+			name:        "duplicate MAC",
+			subResource: "status",
+			device: &networkv1beta2.ERdmaDevice{Status: networkv1beta2.ERdmaDeviceStatus{ERIs: []networkv1beta2.ERIStatus{
+				{ID: "eni-0", MAC: "00:16:3e:00:00:01"},
+				{ID: "eni-1", MAC: "00:16:3E:00:00:01"},
+			}}},
+			oldDevice: &networkv1beta2.ERdmaDevice{},
+			denied:    "duplicate MAC address, already used by ERI eni-0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := erdmaDeviceWebhook(context.Background(), admissionRequest(t, tt.subResource, tt.device, tt.oldDevice), cards)
+			if tt.denied != "" {
+				assert.False(t, resp.Allowed)
+				assert.Contains(t, resp.Result.Message, tt.denied)
+				return
+			}
+			assert.True(t, resp.Allowed, resp.Result.Message)
+			assert.Equal(t, tt.warning, len(resp.Warnings) > 0)
+		})
+	}
+}